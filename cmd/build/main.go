@@ -1,19 +1,67 @@
 package main
 
 import (
+	"crypto/ecdh"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"firestarter/internal/agent/config"
+	"firestarter/internal/agent/protocol"
+	"firestarter/internal/certificates"
+	"firestarter/internal/compression"
+	"firestarter/internal/e2e"
+	"firestarter/internal/signing"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// defaultKillAfter is how long after the build the agent stops for good when no kill date is given
+const defaultKillAfter = 30 * 24 * time.Hour
+
 func main() {
 	// Parse command line arguments for protocol
-	protocolFlag := flag.String("protocol", "h1c", "Protocol to build for (h1c, h1tls, h2c, h2tls, h3)")
+	protocolFlag := flag.String("protocol", "h1c", "Protocol to build for (h1c, h1tls, h2c, h2tls, h3, ws, tcp)")
+
+	// Failover chain embedded into the agent
+	fallbacksFlag := flag.String("fallbacks", "", "Comma-separated backup endpoints tried in order (e.g. h2tls://host:8443,h3://host2:443)")
+
+	// Check-in settings embedded into the agent
+	longPollFlag := flag.Bool("long-poll", true, "Use long-poll check-ins instead of periodic health checks")
+	longPollTimeoutFlag := flag.Duration("long-poll-timeout", 0, "How long the server may hold a check-in open (e.g. 30s), agent default when zero")
+
+	// Compression settings embedded into the agent
+	compressionFlag := flag.String("compression", "gzip", "Payload compression codec (gzip, deflate or none)")
+	compressionThresholdFlag := flag.Int("compression-threshold", 0, "Smallest payload compressed in bytes, agent default when zero")
+
+	// Task execution settings embedded into the agent
+	taskConcurrencyFlag := flag.Int("task-concurrency", 0, "How many tasks the agent runs at the same time, agent default when zero")
+	taskTimeoutFlag := flag.Duration("task-timeout", 0, "How long a task may run unless the operator gives its own timeout (e.g. 10m), agent default when zero")
+
+	// Outbound proxy settings embedded into the agent
+	proxyFlag := flag.String("proxy", "", "Outbound HTTP(S) proxy URL (e.g. http://proxy.corp:3128)")
+	proxyEnvFlag := flag.Bool("proxy-env", false, "Honour HTTP_PROXY, HTTPS_PROXY and NO_PROXY on the target host")
+	proxyUserFlag := flag.String("proxy-user", "", "Username for proxy basic authentication")
+	proxyPassFlag := flag.String("proxy-pass", "", "Password for proxy basic authentication")
+
+	// TLS trust settings embedded into the agent
+	caBundleFlag := flag.String("ca-bundle", "", "PEM file with CA certificates the agent trusts for TLS listeners")
+	pinsFlag := flag.String("pins", "", "Comma-separated base64 SHA-256 SPKI pins the listener certificate must match")
+	pinCertFlag := flag.String("pin-cert", "", "PEM certificate file whose public key(s) are added to the pins")
+	mtlsFlag := flag.Bool("mtls", false, "Embed a client certificate from the agent CA for listeners requiring mutual TLS")
+
+	// Engagement limit embedded into the agent
+	killDateFlag := flag.String("kill-date", "", "When the agent stops for good and removes its identity file, RFC 3339 or YYYY-MM-DD (end of that day, UTC); 30 days after the build when empty")
+
+	// End-to-end encryption settings
+	e2eFlag := flag.Bool("e2e", true, "Seal payloads end to end with a per-agent X25519 key")
+	keysFlag := flag.String("keys", e2e.DefaultKeyPath(), "Directory holding the team server's end-to-end key, agent keyring and signing secrets")
 	flag.Parse()
 
 	// Validate the protocol
@@ -24,11 +72,40 @@ func main() {
 		"h2c":   true,
 		"h2tls": true,
 		"h3":    true,
+		"ws":    true,
+		"tcp":   true,
 	}
 
 	if !validProtocols[protocol] {
 		fmt.Printf("Error: Invalid protocol '%s'\n", protocol)
-		fmt.Println("Valid protocols: h1c, h1tls, h2c, h2tls, h3")
+		fmt.Println("Valid protocols: h1c, h1tls, h2c, h2tls, h3, ws, tcp")
+		os.Exit(1)
+	}
+
+	// Validate the failover chain before embedding it
+	if _, err := config.ParseEndpointList(*fallbacksFlag); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Validate the compression codec before embedding it
+	codec, err := compression.ParseCodec(*compressionFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Resolve the kill date, the agent must never outlive the engagement
+	killDate, err := parseKillDate(*killDateFlag, time.Now())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Collect TLS trust settings
+	caBundle, pins, err := loadTLSTrust(*caBundleFlag, *pinsFlag, *pinCertFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -36,6 +113,41 @@ func main() {
 	agentUUID := uuid.New().String()
 	fmt.Printf("Building agent with UUID: %s\n", agentUUID)
 
+	// Generate the agent's end-to-end keypair, sealed to the team server's key
+	var agentKey *ecdh.PrivateKey
+	var serverKey *ecdh.PrivateKey
+	if *e2eFlag {
+		if serverKey, err = e2e.LoadOrCreateServerKey(*keysFlag); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if agentKey, err = e2e.GenerateKey(); err != nil {
+			fmt.Printf("Error generating agent key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Issue the agent's client certificate, its subject is the agent UUID
+	var clientCert, clientKey []byte
+	if *mtlsFlag {
+		ca, err := certificates.LoadOrCreateAgentCA(certificates.DefaultCertificatePath())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if clientCert, clientKey, err = ca.IssueAgentCertificate(agentUUID, certificates.AgentCertValidity); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Generate the secret the agent signs its requests with
+	secret, err := signing.GenerateSecret()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Get current time for build timestamp
 	buildTime := time.Now().UTC().Format(time.RFC3339)
 
@@ -52,10 +164,80 @@ func main() {
 	fmt.Printf("Building %s for protocol: %s\n", binaryName, protocol)
 
 	// Construct the build command with the UUID and build time injected
+	ldflags := fmt.Sprintf("-X main.embeddedUUID=%s -X main.buildTime=%s -X main.buildProtocol=%s",
+		agentUUID, buildTime, protocol)
+	if *fallbacksFlag != "" {
+		ldflags += fmt.Sprintf(" -X main.fallbackEndpoints=%s", *fallbacksFlag)
+		fmt.Printf("Embedding fallback endpoints: %s\n", *fallbacksFlag)
+	}
+	if !*longPollFlag {
+		ldflags += " -X main.longPoll=false"
+		fmt.Println("Agent will use periodic health checks instead of long-poll check-ins")
+	}
+	if *longPollTimeoutFlag > 0 {
+		ldflags += fmt.Sprintf(" -X main.longPollTimeout=%s", *longPollTimeoutFlag)
+		fmt.Printf("Embedding long-poll timeout: %s\n", *longPollTimeoutFlag)
+	}
+	ldflags += fmt.Sprintf(" -X main.compressionCodec=%s", codec)
+	if codec == compression.Identity {
+		fmt.Println("Agent will send and receive payloads uncompressed")
+	} else {
+		fmt.Printf("Embedding payload compression: %s\n", codec)
+	}
+	if *compressionThresholdFlag > 0 {
+		ldflags += fmt.Sprintf(" -X main.compressionThreshold=%d", *compressionThresholdFlag)
+		fmt.Printf("Embedding compression threshold: %d bytes\n", *compressionThresholdFlag)
+	}
+	if *taskConcurrencyFlag > 0 {
+		ldflags += fmt.Sprintf(" -X main.taskConcurrency=%d", *taskConcurrencyFlag)
+		fmt.Printf("Embedding task concurrency: %d\n", *taskConcurrencyFlag)
+	}
+	if *taskTimeoutFlag > 0 {
+		ldflags += fmt.Sprintf(" -X main.taskTimeout=%s", *taskTimeoutFlag)
+		fmt.Printf("Embedding task timeout: %s\n", *taskTimeoutFlag)
+	}
+	if *proxyFlag != "" {
+		ldflags += fmt.Sprintf(" -X main.proxyURL=%s", *proxyFlag)
+		fmt.Printf("Embedding outbound proxy: %s\n", *proxyFlag)
+	}
+	if *proxyEnvFlag {
+		ldflags += " -X main.proxyFromEnvironment=true"
+		fmt.Println("Agent will honour proxy environment variables")
+	}
+	if *proxyUserFlag != "" {
+		ldflags += fmt.Sprintf(" -X main.proxyUsername=%s -X main.proxyPassword=%s", *proxyUserFlag, *proxyPassFlag)
+		fmt.Println("Embedding proxy credentials")
+	}
+	if caBundle != "" {
+		ldflags += fmt.Sprintf(" -X main.tlsCABundle=%s", caBundle)
+		fmt.Println("Embedding CA bundle for TLS verification")
+	}
+	if len(pins) > 0 {
+		ldflags += fmt.Sprintf(" -X main.tlsPins=%s", strings.Join(pins, ","))
+		fmt.Printf("Embedding %d SPKI pin(s) for TLS verification\n", len(pins))
+	}
+	if clientCert != nil {
+		ldflags += fmt.Sprintf(" -X main.tlsClientCert=%s -X main.tlsClientKey=%s",
+			base64.StdEncoding.EncodeToString(clientCert), base64.StdEncoding.EncodeToString(clientKey))
+		fmt.Printf("Embedding client certificate for mutual TLS (valid %v)\n", certificates.AgentCertValidity)
+	}
+	if agentKey != nil {
+		ldflags += fmt.Sprintf(" -X main.e2eAgentKey=%s -X main.e2eServerKey=%s",
+			e2e.EncodePrivateKey(agentKey), e2e.EncodePublicKey(serverKey.PublicKey()))
+		fmt.Println("Embedding end-to-end keys, payloads will be sealed to the team server")
+	} else {
+		fmt.Println("WARNING: End-to-end encryption disabled, payloads rely on the transport")
+	}
+
+	ldflags += fmt.Sprintf(" -X main.signingSecret=%s", signing.EncodeSecret(secret))
+	fmt.Println("Embedding request signing secret")
+
+	ldflags += fmt.Sprintf(" -X main.killDate=%s", killDate.Format(time.RFC3339))
+	fmt.Printf("Embedding kill date: %s\n", killDate.Format(time.RFC3339))
+
 	cmd := exec.Command("go", "build",
 		"-o", binaryName,
-		"-ldflags", fmt.Sprintf("-X main.embeddedUUID=%s -X main.buildTime=%s -X main.buildProtocol=%s",
-			agentUUID, buildTime, protocol),
+		"-ldflags", ldflags,
 		"cmd/agent/main.go")
 
 	// Connect command's stdout and stderr to our process
@@ -68,6 +250,100 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Only a successful build is registered, the server then accepts its signed requests
+	// and sealed messages
+	if err := signing.RegisterAgentSecret(*keysFlag, agentUUID, secret); err != nil {
+		fmt.Printf("Failed to register signing secret: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signing secret registered in %s\n", *keysFlag)
+
+	if agentKey != nil {
+		if err := e2e.RegisterAgentKey(*keysFlag, agentUUID, agentKey.PublicKey()); err != nil {
+			fmt.Printf("Failed to register agent key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Agent key registered in %s\n", *keysFlag)
+	}
+
 	fmt.Printf("Build successful! Executable: %s\n", binaryName)
 	fmt.Printf("Agent UUID: %s\n", agentUUID)
 }
+
+// parseKillDate resolves the kill date given on the command line. A bare date means the end of
+// that day in UTC, and no date at all means defaultKillAfter from now.
+func parseKillDate(value string, now time.Time) (time.Time, error) {
+	var killDate time.Time
+	switch value = strings.TrimSpace(value); {
+	case value == "":
+		killDate = now.Add(defaultKillAfter)
+	case len(value) == len(time.DateOnly):
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid kill date '%s': %w", value, err)
+		}
+		killDate = day.AddDate(0, 0, 1)
+	default:
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid kill date '%s': expected RFC 3339 or YYYY-MM-DD", value)
+		}
+		killDate = date
+	}
+
+	if !killDate.After(now) {
+		return time.Time{}, fmt.Errorf("kill date %s has already passed", killDate.UTC().Format(time.RFC3339))
+	}
+	return killDate.UTC().Truncate(time.Second), nil
+}
+
+// loadTLSTrust reads the CA bundle and collects SPKI pins for embedding
+// The CA bundle is returned base64-encoded so it survives being passed through -ldflags
+func loadTLSTrust(caBundlePath, pinList, pinCertPath string) (string, []string, error) {
+	var caBundle string
+	if caBundlePath != "" {
+		data, err := os.ReadFile(caBundlePath)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return "", nil, fmt.Errorf("CA bundle %s contains no valid certificates", caBundlePath)
+		}
+		caBundle = base64.StdEncoding.EncodeToString(data)
+	}
+
+	var pins []string
+	for _, pin := range strings.Split(pinList, ",") {
+		if pin = strings.TrimSpace(pin); pin == "" {
+			continue
+		}
+		if err := protocol.ValidateSPKIPin(pin); err != nil {
+			return "", nil, err
+		}
+		pins = append(pins, pin)
+	}
+
+	if pinCertPath != "" {
+		data, err := os.ReadFile(pinCertPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read pin certificate: %w", err)
+		}
+		found := 0
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to parse pin certificate: %w", err)
+			}
+			pins = append(pins, protocol.SPKIPin(cert))
+			found++
+		}
+		if found == 0 {
+			return "", nil, fmt.Errorf("pin certificate %s contains no certificates", pinCertPath)
+		}
+	}
+
+	return caBundle, pins, nil
+}
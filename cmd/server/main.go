@@ -1,16 +1,31 @@
 package main
 
 import (
+	"firestarter/internal/certificates"
+	"firestarter/internal/checkin"
+	"firestarter/internal/compression"
 	"firestarter/internal/connections"
 	"firestarter/internal/connregistry"
+	"firestarter/internal/e2e"
 	"firestarter/internal/factory"
+	"firestarter/internal/filestore"
+	"firestarter/internal/maintenance"
 	"firestarter/internal/manager"
+	"firestarter/internal/registration"
+	"firestarter/internal/runtimeconfig"
+	"firestarter/internal/scope"
+	"firestarter/internal/security"
 	"firestarter/internal/service"
+	"firestarter/internal/signing"
+	"firestarter/internal/tasking"
+	"firestarter/internal/telemetry"
 	"firestarter/internal/websocket"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,7 +35,17 @@ var WebSocketPort = 8080
 
 var connectionMonitor = time.Minute * 5
 
+var requireClientCerts = flag.Bool("mtls", false, "Require agent client certificates issued by the agent CA on TLS and QUIC listeners")
+
+var requireSignatures = flag.Bool("require-signatures", true, "Reject agent requests not signed with the agent's build-time secret, on every route including / and the test endpoints")
+
+var authorizedScope = flag.String("scope", "", "Comma-separated CIDRs agents are authorized to check in from, every address when empty")
+
+var scopeMode = flag.String("scope-mode", scope.ModeRefuse, "How agents outside the scope are dealt with: refuse or quarantine")
+
 func main() {
+	flag.Parse()
+
 	// Setup channel for SIGINT shutdown signal
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
@@ -42,6 +67,11 @@ func main() {
 	// Use the service to stop all listeners
 	fmt.Printf("\nReceived signal: %v. Starting graceful shutdown...\n", sig)
 	listenerService.StopAllListeners(&wg)
+
+	// Tasks are saved in the background, write whatever changed since the last save
+	if err := tasking.GetQueue().Flush(); err != nil {
+		log.Printf("[❌ERR] -> Failed to save task queue: %v", err)
+	}
 }
 
 func ApplicationSetup() *service.ListenerService {
@@ -69,13 +99,103 @@ func ApplicationSetup() *service.ListenerService {
 	// Connect the registry to the connection manager
 	connregistry.ConnectRegistryToManager(connectionManager)
 
+	// Initialize check-in hub for server-initiated work
+	checkin.InitializeCheckInHub()
+
+	// Load the end-to-end key agents seal their payloads to
+	if err := e2e.InitializeKeyStore(e2e.DefaultKeyPath()); err != nil {
+		log.Printf("[❌ERR] -> End-to-end encryption unavailable: %v", err)
+	}
+
+	// Verify agent request signatures, rejections are shown in the UI as security events
+	security.InitializeEventLog()
+	if wsServer != nil {
+		security.GetEventLog().SetNotifier(wsServer.BroadcastSecurityEvent)
+	}
+	if err := signing.InitializeVerifier(e2e.DefaultKeyPath(), *requireSignatures); err != nil {
+		log.Printf("[❌ERR] -> Request signing unavailable: %v", err)
+	}
+
+	// Count each agent's traffic before and after compression for the UI
+	compression.InitializeTrafficStats()
+	if wsServer != nil {
+		compression.GetTrafficStats().SetNotifier(wsServer.BroadcastAgentTraffic)
+	}
+
+	// Keep the host metadata agents register with for the UI
+	registration.InitializeRegistry()
+	if wsServer != nil {
+		registration.GetRegistry().SetNotifier(wsServer.BroadcastAgentRegistered)
+	}
+
+	// Follow agent health from the status records sent with their health checks
+	telemetry.InitializeMonitor()
+	if wsServer != nil {
+		telemetry.GetMonitor().SetNotifier(wsServer.BroadcastAgentHealth)
+	}
+
+	// Keep the configuration in effect on each agent and the signed updates pushed to them
+	runtimeconfig.InitializeStore()
+	if wsServer != nil {
+		runtimeconfig.GetStore().SetNotifier(wsServer.BroadcastAgentConfig)
+	}
+
+	// Agents outside the authorized scope are refused or quarantined, and shown in the UI
+	if err := scope.InitializeScope(strings.Split(*authorizedScope, ","), *scopeMode); err != nil {
+		log.Fatalf("[❌ERR] -> Invalid authorized scope: %v", err)
+	}
+	if wsServer != nil {
+		scope.GetScope().SetNotifier(wsServer.BroadcastScopeViolation)
+	}
+	if scope.GetScope().Restricted() {
+		fmt.Printf("[🎯SCP] -> Authorized scope: %s (%s)\n", strings.Join(scope.GetScope().Status().CIDRs, ", "), scope.GetScope().Mode())
+	} else {
+		log.Println("[🎯SCP] -> No authorized scope set, agents are accepted from every address")
+	}
+
+	// Keep the tasks queued for each agent, saved to disk so they survive a restart
+	if err := tasking.InitializeQueue(tasking.DefaultQueuePath()); err != nil {
+		log.Fatalf("[❌ERR] -> Cannot load task queue: %v", err)
+	}
+	if wsServer != nil {
+		tasking.GetQueue().SetNotifier(wsServer.BroadcastTask)
+	}
+
+	// Keep the files retrieved from agents and those staged for them, with their transfers
+	if err := filestore.InitializeStore(filestore.DefaultStorePath()); err != nil {
+		log.Fatalf("[❌ERR] -> Cannot load file store: %v", err)
+	}
+	if wsServer != nil {
+		filestore.GetStore().SetNotifier(wsServer.BroadcastFile)
+	}
+
+	// Listeners in maintenance turn agents away with a hint of when to come back
+	maintenance.InitializeRegistry()
+
 	af := factory.NewAbstractFactory(connectionManager)
+
+	// Agents built with -mtls carry a certificate from the agent CA, which then identifies them
+	if *requireClientCerts {
+		pool, err := certificates.LoadAgentCAPool(certificates.DefaultCertificatePath())
+		if err != nil {
+			log.Fatalf("[❌ERR] -> Cannot require client certificates: %v", err)
+		}
+		af.RequireClientCertificates(pool)
+	}
 	lm := manager.NewListenerManager()
 	ls := service.NewListenerService(af, lm, connectionManager)
 
 	// ConnectToWebSocket registers Listeners Service with WSS -> Allows UI to execute commands on server
 	ls.ConnectToWebSocket()
 
+	// The tasking service lets the UI queue tasks for agents
+	ts := service.NewTaskingService(tasking.GetQueue())
+	ts.ConnectToWebSocket()
+
+	// The file service lets the UI retrieve files from agents and push files to them
+	fs := service.NewFileService(filestore.GetStore(), ts)
+	fs.ConnectToWebSocket()
+
 	fmt.Println("================================================================")
 	fmt.Println()
 	fmt.Println("[🖥️WUI] -> YOU CAN NOW CONNECT FROM THE WEB UI <- [WUI🖥️]")
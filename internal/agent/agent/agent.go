@@ -2,34 +2,101 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"firestarter/internal/agent/config"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/agent/hostinfo"
+	"firestarter/internal/agent/identity"
 	"firestarter/internal/agent/protocol"
+	"firestarter/internal/e2e"
+	"firestarter/internal/envelope"
+	"firestarter/internal/runtimeconfig"
+	"firestarter/internal/telemetry"
 	"fmt"
 	"log"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// minCheckInInterval is the shortest time between two check-ins that returned without being held
+const minCheckInInterval = time.Second
+
+// shutdownNoticeTimeout bounds how long shutting down waits for the server to take notice
+const shutdownNoticeTimeout = 3 * time.Second
+
+// killDateCheckInterval is how often the kill date is compared to the wall clock, since timers
+// don't advance while the host is suspended
+const killDateCheckInterval = time.Minute
+
+// ErrKillDateReached is returned by Start once the agent's kill date has passed
+var ErrKillDateReached = errors.New("kill date reached")
+
 // Agent represents the core agent functionality
 type Agent struct {
-	// Configuration
-	config *config.Config
+	// Configuration, replaced as a whole when the server pushes an update
+	config        *config.Config
+	configVersion uint64        // Update in effect, 0 while running the build-time configuration
+	pendingConfig *configUpdate // Update received but not applied yet
+	configLock    sync.RWMutex
 
 	// Protocol implementation to use for communication
-	protocol protocol.Protocol
+	protocol     protocol.Protocol
+	protocolLock sync.RWMutex
+
+	// Failover chain and the index of the endpoint currently in use
+	endpoints     []config.Endpoint
+	endpointIndex int
 
 	// Agent state tracking
 	running      bool
 	runningLock  sync.RWMutex
 	stopChan     chan struct{}
+	stoppedChan  chan struct{} // Closed once shutdown has completed
+	stopOnce     sync.Once     // The kill date and Stop may both ask to shut down
 	healthTicker *time.Ticker
 
-	// Error tracking
+	// Error tracking, the last failure is kept for telemetry after lastError is cleared
 	lastError     error
+	lastFailure   error
+	lastFailureAt time.Time
 	lastErrorLock sync.RWMutex
 
 	// Connection attempt tracking
 	connectionAttempts int
+
+	// Set once the server turned out not to support check-ins, health checks are used instead
+	checkInUnsupported bool
+
+	// Until when the server asked the agent to leave it alone, e.g. while its listener is in maintenance
+	retryAt time.Time
+
+	// Telemetry reported with health checks
+	startedAt      time.Time
+	hasConnected   bool         // Whether a connection ever succeeded, later ones are reconnects
+	reconnects     atomic.Int64 // Connections re-established since starting
+	failedConnects atomic.Int64 // Connection attempts that failed since starting
+	lastRTT        atomic.Int64 // Round trip of the last exchange with the server, in nanoseconds
+	lastReport     time.Time    // When the check-in loop last sent a status record
+
+	// Sequence number of the last message envelope sent
+	sequence atomic.Uint64
+
+	// Key sealing payloads to the team server, nil when built without end-to-end encryption
+	e2eKey *e2e.Key
+
+	// Runs the tasks fetched from the server
+	executor   *executor.Executor
+	fetching   atomic.Bool // Whether a task fetch is running
+	fetchAgain atomic.Bool // Whether another fetch was asked for meanwhile
+}
+
+// configUpdate is a verified configuration update waiting to be applied
+type configUpdate struct {
+	version  uint64
+	settings runtimeconfig.Settings
 }
 
 // NewAgent creates a new agent instance with the specified protocol
@@ -38,6 +105,7 @@ func NewAgent(protocol protocol.Protocol) *Agent {
 		protocol:           protocol,
 		connectionAttempts: 0,
 		stopChan:           make(chan struct{}),
+		stoppedChan:        make(chan struct{}),
 	}
 }
 
@@ -46,26 +114,62 @@ func (a *Agent) Initialize(cfg *config.Config) error {
 	log.Printf("Initializing agent with %s protocol", cfg.Protocol)
 
 	a.config = cfg
+	a.endpoints = cfg.Endpoints()
+	a.endpointIndex = 0
 
-	// Convert from agent config to protocol config
-	protocolCfg := protocol.ProtocolConfig{
-		TargetHost:          cfg.TargetHost,
-		TargetPort:          cfg.TargetPort,
-		AgentUUID:           cfg.AgentUUID,
-		ConnectionTimeout:   cfg.ConnectionTimeout,
-		RequestTimeout:      cfg.RequestTimeout,
-		HealthCheckEndpoint: cfg.HealthCheckEndpoint,
+	// Derive the end-to-end key shared with the team server
+	if cfg.E2EPrivateKey != nil && cfg.E2EServerKey != nil {
+		key, err := e2e.AgentKey(cfg.E2EPrivateKey, cfg.E2EServerKey)
+		if err != nil {
+			return fmt.Errorf("failed to derive end-to-end key: %w", err)
+		}
+		a.e2eKey = key
 	}
 
-	// Initialize the protocol
-	err := a.protocol.Initialize(protocolCfg)
+	// Initialize the protocol for the primary endpoint
+	err := a.protocol.Initialize(a.protocolConfig(a.endpoints[0]))
 	if err != nil {
 		return fmt.Errorf("failed to initialize protocol: %w", err)
 	}
 
+	if len(a.endpoints) > 1 {
+		log.Printf("Failover chain has %d endpoints", len(a.endpoints))
+	}
+
+	// Task types are whatever handlers were registered, results and files go out over the active
+	// protocol
+	a.executor = executor.New(executor.DefaultRegistry, a, a, cfg.TaskConcurrency, cfg.TaskTimeout)
+	log.Printf("Task types: %v", executor.DefaultRegistry.Types())
+
 	return nil
 }
 
+// protocolConfig converts from agent config to protocol config for the given endpoint
+func (a *Agent) protocolConfig(endpoint config.Endpoint) protocol.ProtocolConfig {
+	cfg := a.getConfig()
+	return protocol.ProtocolConfig{
+		TargetHost:           endpoint.Host,
+		TargetPort:           endpoint.Port,
+		AgentUUID:            cfg.AgentUUID,
+		ConnectionTimeout:    cfg.ConnectionTimeout,
+		RequestTimeout:       cfg.RequestTimeout,
+		HealthCheckEndpoint:  cfg.HealthCheckEndpoint,
+		TLSCABundle:          cfg.TLSCABundle,
+		TLSPins:              cfg.TLSPins,
+		TLSClientCert:        cfg.TLSClientCert,
+		TLSClientKey:         cfg.TLSClientKey,
+		ProxyURL:             cfg.ProxyURL,
+		ProxyFromEnvironment: cfg.ProxyFromEnvironment,
+		ProxyUsername:        cfg.ProxyUsername,
+		ProxyPassword:        cfg.ProxyPassword,
+		QUICIdleTimeout:      cfg.QUICIdleTimeout,
+		QUICKeepAlivePeriod:  cfg.QUICKeepAlivePeriod,
+		SigningSecret:        cfg.SigningSecret,
+		Compression:          cfg.Compression,
+		CompressionThreshold: cfg.CompressionThreshold,
+	}
+}
+
 // Start begins agent operations, establishing a connection and starting health checks
 func (a *Agent) Start() error {
 	// Prevent starting twice
@@ -73,8 +177,15 @@ func (a *Agent) Start() error {
 		return fmt.Errorf("agent is already running")
 	}
 
-	log.Printf("Starting agent, targeting %s:%s using %s protocol",
-		a.config.TargetHost, a.config.TargetPort, a.config.Protocol)
+	// Never reach out to the server past the kill date
+	if a.killDateReached() {
+		log.Printf("Kill date %s has passed, not starting", a.getConfig().KillDate.UTC().Format(time.RFC3339))
+		a.removeIdentity()
+		return ErrKillDateReached
+	}
+
+	log.Printf("Starting agent, targeting %s", a.CurrentEndpoint())
+	a.startedAt = time.Now()
 
 	// Attempt initial connection
 	if err := a.connect(); err != nil {
@@ -84,20 +195,107 @@ func (a *Agent) Start() error {
 
 	// Mark as running and set up health checks
 	a.setRunning(true)
-	a.healthTicker = time.NewTicker(a.config.HealthCheckInterval)
+	a.healthTicker = time.NewTicker(a.getConfig().HealthCheckInterval)
 
-	// Start health check goroutine
-	go a.healthCheckLoop()
+	// Start the check-in goroutine, or plain health checks when long-poll is disabled
+	go a.run()
+
+	if !a.getConfig().KillDate.IsZero() {
+		go a.killDateLoop()
+	}
 
 	return nil
 }
 
+// Done returns a channel closed once the agent has stopped, including when it stops on its
+// own because its kill date was reached
+func (a *Agent) Done() <-chan struct{} {
+	return a.stoppedChan
+}
+
+// killDateLoop stops the agent for good once its kill date is reached
+func (a *Agent) killDateLoop() {
+	killDate := a.getConfig().KillDate
+	log.Printf("Agent will stop for good at %s", killDate.UTC().Format(time.RFC3339))
+
+	timer := time.NewTimer(time.Until(killDate))
+	defer timer.Stop()
+	ticker := time.NewTicker(killDateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-ticker.C:
+		case <-a.stopChan:
+			return
+		}
+
+		if a.killDateReached() {
+			log.Println("Kill date reached, shutting down for good")
+			a.removeIdentity()
+			if err := a.stop("kill date reached"); err != nil {
+				log.Printf("Error during shutdown: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// killDateReached reports whether the agent is past its kill date
+func (a *Agent) killDateReached() bool {
+	killDate := a.getConfig().KillDate
+	return !killDate.IsZero() && !time.Now().Before(killDate)
+}
+
+// removeIdentity deletes the agent's identity file once it will never run again
+func (a *Agent) removeIdentity() {
+	if err := identity.RemoveIdentityFile(); err != nil {
+		log.Printf("Failed to remove identity file: %v", err)
+		return
+	}
+	log.Println("Identity file removed")
+}
+
+// run keeps the agent checking in with the server until it stops, holding long-poll check-ins
+// or running periodic health checks as configured. Configuration updates can switch between
+// the two.
+func (a *Agent) run() {
+	for {
+		var switched bool
+		if a.longPolling() {
+			switched = a.checkInLoop()
+		} else {
+			switched = a.healthCheckLoop()
+		}
+		if !switched {
+			return
+		}
+	}
+}
+
+// longPolling reports whether the agent should hold long-poll check-ins
+func (a *Agent) longPolling() bool {
+	return a.getConfig().LongPoll && !a.checkInUnsupported
+}
+
 // Stop gracefully shuts down the agent
 func (a *Agent) Stop() error {
+	return a.stop("agent stopped")
+}
+
+// stop shuts down the agent, telling the server why so it knows the agent exited rather than
+// lost its network. Shutdown only runs once; concurrent callers wait for it to complete.
+func (a *Agent) stop(reason string) error {
 	if !a.isRunning() {
-		return nil // Already stopped
+		return nil // Not started, or already stopped
 	}
+	a.stopOnce.Do(func() { a.shutdown(reason) })
+	return nil
+}
 
+// shutdown stops everything the agent runs and closes the connection
+func (a *Agent) shutdown(reason string) {
 	log.Println("Stopping agent...")
 
 	// Signal health check loop to stop
@@ -108,9 +306,16 @@ func (a *Agent) Stop() error {
 		a.healthTicker.Stop()
 	}
 
+	// Cancel running tasks and get their results out while the connection is still up
+	a.executor.Stop(taskShutdownTimeout)
+
 	// Disconnect from server
-	if a.protocol.IsConnected() {
-		if err := a.protocol.Disconnect(); err != nil {
+	proto := a.getProtocol()
+	if proto.IsConnected() {
+		if err := a.sendShutdownNotice(reason); err != nil {
+			log.Printf("Failed to notify server of shutdown: %v", err)
+		}
+		if err := proto.Disconnect(); err != nil {
 			log.Printf("Error disconnecting: %v", err)
 			// Continue with shutdown anyway
 		}
@@ -119,27 +324,35 @@ func (a *Agent) Stop() error {
 	// Mark as not running
 	a.setRunning(false)
 	log.Println("Agent stopped")
-
-	return nil
+	close(a.stoppedChan)
 }
 
 // connect attempts to establish a connection to the server
 func (a *Agent) connect() error {
-	log.Println("Attempting to connect to server...")
+	log.Printf("Attempting to connect to %s...", a.CurrentEndpoint())
+
+	proto := a.getProtocol()
 
 	// Reset connection attempts if we were previously connected
-	if a.protocol.IsConnected() {
+	if proto.IsConnected() {
 		a.connectionAttempts = 0
 	}
 
 	// Create a context with the connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), a.config.ConnectionTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), a.getConfig().ConnectionTimeout)
 	defer cancel()
 
 	// Attempt to connect
-	err := a.protocol.Connect(ctx)
+	err := proto.Connect(ctx)
 	if err != nil {
+		// Being turned away with a hint is not a failed attempt, it doesn't bring failover closer
+		if a.noteRetryHint(err) {
+			a.setLastError(err)
+			return err
+		}
+
 		a.connectionAttempts++
+		a.failedConnects.Add(1)
 		a.setLastError(err)
 		log.Printf("Connection attempt %d failed: %v", a.connectionAttempts, err)
 		return err
@@ -149,19 +362,86 @@ func (a *Agent) connect() error {
 	log.Println("Successfully connected to server")
 	a.connectionAttempts = 0
 	a.setLastError(nil)
+	if a.hasConnected {
+		a.reconnects.Add(1)
+	}
+	a.hasConnected = true
+
+	// Register on every connection, the server may have restarted or be a different one after failover
+	if err := a.register(); err != nil {
+		log.Printf("Registration failed: %v", err)
+	}
+	if err := a.reportConfig(0, ""); err != nil {
+		log.Printf("Configuration report failed: %v", err)
+	}
+
+	// Let the server know straight away that the agent is back
+	if err := a.reportTelemetry(); err != nil {
+		log.Printf("Telemetry report failed: %v", err)
+	}
+	return nil
+}
+
+// register reports the agent's host metadata to the server
+func (a *Agent) register() error {
+	info := hostinfo.Collect(a.getConfig().BuildTime, a.getConfig().BuildProtocol)
+	if killDate := a.getConfig().KillDate; !killDate.IsZero() {
+		info.KillDate = killDate.UTC().Format(time.RFC3339)
+	}
+	payload, err := info.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode host info: %w", err)
+	}
+
+	msg := envelope.New(envelope.TypeRegister, a.getConfig().AgentUUID, a.nextSequence(), payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.getConfig().RequestTimeout)
+	defer cancel()
+
+	reply, err := protocol.Exchange(ctx, a.getProtocol(), "/register", msg, a.e2eKey)
+	if err != nil {
+		return err
+	}
+	if reply.Type != envelope.TypeRegistered {
+		return fmt.Errorf("expected a registered reply, got %s", reply.Type)
+	}
+
+	log.Printf("Registered with server as %s@%s (%s/%s)", info.Username, info.Hostname, info.OS, info.Arch)
 	return nil
 }
 
 // reconnect implements the reconnection logic with exponential backoff
 func (a *Agent) reconnect() {
-	// Check if we've exceeded max attempts
-	if a.config.ReconnectAttempts > 0 && a.connectionAttempts >= a.config.ReconnectAttempts {
-		log.Printf("Exceeded maximum reconnection attempts (%d), giving up", a.config.ReconnectAttempts)
+	// The server's hint takes precedence over our own backoff
+	if wait := a.retryDelay(); wait > 0 {
+		log.Printf("Server asked to retry after %v, waiting before reconnecting", wait.Round(time.Second))
+		if a.sleep(wait) {
+			_ = a.connect()
+		}
+		return
+	}
+
+	// Check if we've exceeded max attempts against the current endpoint
+	if a.getConfig().ReconnectAttempts > 0 && a.connectionAttempts >= a.getConfig().ReconnectAttempts {
+		if len(a.endpoints) < 2 {
+			log.Printf("Exceeded maximum reconnection attempts (%d), giving up", a.getConfig().ReconnectAttempts)
+			return
+		}
+
+		log.Printf("Exceeded maximum reconnection attempts (%d) against %s, failing over",
+			a.getConfig().ReconnectAttempts, a.CurrentEndpoint())
+		if err := a.failover(); err != nil {
+			log.Printf("Failover failed: %v", err)
+			return
+		}
+
+		// Try the new endpoint straight away
+		_ = a.connect()
 		return
 	}
 
 	// Calculate delay with exponential backoff and jitter
-	delay := a.config.ReconnectDelay
+	delay := a.getConfig().ReconnectDelay
 	for i := 0; i < a.connectionAttempts && i < 8; i++ {
 		delay *= 2
 	}
@@ -189,42 +469,250 @@ func (a *Agent) reconnect() {
 	}
 }
 
-// healthCheckLoop runs periodic health checks in a separate goroutine
-func (a *Agent) healthCheckLoop() {
-	log.Printf("Starting health check loop with interval: %v", a.config.HealthCheckInterval)
+// healthCheckLoop runs periodic health checks until the agent stops, returning false, or a
+// configuration update switches it to long-poll check-ins, returning true
+func (a *Agent) healthCheckLoop() bool {
+	log.Printf("Starting health check loop with interval: %v", a.getConfig().HealthCheckInterval)
 
 	for {
 		select {
 		case <-a.healthTicker.C:
-			// Skip if not running
-			if !a.isRunning() {
+			if a.applyConfigUpdate() && a.longPolling() {
+				log.Println("Switching to long-poll check-ins")
+				return true
+			}
+
+			// Skip if not running, or while the server asked to be left alone
+			if !a.isRunning() || a.retryDelay() > 0 {
 				continue
 			}
 
 			// If not connected, try to reconnect
-			if !a.protocol.IsConnected() {
+			if !a.getProtocol().IsConnected() {
 				a.reconnect()
 				continue
 			}
 
 			// Perform health check
-			ctx, cancel := context.WithTimeout(context.Background(), a.config.RequestTimeout)
-			err := a.protocol.PerformHealthCheck(ctx)
+			ctx, cancel := context.WithTimeout(context.Background(), a.getConfig().RequestTimeout)
+			started := time.Now()
+			err := a.getProtocol().PerformHealthCheck(ctx)
 			cancel()
 
 			if err != nil {
 				log.Printf("Health check failed: %v", err)
-				// If health check fails, we need to reconnect
-				a.reconnect()
+				a.setLastError(err)
+				// The server is reachable when it hints at a delay, only reconnect when it isn't
+				if !a.noteRetryHint(err) {
+					a.reconnect()
+				}
 			} else {
 				log.Printf("Health check successful")
+				a.lastRTT.Store(int64(time.Since(started)))
+
+				// Every health check carries the agent's status record, and its acknowledgement
+				// any configuration update waiting for the agent
+				if err := a.reportTelemetry(); err != nil {
+					log.Printf("Telemetry report failed: %v", err)
+					a.noteRetryHint(err)
+				}
+				if a.applyConfigUpdate() && a.longPolling() {
+					log.Println("Switching to long-poll check-ins")
+					return true
+				}
 			}
 
 		case <-a.stopChan:
 			// Agent is stopping
 			log.Println("Health check loop terminating")
+			return false
+		}
+	}
+}
+
+// checkInLoop keeps a long-poll check-in open so the server can hand over work as soon as it has some.
+// The held check-in doubles as the health check. It returns false once the agent stops, and true
+// when the agent should fall back to periodic health checks, because the server doesn't support
+// check-ins or a configuration update turned them off.
+func (a *Agent) checkInLoop() bool {
+	log.Printf("Starting check-in loop, held up to %v per check-in", a.getConfig().LongPollTimeout)
+
+	for {
+		select {
+		case <-a.stopChan:
+			log.Println("Check-in loop terminating")
+			return false
+		default:
+		}
+
+		if a.applyConfigUpdate() && !a.longPolling() {
+			log.Println("Switching to periodic health checks")
+			return true
+		}
+
+		// Leave the server alone for as long as it asked
+		if wait := a.retryDelay(); wait > 0 {
+			if !a.sleep(wait) {
+				log.Println("Check-in loop terminating")
+				return false
+			}
+			continue
+		}
+
+		// If not connected, try to reconnect
+		if !a.getProtocol().IsConnected() {
+			a.reconnect()
+
+			// Pace retries when the reconnect gave up or failed without waiting
+			if !a.getProtocol().IsConnected() && !a.sleep(a.getConfig().HealthCheckInterval) {
+				log.Println("Check-in loop terminating")
+				return false
+			}
+			continue
+		}
+
+		started := time.Now()
+		delivered, err := a.checkIn()
+
+		switch {
+		case errors.Is(err, protocol.ErrCheckInUnsupported):
+			log.Println("Server does not support check-ins, falling back to periodic health checks")
+			a.checkInUnsupported = true
+			return true
+		case err != nil:
+			if a.isStopping() {
+				continue
+			}
+			log.Printf("Check-in failed: %v", err)
+			a.setLastError(err)
+			if !a.noteRetryHint(err) {
+				a.reconnect()
+			}
+			continue
+		case delivered == 0 && time.Since(started) < minCheckInInterval:
+			// Never spin if the server answers check-ins without holding them
+			if !a.sleep(minCheckInInterval) {
+				log.Println("Check-in loop terminating")
+				return false
+			}
+		}
+
+		// Check-ins stand in for health checks, so the status record goes out on the health check interval
+		if time.Since(a.lastReport) >= a.getConfig().HealthCheckInterval {
+			if err := a.reportTelemetry(); err != nil {
+				log.Printf("Telemetry report failed: %v", err)
+				a.noteRetryHint(err)
+			}
+		}
+	}
+}
+
+// checkIn performs a single held check-in, cancelled early if the agent stops.
+// It returns the number of work items delivered.
+func (a *Agent) checkIn() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.getConfig().LongPollTimeout+a.getConfig().RequestTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-a.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	delivered := 0
+	err := a.getProtocol().CheckIn(ctx, a.getConfig().CheckInEndpoint, a.getConfig().LongPollTimeout, func(work protocol.Work) {
+		delivered++
+		a.handleWork(work)
+
+		// Let go of the check-in so a configuration update is applied straight away
+		if a.hasConfigUpdate() {
+			cancel()
+		}
+	})
+	if err != nil && ctx.Err() != nil && a.hasConfigUpdate() {
+		err = nil
+	}
+	return delivered, err
+}
+
+// handleWork dispatches work delivered on a check-in
+func (a *Agent) handleWork(work protocol.Work) {
+	log.Printf("Received %s work %s", work.Type, work.ID)
+
+	if work.Sealed {
+		if a.e2eKey == nil {
+			log.Printf("Dropping sealed work %s: no end-to-end key", work.ID)
+			return
+		}
+		payload, err := a.e2eKey.Open(e2e.ToAgent, work.Payload)
+		if err != nil {
+			log.Printf("Dropping work %s: %v", work.ID, err)
+			return
+		}
+		work.Payload = payload
+		work.Sealed = false
+	}
+
+	switch work.Type {
+	case "ping":
+		// Answer on a separate request so the check-in stream is not held up
+		go func() {
+			if err := a.TestConnection(); err != nil {
+				log.Printf("Ping for work %s failed: %v", work.ID, err)
+			}
+		}()
+	case "config":
+		a.queueConfigUpdate(work.Payload)
+	case "tasks":
+		a.fetchTasks()
+	case "cancel":
+		id, err := decodeCancelWork(work.Payload)
+		if err != nil {
+			log.Printf("Dropping work %s: %v", work.ID, err)
 			return
 		}
+		a.cancelTasks([]string{id})
+	default:
+		log.Printf("Ignoring unknown work type: %s", work.Type)
+	}
+}
+
+// noteRetryHint remembers when the server asked the agent to come back, returning whether err
+// carried such a hint
+func (a *Agent) noteRetryHint(err error) bool {
+	delay, ok := protocol.RetryAfter(err)
+	if !ok {
+		return false
+	}
+	a.retryAt = time.Now().Add(delay)
+	log.Printf("Server asked to be left alone for %v", delay)
+	return true
+}
+
+// retryDelay returns what is left of the delay the server asked for, zero or less once over
+func (a *Agent) retryDelay() time.Duration {
+	return time.Until(a.retryAt)
+}
+
+// isStopping reports whether Stop has been called
+func (a *Agent) isStopping() bool {
+	select {
+	case <-a.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the given duration, returning false if the agent was stopped meanwhile
+func (a *Agent) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-a.stopChan:
+		return false
 	}
 }
 
@@ -234,19 +722,65 @@ func (a *Agent) SendRequest(endpoint string, payload []byte) ([]byte, error) {
 		return nil, fmt.Errorf("agent is not running")
 	}
 
-	if !a.protocol.IsConnected() {
+	proto := a.getProtocol()
+	if !proto.IsConnected() {
 		return nil, fmt.Errorf("agent is not connected to server")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.config.RequestTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), a.getConfig().RequestTimeout)
 	defer cancel()
 
-	return a.protocol.SendRequest(ctx, endpoint, payload)
+	return proto.SendRequest(ctx, endpoint, payload)
 }
 
 // IsConnected returns whether the agent is currently connected to the server
 func (a *Agent) IsConnected() bool {
-	return a.isRunning() && a.protocol.IsConnected()
+	return a.isRunning() && a.getProtocol().IsConnected()
+}
+
+// CurrentEndpoint returns the endpoint the agent is currently using
+func (a *Agent) CurrentEndpoint() config.Endpoint {
+	a.protocolLock.RLock()
+	defer a.protocolLock.RUnlock()
+	return a.endpoints[a.endpointIndex]
+}
+
+// failover switches to the next endpoint in the chain, wrapping around after the last one
+// Endpoints whose protocol cannot be initialized are skipped
+func (a *Agent) failover() error {
+	a.protocolLock.RLock()
+	current := a.endpointIndex
+	a.protocolLock.RUnlock()
+
+	for step := 1; step < len(a.endpoints); step++ {
+		next := (current + step) % len(a.endpoints)
+		endpoint := a.endpoints[next]
+
+		proto, err := protocol.NewProtocol(string(endpoint.Protocol))
+		if err == nil {
+			err = proto.Initialize(a.protocolConfig(endpoint))
+		}
+		if err != nil {
+			log.Printf("Skipping endpoint %s: %v", endpoint, err)
+			continue
+		}
+
+		// Release the old protocol's connections before swapping
+		if err := a.getProtocol().Disconnect(); err != nil {
+			log.Printf("Error disconnecting from %s: %v", a.CurrentEndpoint(), err)
+		}
+
+		a.protocolLock.Lock()
+		a.protocol = proto
+		a.endpointIndex = next
+		a.protocolLock.Unlock()
+
+		a.connectionAttempts = 0
+		log.Printf("Switched to endpoint %d/%d: %s", next+1, len(a.endpoints), endpoint)
+		return nil
+	}
+
+	return fmt.Errorf("no other endpoint in the failover chain could be initialized")
 }
 
 // GetLastError returns the last error encountered by the agent
@@ -270,11 +804,29 @@ func (a *Agent) setRunning(running bool) {
 	a.running = running
 }
 
+// Helper method to safely get the configuration in effect
+func (a *Agent) getConfig() *config.Config {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.config
+}
+
+// Helper method to safely get the active protocol
+func (a *Agent) getProtocol() protocol.Protocol {
+	a.protocolLock.RLock()
+	defer a.protocolLock.RUnlock()
+	return a.protocol
+}
+
 // Helper method to safely set the last error
 func (a *Agent) setLastError(err error) {
 	a.lastErrorLock.Lock()
 	defer a.lastErrorLock.Unlock()
 	a.lastError = err
+	if err != nil {
+		a.lastFailure = err
+		a.lastFailureAt = time.Now()
+	}
 }
 
 // TestConnection sends a simple request to the server to verify connectivity
@@ -283,12 +835,13 @@ func (a *Agent) TestConnection() error {
 		return fmt.Errorf("agent is not running")
 	}
 
-	if !a.protocol.IsConnected() {
+	proto := a.getProtocol()
+	if !proto.IsConnected() {
 		return fmt.Errorf("agent is not connected to server")
 	}
 
-	// Create a simple test payload
-	payload := []byte("ping")
+	// Create a simple test message
+	ping := envelope.New(envelope.TypePing, a.getConfig().AgentUUID, a.nextSequence(), []byte("ping"))
 
 	log.Println("Sending test request to server...")
 
@@ -296,12 +849,270 @@ func (a *Agent) TestConnection() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Send the request to a test endpoint
-	response, err := a.protocol.SendRequest(ctx, "/ping", payload)
+	// Send the message to a test endpoint
+	reply, err := protocol.Exchange(ctx, proto, "/ping", ping, a.e2eKey)
 	if err != nil {
 		return fmt.Errorf("test request failed: %w", err)
 	}
+	if reply.Type != envelope.TypePong {
+		return fmt.Errorf("test request failed: expected a pong, got %s", reply.Type)
+	}
+
+	log.Printf("Received response from server: %s (sequence %d)", string(reply.Payload), reply.Sequence)
+	return nil
+}
+
+// nextSequence returns the sequence number for the next message envelope
+func (a *Agent) nextSequence() uint64 {
+	return a.sequence.Add(1)
+}
+
+// status builds the agent's status record, reason being set on shutdown notices only
+func (a *Agent) status(reason string) telemetry.Status {
+	now := time.Now()
+	status := telemetry.Status{
+		AgentTime:                 now,
+		StartedAt:                 a.startedAt,
+		UptimeSeconds:             int64(now.Sub(a.startedAt).Seconds()),
+		Protocol:                  a.getProtocol().Name(),
+		Reconnects:                int(a.reconnects.Load()),
+		FailedConnects:            int(a.failedConnects.Load()),
+		RTTMillis:                 time.Duration(a.lastRTT.Load()).Milliseconds(),
+		HealthCheckIntervalMillis: a.getConfig().HealthCheckInterval.Milliseconds(),
+		LongPoll:                  a.getConfig().LongPoll,
+		LongPollTimeoutMillis:     a.getConfig().LongPollTimeout.Milliseconds(),
+		ReconnectDelayMillis:      a.getConfig().ReconnectDelay.Milliseconds(),
+		Reason:                    reason,
+	}
+
+	a.lastErrorLock.RLock()
+	if a.lastFailure != nil {
+		failedAt := a.lastFailureAt
+		status.LastError = a.lastFailure.Error()
+		status.LastErrorAt = &failedAt
+	}
+	a.lastErrorLock.RUnlock()
+
+	return status
+}
+
+// reportTelemetry sends the agent's status record to the server. Nothing is reported once the
+// agent is stopping, the shutdown notice is the last word.
+func (a *Agent) reportTelemetry() error {
+	if a.isStopping() {
+		return nil
+	}
+	a.lastReport = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.getConfig().RequestTimeout)
+	defer cancel()
+
+	return a.sendStatus(ctx, envelope.TypeTelemetry, envelope.TypeTelemetryAck, "")
+}
+
+// sendShutdownNotice tells the server the agent is shutting down on purpose
+func (a *Agent) sendShutdownNotice(reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownNoticeTimeout)
+	defer cancel()
+
+	if err := a.sendStatus(ctx, envelope.TypeShutdown, envelope.TypeShutdownAck, reason); err != nil {
+		return err
+	}
+	log.Println("Server notified of shutdown")
+	return nil
+}
+
+// sendStatus sends a status record as a message of msgType and checks the server acknowledged it,
+// warning when the server finds this host's clock off
+func (a *Agent) sendStatus(ctx context.Context, msgType envelope.MessageType, ackType envelope.MessageType, reason string) error {
+	payload, err := a.status(reason).Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode status record: %w", err)
+	}
+	msg := envelope.New(msgType, a.getConfig().AgentUUID, a.nextSequence(), payload)
+
+	sent := time.Now()
+	reply, err := protocol.Exchange(ctx, a.getProtocol(), "/telemetry", msg, a.e2eKey)
+	if err != nil {
+		return err
+	}
+	a.lastRTT.Store(int64(time.Since(sent)))
+
+	if reply.Type != ackType {
+		return fmt.Errorf("expected a %s reply, got %s", ackType, reply.Type)
+	}
+
+	var ack telemetry.Ack
+	if err := json.Unmarshal(reply.Payload, &ack); err != nil {
+		return fmt.Errorf("invalid %s reply: %w", ackType, err)
+	}
+	if skew := time.Duration(ack.ClockSkewMs) * time.Millisecond; skew > telemetry.SkewThreshold || skew < -telemetry.SkewThreshold {
+		log.Printf("WARNING: Server reports this host's clock is off by %v", skew)
+	}
+	if len(ack.ConfigUpdate) > 0 {
+		a.queueConfigUpdate(ack.ConfigUpdate)
+	}
+	if len(ack.CancelTasks) > 0 {
+		a.cancelTasks(ack.CancelTasks)
+	}
+	if ack.PendingTasks > 0 {
+		a.fetchTasks()
+	}
+
+	// The server is reachable, results that could not be posted earlier can go out
+	if msgType == envelope.TypeTelemetry {
+		a.executor.ScheduleFlush()
+	}
+	return nil
+}
+
+// queueConfigUpdate verifies a configuration update from the server and keeps it until the
+// check-in or health check loop applies it. Updates that are not newer than the configuration
+// in effect are ignored, so an update can't be replayed over a later one.
+func (a *Agent) queueConfigUpdate(payload []byte) {
+	cfg := a.getConfig()
+	if cfg.SigningSecret == nil {
+		log.Println("Ignoring configuration update: built without a signing secret to verify it")
+		return
+	}
+
+	update, err := runtimeconfig.UnmarshalUpdate(payload)
+	if err != nil {
+		log.Printf("Ignoring configuration update: %v", err)
+		return
+	}
+	settings, err := update.Verify(cfg.SigningSecret, cfg.AgentUUID)
+	if err != nil {
+		log.Printf("Ignoring configuration update %d: %v", update.Version, err)
+		return
+	}
+
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+	if update.Version <= a.configVersion || (a.pendingConfig != nil && update.Version <= a.pendingConfig.version) {
+		return // Already applied or queued, updates are delivered until acknowledged
+	}
+	a.pendingConfig = &configUpdate{version: update.Version, settings: settings}
+	log.Printf("Received configuration update %d", update.Version)
+}
+
+// hasConfigUpdate reports whether a configuration update is waiting to be applied
+func (a *Agent) hasConfigUpdate() bool {
+	a.configLock.RLock()
+	defer a.configLock.RUnlock()
+	return a.pendingConfig != nil
+}
+
+// applyConfigUpdate applies the configuration update waiting to be applied, if any, and reports
+// the outcome to the server. An update that doesn't validate is refused as a whole and the
+// configuration in effect is kept. It returns whether the configuration changed.
+func (a *Agent) applyConfigUpdate() bool {
+	a.configLock.Lock()
+	update := a.pendingConfig
+	a.pendingConfig = nil
+	current := a.config
+	a.configLock.Unlock()
+
+	if update == nil {
+		return false
+	}
 
-	log.Printf("Received response from server: %s", string(response))
+	updated, err := current.WithSettings(update.settings)
+	if err != nil {
+		log.Printf("Refusing configuration update %d: %v", update.version, err)
+		if err := a.reportConfig(update.version, err.Error()); err != nil {
+			log.Printf("Configuration report failed: %v", err)
+		}
+		return false
+	}
+
+	a.configLock.Lock()
+	a.config = updated
+	a.configVersion = update.version
+	a.configLock.Unlock()
+
+	a.healthTicker.Reset(updated.HealthCheckInterval)
+	log.Printf("Applied configuration update %d", update.version)
+
+	// Acknowledge over the transport the update came in on, before it is replaced
+	if err := a.reportConfig(0, ""); err != nil {
+		log.Printf("Configuration report failed: %v", err)
+	}
+
+	endpointsChanged := !slices.Equal(current.Endpoints(), updated.Endpoints())
+	if endpointsChanged || current.ConnectionTimeout != updated.ConnectionTimeout || current.RequestTimeout != updated.RequestTimeout {
+		a.rebuildTransport(endpointsChanged)
+	}
+	return true
+}
+
+// rebuildTransport replaces the protocol once a configuration update changed the endpoints or
+// timeouts it was initialized with, and reconnects. A changed failover chain is started over
+// from the primary target.
+func (a *Agent) rebuildTransport(endpointsChanged bool) {
+	endpoints := a.getConfig().Endpoints()
+
+	a.protocolLock.RLock()
+	index := a.endpointIndex
+	a.protocolLock.RUnlock()
+	if endpointsChanged {
+		index = 0
+	}
+	endpoint := endpoints[index]
+
+	proto, err := protocol.NewProtocol(string(endpoint.Protocol))
+	if err == nil {
+		err = proto.Initialize(a.protocolConfig(endpoint))
+	}
+	if err != nil {
+		log.Printf("Keeping the current connection, %s could not be initialized: %v", endpoint, err)
+		return
+	}
+
+	if err := a.getProtocol().Disconnect(); err != nil {
+		log.Printf("Error disconnecting from %s: %v", a.CurrentEndpoint(), err)
+	}
+
+	a.protocolLock.Lock()
+	a.protocol = proto
+	a.endpoints = endpoints
+	a.endpointIndex = index
+	a.protocolLock.Unlock()
+
+	a.connectionAttempts = 0
+	log.Printf("Reconnecting to %s with the new configuration", endpoint)
+	_ = a.connect()
+}
+
+// reportConfig tells the server which configuration is in effect, and which update was refused
+// and why when rejectedVersion is set
+func (a *Agent) reportConfig(rejectedVersion uint64, reason string) error {
+	a.configLock.RLock()
+	report := runtimeconfig.Report{
+		Version:         a.configVersion,
+		Settings:        a.config.Settings(),
+		RejectedVersion: rejectedVersion,
+		Error:           reason,
+	}
+	agentUUID := a.config.AgentUUID
+	requestTimeout := a.config.RequestTimeout
+	a.configLock.RUnlock()
+
+	payload, err := report.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode configuration report: %w", err)
+	}
+	msg := envelope.New(envelope.TypeConfigReport, agentUUID, a.nextSequence(), payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	reply, err := protocol.Exchange(ctx, a.getProtocol(), "/config", msg, a.e2eKey)
+	if err != nil {
+		return err
+	}
+	if reply.Type != envelope.TypeConfigReportAck {
+		return fmt.Errorf("expected a config report ack, got %s", reply.Type)
+	}
 	return nil
 }
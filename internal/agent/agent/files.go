@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"firestarter/internal/envelope"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// transferRetryDelay is the wait before a file transfer that lost the server resumes, doubled
+	// after every failure up to transferMaxDelay. Transfers keep resuming until their task's
+	// timeout, the chunks already moved are never sent again.
+	transferRetryDelay = 5 * time.Second
+	transferMaxDelay   = time.Minute
+)
+
+// SendFile sends a file to the server's file store for a download task. The file is read chunk
+// by chunk, never held in memory as a whole.
+func (a *Agent) SendFile(ctx context.Context, taskID string, path string, chunkSize int) (string, transfer.Manifest, error) {
+	if chunkSize == 0 {
+		chunkSize = transfer.DefaultChunkSize
+	}
+	if err := transfer.ValidateChunkSize(chunkSize); err != nil {
+		return "", transfer.Manifest{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", transfer.Manifest{}, err
+	}
+	defer file.Close()
+
+	manifest, info, err := describeFile(file, chunkSize)
+	if err != nil {
+		return "", transfer.Manifest{}, err
+	}
+
+	what := fmt.Sprintf("file %s", path)
+	delay := transferRetryDelay
+	for {
+		offer := transfer.Offer{TaskID: taskID, Path: path, Manifest: manifest}
+		payload, err := offer.Marshal()
+		if err != nil {
+			return "", transfer.Manifest{}, fmt.Errorf("failed to encode file offer: %w", err)
+		}
+
+		status, err := a.exchangeStatus(ctx, fileRoute.endpoint, envelope.TypeFileOffer, fileRoute.statusType, payload)
+		if err == nil {
+			err = a.sendChunks(ctx, fileRoute, manifest, status, file, what)
+		}
+		if err == nil {
+			log.Printf("Sent the %s as file %s", what, status.ID)
+			return status.ID, manifest, nil
+		}
+		if errors.Is(err, errTransferDropped) || ctx.Err() != nil || a.isStopping() {
+			return "", transfer.Manifest{}, err
+		}
+
+		log.Printf("Sending the %s failed, resuming in %v: %v", what, delay, err)
+		if !a.wait(ctx, delay) {
+			return "", transfer.Manifest{}, err
+		}
+		delay = min(2*delay, transferMaxDelay)
+
+		// The server checks the whole against the hash it was offered, a file that changed
+		// meanwhile is offered anew
+		if current, statErr := file.Stat(); statErr == nil && (current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime())) {
+			log.Printf("The %s changed while it was being sent, starting over", what)
+			if manifest, info, err = describeFile(file, chunkSize); err != nil {
+				return "", transfer.Manifest{}, err
+			}
+		}
+	}
+}
+
+// ReceiveFile writes a file staged in the server's file store for an upload task. Chunks are
+// written to a partial file next to the destination, so an interrupted upload resumes from it,
+// which is renamed into place once the whole matches its hash.
+func (a *Agent) ReceiveFile(ctx context.Context, taskID string, args transfer.UploadArgs) error {
+	if args.FileID == "" || args.Path == "" {
+		return fmt.Errorf("no file ID or destination path given")
+	}
+	if err := args.Validate(); err != nil {
+		return err
+	}
+
+	partialPath := fmt.Sprintf("%s.%.8s.part", args.Path, args.FileID)
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Only whole chunks are kept from an earlier attempt
+	next := 0
+	if info, err := file.Stat(); err == nil && info.Size() <= args.Size {
+		next = int(info.Size() / int64(args.ChunkSize))
+	}
+	if err := file.Truncate(args.ChunkOffset(next)); err != nil {
+		return err
+	}
+
+	what := fmt.Sprintf("file %s", args.Path)
+	chunks := args.Chunks()
+	if next > 0 {
+		log.Printf("Resuming the %s, %d/%d chunk(s) already received", what, next, chunks)
+	} else {
+		log.Printf("Receiving the %s, %d bytes in %d chunk(s)", what, args.Size, chunks)
+	}
+
+	delay := transferRetryDelay
+	for index := next; index < chunks; {
+		if a.isStopping() {
+			return fmt.Errorf("%s interrupted by shutdown", what)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk, err := a.fetchChunk(ctx, transfer.Fetch{TaskID: taskID, FileID: args.FileID, Index: index})
+		if err == nil && (chunk.Index != index || int64(len(chunk.Data)) != args.ChunkLen(index)) {
+			err = fmt.Errorf("server sent %d bytes for chunk %d, expected %d bytes for chunk %d", len(chunk.Data), chunk.Index, args.ChunkLen(index), index)
+		}
+		if err != nil && (errors.Is(err, errTransferDropped) || ctx.Err() != nil || a.isStopping()) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Chunk %d/%d of the %s failed, resuming in %v: %v", index+1, chunks, what, delay, err)
+			if !a.wait(ctx, delay) {
+				return err
+			}
+			delay = min(2*delay, transferMaxDelay)
+			continue
+		}
+
+		if _, err := file.WriteAt(chunk.Data, args.ChunkOffset(index)); err != nil {
+			return fmt.Errorf("failed to write chunk %d/%d of the %s: %w", index+1, chunks, what, err)
+		}
+		delay = transferRetryDelay
+		index++
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum, size, err := transfer.SumReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to verify the %s: %w", what, err)
+	}
+	if sum != args.SHA256 || size != args.Size {
+		_ = os.Remove(partialPath)
+		return fmt.Errorf("received %s does not match its hash", what)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(partialPath, args.Path); err != nil {
+		return err
+	}
+	log.Printf("Received the %s", what)
+	return nil
+}
+
+// fetchChunk asks the server for a chunk of a staged file, retrying with a growing delay
+func (a *Agent) fetchChunk(ctx context.Context, fetch transfer.Fetch) (transfer.Chunk, error) {
+	payload, err := fetch.Marshal()
+	if err != nil {
+		return transfer.Chunk{}, fmt.Errorf("failed to encode file fetch: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		chunk, err := a.exchangeFetch(ctx, payload)
+		if err == nil || errors.Is(err, errTransferDropped) || attempt == chunkAttempts {
+			return chunk, err
+		}
+
+		log.Printf("Chunk %d of %s failed (attempt %d/%d): %v", fetch.Index+1, fetch.FileID, attempt, chunkAttempts, err)
+		if !a.wait(ctx, time.Duration(attempt)*chunkRetryDelay) {
+			return transfer.Chunk{}, err
+		}
+	}
+}
+
+// exchangeFetch sends a file fetch, the server answers with the chunk, or with a status when it
+// no longer expects the agent to fetch the file
+func (a *Agent) exchangeFetch(ctx context.Context, payload []byte) (transfer.Chunk, error) {
+	reply, err := a.send(ctx, fileRoute.endpoint, envelope.TypeFileFetch, payload)
+	if err != nil {
+		return transfer.Chunk{}, err
+	}
+
+	switch reply.Type {
+	case envelope.TypeFileData:
+		return transfer.UnmarshalChunk(reply.Payload)
+	case envelope.TypeFileStatus:
+		return transfer.Chunk{}, errTransferDropped
+	default:
+		return transfer.Chunk{}, fmt.Errorf("expected a %s reply, got %s", envelope.TypeFileData, reply.Type)
+	}
+}
+
+// describeFile hashes a regular file from its start, leaving it positioned anywhere
+func describeFile(file *os.File, chunkSize int) (transfer.Manifest, os.FileInfo, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return transfer.Manifest{}, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return transfer.Manifest{}, nil, fmt.Errorf("%s is not a regular file", info.Name())
+	}
+	if info.Size() > transfer.MaxSize {
+		return transfer.Manifest{}, nil, fmt.Errorf("%s exceeds the maximum of %d bytes", info.Name(), int64(transfer.MaxSize))
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return transfer.Manifest{}, nil, err
+	}
+	sum, size, err := transfer.SumReader(file)
+	if err != nil {
+		return transfer.Manifest{}, nil, fmt.Errorf("failed to hash %s: %w", info.Name(), err)
+	}
+	return transfer.Manifest{Size: size, ChunkSize: chunkSize, SHA256: sum}, info, nil
+}
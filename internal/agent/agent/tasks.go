@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/agent/protocol"
+	"firestarter/internal/envelope"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// taskShutdownTimeout bounds how long shutting down waits for cancelled tasks to wrap up
+const taskShutdownTimeout = 5 * time.Second
+
+// fetchTasks fetches the tasks waiting for the agent in the background and hands them to the
+// executor. A fetch asked for while one is running is folded into it.
+func (a *Agent) fetchTasks() {
+	if a.isStopping() {
+		return
+	}
+	a.fetchAgain.Store(true)
+	if !a.fetching.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer a.fetching.Store(false)
+
+		for a.fetchAgain.Swap(false) {
+			for {
+				more, err := a.fetchTaskBatch()
+				if err != nil {
+					log.Printf("Task fetch failed: %v", err)
+					return
+				}
+				if !more {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// fetchTaskBatch fetches a batch of tasks, acknowledges them, and submits those the server
+// confirmed to the executor. It returns whether more tasks may be waiting.
+func (a *Agent) fetchTaskBatch() (bool, error) {
+	request := tasking.FetchRequest{Max: tasking.DefaultFetch}
+	payload, err := request.Marshal()
+	if err != nil {
+		return false, fmt.Errorf("failed to encode task fetch: %w", err)
+	}
+
+	reply, err := a.exchangeTasks(envelope.TypeTaskFetch, envelope.TypeTaskBatch, payload)
+	if err != nil {
+		return false, err
+	}
+	batch, err := tasking.UnmarshalBatch(reply.Payload)
+	if err != nil {
+		return false, err
+	}
+	if len(batch.Tasks) == 0 {
+		return false, nil
+	}
+
+	// Only run what the server confirms, tasks may have expired or been cancelled meanwhile
+	ack := tasking.Ack{IDs: make([]string, 0, len(batch.Tasks))}
+	for _, task := range batch.Tasks {
+		ack.IDs = append(ack.IDs, task.ID)
+	}
+	payload, err = ack.Marshal()
+	if err != nil {
+		return false, fmt.Errorf("failed to encode task acknowledgement: %w", err)
+	}
+	reply, err = a.exchangeTasks(envelope.TypeTaskAck, envelope.TypeTaskReceipt, payload)
+	if err != nil {
+		return false, err
+	}
+	receipt, err := tasking.UnmarshalReceipt(reply.Payload)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Received %d task(s), %d confirmed", len(batch.Tasks), len(receipt.IDs))
+	for _, task := range batch.Tasks {
+		if !slices.Contains(receipt.IDs, task.ID) {
+			continue
+		}
+		a.executor.Submit(executor.Task{
+			ID:      task.ID,
+			Type:    task.Type,
+			Args:    task.Args,
+			Attempt: task.Attempt,
+		}, time.Duration(task.TimeoutSeconds)*time.Second)
+	}
+	return len(batch.Tasks) >= request.Max, nil
+}
+
+// ReportTask posts a task's progress or outcome to the server over the active protocol, with the
+// output waiting in the spool file at spool, if any. Outputs too large for a single request are
+// uploaded in chunks read from the file first. Results the server no longer tracks are dropped,
+// retrying them would not change anything.
+func (a *Agent) ReportTask(result tasking.Result, spool string) error {
+	if !a.getProtocol().IsConnected() {
+		return fmt.Errorf("agent is not connected to server")
+	}
+
+	if spool != "" {
+		file, err := os.Open(spool)
+		if err != nil {
+			return fmt.Errorf("failed to open the output of task %s: %w", result.ID, err)
+		}
+		defer file.Close()
+
+		manifest, _, err := describeFile(file, transfer.DefaultChunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to read the output of task %s: %w", result.ID, err)
+		}
+		if manifest.Size > transfer.DefaultChunkSize {
+			err := a.uploadOutput(result.ID, manifest, file)
+			if errors.Is(err, errTransferDropped) {
+				log.Printf("Server no longer tracks task %s, dropping its output", result.ID)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			result.Chunked = true
+		} else {
+			result.Output = make([]byte, manifest.Size)
+			if _, err := file.ReadAt(result.Output, 0); err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("failed to read the output of task %s: %w", result.ID, err)
+			}
+		}
+	}
+
+	payload, err := result.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode task result: %w", err)
+	}
+	reply, err := a.exchangeTasks(envelope.TypeTaskResult, envelope.TypeTaskReceipt, payload)
+	if err != nil {
+		return err
+	}
+	receipt, err := tasking.UnmarshalReceipt(reply.Payload)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(receipt.IDs, result.ID) {
+		log.Printf("Server no longer tracks task %s, dropping its result", result.ID)
+	}
+	return nil
+}
+
+// cancelTasks stops tasks an operator cancelled. Tasks the agent doesn't know, e.g. because it
+// restarted while running them, are reported failed so the server stops asking.
+func (a *Agent) cancelTasks(ids []string) {
+	for _, id := range ids {
+		if a.executor.Cancel(id, "cancelled by the operator") {
+			log.Printf("Cancelling task %s", id)
+			continue
+		}
+
+		go func(id string) {
+			result := tasking.Result{ID: id, State: tasking.StateFailed, Error: "cancelled, the agent was not running it"}
+			if err := a.ReportTask(result, ""); err != nil {
+				log.Printf("Failed to report cancelled task %s: %v", id, err)
+			}
+		}(id)
+	}
+}
+
+// exchangeTasks sends a tasking message to the server and checks the reply type
+func (a *Agent) exchangeTasks(msgType envelope.MessageType, replyType envelope.MessageType, payload []byte) (*envelope.Envelope, error) {
+	return a.exchange(context.Background(), "/tasks", msgType, replyType, payload)
+}
+
+// exchange sends a message to an endpoint and checks the reply type
+func (a *Agent) exchange(ctx context.Context, endpoint string, msgType envelope.MessageType, replyType envelope.MessageType, payload []byte) (*envelope.Envelope, error) {
+	reply, err := a.send(ctx, endpoint, msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type != replyType {
+		return nil, fmt.Errorf("expected a %s reply, got %s", replyType, reply.Type)
+	}
+	return reply, nil
+}
+
+// send sends a message to an endpoint and returns the reply, giving up after the request timeout
+// or once ctx is done
+func (a *Agent) send(ctx context.Context, endpoint string, msgType envelope.MessageType, payload []byte) (*envelope.Envelope, error) {
+	msg := envelope.New(msgType, a.getConfig().AgentUUID, a.nextSequence(), payload)
+
+	ctx, cancel := context.WithTimeout(ctx, a.getConfig().RequestTimeout)
+	defer cancel()
+
+	return protocol.Exchange(ctx, a.getProtocol(), endpoint, msg, a.e2eKey)
+}
+
+// decodeCancelWork reads the task ID carried by cancel work
+func decodeCancelWork(payload []byte) (string, error) {
+	var id string
+	if err := json.Unmarshal(payload, &id); err != nil || id == "" {
+		return "", fmt.Errorf("invalid cancel work payload")
+	}
+	return id, nil
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"firestarter/internal/envelope"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	// chunkAttempts is how many times a chunk is sent before the transfer is left for later
+	chunkAttempts = 3
+
+	// chunkRetryDelay is the wait before sending a chunk again, growing with every attempt
+	chunkRetryDelay = 2 * time.Second
+)
+
+// errTransferDropped is returned when the server no longer expects the data being sent, e.g.
+// because the task it belongs to settled
+var errTransferDropped = errors.New("server no longer expects the data")
+
+// chunkRoute is where the chunks of a transfer are sent, and the status the server answers with
+type chunkRoute struct {
+	endpoint   string
+	chunkType  envelope.MessageType
+	statusType envelope.MessageType
+}
+
+var (
+	// outputRoute carries the outputs of tasks
+	outputRoute = chunkRoute{endpoint: "/tasks", chunkType: envelope.TypeUploadChunk, statusType: envelope.TypeUploadStatus}
+
+	// fileRoute carries the files download tasks send to the server's file store
+	fileRoute = chunkRoute{endpoint: "/files", chunkType: envelope.TypeFileChunk, statusType: envelope.TypeFileStatus}
+)
+
+// uploadOutput uploads a task's output in chunks read from its spool file, each exchanged on its
+// own so no request carries more than a chunk. The server keeps the chunks it received, so an
+// upload that fails midway resumes with the missing chunks when the result is reported again.
+func (a *Agent) uploadOutput(id string, manifest transfer.Manifest, output io.ReaderAt) error {
+	begin := tasking.UploadBegin{ID: id, Manifest: manifest}
+	payload, err := begin.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode upload: %w", err)
+	}
+	status, err := a.exchangeStatus(context.Background(), outputRoute.endpoint, envelope.TypeUploadBegin, outputRoute.statusType, payload)
+	if err != nil {
+		return err
+	}
+
+	what := fmt.Sprintf("output of task %s", id)
+	if err := a.sendChunks(context.Background(), outputRoute, begin.Manifest, status, output, what); err != nil {
+		return err
+	}
+	log.Printf("Uploaded the %s", what)
+	return nil
+}
+
+// sendChunks sends the chunks the server is missing according to status, reading them from
+// source, and requires the server to confirm it reassembled and verified the whole
+func (a *Agent) sendChunks(ctx context.Context, route chunkRoute, manifest transfer.Manifest, status transfer.Status, source io.ReaderAt, what string) error {
+	received := make(map[int]bool, len(status.Received))
+	for _, index := range status.Received {
+		received[index] = true
+	}
+	chunks := manifest.Chunks()
+	if len(received) > 0 && !status.Complete {
+		log.Printf("Resuming the %s, %d/%d chunk(s) already received", what, len(received), chunks)
+	} else if !status.Complete {
+		log.Printf("Sending the %s, %d bytes in %d chunk(s)", what, manifest.Size, chunks)
+	}
+
+	for index := 0; index < chunks && !status.Complete; index++ {
+		if received[index] {
+			continue
+		}
+		if a.isStopping() {
+			return fmt.Errorf("%s interrupted by shutdown", what)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data := make([]byte, manifest.ChunkLen(index))
+		if _, err := source.ReadAt(data, manifest.ChunkOffset(index)); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read chunk %d/%d of the %s: %w", index+1, chunks, what, err)
+		}
+
+		var err error
+		status, err = a.sendChunk(ctx, route, transfer.NewChunk(status.ID, index, data))
+		if err != nil {
+			return fmt.Errorf("chunk %d/%d of the %s: %w", index+1, chunks, what, err)
+		}
+	}
+
+	if !status.Complete {
+		return fmt.Errorf("server did not confirm the %s", what)
+	}
+	return nil
+}
+
+// sendChunk sends a single chunk, retrying with a growing delay
+func (a *Agent) sendChunk(ctx context.Context, route chunkRoute, chunk transfer.Chunk) (transfer.Status, error) {
+	payload, err := chunk.Marshal()
+	if err != nil {
+		return transfer.Status{}, fmt.Errorf("failed to encode chunk: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		status, err := a.exchangeStatus(ctx, route.endpoint, route.chunkType, route.statusType, payload)
+		if err == nil || errors.Is(err, errTransferDropped) || attempt == chunkAttempts {
+			return status, err
+		}
+
+		log.Printf("Chunk %d of %s failed (attempt %d/%d): %v", chunk.Index+1, chunk.ID, attempt, chunkAttempts, err)
+		if !a.wait(ctx, time.Duration(attempt)*chunkRetryDelay) {
+			return transfer.Status{}, err
+		}
+	}
+}
+
+// exchangeStatus sends a transfer message and decodes the status the server answers with
+func (a *Agent) exchangeStatus(ctx context.Context, endpoint string, msgType envelope.MessageType, statusType envelope.MessageType, payload []byte) (transfer.Status, error) {
+	reply, err := a.exchange(ctx, endpoint, msgType, statusType, payload)
+	if err != nil {
+		return transfer.Status{}, err
+	}
+	status, err := transfer.UnmarshalStatus(reply.Payload)
+	if err != nil {
+		return transfer.Status{}, err
+	}
+	if status.Unknown {
+		return status, errTransferDropped
+	}
+	return status, nil
+}
+
+// wait waits for d, returning false early when ctx is done or the agent stops
+func (a *Agent) wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	case <-a.stopChan:
+		return false
+	}
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"crypto/ecdh"
+	"crypto/tls"
+	"firestarter/internal/compression"
 	"flag"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -17,6 +21,8 @@ const (
 	H2C   ProtocolType = "H2C"
 	H2TLS ProtocolType = "H2TLS"
 	H3    ProtocolType = "H3"
+	WS    ProtocolType = "WS"
+	TCP   ProtocolType = "TCP"
 )
 
 // Config holds all configuration options for the agent
@@ -28,6 +34,9 @@ type Config struct {
 	// Protocol configuration
 	Protocol ProtocolType
 
+	// Backup servers, tried in order once the primary target exhausts its reconnect attempts
+	FallbackEndpoints []Endpoint
+
 	// Connection management
 	ReconnectAttempts int
 	ReconnectDelay    time.Duration
@@ -37,23 +46,78 @@ type Config struct {
 	// Agent identity
 	AgentUUID string
 
+	// Build metadata, reported to the server when registering
+	BuildTime     string
+	BuildProtocol string
+
 	// Health check configuration
 	HealthCheckInterval time.Duration
 	HealthCheckEndpoint string
+
+	// Long-poll check-in, used instead of periodic health checks when enabled
+	LongPoll        bool
+	LongPollTimeout time.Duration // How long the server may hold a check-in open
+	CheckInEndpoint string
+
+	// QUIC configuration (H3 only)
+	QUICIdleTimeout     time.Duration
+	QUICKeepAlivePeriod time.Duration
+
+	// Payload compression, negotiated with the server per message
+	Compression          string // Codec request bodies are compressed with (gzip, deflate or identity)
+	CompressionThreshold int    // Smallest body compressed, in bytes
+
+	// Task execution
+	TaskConcurrency int           // How many tasks run at the same time
+	TaskTimeout     time.Duration // How long a task may run when the server doesn't say
+
+	// Outbound proxy configuration
+	ProxyURL             string // Explicit HTTP(S) proxy, takes precedence over the environment
+	ProxyFromEnvironment bool   // Use HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	ProxyUsername        string // Basic auth credentials for the proxy
+	ProxyPassword        string
+
+	// TLS trust configuration, embedded at build time (TLS-based protocols only)
+	TLSCABundle []byte   // PEM-encoded CA certificates, system roots are used when empty
+	TLSPins     []string // Base64-encoded SHA-256 SPKI hashes
+
+	// Client certificate for listeners that require mutual TLS, issued by the team server's agent CA
+	TLSClientCert []byte // PEM-encoded certificate naming this agent's UUID
+	TLSClientKey  []byte // PEM-encoded private key
+
+	// End-to-end encryption keys, embedded at build time. Payloads are sealed when both are set
+	E2EPrivateKey *ecdh.PrivateKey // This agent's X25519 key
+	E2EServerKey  *ecdh.PublicKey  // The team server's X25519 key
+
+	// Request signing secret, embedded at build time. Every request is signed when set
+	SigningSecret []byte
+
+	// Engagement limit, embedded at build time. Once reached the agent stops for good and
+	// removes its identity file. It can't be changed by flags or configuration updates
+	KillDate time.Time
 }
 
 // DefaultConfig returns a Config with sensible default values
 func DefaultConfig() *Config {
 	return &Config{
-		TargetHost:          "localhost",
-		TargetPort:          "7777",
-		Protocol:            H1C,
-		ReconnectAttempts:   9999,             // practically indefinite, decrease if implementing backup host (TODO)
-		ConnectionTimeout:   60 * time.Second, // kernel will try incremental transmissions up until 60 sec
-		ReconnectDelay:      30 * time.Minute, // if not able to connect, wait 30 mins, try process again
-		RequestTimeout:      5 * time.Minute,  // very generous here since unplanned timeouts can be an issue
-		HealthCheckInterval: 45 * time.Second,
-		HealthCheckEndpoint: "/",
+		TargetHost:           "localhost",
+		TargetPort:           "7777",
+		Protocol:             H1C,
+		ReconnectAttempts:    9999,             // per endpoint, practically indefinite, decrease when fallback endpoints are configured
+		ConnectionTimeout:    60 * time.Second, // kernel will try incremental transmissions up until 60 sec
+		ReconnectDelay:       30 * time.Minute, // if not able to connect, wait 30 mins, try process again
+		RequestTimeout:       5 * time.Minute,  // very generous here since unplanned timeouts can be an issue
+		HealthCheckInterval:  45 * time.Second,
+		HealthCheckEndpoint:  "/",
+		LongPoll:             true,
+		LongPollTimeout:      30 * time.Second, // long enough to be cheap, short enough to survive idle-timeout middleboxes
+		CheckInEndpoint:      "/checkin",
+		QUICIdleTimeout:      60 * time.Second,
+		QUICKeepAlivePeriod:  15 * time.Second, // below the listener's 30 sec idle timeout so the connection survives between health checks
+		Compression:          compression.Gzip,
+		CompressionThreshold: compression.DefaultThreshold,
+		TaskConcurrency:      4,
+		TaskTimeout:          10 * time.Minute,
 	}
 }
 
@@ -64,10 +128,13 @@ func (c *Config) LoadFromFlags() {
 	flag.StringVar(&c.TargetPort, "port", c.TargetPort, "Target server port")
 
 	// Protocol flag
-	protocol := flag.String("protocol", string(c.Protocol), "Communication protocol (H1C, H1TLS, H2C, H2TLS, H3)")
+	protocol := flag.String("protocol", string(c.Protocol), "Communication protocol (H1C, H1TLS, H2C, H2TLS, H3, WS, TCP)")
+
+	// Failover flag
+	fallbacks := flag.String("fallbacks", "", "Comma-separated backup endpoints tried in order (e.g. h2tls://host:8443,h3://host2:443)")
 
 	// Connection management flags
-	flag.IntVar(&c.ReconnectAttempts, "reconnect-attempts", c.ReconnectAttempts, "Number of reconnection attempts before giving up")
+	flag.IntVar(&c.ReconnectAttempts, "reconnect-attempts", c.ReconnectAttempts, "Number of reconnection attempts per endpoint before failing over (or giving up)")
 	reconnectDelay := flag.Int("reconnect-delay", int(c.ReconnectDelay.Seconds()), "Delay between reconnection attempts in seconds")
 	connectionTimeout := flag.Int("connection-timeout", int(c.ConnectionTimeout.Seconds()), "Connection timeout in seconds")
 	requestTimeout := flag.Int("request-timeout", int(c.RequestTimeout.Seconds()), "Request timeout in seconds")
@@ -76,34 +143,60 @@ func (c *Config) LoadFromFlags() {
 	healthCheckInterval := flag.Int("health-check-interval", int(c.HealthCheckInterval.Seconds()), "Health check interval in seconds")
 	flag.StringVar(&c.HealthCheckEndpoint, "health-check-endpoint", c.HealthCheckEndpoint, "Endpoint to use for health checks")
 
+	// Check-in flags
+	flag.BoolVar(&c.LongPoll, "long-poll", c.LongPoll, "Use long-poll check-ins instead of periodic health checks")
+	longPollTimeout := flag.Int("long-poll-timeout", int(c.LongPollTimeout.Seconds()), "How long the server may hold a check-in open in seconds")
+	flag.StringVar(&c.CheckInEndpoint, "checkin-endpoint", c.CheckInEndpoint, "Endpoint to use for long-poll check-ins")
+
+	// Proxy flags
+	flag.StringVar(&c.ProxyURL, "proxy", c.ProxyURL, "Outbound HTTP(S) proxy URL (e.g. http://proxy.corp:3128)")
+	flag.BoolVar(&c.ProxyFromEnvironment, "proxy-env", c.ProxyFromEnvironment, "Use HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment")
+	flag.StringVar(&c.ProxyUsername, "proxy-user", c.ProxyUsername, "Username for proxy basic authentication")
+	flag.StringVar(&c.ProxyPassword, "proxy-pass", c.ProxyPassword, "Password for proxy basic authentication")
+
+	// QUIC flags
+	quicIdleTimeout := flag.Int("quic-idle-timeout", int(c.QUICIdleTimeout.Seconds()), "QUIC idle timeout in seconds (H3 only)")
+	quicKeepAlive := flag.Int("quic-keepalive", int(c.QUICKeepAlivePeriod.Seconds()), "QUIC keep-alive period in seconds, 0 to disable (H3 only)")
+
+	// Compression flags
+	flag.StringVar(&c.Compression, "compression", c.Compression, "Payload compression codec (gzip, deflate or none)")
+	flag.IntVar(&c.CompressionThreshold, "compression-threshold", c.CompressionThreshold, "Smallest payload compressed, in bytes")
+
+	// Task flags
+	flag.IntVar(&c.TaskConcurrency, "task-concurrency", c.TaskConcurrency, "How many tasks run at the same time")
+	taskTimeout := flag.Int("task-timeout", int(c.TaskTimeout.Seconds()), "How long a task may run in seconds, unless the server says otherwise")
+
 	// Parse flags
 	flag.Parse()
 
 	// Convert string protocol to ProtocolType
 	if *protocol != "" {
-		protUpper := strings.ToUpper(*protocol)
-		switch protUpper {
-		case "H1C":
-			c.Protocol = H1C
-		case "H1TLS":
-			c.Protocol = H1TLS
-		case "H2C":
-			c.Protocol = H2C
-		case "H2TLS":
-			c.Protocol = H2TLS
-		case "H3":
-			c.Protocol = H3
-		default:
+		if protocolType, err := ParseProtocolType(*protocol); err == nil {
+			c.Protocol = protocolType
+		} else {
 			fmt.Printf("Warning: Unknown protocol '%s', defaulting to H1C\n", *protocol)
 			c.Protocol = H1C
 		}
 	}
 
+	// Replace build-time fallbacks when provided on the command line
+	if *fallbacks != "" {
+		if endpoints, err := ParseEndpointList(*fallbacks); err == nil {
+			c.FallbackEndpoints = endpoints
+		} else {
+			fmt.Printf("Warning: Ignoring fallback endpoints: %v\n", err)
+		}
+	}
+
 	// Convert time values from seconds to Duration
 	c.ReconnectDelay = time.Duration(*reconnectDelay) * time.Second
 	c.ConnectionTimeout = time.Duration(*connectionTimeout) * time.Second
 	c.RequestTimeout = time.Duration(*requestTimeout) * time.Second
 	c.HealthCheckInterval = time.Duration(*healthCheckInterval) * time.Second
+	c.LongPollTimeout = time.Duration(*longPollTimeout) * time.Second
+	c.QUICIdleTimeout = time.Duration(*quicIdleTimeout) * time.Second
+	c.QUICKeepAlivePeriod = time.Duration(*quicKeepAlive) * time.Second
+	c.TaskTimeout = time.Duration(*taskTimeout) * time.Second
 }
 
 // Validate checks if the configuration is valid
@@ -114,26 +207,199 @@ func (c *Config) Validate() error {
 	if c.TargetPort == "" {
 		return fmt.Errorf("target port cannot be empty")
 	}
+	for i, endpoint := range c.FallbackEndpoints {
+		if endpoint.Host == "" || endpoint.Port == "" {
+			return fmt.Errorf("fallback endpoint %d must have a host and port", i+1)
+		}
+		if _, err := ParseProtocolType(string(endpoint.Protocol)); err != nil {
+			return fmt.Errorf("fallback endpoint %d: %w", i+1, err)
+		}
+	}
+	if c.ReconnectAttempts < 0 {
+		return fmt.Errorf("reconnect attempts cannot be negative, got %d", c.ReconnectAttempts)
+	}
+	if c.ReconnectDelay < 0 {
+		return fmt.Errorf("reconnect delay cannot be negative, got %v", c.ReconnectDelay)
+	}
+	if c.ConnectionTimeout <= 0 || c.RequestTimeout <= 0 {
+		return fmt.Errorf("connection and request timeouts must be positive, got %v and %v",
+			c.ConnectionTimeout, c.RequestTimeout)
+	}
+	if c.HealthCheckInterval <= 0 {
+		return fmt.Errorf("health check interval must be positive, got %v", c.HealthCheckInterval)
+	}
+	if c.LongPoll && c.LongPollTimeout < time.Second {
+		return fmt.Errorf("long-poll timeout must be at least one second, got %v", c.LongPollTimeout)
+	}
+	if c.TaskConcurrency <= 0 || c.TaskTimeout <= 0 {
+		return fmt.Errorf("task concurrency and timeout must be positive, got %d and %v", c.TaskConcurrency, c.TaskTimeout)
+	}
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https") || proxyURL.Host == "" {
+			return fmt.Errorf("proxy URL must be of the form http(s)://host:port, got '%s'", c.ProxyURL)
+		}
+	}
+	if (len(c.TLSClientCert) == 0) != (len(c.TLSClientKey) == 0) {
+		return fmt.Errorf("mutual TLS needs both the client certificate and its private key")
+	}
+	if len(c.TLSClientCert) > 0 {
+		if _, err := tls.X509KeyPair(c.TLSClientCert, c.TLSClientKey); err != nil {
+			return fmt.Errorf("invalid client certificate: %w", err)
+		}
+	}
+	if (c.E2EPrivateKey == nil) != (c.E2EServerKey == nil) {
+		return fmt.Errorf("end-to-end encryption needs both the agent key and the server public key")
+	}
+	codec, err := compression.ParseCodec(c.Compression)
+	if err != nil {
+		return err
+	}
+	c.Compression = codec
+	if c.CompressionThreshold < 0 {
+		return fmt.Errorf("compression threshold cannot be negative, got %d", c.CompressionThreshold)
+	}
+	if c.QUICKeepAlivePeriod > 0 && c.QUICIdleTimeout > 0 && c.QUICKeepAlivePeriod >= c.QUICIdleTimeout {
+		return fmt.Errorf("QUIC keep-alive period (%v) must be shorter than the QUIC idle timeout (%v)",
+			c.QUICKeepAlivePeriod, c.QUICIdleTimeout)
+	}
 	return nil
 }
 
+// Endpoints returns the ordered failover chain, starting with the primary target
+func (c *Config) Endpoints() []Endpoint {
+	endpoints := make([]Endpoint, 0, 1+len(c.FallbackEndpoints))
+	endpoints = append(endpoints, Endpoint{Host: c.TargetHost, Port: c.TargetPort, Protocol: c.Protocol})
+	return append(endpoints, c.FallbackEndpoints...)
+}
+
 // String returns a string representation of the configuration
 func (c *Config) String() string {
 	return fmt.Sprintf(`Agent Configuration:
   Target:                %s:%s
   Protocol:              %s
+  Fallback Endpoints:    %s
   Reconnect Attempts:    %d
   Reconnect Delay:       %v
   Connection Timeout:    %v
   Request Timeout:       %v
   Health Check Interval: %v
-  Health Check Endpoint: %s`,
+  Health Check Endpoint: %s
+  Check-In:              %s
+  QUIC Idle Timeout:     %v
+  QUIC Keep-Alive:       %v
+  Compression:           %s
+  Tasks:                 %d at a time, %v timeout
+  Proxy:                 %s
+  TLS Trust:             %s
+  E2E Encryption:        %s
+  Request Signing:       %s
+  Kill Date:             %s`,
 		c.TargetHost, c.TargetPort,
 		c.Protocol,
+		c.fallbackSummary(),
 		c.ReconnectAttempts,
 		c.ReconnectDelay,
 		c.ConnectionTimeout,
 		c.RequestTimeout,
 		c.HealthCheckInterval,
-		c.HealthCheckEndpoint)
+		c.HealthCheckEndpoint,
+		c.checkInSummary(),
+		c.QUICIdleTimeout,
+		c.QUICKeepAlivePeriod,
+		c.compressionSummary(),
+		c.TaskConcurrency, c.TaskTimeout,
+		c.proxySummary(),
+		c.tlsTrustSummary(),
+		c.e2eSummary(),
+		c.signingSummary(),
+		c.killDateSummary())
+}
+
+// fallbackSummary lists the fallback endpoints in failover order
+func (c *Config) fallbackSummary() string {
+	if len(c.FallbackEndpoints) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(c.FallbackEndpoints))
+	for _, endpoint := range c.FallbackEndpoints {
+		names = append(names, endpoint.String())
+	}
+	return strings.Join(names, " -> ")
+}
+
+// checkInSummary describes whether long-poll check-ins replace periodic health checks
+func (c *Config) checkInSummary() string {
+	if !c.LongPoll {
+		return "disabled (periodic health checks)"
+	}
+	return fmt.Sprintf("long-poll %s, held up to %v", c.CheckInEndpoint, c.LongPollTimeout)
+}
+
+// compressionSummary describes which codec payloads are compressed with
+func (c *Config) compressionSummary() string {
+	if c.Compression == compression.Identity {
+		return "disabled"
+	}
+	return fmt.Sprintf("%s, payloads of %d bytes and more", c.Compression, c.CompressionThreshold)
+}
+
+// proxySummary describes the outbound proxy without revealing credentials
+func (c *Config) proxySummary() string {
+	summary := "none"
+	if c.ProxyURL != "" {
+		summary = c.ProxyURL
+		if proxyURL, err := url.Parse(c.ProxyURL); err == nil {
+			summary = proxyURL.Redacted()
+		}
+	} else if c.ProxyFromEnvironment {
+		summary = "from environment"
+	}
+	if c.ProxyUsername != "" && summary != "none" {
+		summary += fmt.Sprintf(" (basic auth as %s)", c.ProxyUsername)
+	}
+	return summary
+}
+
+// tlsTrustSummary describes how TLS listener certificates are verified
+func (c *Config) tlsTrustSummary() string {
+	trust := "system roots"
+	if len(c.TLSCABundle) > 0 {
+		trust = "embedded CA bundle"
+	}
+	if len(c.TLSPins) > 0 {
+		if len(c.TLSCABundle) > 0 {
+			trust += fmt.Sprintf(" + %d SPKI pin(s)", len(c.TLSPins))
+		} else {
+			trust = fmt.Sprintf("%d SPKI pin(s)", len(c.TLSPins))
+		}
+	}
+	if len(c.TLSClientCert) > 0 {
+		trust += ", client certificate for mutual TLS"
+	}
+	return trust
+}
+
+// e2eSummary describes whether payloads are sealed end to end
+func (c *Config) e2eSummary() string {
+	if c.E2EPrivateKey == nil || c.E2EServerKey == nil {
+		return "disabled (payloads rely on the transport)"
+	}
+	return "sealed to the team server (X25519, XChaCha20-Poly1305)"
+}
+
+// signingSummary describes whether requests are signed
+func (c *Config) signingSummary() string {
+	if c.SigningSecret == nil {
+		return "disabled (requests are not authenticated)"
+	}
+	return "HMAC-SHA256 with the embedded agent secret"
+}
+
+// killDateSummary describes when the agent stops for good
+func (c *Config) killDateSummary() string {
+	if c.KillDate.IsZero() {
+		return "none (the agent runs until stopped)"
+	}
+	return fmt.Sprintf("%s (in %v)", c.KillDate.UTC().Format(time.RFC3339), time.Until(c.KillDate).Round(time.Minute))
 }
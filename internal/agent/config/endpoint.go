@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Endpoint describes a single server the agent can connect to
+type Endpoint struct {
+	Host     string
+	Port     string
+	Protocol ProtocolType
+}
+
+// String returns the endpoint in the same proto://host:port form accepted by ParseEndpoint
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s://%s", strings.ToLower(string(e.Protocol)), net.JoinHostPort(e.Host, e.Port))
+}
+
+// ParseProtocolType converts a case-insensitive protocol name into a ProtocolType
+func ParseProtocolType(name string) (ProtocolType, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "H1C":
+		return H1C, nil
+	case "H1TLS":
+		return H1TLS, nil
+	case "H2C":
+		return H2C, nil
+	case "H2TLS":
+		return H2TLS, nil
+	case "H3":
+		return H3, nil
+	case "WS":
+		return WS, nil
+	case "TCP":
+		return TCP, nil
+	default:
+		return "", fmt.Errorf("unknown protocol '%s'", name)
+	}
+}
+
+// ParseEndpoint parses an endpoint in the form proto://host:port (e.g. h2tls://10.0.0.5:8443)
+func ParseEndpoint(s string) (Endpoint, error) {
+	scheme, address, found := strings.Cut(strings.TrimSpace(s), "://")
+	if !found {
+		return Endpoint{}, fmt.Errorf("invalid endpoint '%s': expected proto://host:port", s)
+	}
+
+	protocol, err := ParseProtocolType(scheme)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid endpoint '%s': %w", s, err)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("invalid endpoint '%s': %w", s, err)
+	}
+	if host == "" || port == "" {
+		return Endpoint{}, fmt.Errorf("invalid endpoint '%s': host and port are required", s)
+	}
+
+	return Endpoint{Host: host, Port: port, Protocol: protocol}, nil
+}
+
+// ParseEndpointList parses a comma-separated list of endpoints, preserving their order
+func ParseEndpointList(s string) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		endpoint, err := ParseEndpoint(item)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
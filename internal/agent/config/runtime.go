@@ -0,0 +1,65 @@
+package config
+
+import (
+	"firestarter/internal/runtimeconfig"
+	"fmt"
+	"time"
+)
+
+// Settings returns the parts of the configuration the server can change while the agent runs
+func (c *Config) Settings() runtimeconfig.Settings {
+	fallbacks := make([]string, 0, len(c.FallbackEndpoints))
+	for _, endpoint := range c.FallbackEndpoints {
+		fallbacks = append(fallbacks, endpoint.String())
+	}
+
+	return runtimeconfig.Settings{
+		TargetHost:                c.TargetHost,
+		TargetPort:                c.TargetPort,
+		Protocol:                  string(c.Protocol),
+		FallbackEndpoints:         fallbacks,
+		ReconnectAttempts:         c.ReconnectAttempts,
+		ReconnectDelayMillis:      c.ReconnectDelay.Milliseconds(),
+		ConnectionTimeoutMillis:   c.ConnectionTimeout.Milliseconds(),
+		RequestTimeoutMillis:      c.RequestTimeout.Milliseconds(),
+		HealthCheckIntervalMillis: c.HealthCheckInterval.Milliseconds(),
+		LongPoll:                  c.LongPoll,
+		LongPollTimeoutMillis:     c.LongPollTimeout.Milliseconds(),
+	}
+}
+
+// WithSettings returns a copy of the configuration with settings applied, validated as a whole.
+// The configuration itself is left untouched.
+func (c *Config) WithSettings(settings runtimeconfig.Settings) (*Config, error) {
+	protocol, err := ParseProtocolType(settings.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	fallbacks := make([]Endpoint, 0, len(settings.FallbackEndpoints))
+	for _, item := range settings.FallbackEndpoints {
+		endpoint, err := ParseEndpoint(item)
+		if err != nil {
+			return nil, err
+		}
+		fallbacks = append(fallbacks, endpoint)
+	}
+
+	updated := *c
+	updated.TargetHost = settings.TargetHost
+	updated.TargetPort = settings.TargetPort
+	updated.Protocol = protocol
+	updated.FallbackEndpoints = fallbacks
+	updated.ReconnectAttempts = settings.ReconnectAttempts
+	updated.ReconnectDelay = time.Duration(settings.ReconnectDelayMillis) * time.Millisecond
+	updated.ConnectionTimeout = time.Duration(settings.ConnectionTimeoutMillis) * time.Millisecond
+	updated.RequestTimeout = time.Duration(settings.RequestTimeoutMillis) * time.Millisecond
+	updated.HealthCheckInterval = time.Duration(settings.HealthCheckIntervalMillis) * time.Millisecond
+	updated.LongPoll = settings.LongPoll
+	updated.LongPollTimeout = time.Duration(settings.LongPollTimeoutMillis) * time.Millisecond
+
+	if err := updated.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &updated, nil
+}
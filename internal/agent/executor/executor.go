@@ -0,0 +1,433 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"firestarter/internal/tasking"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// spoolPattern names the files task outputs are written to, in the temporary directory
+	spoolPattern = "task-output-*"
+
+	// queueCapacity bounds the tasks waiting for a free slot, further tasks fail straight away
+	queueCapacity = 256
+
+	// seenRetention is how long task IDs are remembered, so a task handed out again is not run twice
+	seenRetention = 24 * time.Hour
+)
+
+// Reporter posts task results to the server over whichever transport is active. The output of
+// a result waits in the spool file at spool, empty when there is none; the executor removes the
+// file once the result was posted.
+type Reporter interface {
+	ReportTask(result tasking.Result, spool string) error
+}
+
+// Executor runs tasks with a bounded number of them at a time, each under a timeout, and
+// reports their outcome. Results that can't be posted are kept, and posted by a single background
+// flush once the server can be reached again.
+type Executor struct {
+	registry *Registry
+	reporter Reporter
+	files    Files
+	timeout  time.Duration // Applies to tasks that don't carry their own
+
+	queue   chan Task
+	running map[string]*execution // Task ID : task being run
+	waiting map[string]string     // Task ID : why it was cancelled before it started
+	seen    map[string]time.Time  // Task ID : when it was submitted
+
+	// Results not posted yet, in the order they were produced
+	outbox      []pending
+	outboxLock  sync.Mutex
+	flushLock   sync.Mutex
+	flushSignal chan struct{} // Wakes the background flush
+	flushing    sync.WaitGroup
+	ctx         context.Context
+	stop        context.CancelFunc
+	workers     sync.WaitGroup
+	mutex       sync.Mutex
+	stoppedOnce sync.Once
+}
+
+// pending is a result waiting to be posted, its output left in its spool file
+type pending struct {
+	result tasking.Result
+	spool  string
+}
+
+// execution is a task being run
+type execution struct {
+	cancel context.CancelFunc
+	reason string // Why it was cancelled, if it was
+}
+
+// New creates an executor running up to concurrency tasks at a time, each limited to timeout
+// unless it carries its own. Handlers move files through files.
+func New(registry *Registry, reporter Reporter, files Files, concurrency int, timeout time.Duration) *Executor {
+	ctx, stop := context.WithCancel(context.Background())
+	e := &Executor{
+		registry: registry,
+		reporter: reporter,
+		files:    files,
+		timeout:  timeout,
+		queue:    make(chan Task, queueCapacity),
+		running:  make(map[string]*execution),
+		waiting:  make(map[string]string),
+		seen:     make(map[string]time.Time),
+		ctx:      ctx,
+		stop:     stop,
+
+		flushSignal: make(chan struct{}, 1),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		e.workers.Add(1)
+		go e.work()
+	}
+	e.flushing.Add(1)
+	go e.flushLoop()
+	return e
+}
+
+// Submit queues a task to run once a slot is free. Tasks already submitted are ignored, since
+// the server hands tasks out again until it hears they were received. Tasks of a type without a
+// handler, or that can't be queued, fail straight away.
+func (e *Executor) Submit(task Task, timeout time.Duration) {
+	now := time.Now()
+
+	e.mutex.Lock()
+	if e.ctx.Err() != nil {
+		e.mutex.Unlock()
+		return
+	}
+	if _, seen := e.seen[task.ID]; seen {
+		e.mutex.Unlock()
+		log.Printf("Ignoring task %s, already received", task.ID)
+		return
+	}
+	for id, submitted := range e.seen {
+		if now.Sub(submitted) > seenRetention {
+			delete(e.seen, id)
+			delete(e.waiting, id)
+		}
+	}
+	e.seen[task.ID] = now
+	e.mutex.Unlock()
+
+	if _, exists := e.registry.Lookup(task.Type); !exists {
+		log.Printf("Refusing task %s: no handler for task type '%s'", task.ID, task.Type)
+		e.finish(tasking.Result{ID: task.ID, State: tasking.StateFailed, Error: fmt.Sprintf("unknown task type '%s'", task.Type)}, "")
+		return
+	}
+
+	if timeout <= 0 {
+		timeout = e.timeout
+	}
+	task.timeout = timeout
+	task.Files = e.files
+
+	select {
+	case e.queue <- task:
+		log.Printf("Queued %s task %s", task.Type, task.ID)
+	default:
+		log.Printf("Refusing task %s: %d tasks already waiting", task.ID, queueCapacity)
+		e.finish(tasking.Result{ID: task.ID, State: tasking.StateFailed, Error: "too many tasks waiting on the agent"}, "")
+	}
+}
+
+// Cancel stops a task that is running or waiting to run, returning whether it was found
+func (e *Executor) Cancel(id string, reason string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if running, exists := e.running[id]; exists {
+		running.reason = reason
+		running.cancel()
+		return true
+	}
+	if _, seen := e.seen[id]; seen {
+		// Still waiting for a slot, or already done, in which case nothing happens
+		e.waiting[id] = reason
+		return true
+	}
+	return false
+}
+
+// Running returns how many tasks are being run
+func (e *Executor) Running() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.running)
+}
+
+// Stop cancels every task, waits up to wait for them to wrap up, and makes a last attempt at
+// posting their results
+func (e *Executor) Stop(wait time.Duration) {
+	e.stoppedOnce.Do(func() {
+		e.mutex.Lock()
+		for _, running := range e.running {
+			running.reason = "agent is shutting down"
+		}
+		e.stop()
+		e.mutex.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			e.workers.Wait()
+			e.flushing.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(wait):
+			log.Println("Some tasks did not wrap up in time")
+		}
+
+		// Tasks that never got a slot won't run anymore
+		for {
+			select {
+			case task := <-e.queue:
+				e.keep(tasking.Result{ID: task.ID, State: tasking.StateFailed, Error: "agent is shutting down"}, "")
+				continue
+			default:
+			}
+			break
+		}
+
+		if err := e.FlushResults(); err != nil {
+			log.Printf("Task results lost on shutdown: %v", err)
+		}
+
+		// Nothing posts the results left behind anymore
+		e.outboxLock.Lock()
+		for _, kept := range e.outbox {
+			removeSpool(kept.spool)
+		}
+		e.outbox = nil
+		e.outboxLock.Unlock()
+	})
+}
+
+// FlushResults posts the results that could not be posted when they were produced, oldest
+// first, stopping at the first failure
+func (e *Executor) FlushResults() error {
+	e.flushLock.Lock()
+	defer e.flushLock.Unlock()
+
+	for {
+		e.outboxLock.Lock()
+		if len(e.outbox) == 0 {
+			e.outboxLock.Unlock()
+			return nil
+		}
+		kept := e.outbox[0]
+		e.outboxLock.Unlock()
+
+		if err := e.reporter.ReportTask(kept.result, kept.spool); err != nil {
+			return fmt.Errorf("failed to post the result of task %s: %w", kept.result.ID, err)
+		}
+		removeSpool(kept.spool)
+
+		e.outboxLock.Lock()
+		e.outbox = e.outbox[1:]
+		e.outboxLock.Unlock()
+	}
+}
+
+// ScheduleFlush asks the background flush to post the results kept so far, e.g. once the server
+// answered again. A flush already under way picks them up instead of starting another.
+func (e *Executor) ScheduleFlush() {
+	select {
+	case e.flushSignal <- struct{}{}:
+	default: // A flush is already due
+	}
+}
+
+// flushLoop posts kept results whenever a flush is scheduled, until the executor stops. Being
+// the only one, a large upload never holds up more than the results kept behind it.
+func (e *Executor) flushLoop() {
+	defer e.flushing.Done()
+
+	for {
+		select {
+		case <-e.flushSignal:
+			if err := e.FlushResults(); err != nil {
+				log.Printf("Task results still pending: %v", err)
+			}
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// work runs queued tasks until the executor stops
+func (e *Executor) work() {
+	defer e.workers.Done()
+
+	for {
+		select {
+		case task := <-e.queue:
+			if e.ctx.Err() != nil {
+				// Picked up as the executor stopped, it won't run anymore
+				e.keep(tasking.Result{ID: task.ID, State: tasking.StateFailed, Error: "agent is shutting down"}, "")
+				return
+			}
+			e.run(task)
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs a single task under its timeout and reports the outcome
+func (e *Executor) run(task Task) {
+	handler, _ := e.registry.Lookup(task.Type)
+
+	ctx, cancel := context.WithTimeout(e.ctx, task.timeout)
+	defer cancel()
+	running := &execution{cancel: cancel}
+
+	e.mutex.Lock()
+	if reason, cancelled := e.waiting[task.ID]; cancelled {
+		delete(e.waiting, task.ID)
+		e.mutex.Unlock()
+		log.Printf("Task %s cancelled before it started: %s", task.ID, reason)
+		e.finish(tasking.Result{ID: task.ID, State: tasking.StateFailed, Error: reason}, "")
+		return
+	}
+	e.running[task.ID] = running
+	e.mutex.Unlock()
+
+	defer func() {
+		e.mutex.Lock()
+		delete(e.running, task.ID)
+		delete(e.waiting, task.ID)
+		e.mutex.Unlock()
+	}()
+
+	log.Printf("Running %s task %s", task.Type, task.ID)
+	if err := e.reporter.ReportTask(tasking.Result{ID: task.ID, State: tasking.StateRunning}, ""); err != nil {
+		// Only a courtesy, the outcome is what matters
+		log.Printf("Could not report task %s as running: %v", task.ID, err)
+	}
+
+	started := time.Now()
+	spool, err := e.spool(ctx, handler, task)
+
+	result := tasking.Result{ID: task.ID, State: tasking.StateCompleted}
+	if err != nil {
+		result.State = tasking.StateFailed
+
+		e.mutex.Lock()
+		reason := running.reason
+		e.mutex.Unlock()
+
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			result.Error = fmt.Sprintf("timed out after %v", task.timeout)
+		case ctx.Err() != nil && reason != "":
+			result.Error = reason
+		default:
+			result.Error = err.Error()
+		}
+	}
+
+	log.Printf("Task %s %s after %v", task.ID, result.State, time.Since(started).Round(time.Millisecond))
+	e.finish(result, spool)
+}
+
+// spool runs a handler with its output written to a new spool file, so outputs never have to
+// fit in memory. It returns the file's path, empty when the task failed or wrote nothing.
+func (e *Executor) spool(ctx context.Context, handler Handler, task Task) (string, error) {
+	file, err := os.CreateTemp("", spoolPattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to spool output: %w", err)
+	}
+
+	err = e.invoke(ctx, handler, task, file)
+	// A handler that was given up on fails its writes from now on
+	if closeErr := file.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to spool output: %w", closeErr)
+	}
+	if err != nil {
+		removeSpool(file.Name())
+		return "", err
+	}
+
+	if info, err := os.Stat(file.Name()); err != nil || info.Size() == 0 {
+		removeSpool(file.Name())
+		if err != nil {
+			return "", fmt.Errorf("failed to spool output: %w", err)
+		}
+		return "", nil
+	}
+	return file.Name(), nil
+}
+
+// removeSpool deletes a spool file whose output is no longer needed
+func removeSpool(spool string) {
+	if spool == "" {
+		return
+	}
+	if err := os.Remove(spool); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove spool file %s: %v", spool, err)
+	}
+}
+
+// invoke runs a handler, recovering from panics. Handlers that ignore their context are given
+// up on once it is done, their goroutine is left to finish on its own.
+func (e *Executor) invoke(ctx context.Context, handler Handler, task Task, output io.Writer) error {
+	done := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Task %s handler panicked: %v\n%s", task.ID, r, debug.Stack())
+				done <- fmt.Errorf("handler panicked: %v", r)
+			}
+		}()
+		done <- handler(ctx, task, output)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finish posts a result straight away, without waiting for results kept earlier, and keeps it
+// for the background flush when it can't be posted now. The server takes results in any order.
+func (e *Executor) finish(result tasking.Result, spool string) {
+	if err := e.reporter.ReportTask(result, spool); err != nil {
+		log.Printf("Keeping the result of task %s until the server can be reached: %v", result.ID, err)
+		e.keep(result, spool)
+		return
+	}
+	removeSpool(spool)
+
+	// The server answered, results kept earlier can go out too
+	e.outboxLock.Lock()
+	kept := len(e.outbox)
+	e.outboxLock.Unlock()
+	if kept > 0 {
+		e.ScheduleFlush()
+	}
+}
+
+// keep queues a result for FlushResults, its output staying in its spool file meanwhile
+func (e *Executor) keep(result tasking.Result, spool string) {
+	e.outboxLock.Lock()
+	defer e.outboxLock.Unlock()
+	e.outbox = append(e.outbox, pending{result: result, spool: spool})
+}
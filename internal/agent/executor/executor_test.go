@@ -0,0 +1,364 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"firestarter/internal/tasking"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeReporter collects the outcomes posted by an executor, along with their outputs
+type fakeReporter struct {
+	results chan tasking.Result
+	fail    atomic.Bool              // Whether the server is unreachable
+	gates   map[string]chan struct{} // Task ID : closed once its result may be posted
+}
+
+func newFakeReporter() *fakeReporter {
+	return &fakeReporter{
+		results: make(chan tasking.Result, 64),
+		gates:   make(map[string]chan struct{}),
+	}
+}
+
+func (r *fakeReporter) ReportTask(result tasking.Result, spool string) error {
+	if result.State == tasking.StateRunning {
+		return nil
+	}
+	if r.fail.Load() {
+		return errors.New("server unreachable")
+	}
+	if gate, exists := r.gates[result.ID]; exists {
+		<-gate
+	}
+	if spool != "" {
+		output, err := os.ReadFile(spool)
+		if err != nil {
+			return err
+		}
+		result.Output = output
+	}
+	r.results <- result
+	return nil
+}
+
+// next waits for the next outcome posted
+func (r *fakeReporter) next(t *testing.T) tasking.Result {
+	t.Helper()
+
+	select {
+	case result := <-r.results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no task result posted")
+		return tasking.Result{}
+	}
+}
+
+// none checks that no outcome is posted for a little while
+func (r *fakeReporter) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case result := <-r.results:
+		t.Errorf("unexpected result posted: %+v", result)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// newTestExecutor creates an executor running the handlers given, stopped when the test ends
+func newTestExecutor(t *testing.T, reporter Reporter, concurrency int, timeout time.Duration, handlers map[string]Handler) *Executor {
+	t.Helper()
+
+	registry := NewRegistry()
+	for taskType, handler := range handlers {
+		registry.Register(taskType, handler)
+	}
+	e := New(registry, reporter, nil, concurrency, timeout)
+	t.Cleanup(func() { e.Stop(time.Second) })
+	return e
+}
+
+// waitUntil polls a condition, failing the test when it doesn't hold within a few seconds
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockUntilDone is a handler running until its context is done
+func blockUntilDone(ctx context.Context, task Task, output io.Writer) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestExecutorRunsTask(t *testing.T) {
+	reporter := newFakeReporter()
+	e := newTestExecutor(t, reporter, 1, time.Minute, map[string]Handler{
+		"echo": func(ctx context.Context, task Task, output io.Writer) error {
+			_, err := output.Write(task.Args)
+			return err
+		},
+		"fail": func(ctx context.Context, task Task, output io.Writer) error {
+			output.Write([]byte("partial"))
+			return errors.New("access denied")
+		},
+	})
+
+	e.Submit(Task{ID: "echo-task", Type: "echo", Args: []byte(`"hello"`)}, 0)
+	if result := reporter.next(t); result.State != tasking.StateCompleted || string(result.Output) != `"hello"` {
+		t.Errorf("echo result = %s %q, want completed %q", result.State, result.Output, `"hello"`)
+	}
+
+	// Outputs of failed tasks are discarded
+	e.Submit(Task{ID: "fail-task", Type: "fail"}, 0)
+	if result := reporter.next(t); result.State != tasking.StateFailed || result.Error != "access denied" || len(result.Output) != 0 {
+		t.Errorf("fail result = %s %q with %d byte(s) of output, want failed %q without output", result.State, result.Error, len(result.Output), "access denied")
+	}
+
+	e.Submit(Task{ID: "unknown-task", Type: "unknown"}, 0)
+	if result := reporter.next(t); result.State != tasking.StateFailed || !strings.Contains(result.Error, "unknown task type") {
+		t.Errorf("unknown type result = %s %q, want failed for its unknown type", result.State, result.Error)
+	}
+}
+
+func TestExecutorConcurrencyLimit(t *testing.T) {
+	const concurrency = 2
+	reporter := newFakeReporter()
+	release := make(chan struct{})
+	var active, peak atomic.Int32
+
+	e := newTestExecutor(t, reporter, concurrency, time.Minute, map[string]Handler{
+		"wait": func(ctx context.Context, task Task, output io.Writer) error {
+			now := active.Add(1)
+			defer active.Add(-1)
+			for {
+				seen := peak.Load()
+				if now <= seen || peak.CompareAndSwap(seen, now) {
+					break
+				}
+			}
+			<-release
+			return nil
+		},
+	})
+
+	const tasks = 5
+	for i := 0; i < tasks; i++ {
+		e.Submit(Task{ID: string(rune('a' + i)), Type: "wait"}, 0)
+	}
+	waitUntil(t, "the first tasks run", func() bool { return e.Running() == concurrency })
+	reporter.none(t)
+	if running := e.Running(); running != concurrency {
+		t.Errorf("%d tasks running, want %d", running, concurrency)
+	}
+
+	close(release)
+	for i := 0; i < tasks; i++ {
+		if result := reporter.next(t); result.State != tasking.StateCompleted {
+			t.Errorf("task %s %s, want completed", result.ID, result.State)
+		}
+	}
+	if got := peak.Load(); got != concurrency {
+		t.Errorf("at most %d tasks ran at once, want %d", got, concurrency)
+	}
+}
+
+func TestExecutorTimeout(t *testing.T) {
+	reporter := newFakeReporter()
+	e := newTestExecutor(t, reporter, 1, time.Minute, map[string]Handler{
+		"block": blockUntilDone,
+		// Never looks at its context, it is given up on all the same
+		"stuck": func(ctx context.Context, task Task, output io.Writer) error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+
+	// The task's own timeout wins over the executor's
+	e.Submit(Task{ID: "block-task", Type: "block"}, 20*time.Millisecond)
+	if result := reporter.next(t); result.State != tasking.StateFailed || !strings.Contains(result.Error, "timed out") {
+		t.Errorf("block result = %s %q, want failed on its timeout", result.State, result.Error)
+	}
+
+	started := time.Now()
+	e.Submit(Task{ID: "stuck-task", Type: "stuck"}, 20*time.Millisecond)
+	if result := reporter.next(t); result.State != tasking.StateFailed || !strings.Contains(result.Error, "timed out") {
+		t.Errorf("stuck result = %s %q, want failed on its timeout", result.State, result.Error)
+	}
+	if waited := time.Since(started); waited >= time.Second {
+		t.Errorf("stuck handler held its result back for %v", waited)
+	}
+}
+
+func TestExecutorCancel(t *testing.T) {
+	reporter := newFakeReporter()
+	var ranQueued atomic.Bool
+
+	e := newTestExecutor(t, reporter, 1, time.Minute, map[string]Handler{
+		"block": blockUntilDone,
+		"queued": func(ctx context.Context, task Task, output io.Writer) error {
+			ranQueued.Store(true)
+			return nil
+		},
+	})
+
+	e.Submit(Task{ID: "running-task", Type: "block"}, 0)
+	waitUntil(t, "the task runs", func() bool { return e.Running() == 1 })
+	// The only slot is taken, this one waits
+	e.Submit(Task{ID: "queued-task", Type: "queued"}, 0)
+
+	if !e.Cancel("queued-task", "cancelled by the operator") {
+		t.Error("Cancel did not find the waiting task")
+	}
+	if !e.Cancel("running-task", "cancelled by the operator") {
+		t.Error("Cancel did not find the running task")
+	}
+	if e.Cancel("unknown-task", "cancelled by the operator") {
+		t.Error("Cancel found a task never submitted")
+	}
+
+	for i := 0; i < 2; i++ {
+		result := reporter.next(t)
+		if result.State != tasking.StateFailed || result.Error != "cancelled by the operator" {
+			t.Errorf("task %s %s %q, want failed as cancelled", result.ID, result.State, result.Error)
+		}
+	}
+	if ranQueued.Load() {
+		t.Error("task cancelled while waiting was run")
+	}
+}
+
+func TestExecutorRecoversPanics(t *testing.T) {
+	reporter := newFakeReporter()
+	e := newTestExecutor(t, reporter, 1, time.Minute, map[string]Handler{
+		"panic": func(ctx context.Context, task Task, output io.Writer) error {
+			panic("nil map")
+		},
+		"echo": func(ctx context.Context, task Task, output io.Writer) error {
+			_, err := output.Write([]byte("still here"))
+			return err
+		},
+	})
+
+	e.Submit(Task{ID: "panic-task", Type: "panic"}, 0)
+	if result := reporter.next(t); result.State != tasking.StateFailed || !strings.Contains(result.Error, "panicked") {
+		t.Errorf("panic result = %s %q, want failed on the panic", result.State, result.Error)
+	}
+
+	// The worker survived
+	e.Submit(Task{ID: "echo-task", Type: "echo"}, 0)
+	if result := reporter.next(t); result.State != tasking.StateCompleted || string(result.Output) != "still here" {
+		t.Errorf("echo result = %s %q, want completed", result.State, result.Output)
+	}
+}
+
+func TestExecutorIgnoresRepeatedTasks(t *testing.T) {
+	reporter := newFakeReporter()
+	var runs atomic.Int32
+
+	e := newTestExecutor(t, reporter, 2, time.Minute, map[string]Handler{
+		"count": func(ctx context.Context, task Task, output io.Writer) error {
+			runs.Add(1)
+			return nil
+		},
+	})
+
+	// The server hands a task out again until it hears it was received
+	for attempt := 1; attempt <= 3; attempt++ {
+		e.Submit(Task{ID: "task", Type: "count", Attempt: attempt}, 0)
+	}
+	reporter.next(t)
+	e.Submit(Task{ID: "task", Type: "count", Attempt: 4}, 0)
+	reporter.none(t)
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("task ran %d times, want once", got)
+	}
+}
+
+func TestExecutorKeepsResultsUntilFlushed(t *testing.T) {
+	reporter := newFakeReporter()
+	e := newTestExecutor(t, reporter, 1, time.Minute, map[string]Handler{
+		"echo": func(ctx context.Context, task Task, output io.Writer) error {
+			_, err := output.Write([]byte(task.ID))
+			return err
+		},
+	})
+
+	reporter.fail.Store(true)
+	e.Submit(Task{ID: "kept-task", Type: "echo"}, 0)
+	waitUntil(t, "the result is kept", func() bool {
+		e.outboxLock.Lock()
+		defer e.outboxLock.Unlock()
+		return len(e.outbox) == 1
+	})
+
+	// Posting the kept result holds on, as a large upload would
+	gate := make(chan struct{})
+	reporter.gates["kept-task"] = gate
+	reporter.fail.Store(false)
+	e.ScheduleFlush()
+
+	// A new result is posted straight away all the same
+	e.Submit(Task{ID: "new-task", Type: "echo"}, 0)
+	if result := reporter.next(t); result.ID != "new-task" {
+		t.Errorf("first result posted is %s's, want new-task's", result.ID)
+	}
+
+	close(gate)
+	if result := reporter.next(t); result.ID != "kept-task" || string(result.Output) != "kept-task" {
+		t.Errorf("flushed result = %s %q, want kept-task's with its output", result.ID, result.Output)
+	}
+	waitUntil(t, "the outbox empties", func() bool {
+		e.outboxLock.Lock()
+		defer e.outboxLock.Unlock()
+		return len(e.outbox) == 0
+	})
+}
+
+func TestExecutorStopFailsWaitingTasks(t *testing.T) {
+	reporter := newFakeReporter()
+	registry := NewRegistry()
+	registry.Register("block", blockUntilDone)
+	e := New(registry, reporter, nil, 1, time.Minute)
+
+	e.Submit(Task{ID: "running-task", Type: "block"}, 0)
+	waitUntil(t, "the task runs", func() bool { return e.Running() == 1 })
+	e.Submit(Task{ID: "queued-task", Type: "block"}, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.Stop(time.Second)
+	}()
+
+	errors := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		result := reporter.next(t)
+		errors[result.ID] = result.Error
+	}
+	wg.Wait()
+
+	for _, id := range []string{"running-task", "queued-task"} {
+		if errors[id] != "agent is shutting down" {
+			t.Errorf("task %s failed with %q, want it failed for the shutdown", id, errors[id])
+		}
+	}
+	// Stopped executors take no more tasks
+	e.Submit(Task{ID: "late-task", Type: "block"}, 0)
+	reporter.none(t)
+}
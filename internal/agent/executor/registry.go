@@ -0,0 +1,94 @@
+// Package executor runs the tasks the server hands to the agent. Task types are implemented by
+// handlers registered by name, so adding a task type never touches the agent's loops.
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Task is a task as handed to its handler
+type Task struct {
+	ID      string
+	Type    string
+	Args    json.RawMessage // Type-specific parameters, may be empty
+	Attempt int             // Above 1 when the server handed the task out again
+	Files   Files           // Set by the executor, for handlers moving files
+
+	timeout time.Duration // Set by the executor when the task is queued
+}
+
+// Files moves files between the agent and the server's file store on behalf of a task
+type Files interface {
+	// SendFile sends a file to the file store, resuming where an earlier attempt left off, and
+	// returns the ID the store keeps it under along with what was sent
+	SendFile(ctx context.Context, taskID string, path string, chunkSize int) (string, transfer.Manifest, error)
+
+	// ReceiveFile writes a file staged in the file store to the agent, resuming where an
+	// earlier attempt left off
+	ReceiveFile(ctx context.Context, taskID string, args transfer.UploadArgs) error
+}
+
+// Handler runs a task, writing its output to output. The output is spooled to a file rather than
+// kept in memory, and discarded when the handler fails. Handlers must return promptly once ctx
+// is done, which happens when the task times out, is cancelled, or the agent stops.
+type Handler func(ctx context.Context, task Task, output io.Writer) error
+
+// Registry maps task types to their handlers
+type Registry struct {
+	handlers map[string]Handler
+	mutex    sync.RWMutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for a task type. Registering a type twice is a programming error.
+func (r *Registry) Register(taskType string, handler Handler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.handlers[taskType]; exists {
+		panic(fmt.Sprintf("executor: handler for task type '%s' registered twice", taskType))
+	}
+	r.handlers[taskType] = handler
+}
+
+// Lookup returns the handler for a task type
+func (r *Registry) Lookup(taskType string) (Handler, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	handler, exists := r.handlers[taskType]
+	return handler, exists
+}
+
+// Types returns the registered task types, sorted
+func (r *Registry) Types() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	types := make([]string, 0, len(r.handlers))
+	for taskType := range r.handlers {
+		types = append(types, taskType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// DefaultRegistry holds the handlers task packages register from their init functions
+var DefaultRegistry = NewRegistry()
+
+// Register sets the handler for a task type in the default registry
+func Register(taskType string, handler Handler) {
+	DefaultRegistry.Register(taskType, handler)
+}
@@ -0,0 +1,69 @@
+// Package hostinfo collects the host metadata an agent reports when it registers with the server
+package hostinfo
+
+import (
+	"firestarter/internal/registration"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+)
+
+// Collect describes the host and process the agent runs as. Anything that can't be determined
+// is left empty rather than failing, a partial registration is still worth sending.
+func Collect(buildTime string, buildProtocol string) registration.HostInfo {
+	info := registration.HostInfo{
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		PID:           os.Getpid(),
+		Username:      username(),
+		Interfaces:    interfaces(),
+		BuildTime:     buildTime,
+		BuildProtocol: buildProtocol,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		info.Hostname = hostname
+	}
+	if path, err := os.Executable(); err == nil {
+		info.ProcessPath = path
+	}
+	return info
+}
+
+// username returns the user the agent runs as, falling back to the environment when the
+// user database can't be read
+func username() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// interfaces lists the host's interfaces that are up and have an address, loopback excluded
+func interfaces() []registration.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var result []registration.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil || len(addrs) == 0 {
+			continue
+		}
+
+		entry := registration.Interface{Name: iface.Name, MAC: iface.HardwareAddr.String()}
+		for _, addr := range addrs {
+			entry.Addresses = append(entry.Addresses, addr.String())
+		}
+		result = append(result, entry)
+	}
+	return result
+}
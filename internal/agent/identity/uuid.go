@@ -1,6 +1,7 @@
 package identity
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -40,6 +41,16 @@ func GetOrCreateAgentUUID() (string, error) {
 	return newUUID, nil
 }
 
+// RemoveIdentityFile deletes the stored agent UUID, so nothing left on the host ties it to the
+// agent. It is not an error for the file not to exist.
+func RemoveIdentityFile() error {
+	err := os.Remove(getIdentityFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
 // getIdentityFilePath returns the path to the identity file
 func getIdentityFilePath() string {
 	// In a real C2 agent, you might want to use a less obvious location
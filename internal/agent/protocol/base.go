@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BaseHTTPProtocol provides the request handling shared by all HTTP-based protocols.
+// Protocol-specific types embed it and only need to build the HTTP client in Initialize.
+type BaseHTTPProtocol struct {
+	// Configuration
+	config ProtocolConfig
+
+	// URL scheme used for requests ("http" or "https")
+	scheme string
+
+	// Method used for idempotent requests (connect and health checks)
+	getMethod string
+
+	// HTTP client for connection
+	client *http.Client
+
+	// Connection state
+	connected     bool
+	connectedLock sync.RWMutex
+
+	// Activity tracking
+	lastActivity     time.Time
+	lastActivityLock sync.RWMutex
+}
+
+// newBaseHTTPProtocol creates the shared state for an HTTP-based protocol
+func newBaseHTTPProtocol(scheme string) BaseHTTPProtocol {
+	return BaseHTTPProtocol{
+		scheme:       scheme,
+		getMethod:    http.MethodGet,
+		lastActivity: time.Now(),
+	}
+}
+
+// Connect establishes a connection to the server
+func (p *BaseHTTPProtocol) Connect(ctx context.Context) error {
+	// Create request to check if server is reachable
+	req, err := http.NewRequestWithContext(ctx, p.getMethod, p.buildURL(p.config.HealthCheckEndpoint), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add the agent UUID to the request
+	req.Header.Set("X-Agent-UUID", p.config.AgentUUID)
+	if err := signRequest(p.config, req, nil); err != nil {
+		return err
+	}
+
+	// Send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.setConnected(false)
+		return fmt.Errorf("connection failed: %w", err)
+	}
+
+	// Fully read and discard the response body to properly reuse the connection
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close() // Close immediately after reading
+
+	// Check if the response is successful
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		p.setConnected(false)
+		return responseError(resp.StatusCode, resp.Header.Get("Retry-After"), nil)
+	}
+
+	// Update connection status and last activity
+	p.setConnected(true)
+	p.updateLastActivity()
+
+	return nil
+}
+
+// Disconnect terminates the connection to the server
+func (p *BaseHTTPProtocol) Disconnect() error {
+	// HTTP is stateless, so we mark ourselves as disconnected and drop any
+	// idle connections the client is still holding on to
+	if p.client != nil {
+		p.client.CloseIdleConnections()
+	}
+	p.setConnected(false)
+	return nil
+}
+
+// IsConnected returns whether the connection is currently active
+func (p *BaseHTTPProtocol) IsConnected() bool {
+	p.connectedLock.RLock()
+	defer p.connectedLock.RUnlock()
+	return p.connected
+}
+
+// SendRequest sends a request to the server and returns the response
+func (p *BaseHTTPProtocol) SendRequest(ctx context.Context, endpoint string, payload []byte) ([]byte, error) {
+	// Ensure we're connected
+	if !p.IsConnected() {
+		return nil, fmt.Errorf("not connected to server")
+	}
+
+	// Compress the body when it is worth it, the signature covers it as sent
+	body, encoding, err := compressBody(p.config, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", p.buildURL(endpoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add headers
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Agent-UUID", p.config.AgentUUID)
+	setCompressionHeaders(p.config, req.Header, encoding)
+	if err := signRequest(p.config, req, body); err != nil {
+		return nil, err
+	}
+
+	// Send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.setConnected(false)
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the response is successful
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		explanation, _ := io.ReadAll(io.LimitReader(resp.Body, maxStatusExplanation))
+		return nil, responseError(resp.StatusCode, resp.Header.Get("Retry-After"), explanation)
+	}
+
+	// Read the response body
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if respBody, err = decompressBody(resp.Header.Get("Content-Encoding"), respBody); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Update last activity
+	p.updateLastActivity()
+
+	return respBody, nil
+}
+
+// PerformHealthCheck conducts a health check against the server
+func (p *BaseHTTPProtocol) PerformHealthCheck(ctx context.Context) error {
+	// Create a simple GET request to any endpoint (root is fine)
+	req, err := http.NewRequestWithContext(ctx, p.getMethod, p.buildURL("/"), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health check request: %w", err)
+	}
+
+	// Add the agent UUID header
+	req.Header.Set("X-Agent-UUID", p.config.AgentUUID)
+	if err := signRequest(p.config, req, nil); err != nil {
+		return err
+	}
+
+	// Send the request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.setConnected(false)
+		return fmt.Errorf("health check failed: %w", err)
+	}
+
+	// The one crucial step: fully read the response body before closing
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The server is reachable, but asked to be left alone for a while
+	if err := responseError(resp.StatusCode, resp.Header.Get("Retry-After"), nil); isRetryHint(err) {
+		return err
+	}
+
+	// We got a response, so we're connected!
+	p.setConnected(true)
+	p.updateLastActivity()
+
+	return nil
+}
+
+// GetLastActivity returns the time of the last successful communication
+func (p *BaseHTTPProtocol) GetLastActivity() time.Time {
+	p.lastActivityLock.RLock()
+	defer p.lastActivityLock.RUnlock()
+	return p.lastActivity
+}
+
+// buildURL returns the full URL for an endpoint on the target server
+func (p *BaseHTTPProtocol) buildURL(endpoint string) string {
+	return fmt.Sprintf("%s://%s:%s%s",
+		p.scheme,
+		p.config.TargetHost,
+		p.config.TargetPort,
+		endpoint)
+}
+
+// Helper method to update the last activity time
+func (p *BaseHTTPProtocol) updateLastActivity() {
+	p.lastActivityLock.Lock()
+	defer p.lastActivityLock.Unlock()
+	p.lastActivity = time.Now()
+}
+
+// Helper method to update the connection status
+func (p *BaseHTTPProtocol) setConnected(connected bool) {
+	p.connectedLock.Lock()
+	defer p.connectedLock.Unlock()
+	p.connected = connected
+}
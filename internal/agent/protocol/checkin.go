@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrCheckInUnsupported is returned by CheckIn when the server has no check-in endpoint
+var ErrCheckInUnsupported = errors.New("server does not support check-in")
+
+// Work is a unit of server-initiated work received during a check-in
+type Work struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Payload   []byte    `json:"payload,omitempty"`
+	Sealed    bool      `json:"sealed,omitempty"` // Payload is sealed with the end-to-end key
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CheckIn holds a long-poll request open until the server has work or the hold expires,
+// passing each work item to handler as it arrives. Over HTTP/2 and HTTP/3 the server keeps
+// the stream open for the whole hold, so several items may arrive on a single check-in.
+// The context must allow for the full hold plus the time needed to complete the request.
+func (p *BaseHTTPProtocol) CheckIn(ctx context.Context, endpoint string, hold time.Duration, handler func(Work)) error {
+	// Ensure we're connected
+	if !p.IsConnected() {
+		return fmt.Errorf("not connected to server")
+	}
+
+	// Check-ins dequeue work on the server, so never send them as replayable early data
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.buildURL(endpoint), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create check-in request: %w", err)
+	}
+
+	req.Header.Set("X-Agent-UUID", p.config.AgentUUID)
+	req.Header.Set("X-Poll-Timeout", strconv.Itoa(int(hold.Seconds())))
+	req.Header.Set("Accept", "application/x-ndjson")
+	setCompressionHeaders(p.config, req.Header, "")
+	if err := signRequest(p.config, req, nil); err != nil {
+		return err
+	}
+
+	resp, err := p.pollClient().Do(req)
+	if err != nil {
+		p.setConnected(false)
+		return fmt.Errorf("check-in failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		io.Copy(io.Discard, resp.Body)
+		return ErrCheckInUnsupported
+	case resp.StatusCode == http.StatusNoContent:
+		// Hold expired without any work
+		p.updateLastActivity()
+		return nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		io.Copy(io.Discard, resp.Body)
+		return responseError(resp.StatusCode, resp.Header.Get("Retry-After"), nil)
+	}
+
+	// The stream is established, which is as good as a successful health check
+	p.updateLastActivity()
+
+	stream, err := decompressStream(resp.Header.Get("Content-Encoding"), resp.Body)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var work Work
+		if err := decoder.Decode(&work); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			p.setConnected(false)
+			return fmt.Errorf("check-in stream interrupted: %w", err)
+		}
+
+		p.updateLastActivity()
+		handler(work)
+	}
+}
+
+// pollClient returns a client sharing the protocol's transport but without the per-request
+// timeout, since a held check-in is expected to outlive it. The caller's context bounds it instead.
+func (p *BaseHTTPProtocol) pollClient() *http.Client {
+	client := *p.client
+	client.Timeout = 0
+	return &client
+}
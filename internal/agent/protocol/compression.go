@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"firestarter/internal/compression"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxDecompressedBody bounds how far a compressed response to a single request may inflate
+const maxDecompressedBody = 64 << 20
+
+// compressBody compresses a request body with the build's codec when it reaches the threshold
+// and comes out smaller, returning the body to send and its encoding ("" when sent as is)
+func compressBody(config ProtocolConfig, body []byte) ([]byte, string, error) {
+	compressed, codec, err := compression.Compress(config.Compression, config.CompressionThreshold, body)
+	if err != nil {
+		return nil, "", err
+	}
+	if codec == compression.Identity {
+		return body, "", nil
+	}
+	return compressed, codec, nil
+}
+
+// decompressBody decodes a response body the server compressed
+func decompressBody(encoding string, body []byte) ([]byte, error) {
+	if encoding == "" || encoding == compression.Identity {
+		return body, nil
+	}
+	if !compression.IsSupported(encoding) {
+		return nil, fmt.Errorf("server answered with unsupported content encoding '%s'", encoding)
+	}
+	return compression.Decompress(encoding, body, maxDecompressedBody)
+}
+
+// setCompressionHeaders announces how a request body is encoded and which codecs the agent
+// accepts for the response. Agents built without compression announce nothing.
+func setCompressionHeaders(config ProtocolConfig, header http.Header, encoding string) {
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+	}
+	if accept := compression.AcceptEncoding(config.Compression); accept != "" {
+		header.Set("Accept-Encoding", accept)
+	}
+}
+
+// decompressStream decodes a streamed response the server compressed. The decompressor is
+// created on the first read, since it reads the codec's header and the body may not have
+// arrived yet.
+func decompressStream(encoding string, stream io.ReadCloser) (io.ReadCloser, error) {
+	if encoding == "" || encoding == compression.Identity {
+		return stream, nil
+	}
+	if !compression.IsSupported(encoding) {
+		stream.Close()
+		return nil, fmt.Errorf("server answered with unsupported content encoding '%s'", encoding)
+	}
+	return &decompressedStream{encoding: encoding, stream: stream}, nil
+}
+
+// decompressedStream is a response body decoded as it is read
+type decompressedStream struct {
+	encoding string
+	stream   io.ReadCloser
+	decoded  io.ReadCloser
+	err      error
+}
+
+func (s *decompressedStream) Read(b []byte) (int, error) {
+	if s.decoded == nil && s.err == nil {
+		s.decoded, s.err = compression.NewReader(s.encoding, s.stream)
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.decoded.Read(b)
+}
+
+func (s *decompressedStream) Close() error {
+	if s.decoded != nil {
+		s.decoded.Close()
+	}
+	return s.stream.Close()
+}
@@ -0,0 +1,45 @@
+package protocol
+
+import (
+	"context"
+	"firestarter/internal/e2e"
+	"firestarter/internal/envelope"
+	"fmt"
+)
+
+// Exchange sends a message envelope to endpoint and decodes the envelope the server answers
+// with. When key is set the message is sealed end to end and the reply must be sealed too.
+// The reply must be of a compatible version and echo the sequence number of msg.
+func Exchange(ctx context.Context, p Protocol, endpoint string, msg *envelope.Envelope, key *e2e.Key) (*envelope.Envelope, error) {
+	body, err := envelope.Marshal(msg, envelope.Binary)
+	if err == nil && key != nil {
+		body, err = key.Seal(e2e.ToServer, body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s message: %w", msg.Type, err)
+	}
+
+	response, err := p.SendRequest(ctx, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		if !e2e.IsSealed(response) {
+			return nil, fmt.Errorf("reply to %s message was not sealed", msg.Type)
+		}
+		if response, err = key.Open(e2e.ToAgent, response); err != nil {
+			return nil, fmt.Errorf("invalid reply to %s message: %w", msg.Type, err)
+		}
+	}
+
+	reply, _, err := envelope.Unmarshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reply to %s message: %w", msg.Type, err)
+	}
+	if reply.Sequence != msg.Sequence {
+		return nil, fmt.Errorf("reply to %s message %d carries sequence %d", msg.Type, msg.Sequence, reply.Sequence)
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,359 @@
+package protocol
+
+import (
+	"context"
+	"firestarter/internal/compression"
+	"firestarter/internal/signing"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// framedReadTimeout is how long the agent waits for any traffic, including the listener's pings
+	framedReadTimeout = 90 * time.Second
+
+	// framedWriteTimeout bounds a single frame write
+	framedWriteTimeout = 10 * time.Second
+
+	// framedWorkBacklog is how many pushed work items are buffered until the agent checks in
+	framedWorkBacklog = 64
+
+	// framedMaxFrameSize matches the listener's limit on a single frame
+	framedMaxFrameSize = 16 << 20
+)
+
+// frame is a single message on a persistent framed transport (WebSocket, raw TCP)
+type frame struct {
+	Type      string `json:"type"` // "hello", "welcome", "request", "response", "work", "ping" or "pong"
+	ID        string `json:"id,omitempty"`
+	AgentUUID string `json:"agentUUID,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Payload   []byte `json:"payload,omitempty"`
+	Work      *Work  `json:"work,omitempty"`
+
+	// Signature authenticates hello and request frames
+	Signature *signing.Signature `json:"signature,omitempty"`
+
+	// Encoding is the codec the payload is compressed with, Accept the codecs taken for the
+	// response, as in the HTTP headers of the same names
+	Encoding string `json:"encoding,omitempty"`
+	Accept   string `json:"accept,omitempty"`
+
+	// RetryAfter is the Retry-After hint of a response, or of a welcome turning the agent away
+	RetryAfter string `json:"retryAfter,omitempty"`
+}
+
+// frameConn is a connected transport that carries whole frames
+type frameConn interface {
+	readFrame(f *frame) error
+	writeFrame(f frame) error
+	setReadDeadline(t time.Time) error
+	setWriteDeadline(t time.Time) error
+	close() error
+}
+
+// BaseFramedProtocol provides the request handling shared by persistent framed transports.
+// Requests and responses are correlated by ID, and work is pushed by the server at any time.
+// Transport-specific types embed it and only need to provide dial in Initialize.
+type BaseFramedProtocol struct {
+	// Configuration
+	config ProtocolConfig
+
+	// dial opens and, where needed, handshakes a new transport connection
+	dial func(ctx context.Context) (frameConn, error)
+
+	// Active connection, replaced on every Connect
+	conn     frameConn
+	closed   chan struct{} // Closed when the read loop of conn exits
+	connLock sync.RWMutex
+
+	// Frames must not be written concurrently
+	writeLock sync.Mutex
+
+	// Requests waiting for their response frame
+	pending     map[string]chan frame
+	pendingLock sync.Mutex
+
+	// Work pushed by the server, drained by CheckIn
+	work chan Work
+
+	// Connection state
+	connected     bool
+	connectedLock sync.RWMutex
+
+	// Activity tracking
+	lastActivity     time.Time
+	lastActivityLock sync.RWMutex
+}
+
+// newBaseFramedProtocol creates the shared state for a framed protocol
+func newBaseFramedProtocol() BaseFramedProtocol {
+	return BaseFramedProtocol{
+		pending:      make(map[string]chan frame),
+		work:         make(chan Work, framedWorkBacklog),
+		lastActivity: time.Now(),
+	}
+}
+
+// Connect opens a new transport connection, replacing any previous one
+func (p *BaseFramedProtocol) Connect(ctx context.Context) error {
+	conn, err := p.dial(ctx)
+	if err != nil {
+		p.setConnected(false)
+		return fmt.Errorf("connection failed: %w", err)
+	}
+
+	// Drop whatever connection we had before
+	p.closeConn()
+
+	closed := make(chan struct{})
+	p.connLock.Lock()
+	p.conn = conn
+	p.closed = closed
+	p.connLock.Unlock()
+
+	go p.readLoop(conn, closed)
+
+	p.setConnected(true)
+	p.updateLastActivity()
+	return nil
+}
+
+// Disconnect closes the transport connection
+func (p *BaseFramedProtocol) Disconnect() error {
+	p.closeConn()
+	p.setConnected(false)
+	return nil
+}
+
+// IsConnected returns whether the connection is currently active
+func (p *BaseFramedProtocol) IsConnected() bool {
+	p.connectedLock.RLock()
+	defer p.connectedLock.RUnlock()
+	return p.connected
+}
+
+// SendRequest sends a request frame and waits for the matching response
+func (p *BaseFramedProtocol) SendRequest(ctx context.Context, endpoint string, payload []byte) ([]byte, error) {
+	return p.roundTrip(ctx, http.MethodPost, endpoint, payload)
+}
+
+// PerformHealthCheck conducts a health check against the server
+func (p *BaseFramedProtocol) PerformHealthCheck(ctx context.Context) error {
+	if _, err := p.roundTrip(ctx, http.MethodGet, "/", nil); err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	return nil
+}
+
+// CheckIn waits for work pushed by the server. The connection is already held open, so no
+// request is made; the call returns when the hold expires or the connection is lost.
+func (p *BaseFramedProtocol) CheckIn(ctx context.Context, endpoint string, hold time.Duration, handler func(Work)) error {
+	if !p.IsConnected() {
+		return fmt.Errorf("not connected to server")
+	}
+
+	p.connLock.RLock()
+	closed := p.closed
+	p.connLock.RUnlock()
+
+	timer := time.NewTimer(hold)
+	defer timer.Stop()
+
+	for {
+		select {
+		case work := <-p.work:
+			handler(work)
+		case <-timer.C:
+			return nil
+		case <-closed:
+			return fmt.Errorf("connection lost")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// GetLastActivity returns the time of the last successful communication
+func (p *BaseFramedProtocol) GetLastActivity() time.Time {
+	p.lastActivityLock.RLock()
+	defer p.lastActivityLock.RUnlock()
+	return p.lastActivity
+}
+
+// roundTrip sends a request frame and waits for its response frame
+func (p *BaseFramedProtocol) roundTrip(ctx context.Context, method string, endpoint string, payload []byte) ([]byte, error) {
+	// Ensure we're connected
+	if !p.IsConnected() {
+		return nil, fmt.Errorf("not connected to server")
+	}
+
+	// Compress the payload when it is worth it, the signature covers it as sent
+	body, encoding, err := compressBody(p.config, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	request := frame{
+		Type:     "request",
+		ID:       uuid.New().String(),
+		Method:   method,
+		Endpoint: endpoint,
+		Payload:  body,
+		Encoding: encoding,
+		Accept:   compression.AcceptEncoding(p.config.Compression),
+	}
+	if err := signFrame(p.config, &request); err != nil {
+		return nil, err
+	}
+
+	responseChan := make(chan frame, 1)
+	p.pendingLock.Lock()
+	p.pending[request.ID] = responseChan
+	p.pendingLock.Unlock()
+
+	defer func() {
+		p.pendingLock.Lock()
+		delete(p.pending, request.ID)
+		p.pendingLock.Unlock()
+	}()
+
+	if err := p.write(request); err != nil {
+		p.setConnected(false)
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	select {
+	case response, ok := <-responseChan:
+		if !ok {
+			return nil, fmt.Errorf("request failed: connection lost")
+		}
+		payload, err := decompressBody(response.Encoding, response.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		if response.Status < 200 || response.Status >= 300 {
+			return nil, responseError(response.Status, response.RetryAfter, payload)
+		}
+		p.updateLastActivity()
+		return payload, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("request failed: %w", ctx.Err())
+	}
+}
+
+// readLoop delivers incoming frames until the connection fails
+func (p *BaseFramedProtocol) readLoop(conn frameConn, closed chan struct{}) {
+	defer func() {
+		close(closed)
+		p.failPending()
+
+		// Only a failure of the current connection means we are disconnected
+		p.connLock.RLock()
+		current := p.conn == conn
+		p.connLock.RUnlock()
+		if current {
+			p.setConnected(false)
+		}
+	}()
+
+	for {
+		conn.setReadDeadline(time.Now().Add(framedReadTimeout))
+
+		var incoming frame
+		if err := conn.readFrame(&incoming); err != nil {
+			return
+		}
+		p.updateLastActivity()
+
+		switch incoming.Type {
+		case "response":
+			p.pendingLock.Lock()
+			responseChan, exists := p.pending[incoming.ID]
+			p.pendingLock.Unlock()
+			if exists {
+				responseChan <- incoming
+			}
+		case "work":
+			if incoming.Work == nil {
+				continue
+			}
+			select {
+			case p.work <- *incoming.Work:
+			default:
+				// Never block the read loop, responses must keep flowing
+				log.Printf("Dropping %s work %s: backlog full", incoming.Work.Type, incoming.Work.ID)
+			}
+		case "ping":
+			// Keep-alive on transports without control frames
+			if err := p.writeTo(conn, frame{Type: "pong"}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// failPending releases every request still waiting for a response
+func (p *BaseFramedProtocol) failPending() {
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	for id, responseChan := range p.pending {
+		close(responseChan)
+		delete(p.pending, id)
+	}
+}
+
+// write sends a single frame on the current connection
+func (p *BaseFramedProtocol) write(f frame) error {
+	p.connLock.RLock()
+	conn := p.conn
+	p.connLock.RUnlock()
+	if conn == nil {
+		return fmt.Errorf("no open connection")
+	}
+	return p.writeTo(conn, f)
+}
+
+// writeTo sends a single frame on the given connection
+func (p *BaseFramedProtocol) writeTo(conn frameConn, f frame) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	conn.setWriteDeadline(time.Now().Add(framedWriteTimeout))
+	return conn.writeFrame(f)
+}
+
+// closeConn closes the current connection, if any
+func (p *BaseFramedProtocol) closeConn() {
+	p.connLock.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.connLock.Unlock()
+
+	if conn != nil {
+		p.writeLock.Lock()
+		conn.close()
+		p.writeLock.Unlock()
+	}
+}
+
+// Helper method to update the last activity time
+func (p *BaseFramedProtocol) updateLastActivity() {
+	p.lastActivityLock.Lock()
+	defer p.lastActivityLock.Unlock()
+	p.lastActivity = time.Now()
+}
+
+// Helper method to update the connection status
+func (p *BaseFramedProtocol) setConnected(connected bool) {
+	p.connectedLock.Lock()
+	defer p.connectedLock.Unlock()
+	p.connected = connected
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// H1TLSProtocol implements the Protocol interface for HTTP/1.1 over TLS (H1TLS)
+type H1TLSProtocol struct {
+	BaseHTTPProtocol
+}
+
+// NewH1TLSProtocol creates a new instance of the H1TLS protocol
+func NewH1TLSProtocol() *H1TLSProtocol {
+	return &H1TLSProtocol{
+		BaseHTTPProtocol: newBaseHTTPProtocol("https"),
+	}
+}
+
+// Initialize sets up the H1TLS protocol with the provided configuration
+func (p *H1TLSProtocol) Initialize(config ProtocolConfig) error {
+	p.config = config
+
+	proxy, err := newProxySelector(config)
+	if err != nil {
+		return err
+	}
+
+	// Only offer http/1.1 during ALPN so the listener never upgrades us to h2
+	tlsConfig, err := newTLSClientConfig(config, []string{"http/1.1"})
+	if err != nil {
+		return err
+	}
+
+	// Create the HTTP client with appropriate timeouts
+	p.client = &http.Client{
+		Timeout: config.RequestTimeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        1,
+			IdleConnTimeout:     24 * time.Hour,
+			DisableCompression:  true,
+			MaxConnsPerHost:     5,
+			ForceAttemptHTTP2:   false, // Ensure HTTP/1.1 is used
+			TLSHandshakeTimeout: config.ConnectionTimeout,
+			Proxy:               proxy.forTransport(), // Tunnelled with CONNECT
+			TLSClientConfig:     tlsConfig,
+			// Custom dialer with keepalives enabled
+			DialContext: (&net.Dialer{
+				Timeout:   config.ConnectionTimeout,
+				KeepAlive: 5 * time.Minute,
+			}).DialContext,
+		},
+	}
+
+	return nil
+}
+
+// Name returns the name of the protocol
+func (p *H1TLSProtocol) Name() string {
+	return "H1TLS"
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// H2CProtocol implements the Protocol interface for HTTP/2 Clear (H2C) using prior knowledge
+type H2CProtocol struct {
+	BaseHTTPProtocol
+}
+
+// NewH2CProtocol creates a new instance of the H2C protocol
+func NewH2CProtocol() *H2CProtocol {
+	return &H2CProtocol{
+		BaseHTTPProtocol: newBaseHTTPProtocol("http"),
+	}
+}
+
+// Initialize sets up the H2C protocol with the provided configuration
+func (p *H2CProtocol) Initialize(config ProtocolConfig) error {
+	p.config = config
+
+	proxy, err := newProxySelector(config)
+	if err != nil {
+		return err
+	}
+
+	// Custom dialer with keepalives enabled
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectionTimeout,
+		KeepAlive: 5 * time.Minute,
+	}
+
+	// The HTTP/2 transport speaks h2 straight away (prior knowledge) when AllowHTTP
+	// is set and the "TLS" dial hands back a plain TCP connection. All health checks
+	// and requests are multiplexed as streams over that single connection.
+	// Proxies cannot forward prior-knowledge h2, so a CONNECT tunnel is always used
+	// when a proxy applies.
+	p.client = &http.Client{
+		Timeout: config.RequestTimeout,
+		Transport: &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: true,
+			// Queue requests on the existing connection rather than dialing a second one
+			// when the server's concurrent stream limit is reached
+			StrictMaxConcurrentStreams: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialTCP(ctx, dialer, proxy, "http", addr)
+			},
+		},
+	}
+
+	return nil
+}
+
+// Name returns the name of the protocol
+func (p *H2CProtocol) Name() string {
+	return "H2C"
+}
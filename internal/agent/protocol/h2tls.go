@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// H2TLSProtocol implements the Protocol interface for HTTP/2 over TLS (H2TLS)
+type H2TLSProtocol struct {
+	BaseHTTPProtocol
+}
+
+// NewH2TLSProtocol creates a new instance of the H2TLS protocol
+func NewH2TLSProtocol() *H2TLSProtocol {
+	return &H2TLSProtocol{
+		BaseHTTPProtocol: newBaseHTTPProtocol("https"),
+	}
+}
+
+// Initialize sets up the H2TLS protocol with the provided configuration
+func (p *H2TLSProtocol) Initialize(config ProtocolConfig) error {
+	p.config = config
+
+	proxy, err := newProxySelector(config)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := newTLSClientConfig(config, []string{"h2"})
+	if err != nil {
+		return err
+	}
+
+	// Custom dialer with keepalives enabled
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectionTimeout,
+		KeepAlive: 5 * time.Minute,
+	}
+
+	// Use the HTTP/2 transport directly so that a listener which fails to
+	// negotiate "h2" via ALPN is treated as an error instead of silently
+	// falling back to HTTP/1.1
+	p.client = &http.Client{
+		Timeout: config.RequestTimeout,
+		Transport: &http2.Transport{
+			DisableCompression: true,
+			TLSClientConfig:    tlsConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				// Direct or through a CONNECT tunnel when a proxy applies
+				rawConn, err := dialTCP(ctx, dialer, proxy, "https", addr)
+				if err != nil {
+					return nil, err
+				}
+
+				conn := tls.Client(rawConn, cfg)
+				if err := conn.HandshakeContext(ctx); err != nil {
+					rawConn.Close()
+					return nil, err
+				}
+
+				// A custom dialer bypasses the transport's own ALPN check, so repeat it here
+				if proto := conn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+					conn.Close()
+					return nil, fmt.Errorf("listener negotiated ALPN protocol %q, expected %q", proto, http2.NextProtoTLS)
+				}
+				return conn, nil
+			},
+		},
+	}
+
+	return nil
+}
+
+// Name returns the name of the protocol
+func (p *H2TLSProtocol) Name() string {
+	return "H2TLS"
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// sessionCacheSize is the number of TLS session tickets kept for resumption
+const sessionCacheSize = 8
+
+// H3Protocol implements the Protocol interface for HTTP/3 over QUIC (H3)
+type H3Protocol struct {
+	BaseHTTPProtocol
+
+	// HTTP/3 transport, which caches and reuses the QUIC connection
+	transport *http3.Transport
+}
+
+// NewH3Protocol creates a new instance of the H3 protocol
+func NewH3Protocol() *H3Protocol {
+	p := &H3Protocol{
+		BaseHTTPProtocol: newBaseHTTPProtocol("https"),
+	}
+
+	// Connect and health check requests are idempotent, so it is safe to send
+	// them as 0-RTT data when a connection is resumed
+	p.getMethod = http3.MethodGet0RTT
+
+	return p
+}
+
+// Initialize sets up the H3 protocol with the provided configuration
+func (p *H3Protocol) Initialize(config ProtocolConfig) error {
+	p.config = config
+
+	// QUIC runs over UDP and cannot be carried through an HTTP CONNECT tunnel, so
+	// refuse rather than silently bypassing a proxy the operator asked for
+	proxy, err := newProxySelector(config)
+	if err != nil {
+		return err
+	}
+	if proxy != nil {
+		target := &url.URL{Scheme: "https", Host: net.JoinHostPort(config.TargetHost, config.TargetPort)}
+		if proxyURL, _ := proxy(target); proxyURL != nil {
+			return fmt.Errorf("H3 cannot be used through HTTP proxy %s", proxyURL.Redacted())
+		}
+	}
+
+	// ALPN is set by the transport itself based on the negotiated QUIC version
+	tlsConfig, err := newTLSClientConfig(config, nil)
+	if err != nil {
+		return err
+	}
+
+	// Keep session tickets so that a QUIC connection lost to a short network drop
+	// can be resumed (with 0-RTT) instead of performing a full handshake
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(sessionCacheSize)
+
+	p.transport = &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		QUICConfig: &quic.Config{
+			HandshakeIdleTimeout: config.ConnectionTimeout,
+			MaxIdleTimeout:       config.QUICIdleTimeout,
+			KeepAlivePeriod:      config.QUICKeepAlivePeriod,
+		},
+		DisableCompression: true,
+	}
+
+	// Create the HTTP client, all requests share the transport's cached QUIC connection
+	p.client = &http.Client{
+		Timeout:   config.RequestTimeout,
+		Transport: p.transport,
+	}
+
+	return nil
+}
+
+// Name returns the name of the protocol
+func (p *H3Protocol) Name() string {
+	return "H3"
+}
@@ -2,6 +2,11 @@ package protocol
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,6 +29,44 @@ type ProtocolConfig struct {
 
 	// HealthCheckEndpoint specifies the endpoint used for health checks
 	HealthCheckEndpoint string
+
+	// SigningSecret authenticates every request to the server, nil sends requests unsigned
+	SigningSecret []byte
+
+	// Compression is the codec request bodies are compressed with and the one preferred for
+	// responses, compression.Identity disables compression both ways
+	Compression string
+
+	// CompressionThreshold is the smallest body that is compressed, in bytes
+	CompressionThreshold int
+
+	// TLSCABundle is a PEM-encoded set of CA certificates trusted for TLS listeners
+	// When empty the system roots are used
+	TLSCABundle []byte
+
+	// TLSPins are base64-encoded SHA-256 hashes of trusted SubjectPublicKeyInfo structures
+	TLSPins []string
+
+	// TLSClientCert and TLSClientKey are the PEM-encoded client certificate presented to
+	// listeners that require mutual TLS
+	TLSClientCert []byte
+	TLSClientKey  []byte
+
+	// ProxyURL is an explicit HTTP(S) proxy, e.g. http://proxy.corp:3128
+	ProxyURL string
+
+	// ProxyFromEnvironment uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY when no explicit proxy is set
+	ProxyFromEnvironment bool
+
+	// ProxyUsername and ProxyPassword enable basic authentication against the proxy
+	ProxyUsername string
+	ProxyPassword string
+
+	// QUICIdleTimeout is how long a QUIC connection may stay idle before it is closed (H3 only)
+	QUICIdleTimeout time.Duration
+
+	// QUICKeepAlivePeriod is how often QUIC keep-alive packets are sent, zero disables them (H3 only)
+	QUICKeepAlivePeriod time.Duration
 }
 
 // Protocol defines the interface that all communication protocols must implement
@@ -46,9 +89,118 @@ type Protocol interface {
 	// PerformHealthCheck conducts a health check against the server
 	PerformHealthCheck(ctx context.Context) error
 
+	// CheckIn holds a long-poll request open until the server has work or the hold expires,
+	// passing each received work item to handler
+	CheckIn(ctx context.Context, endpoint string, hold time.Duration, handler func(Work)) error
+
 	// GetLastActivity returns the time of the last successful communication
 	GetLastActivity() time.Time
 
 	// Name returns the name of the protocol (e.g., "H1C", "H2C", etc.)
 	Name() string
 }
+
+// maxStatusExplanation bounds how much of an error response is quoted in the returned error
+const maxStatusExplanation = 256
+
+// statusError describes a non-success response, quoting the server's explanation when it sent one
+func statusError(status int, body []byte) error {
+	if len(body) > maxStatusExplanation {
+		body = body[:maxStatusExplanation]
+	}
+	explanation := strings.TrimSpace(string(body))
+	if explanation == "" {
+		return fmt.Errorf("server returned non-success status: %d", status)
+	}
+	return fmt.Errorf("server returned non-success status: %d (%s)", status, explanation)
+}
+
+// maxRetryAfter caps the delay a server may ask the agent to wait
+const maxRetryAfter = 24 * time.Hour
+
+// RetryAfterError is returned when the server turned the agent away with a Retry-After hint,
+// e.g. while its listener is in maintenance. The agent should wait Delay before trying again.
+type RetryAfterError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", e.Err, e.Delay)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter returns the delay the server asked for when err carries a Retry-After hint
+func RetryAfter(err error) (time.Duration, bool) {
+	var hint *RetryAfterError
+	if errors.As(err, &hint) {
+		return hint.Delay, true
+	}
+	return 0, false
+}
+
+// isRetryHint reports whether err carries a Retry-After hint
+func isRetryHint(err error) bool {
+	_, ok := RetryAfter(err)
+	return ok
+}
+
+// responseError describes a non-success response, as a *RetryAfterError when the server asked
+// the agent to come back later
+func responseError(status int, retryAfter string, body []byte) error {
+	err := statusError(status, body)
+	if status != http.StatusServiceUnavailable && status != http.StatusTooManyRequests {
+		return err
+	}
+	if delay, ok := parseRetryAfter(retryAfter); ok {
+		return &RetryAfterError{Delay: delay, Err: err}
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After value, given either in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(min(seconds, int64(maxRetryAfter/time.Second))) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = max(time.Until(date), 0)
+	} else {
+		return 0, false
+	}
+
+	return min(delay, maxRetryAfter), true
+}
+
+// NewProtocol creates an uninitialized protocol implementation by name (e.g., "H1C", "H2TLS")
+func NewProtocol(name string) (Protocol, error) {
+	switch strings.ToUpper(name) {
+	case "H1C":
+		return NewH1CProtocol(), nil
+	case "H1TLS":
+		return NewH1TLSProtocol(), nil
+	case "H2C":
+		return NewH2CProtocol(), nil
+	case "H2TLS":
+		return NewH2TLSProtocol(), nil
+	case "H3":
+		return NewH3Protocol(), nil
+	case "WS":
+		return NewWSProtocol(), nil
+	case "TCP":
+		return NewTCPProtocol(), nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", name)
+	}
+}
@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxySelector returns the proxy to use for a target URL, or nil to connect directly
+type proxySelector func(target *url.URL) (*url.URL, error)
+
+// newProxySelector builds the proxy selector from the protocol configuration
+// An explicit proxy URL takes precedence over the environment (HTTP_PROXY, HTTPS_PROXY, NO_PROXY)
+// Returns nil when no proxy is configured
+func newProxySelector(config ProtocolConfig) (proxySelector, error) {
+	var selector proxySelector
+	switch {
+	case config.ProxyURL != "":
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		selector = func(*url.URL) (*url.URL, error) {
+			return proxyURL, nil
+		}
+	case config.ProxyFromEnvironment:
+		selector = httpproxy.FromEnvironment().ProxyFunc()
+	default:
+		return nil, nil
+	}
+
+	if config.ProxyUsername == "" {
+		return selector, nil
+	}
+
+	// Apply the basic auth credentials to whichever proxy gets selected
+	return func(target *url.URL) (*url.URL, error) {
+		proxyURL, err := selector(target)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		withAuth := *proxyURL
+		withAuth.User = url.UserPassword(config.ProxyUsername, config.ProxyPassword)
+		return &withAuth, nil
+	}, nil
+}
+
+// forTransport adapts the selector to http.Transport's Proxy field (nil means no proxy)
+func (s proxySelector) forTransport() func(*http.Request) (*url.URL, error) {
+	if s == nil {
+		return nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		return s(req.URL)
+	}
+}
+
+// dialTCP connects to addr directly or, when a proxy applies to the target, through a CONNECT tunnel
+func dialTCP(ctx context.Context, dialer *net.Dialer, proxy proxySelector, scheme string, addr string) (net.Conn, error) {
+	if proxy != nil {
+		proxyURL, err := proxy(&url.URL{Scheme: scheme, Host: addr})
+		if err != nil {
+			return nil, fmt.Errorf("proxy selection failed: %w", err)
+		}
+		if proxyURL != nil {
+			return dialConnectTunnel(ctx, dialer, proxyURL, addr)
+		}
+	}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// dialConnectTunnel opens a tunnel to addr through an HTTP(S) proxy using the CONNECT method
+func dialConnectTunnel(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		defaultPort := "80"
+		if proxyURL.Scheme == "https" {
+			defaultPort = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), defaultPort)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach proxy %s: %w", proxyAddr, err)
+	}
+
+	// TLS to the proxy itself, independent of whatever runs inside the tunnel
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy %s failed: %w", proxyAddr, err)
+		}
+		conn = tlsConn
+	}
+
+	// Bound the CONNECT exchange by the dial context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy %s: %w", proxyAddr, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy %s: %w", proxyAddr, err)
+	}
+
+	// The body is deliberately left unread: after a successful CONNECT it is the tunnel itself
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", proxyAddr, addr, resp.Status)
+	}
+
+	// Clear the handshake deadline, the tunnel now belongs to the caller
+	conn.SetDeadline(time.Time{})
+
+	// Keep any bytes the proxy already forwarded from the target
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads drain a buffered reader first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
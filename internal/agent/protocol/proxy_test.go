@@ -0,0 +1,230 @@
+package protocol
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standInProxy is an HTTP proxy that only tunnels CONNECT requests, optionally requiring basic
+// authentication, and records what it was asked for
+type standInProxy struct {
+	server        *httptest.Server
+	authorization string // Proxy-Authorization required, empty to accept anyone
+
+	mutex    sync.Mutex
+	requests []string // Targets asked for
+}
+
+func newStandInProxy(t *testing.T, username string, password string) *standInProxy {
+	t.Helper()
+
+	p := &standInProxy{}
+	if username != "" {
+		p.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	p.server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *standInProxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	p.requests = append(p.requests, r.Host)
+	p.mutex.Unlock()
+
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if p.authorization != "" && r.Header.Get("Proxy-Authorization") != p.authorization {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	buffered.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+	buffered.Flush()
+
+	go io.Copy(target, buffered)
+	io.Copy(client, target)
+}
+
+func (p *standInProxy) targets() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+// newEchoServer starts a TCP server echoing whatever it receives, returning its address
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func dialThrough(t *testing.T, config ProtocolConfig, addr string) (net.Conn, error) {
+	t.Helper()
+
+	selector, err := newProxySelector(config)
+	if err != nil {
+		t.Fatalf("newProxySelector: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return dialTCP(ctx, &net.Dialer{}, selector, "http", addr)
+}
+
+func TestDialThroughConnectTunnel(t *testing.T) {
+	proxy := newStandInProxy(t, "agent", "s3cret")
+	target := newEchoServer(t)
+
+	conn, err := dialThrough(t, ProtocolConfig{
+		ProxyURL:      proxy.server.URL,
+		ProxyUsername: "agent",
+		ProxyPassword: "s3cret",
+	}, target)
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write through tunnel: %v", err)
+	}
+	echoed := make([]byte, 4)
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatalf("read through tunnel: %v", err)
+	}
+	if string(echoed) != "ping" {
+		t.Errorf("tunnel echoed %q, want %q", echoed, "ping")
+	}
+
+	if targets := proxy.targets(); len(targets) != 1 || targets[0] != target {
+		t.Errorf("proxy was asked for %v, want [%s]", targets, target)
+	}
+}
+
+func TestDialThroughProxyRefused(t *testing.T) {
+	proxy := newStandInProxy(t, "agent", "s3cret")
+	target := newEchoServer(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"no credentials", "", ""},
+		{"wrong password", "agent", "guess"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := dialThrough(t, ProtocolConfig{
+				ProxyURL:      proxy.server.URL,
+				ProxyUsername: tt.username,
+				ProxyPassword: tt.password,
+			}, target)
+			if err == nil {
+				conn.Close()
+				t.Fatal("dial succeeded, want the proxy to refuse the tunnel")
+			}
+			if !strings.Contains(err.Error(), "refused CONNECT") || !strings.Contains(err.Error(), "407") {
+				t.Errorf("error = %q, want a refused CONNECT with status 407", err)
+			}
+		})
+	}
+}
+
+func TestProxyFromEnvironmentNoProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://proxy.corp:3128")
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("NO_PROXY", "internal.corp,.lab.corp")
+
+	selector, err := newProxySelector(ProtocolConfig{
+		ProxyFromEnvironment: true,
+		ProxyUsername:        "agent",
+		ProxyPassword:        "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("newProxySelector: %v", err)
+	}
+
+	tests := []struct {
+		target    string
+		wantProxy bool
+	}{
+		{"http://c2.example.com:8080", true},
+		{"http://internal.corp:8080", false},
+		{"http://host.lab.corp:8080", false},
+		{"http://corp:8080", true},
+	}
+	for _, tt := range tests {
+		target, _ := url.Parse(tt.target)
+		proxyURL, err := selector(target)
+		if err != nil {
+			t.Fatalf("selector(%s): %v", tt.target, err)
+		}
+		if got := proxyURL != nil; got != tt.wantProxy {
+			t.Errorf("selector(%s) proxied = %v, want %v", tt.target, got, tt.wantProxy)
+			continue
+		}
+		if proxyURL == nil {
+			continue
+		}
+		if proxyURL.Host != "proxy.corp:3128" {
+			t.Errorf("selector(%s) = %s, want proxy.corp:3128", tt.target, proxyURL.Host)
+		}
+		if password, _ := proxyURL.User.Password(); proxyURL.User.Username() != "agent" || password != "s3cret" {
+			t.Errorf("selector(%s) credentials = %s, want agent:s3cret", tt.target, proxyURL.User)
+		}
+	}
+}
+
+func TestDialDirectWithoutProxy(t *testing.T) {
+	target := newEchoServer(t)
+
+	conn, err := dialThrough(t, ProtocolConfig{}, target)
+	if err != nil {
+		t.Fatalf("direct dial: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"firestarter/internal/signing"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// signRequest signs an HTTP request whose whole body is body, so the server can tell it came
+// from this agent. Agents built without a signing secret send their requests unsigned, and the
+// server turns them away.
+func signRequest(config ProtocolConfig, req *http.Request, body []byte) error {
+	if config.SigningSecret == nil {
+		return nil
+	}
+
+	sig, err := signing.Sign(config.SigningSecret, config.AgentUUID, req.Method, req.URL.RequestURI(), body)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	sig.SetHeaders(req.Header)
+	return nil
+}
+
+// signFrame signs a request or hello frame. The listener turns request frames back into HTTP
+// requests for its router, so they are signed exactly like an HTTP request to the same endpoint.
+func signFrame(config ProtocolConfig, f *frame) error {
+	if config.SigningSecret == nil {
+		return nil
+	}
+
+	uri := ""
+	if f.Endpoint != "" {
+		endpoint, err := url.Parse(f.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint %q: %w", f.Endpoint, err)
+		}
+		uri = endpoint.RequestURI()
+	}
+
+	method := f.Method
+	if f.Type == "hello" {
+		method = f.Type
+	}
+
+	var err error
+	f.Signature, err = signing.Sign(config.SigningSecret, config.AgentUUID, method, uri, f.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// TCPProtocol implements the Protocol interface over raw TCP carrying length-prefixed frames
+type TCPProtocol struct {
+	BaseFramedProtocol
+	dialer *net.Dialer
+	proxy  proxySelector
+}
+
+// NewTCPProtocol creates a new instance of the raw TCP protocol
+func NewTCPProtocol() *TCPProtocol {
+	return &TCPProtocol{
+		BaseFramedProtocol: newBaseFramedProtocol(),
+	}
+}
+
+// Initialize sets up the raw TCP protocol with the provided configuration
+func (p *TCPProtocol) Initialize(config ProtocolConfig) error {
+	p.config = config
+
+	proxy, err := newProxySelector(config)
+	if err != nil {
+		return err
+	}
+
+	p.proxy = proxy
+	p.dialer = &net.Dialer{
+		Timeout:   config.ConnectionTimeout,
+		KeepAlive: 5 * time.Minute,
+	}
+	p.dial = p.handshake
+
+	return nil
+}
+
+// Name returns the name of the protocol
+func (p *TCPProtocol) Name() string {
+	return "TCP"
+}
+
+// handshake connects to the listener and identifies the agent with a hello frame
+func (p *TCPProtocol) handshake(ctx context.Context) (frameConn, error) {
+	addr := net.JoinHostPort(p.config.TargetHost, p.config.TargetPort)
+	conn, err := dialTCP(ctx, p.dialer, p.proxy, "http", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	frameConn := &tcpFrameConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	// Bound the handshake by the dial context
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	hello := frame{Type: "hello", AgentUUID: p.config.AgentUUID}
+	if err := signFrame(p.config, &hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := frameConn.writeFrame(hello); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	var welcome frame
+	if err := frameConn.readFrame(&welcome); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no welcome from listener: %w", err)
+	}
+	if welcome.Type != "welcome" {
+		conn.Close()
+		return nil, fmt.Errorf("listener answered hello with unexpected %s frame", welcome.Type)
+	}
+	if welcome.Status != 0 && (welcome.Status < 200 || welcome.Status >= 300) {
+		conn.Close()
+		return nil, responseError(welcome.Status, welcome.RetryAfter, nil)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return frameConn, nil
+}
+
+// tcpFrameConn carries frames as JSON prefixed with a 4-byte big-endian length
+type tcpFrameConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *tcpFrameConn) readFrame(f *frame) error {
+	var header [4]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > framedMaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the %d byte limit", size, framedMaxFrameSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, f)
+}
+
+func (c *tcpFrameConn) writeFrame(f frame) error {
+	body, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if len(body) > framedMaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the %d byte limit", len(body), framedMaxFrameSize)
+	}
+
+	// Header and body go out in a single write
+	buf := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	copy(buf[4:], body)
+
+	_, err = c.conn.Write(buf)
+	return err
+}
+
+func (c *tcpFrameConn) setReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *tcpFrameConn) setWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *tcpFrameConn) close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// newTLSClientConfig builds the TLS client configuration shared by all TLS-based protocols
+// nextProtos must match the ALPN identifiers advertised by the corresponding listener
+//
+// Trust is decided by the build-time settings and always fails closed:
+//   - CA bundle: the chain must verify against the embedded CAs (instead of the system roots)
+//   - SPKI pins: a certificate in the chain must match one of the pinned keys
+//   - Neither: the chain must verify against the system roots
+func newTLSClientConfig(config ProtocolConfig, nextProtos []string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: config.TargetHost,
+		NextProtos: nextProtos,
+		MinVersion: tls.VersionTLS12, // Listeners require TLS 1.2 or higher
+	}
+
+	// Replace the system roots with the embedded CA bundle
+	hasCABundle := len(config.TLSCABundle) > 0
+	if hasCABundle {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(config.TLSCABundle) {
+			return nil, fmt.Errorf("TLS trust: embedded CA bundle contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// Present the client certificate to listeners that require mutual TLS
+	if len(config.TLSClientCert) > 0 {
+		cert, err := tls.X509KeyPair(config.TLSClientCert, config.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("TLS trust: invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.TLSPins) == 0 {
+		return tlsConfig, nil
+	}
+
+	pins := make(map[string]bool, len(config.TLSPins))
+	for _, pin := range config.TLSPins {
+		if err := ValidateSPKIPin(pin); err != nil {
+			return nil, fmt.Errorf("TLS trust: %w", err)
+		}
+		pins[strings.TrimSpace(pin)] = true
+	}
+
+	// Without a CA bundle the pin is the only source of trust, so chain building
+	// is skipped and the pin check below is applied to the leaf certificate alone
+	if !hasCABundle {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		return verifySPKIPins(state, pins, hasCABundle)
+	}
+
+	return tlsConfig, nil
+}
+
+// verifySPKIPins checks that the server presented a certificate matching one of the pins
+func verifySPKIPins(state tls.ConnectionState, pins map[string]bool, chainVerified bool) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("TLS trust: server presented no certificate")
+	}
+
+	// Only certificates from a verified chain may match a pin. Without chain
+	// verification anything past the leaf is attacker-controlled, so only the
+	// leaf is considered
+	candidates := state.PeerCertificates[:1]
+	if chainVerified {
+		candidates = nil
+		for _, chain := range state.VerifiedChains {
+			candidates = append(candidates, chain...)
+		}
+	}
+
+	for _, cert := range candidates {
+		if pins[SPKIPin(cert)] {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("TLS trust: server certificate for %s does not match any pinned key (presented %s)",
+		state.ServerName, SPKIPin(state.PeerCertificates[0]))
+}
+
+// SPKIPin returns the base64-encoded SHA-256 hash of a certificate's SubjectPublicKeyInfo
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ValidateSPKIPin checks that a pin is a base64-encoded SHA-256 hash
+func ValidateSPKIPin(pin string) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(pin))
+	if err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("invalid SPKI pin %q: expected base64-encoded SHA-256 hash", pin)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsUpgradePath is the endpoint the listener upgrades agents on
+const wsUpgradePath = "/ws"
+
+// WSProtocol implements the Protocol interface over a persistent WebSocket
+type WSProtocol struct {
+	BaseFramedProtocol
+	dialer *websocket.Dialer
+}
+
+// NewWSProtocol creates a new instance of the WebSocket protocol
+func NewWSProtocol() *WSProtocol {
+	return &WSProtocol{
+		BaseFramedProtocol: newBaseFramedProtocol(),
+	}
+}
+
+// Initialize sets up the WebSocket protocol with the provided configuration
+func (p *WSProtocol) Initialize(config ProtocolConfig) error {
+	p.config = config
+
+	proxy, err := newProxySelector(config)
+	if err != nil {
+		return err
+	}
+
+	p.dialer = &websocket.Dialer{
+		HandshakeTimeout: config.ConnectionTimeout,
+		Proxy:            proxy.forTransport(), // Tunnels through the proxy with CONNECT
+		NetDialContext: (&net.Dialer{
+			Timeout:   config.ConnectionTimeout,
+			KeepAlive: 5 * time.Minute,
+		}).DialContext,
+	}
+	p.dial = p.upgrade
+
+	return nil
+}
+
+// Name returns the name of the protocol
+func (p *WSProtocol) Name() string {
+	return "WS"
+}
+
+// upgrade opens a connection to the listener and upgrades it to a WebSocket
+func (p *WSProtocol) upgrade(ctx context.Context) (frameConn, error) {
+	target := fmt.Sprintf("ws://%s%s", net.JoinHostPort(p.config.TargetHost, p.config.TargetPort), wsUpgradePath)
+
+	// The upgrade request goes through the listener's router like any other, so sign it the same way
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upgrade request: %w", err)
+	}
+	req.Header.Set("X-Agent-UUID", p.config.AgentUUID)
+	if err := signRequest(p.config, req, nil); err != nil {
+		return nil, err
+	}
+
+	conn, resp, err := p.dialer.DialContext(ctx, target, req.Header)
+	if err != nil {
+		if resp != nil {
+			// Keep the hint of a listener turning agents away, the handshake error only says it failed
+			hintErr := responseError(resp.StatusCode, resp.Header.Get("Retry-After"), nil)
+			if isRetryHint(hintErr) {
+				return nil, hintErr
+			}
+			return nil, fmt.Errorf("server returned status %d: %w", resp.StatusCode, err)
+		}
+		return nil, err
+	}
+
+	// Never buffer a frame larger than the listener would accept
+	conn.SetReadLimit(framedMaxFrameSize)
+
+	// The listener pings with control frames, which also prove the connection alive
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(framedReadTimeout))
+		p.updateLastActivity()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(framedWriteTimeout))
+	})
+
+	return &wsFrameConn{conn: conn}, nil
+}
+
+// wsFrameConn carries frames as JSON WebSocket messages
+type wsFrameConn struct {
+	conn *websocket.Conn
+}
+
+func (c *wsFrameConn) readFrame(f *frame) error {
+	return c.conn.ReadJSON(f)
+}
+
+func (c *wsFrameConn) writeFrame(f frame) error {
+	return c.conn.WriteJSON(f)
+}
+
+func (c *wsFrameConn) setReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *wsFrameConn) setWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *wsFrameConn) close() error {
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(framedWriteTimeout))
+	return c.conn.Close()
+}
@@ -0,0 +1,52 @@
+// Package tasks holds the task handlers built into the agent. Each file registers its task types
+// with the executor's default registry from an init function, so importing the package is all
+// it takes to make them available.
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"firestarter/internal/agent/executor"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxSleep bounds how long a sleep task may ask for
+const maxSleep = time.Hour
+
+func init() {
+	executor.Register("echo", echo)
+	executor.Register("sleep", sleep)
+}
+
+// echo writes back its arguments, to check tasks make the round trip
+func echo(ctx context.Context, task executor.Task, output io.Writer) error {
+	_, err := output.Write(task.Args)
+	return err
+}
+
+// sleepArgs are the arguments of a sleep task
+type sleepArgs struct {
+	Seconds float64 `json:"seconds"`
+}
+
+// sleep waits for the given number of seconds, to check timeouts and cancellation
+func sleep(ctx context.Context, task executor.Task, output io.Writer) error {
+	var args sleepArgs
+	if err := json.Unmarshal(task.Args, &args); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	duration := time.Duration(args.Seconds * float64(time.Second))
+	if duration < 0 || duration > maxSleep {
+		return fmt.Errorf("seconds must be between 0 and %v", maxSleep.Seconds())
+	}
+
+	select {
+	case <-time.After(duration):
+		_, err := fmt.Fprintf(output, "slept %v", duration)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
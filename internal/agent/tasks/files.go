@@ -0,0 +1,73 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+func init() {
+	executor.Register(transfer.TaskDownload, download)
+	executor.Register(transfer.TaskUpload, upload)
+}
+
+// fileOutput is the output of the file tasks, describing the file moved
+type fileOutput struct {
+	FileID string `json:"fileId"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// download sends a file from the agent to the server's file store
+func download(ctx context.Context, task executor.Task, output io.Writer) error {
+	var args transfer.DownloadArgs
+	if err := json.Unmarshal(task.Args, &args); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	if args.Path == "" {
+		return fmt.Errorf("no path given")
+	}
+	if task.Files == nil {
+		return fmt.Errorf("file transfers not available")
+	}
+
+	// The server records where the file came from, a relative path would not say much
+	path, err := filepath.Abs(args.Path)
+	if err != nil {
+		return err
+	}
+	id, manifest, err := task.Files.SendFile(ctx, task.ID, path, args.ChunkSize)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(output).Encode(fileOutput{FileID: id, Path: path, Size: manifest.Size, SHA256: manifest.SHA256})
+}
+
+// upload writes a file staged in the server's file store to the agent
+func upload(ctx context.Context, task executor.Task, output io.Writer) error {
+	var args transfer.UploadArgs
+	if err := json.Unmarshal(task.Args, &args); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	if args.FileID == "" || args.Path == "" {
+		return fmt.Errorf("no file ID or path given")
+	}
+	if task.Files == nil {
+		return fmt.Errorf("file transfers not available")
+	}
+
+	path, err := filepath.Abs(args.Path)
+	if err != nil {
+		return err
+	}
+	args.Path = path
+	if err := task.Files.ReceiveFile(ctx, task.ID, args); err != nil {
+		return err
+	}
+	return json.NewEncoder(output).Encode(fileOutput{FileID: args.FileID, Path: path, Size: args.Size, SHA256: args.SHA256})
+}
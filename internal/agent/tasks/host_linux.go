@@ -0,0 +1,258 @@
+package tasks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/recon"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The host information tasks only read from the host, /proc and /etc on Linux. On other systems
+// they are not registered, the server is then told their type is unknown.
+
+const (
+	// passwdPath and groupPath are the local user and group databases, accounts from other
+	// sources such as LDAP are not listed
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+
+	// clockTicks is USER_HZ, the unit of the times in /proc. It is 100 on every architecture
+	// Linux supports today, and can't be read without cgo.
+	clockTicks = 100
+)
+
+func init() {
+	executor.Register(recon.TaskSystem, system)
+	executor.Register(recon.TaskUsers, users)
+}
+
+// system reports the hostname, kernel and distribution
+func system(ctx context.Context, task executor.Task, output io.Writer) error {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return fmt.Errorf("uname failed: %w", err)
+	}
+
+	info := recon.System{
+		Hostname:     utsString(uts.Nodename),
+		Kernel:       utsString(uts.Sysname),
+		Release:      utsString(uts.Release),
+		Version:      utsString(uts.Version),
+		Arch:         utsString(uts.Machine),
+		Distribution: distribution(),
+		CPUs:         cpuCount(),
+	}
+	if domain := utsString(uts.Domainname); domain != "(none)" {
+		info.Domain = domain
+	}
+	if id, err := os.ReadFile("/etc/machine-id"); err == nil {
+		info.MachineID = strings.TrimSpace(string(id))
+	}
+	if memory, err := meminfo("MemTotal"); err == nil {
+		info.MemoryBytes = memory
+	}
+	if boot, err := bootTime(); err == nil {
+		info.BootTime = boot
+		info.UptimeSeconds = int64(time.Since(boot).Seconds())
+	}
+	return json.NewEncoder(output).Encode(info)
+}
+
+// users reports the local users and groups
+func users(ctx context.Context, task executor.Task, output io.Writer) error {
+	accounts, err := readPasswd()
+	if err != nil {
+		return err
+	}
+	groups, err := readGroups()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(output).Encode(recon.Accounts{Users: accounts, Groups: groups})
+}
+
+// utsString converts a NUL terminated uname field, whose element type differs between
+// architectures
+func utsString[T int8 | uint8](field [65]T) string {
+	var value strings.Builder
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		value.WriteByte(byte(c))
+	}
+	return value.String()
+}
+
+// distribution returns the pretty name of the distribution from os-release, empty when unknown
+func distribution() string {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if value, found := strings.CutPrefix(line, "PRETTY_NAME="); found {
+				if unquoted, err := strconv.Unquote(value); err == nil {
+					return unquoted
+				}
+				return strings.Trim(value, `"'`)
+			}
+		}
+	}
+	return ""
+}
+
+// cpuCount counts the online processors, rather than those the agent may run on
+func cpuCount() int {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key, _, found := strings.Cut(scanner.Text(), ":"); found && strings.TrimSpace(key) == "processor" {
+			count++
+		}
+	}
+	return count
+}
+
+// meminfo returns a field of /proc/meminfo in bytes
+func meminfo(field string) (int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), field+":")
+		if !found {
+			continue
+		}
+		kilobytes, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s in /proc/meminfo: %w", field, err)
+		}
+		return kilobytes * 1024, nil
+	}
+	return 0, fmt.Errorf("no %s in /proc/meminfo", field)
+}
+
+// bootTime returns when the host booted, from /proc/stat
+func bootTime() (time.Time, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "btime "); found {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime in /proc/stat: %w", err)
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// readPasswd parses the local user database
+func readPasswd() ([]recon.User, error) {
+	var result []recon.User
+	err := readDatabase(passwdPath, 7, func(fields []string) {
+		uid, uidErr := strconv.Atoi(fields[2])
+		gid, gidErr := strconv.Atoi(fields[3])
+		if uidErr != nil || gidErr != nil {
+			return
+		}
+		result = append(result, recon.User{
+			Name: fields[0], UID: uid, GID: gid, Gecos: fields[4], Home: fields[5], Shell: fields[6],
+		})
+	})
+	return result, err
+}
+
+// readGroups parses the local group database
+func readGroups() ([]recon.Group, error) {
+	var result []recon.Group
+	err := readDatabase(groupPath, 4, func(fields []string) {
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		members := []string{}
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		result = append(result, recon.Group{Name: fields[0], GID: gid, Members: members})
+	})
+	return result, err
+}
+
+// readDatabase calls entry with the fields of each line of a colon separated database, skipping
+// comments and lines with fewer fields than expected
+func readDatabase(path string, fields int, entry func([]string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if parts := strings.Split(line, ":"); len(parts) >= fields {
+			entry(parts)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// idNames maps user and group IDs to names, from the local databases
+type idNames struct {
+	users  map[int]string
+	groups map[int]string
+}
+
+// lookupNames reads the local databases, IDs it can't name are left out
+func lookupNames() idNames {
+	names := idNames{users: make(map[int]string), groups: make(map[int]string)}
+	if accounts, err := readPasswd(); err == nil {
+		for _, user := range accounts {
+			if _, exists := names.users[user.UID]; !exists {
+				names.users[user.UID] = user.Name
+			}
+		}
+	}
+	if groups, err := readGroups(); err == nil {
+		for _, group := range groups {
+			if _, exists := names.groups[group.GID]; !exists {
+				names.groups[group.GID] = group.Name
+			}
+		}
+	}
+	return names
+}
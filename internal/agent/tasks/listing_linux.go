@@ -0,0 +1,144 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/recon"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func init() {
+	executor.Register(recon.TaskListing, listDirectory)
+}
+
+// listDirectory lists a directory's entries with their metadata, the agent's working directory
+// when no path is given
+func listDirectory(ctx context.Context, task executor.Task, output io.Writer) error {
+	var args recon.ListingArgs
+	if len(task.Args) > 0 {
+		if err := json.Unmarshal(task.Args, &args); err != nil {
+			return fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	if args.Path == "" {
+		args.Path = "."
+	}
+	path, err := filepath.Abs(args.Path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if info, err := dir.Stat(); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+
+	// One entry more than reported tells whether there are more
+	entries, err := dir.ReadDir(recon.MaxEntries + 1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	result := recon.Listing{Path: path, Entries: make([]recon.Entry, 0, len(entries))}
+	if len(entries) > recon.MaxEntries {
+		entries = entries[:recon.MaxEntries]
+		result.Truncated = true
+	}
+
+	names := lookupNames()
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since it was read
+			continue
+		}
+
+		listed := recon.Entry{
+			Name:    entry.Name(),
+			Type:    entryType(info.Mode()),
+			Mode:    entryMode(info.Mode()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			listed.UID, listed.GID = int(stat.Uid), int(stat.Gid)
+			listed.Owner, listed.Group = names.users[listed.UID], names.groups[listed.GID]
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			listed.Target, _ = os.Readlink(filepath.Join(path, entry.Name()))
+		}
+		result.Entries = append(result.Entries, listed)
+	}
+	return json.NewEncoder(output).Encode(result)
+}
+
+// entryType names the type of a directory entry
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "dir"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeNamedPipe != 0:
+		return "pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	default:
+		return "other"
+	}
+}
+
+// entryMode spells a mode the way ls does, where Go's own spelling differs for links, devices
+// and the setuid, setgid and sticky bits
+func entryMode(mode fs.FileMode) string {
+	spelled := []byte("-" + mode.Perm().String()[1:])
+	switch {
+	case mode.IsDir():
+		spelled[0] = 'd'
+	case mode&fs.ModeSymlink != 0:
+		spelled[0] = 'l'
+	case mode&fs.ModeCharDevice != 0:
+		spelled[0] = 'c'
+	case mode&fs.ModeDevice != 0:
+		spelled[0] = 'b'
+	case mode&fs.ModeNamedPipe != 0:
+		spelled[0] = 'p'
+	case mode&fs.ModeSocket != 0:
+		spelled[0] = 's'
+	}
+
+	special := func(set bool, at int, executable byte, plain byte) {
+		if !set {
+			return
+		}
+		if spelled[at] == 'x' {
+			spelled[at] = executable
+		} else {
+			spelled[at] = plain
+		}
+	}
+	special(mode&fs.ModeSetuid != 0, 3, 's', 'S')
+	special(mode&fs.ModeSetgid != 0, 6, 's', 'S')
+	special(mode&fs.ModeSticky != 0, 9, 't', 'T')
+	return string(spelled)
+}
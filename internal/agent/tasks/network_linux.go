@@ -0,0 +1,388 @@
+package tasks
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/recon"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Route flags from the kernel's route.h
+const (
+	routeUp      = 0x0001
+	routeGateway = 0x0002
+	routeHost    = 0x0004
+	routeReject  = 0x0200
+)
+
+const (
+	// tcpListen is the state of a listening TCP socket in /proc/net, and udpUnconnected that of
+	// a UDP socket not connected to a peer
+	tcpListen      = "0A"
+	udpUnconnected = "07"
+)
+
+func init() {
+	executor.Register(recon.TaskNetwork, network)
+	executor.Register(recon.TaskSockets, sockets)
+}
+
+// network reports the network interfaces and the IPv4 and IPv6 routes
+func network(ctx context.Context, task executor.Task, output io.Writer) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	result := recon.Network{Interfaces: make([]recon.Interface, 0, len(ifaces))}
+	for _, iface := range ifaces {
+		entry := recon.Interface{
+			Name:      iface.Name,
+			Index:     iface.Index,
+			MAC:       iface.HardwareAddr.String(),
+			MTU:       iface.MTU,
+			Flags:     strings.Split(iface.Flags.String(), "|"),
+			Addresses: []string{},
+		}
+		if iface.Flags == 0 {
+			entry.Flags = []string{}
+		}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				entry.Addresses = append(entry.Addresses, addr.String())
+			}
+		}
+		result.Interfaces = append(result.Interfaces, entry)
+	}
+
+	if result.Routes, err = routes(); err != nil {
+		return err
+	}
+	routes6, err := routes6()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	result.Routes = append(result.Routes, routes6...)
+	return json.NewEncoder(output).Encode(result)
+}
+
+// routes reads the IPv4 routes from /proc/net/route
+func routes() ([]recon.Route, error) {
+	var result []recon.Route
+	err := readProcTable("/proc/net/route", func(fields []string) error {
+		if len(fields) < 8 {
+			return fmt.Errorf("invalid route: %q", strings.Join(fields, " "))
+		}
+		destination, err := parseIPv4(fields[1])
+		if err != nil {
+			return err
+		}
+		gateway, err := parseIPv4(fields[2])
+		if err != nil {
+			return err
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid route flags: %w", err)
+		}
+		metric, err := strconv.Atoi(fields[6])
+		if err != nil {
+			return fmt.Errorf("invalid route metric: %w", err)
+		}
+		mask, err := parseIPv4(fields[7])
+		if err != nil {
+			return err
+		}
+		if flags&routeReject != 0 {
+			return nil
+		}
+
+		ones, _ := net.IPMask(mask.To4()).Size()
+		route := recon.Route{
+			Interface:   fields[0],
+			Destination: (&net.IPNet{IP: destination, Mask: net.CIDRMask(ones, 32)}).String(),
+			Metric:      metric,
+			Flags:       routeFlags(flags),
+		}
+		if flags&routeGateway != 0 {
+			route.Gateway = gateway.String()
+		}
+		result = append(result, route)
+		return nil
+	})
+	return result, err
+}
+
+// routes6 reads the IPv6 routes from /proc/net/ipv6_route, which has no header
+func routes6() ([]recon.Route, error) {
+	file, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []recon.Route
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		destination, err := parseIPv6(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route prefix: %w", err)
+		}
+		gateway, err := parseIPv6(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		metric, err := strconv.ParseUint(fields[5], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route metric: %w", err)
+		}
+		flags, err := strconv.ParseUint(fields[8], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route flags: %w", err)
+		}
+		if flags&routeReject != 0 {
+			continue
+		}
+
+		route := recon.Route{
+			Interface:   fields[9],
+			Destination: (&net.IPNet{IP: destination, Mask: net.CIDRMask(int(prefix), 128)}).String(),
+			Metric:      int(metric),
+			Flags:       routeFlags(flags),
+		}
+		if flags&routeGateway != 0 {
+			route.Gateway = gateway.String()
+		}
+		result = append(result, route)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read IPv6 routes: %w", err)
+	}
+	return result, nil
+}
+
+// routeFlags spells route flags the way route(8) does
+func routeFlags(flags uint64) string {
+	var spelled strings.Builder
+	for _, flag := range []struct {
+		bit    uint64
+		letter byte
+	}{{routeUp, 'U'}, {routeGateway, 'G'}, {routeHost, 'H'}} {
+		if flags&flag.bit != 0 {
+			spelled.WriteByte(flag.letter)
+		}
+	}
+	return spelled.String()
+}
+
+// sockets lists the listening TCP sockets and the unconnected UDP sockets, along with the
+// processes owning them when the agent can see those
+func sockets(ctx context.Context, task executor.Task, output io.Writer) error {
+	result := recon.Sockets{Sockets: []recon.Socket{}}
+	for _, table := range []struct {
+		protocol string
+		state    string
+	}{{"tcp", tcpListen}, {"tcp6", tcpListen}, {"udp", udpUnconnected}, {"udp6", udpUnconnected}} {
+		found, err := readSockets(table.protocol, table.state)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		result.Sockets = append(result.Sockets, found...)
+	}
+
+	owners, err := socketOwners(ctx)
+	if err != nil {
+		return err
+	}
+	for i, socket := range result.Sockets {
+		if owner, found := owners[socket.Inode]; found {
+			result.Sockets[i].PID = owner.pid
+			result.Sockets[i].Process = owner.name
+		}
+	}
+	return json.NewEncoder(output).Encode(result)
+}
+
+// readSockets reads the sockets of a protocol in a state from /proc/net
+func readSockets(protocol string, state string) ([]recon.Socket, error) {
+	var result []recon.Socket
+	err := readProcTable(filepath.Join("/proc/net", protocol), func(fields []string) error {
+		if len(fields) < 10 {
+			return fmt.Errorf("invalid %s socket: %q", protocol, strings.Join(fields, " "))
+		}
+		if fields[3] != state {
+			return nil
+		}
+
+		// A UDP socket connected to a peer isn't waiting for anyone
+		if _, remotePort, _ := strings.Cut(fields[2], ":"); protocol[:3] == "udp" && remotePort != "0000" {
+			return nil
+		}
+
+		address, port, err := parseSocketAddress(fields[1])
+		if err != nil {
+			return err
+		}
+		uid, err := strconv.Atoi(fields[7])
+		if err != nil {
+			return fmt.Errorf("invalid socket owner: %w", err)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid socket inode: %w", err)
+		}
+		result = append(result, recon.Socket{Protocol: protocol, Address: address, Port: port, UID: uid, Inode: inode})
+		return nil
+	})
+	return result, err
+}
+
+// socketOwner is a process holding a socket open
+type socketOwner struct {
+	pid  int
+	name string
+}
+
+// socketOwners maps socket inodes to the first process holding them open, among the processes
+// whose file descriptors the agent may read
+func socketOwners(ctx context.Context) (map[uint64]socketOwner, error) {
+	pids, err := listPIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[uint64]socketOwner)
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		var name string
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, exists := owners[inode]; exists {
+				continue
+			}
+			if name == "" {
+				comm, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+				name = strings.TrimSpace(string(comm))
+			}
+			owners[inode] = socketOwner{pid: pid, name: name}
+		}
+	}
+	return owners, nil
+}
+
+// readProcTable calls row with the fields of each line of a /proc table, after its header
+func readProcTable(path string, row func([]string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for header := true; scanner.Scan(); header = false {
+		if header {
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := row(fields); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseSocketAddress parses an address and port from /proc/net, e.g. 0100007F:1F90
+func parseSocketAddress(value string) (string, int, error) {
+	address, port, found := strings.Cut(value, ":")
+	if !found {
+		return "", 0, fmt.Errorf("invalid socket address %q", value)
+	}
+	portNumber, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid socket port %q", value)
+	}
+
+	var ip net.IP
+	if len(address) == 8 {
+		ip, err = parseIPv4(address)
+	} else {
+		ip, err = parseIPv6Words(address)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return ip.String(), int(portNumber), nil
+}
+
+// parseIPv4 parses an IPv4 address from /proc/net, in hex of the host's byte order
+func parseIPv4(value string) (net.IP, error) {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != net.IPv4len {
+		return nil, fmt.Errorf("invalid IPv4 address %q", value)
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, binary.NativeEndian.Uint32(raw))
+	return ip, nil
+}
+
+// parseIPv6 parses an IPv6 address from /proc/net/ipv6_route, in hex of network byte order
+func parseIPv6(value string) (net.IP, error) {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != net.IPv6len {
+		return nil, fmt.Errorf("invalid IPv6 address %q", value)
+	}
+	return net.IP(raw), nil
+}
+
+// parseIPv6Words parses an IPv6 address from a /proc/net socket table, four 32 bit words in hex
+// of the host's byte order
+func parseIPv6Words(value string) (net.IP, error) {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != net.IPv6len {
+		return nil, fmt.Errorf("invalid IPv6 address %q", value)
+	}
+	ip := make(net.IP, net.IPv6len)
+	for word := 0; word < net.IPv6len; word += 4 {
+		binary.BigEndian.PutUint32(ip[word:], binary.NativeEndian.Uint32(raw[word:]))
+	}
+	return ip, nil
+}
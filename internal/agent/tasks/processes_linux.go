@@ -0,0 +1,131 @@
+package tasks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"firestarter/internal/agent/executor"
+	"firestarter/internal/recon"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	executor.Register(recon.TaskProcesses, processes)
+}
+
+// processes lists the running processes from /proc. Processes that exit while being read are
+// left out, and details the agent isn't allowed to read are left empty.
+func processes(ctx context.Context, task executor.Task, output io.Writer) error {
+	pids, err := listPIDs()
+	if err != nil {
+		return err
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return err
+	}
+	names := lookupNames()
+
+	result := recon.Processes{Processes: make([]recon.Process, 0, len(pids))}
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		process, err := readProcess(pid, boot)
+		if err != nil {
+			continue
+		}
+		process.User = names.users[process.UID]
+		result.Processes = append(result.Processes, process)
+	}
+	return json.NewEncoder(output).Encode(result)
+}
+
+// listPIDs returns the IDs of the running processes, lowest first
+func listPIDs() ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// readProcess reads a process's stat, status and command line
+func readProcess(pid int, boot time.Time) (recon.Process, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return recon.Process{}, err
+	}
+
+	// The name is in parentheses and may hold anything, the fields after it are split on spaces
+	start, end := strings.IndexByte(string(stat), '('), strings.LastIndexByte(string(stat), ')')
+	if start < 0 || end < start {
+		return recon.Process{}, fmt.Errorf("invalid stat for process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 22 {
+		return recon.Process{}, fmt.Errorf("invalid stat for process %d", pid)
+	}
+
+	// Field numbers from proc(5), less the three before them
+	process := recon.Process{PID: pid, Name: string(stat[start+1 : end]), State: fields[0]}
+	process.PPID, _ = strconv.Atoi(fields[1])
+	process.Threads, _ = strconv.Atoi(fields[17])
+	if ticks, err := strconv.ParseInt(fields[19], 10, 64); err == nil {
+		process.StartTime = boot.Add(time.Duration(ticks) * time.Second / clockTicks).Truncate(time.Second)
+	}
+	if pages, err := strconv.ParseInt(fields[21], 10, 64); err == nil {
+		process.RSSBytes = pages * int64(os.Getpagesize())
+	}
+
+	uid, err := statusUID(filepath.Join(dir, "status"))
+	if err != nil {
+		return recon.Process{}, err
+	}
+	process.UID = uid
+
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		process.Command = strings.Join(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), " ")
+	}
+	if executable, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		process.Executable = executable
+	}
+	return process, nil
+}
+
+// statusUID returns the real user ID of a process from its status
+func statusUID(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "Uid:"); found {
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				break
+			}
+			return strconv.Atoi(fields[0])
+		}
+	}
+	return 0, fmt.Errorf("no Uid in %s", path)
+}
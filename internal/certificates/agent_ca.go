@@ -0,0 +1,185 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files of the CA that issues agent client certificates, kept next to the server certificate
+const (
+	agentCACertFile = "agent-ca.crt" // Trusted by listeners that require client certificates
+	agentCAKeyFile  = "agent-ca.key" // Only needed by cmd/build to issue agent certificates
+)
+
+const (
+	agentCAValidity   = 10 * 365 * 24 * time.Hour
+	AgentCertValidity = 365 * 24 * time.Hour
+)
+
+// AgentCA issues the client certificates agents authenticate to mTLS listeners with
+type AgentCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// LoadOrCreateAgentCA reads the agent CA from dir, creating it the first time an agent is
+// built with a client certificate
+func LoadOrCreateAgentCA(dir string) (*AgentCA, error) {
+	certPath := filepath.Join(dir, agentCACertFile)
+	keyPath := filepath.Join(dir, agentCAKeyFile)
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: agent CA key must be an ECDSA key", keyPath)
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", certPath, err)
+		}
+		return &AgentCA{cert: cert, key: key}, nil
+	}
+	if _, statErr := os.Stat(certPath); !errors.Is(statErr, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load agent CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate agent CA key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "firestarter agent CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(agentCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create agent CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save agent CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save agent CA: %w", err)
+	}
+
+	return &AgentCA{cert: cert, key: key}, nil
+}
+
+// IssueAgentCertificate issues a client certificate naming agentUUID as its subject,
+// returning the PEM-encoded certificate and private key
+func (ca *AgentCA) IssueAgentCertificate(agentUUID string, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate agent key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: agentUUID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue agent certificate: %w", err)
+	}
+
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// LoadAgentCAPool reads the agent CA certificate from dir for verifying client certificates
+func LoadAgentCAPool(dir string) (*x509.CertPool, error) {
+	certPath := filepath.Join(dir, agentCACertFile)
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no valid certificates", certPath)
+	}
+	return pool, nil
+}
+
+// RequireClientCertificates makes a listener's TLS configuration demand a client certificate
+// issued by one of the CAs in pool
+func RequireClientCertificates(config *tls.Config, pool *x509.CertPool) {
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = pool
+}
+
+// IdentifyAgents calls identify with the underlying connection and the agent identity as soon
+// as a client certificate is verified, before any request is read from the connection
+func IdentifyAgents(config *tls.Config, identify func(conn net.Conn, agentUUID string)) {
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		perConn := config.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.VerifyConnection = func(state tls.ConnectionState) error {
+			if agentUUID := AgentIdentity(&state); agentUUID != "" {
+				identify(hello.Conn, agentUUID)
+			}
+			return nil
+		}
+		return perConn, nil
+	}
+}
+
+// AgentIdentity returns the agent UUID named by a verified client certificate, or "" when the
+// peer presented none
+func AgentIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
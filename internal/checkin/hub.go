@@ -0,0 +1,133 @@
+package checkin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Global check-in hub instance
+var GlobalCheckInHub *Hub
+
+// Work is a unit of server-initiated work delivered to an agent on check-in
+type Work struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`              // What the agent should do (e.g. "ping")
+	Payload   []byte    `json:"payload,omitempty"` // Type-specific parameters
+	Sealed    bool      `json:"sealed,omitempty"`  // Payload is sealed with the agent's end-to-end key
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Hub holds pending work per agent and wakes agents whose check-in is being held open
+type Hub struct {
+	pending map[string][]Work        // Agent UUID : work waiting for the next check-in
+	waiters map[string]chan struct{} // Agent UUID : closed when new work arrives
+	holding map[string]int           // Agent UUID : number of check-ins currently held open
+	mutex   sync.Mutex
+}
+
+// NewHub creates a new check-in hub
+func NewHub() *Hub {
+	fmt.Println("[📥CHK] -> Check-in Hub initialized.")
+	return &Hub{
+		pending: make(map[string][]Work),
+		waiters: make(map[string]chan struct{}),
+		holding: make(map[string]int),
+	}
+}
+
+// Publish queues work for an agent and wakes its held check-in, if any
+func (h *Hub) Publish(agentUUID string, workType string, payload []byte) Work {
+	work := Work{
+		ID:        uuid.New().String(),
+		Type:      workType,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.pending[agentUUID] = append(h.pending[agentUUID], work)
+	h.wakeLocked(agentUUID)
+
+	fmt.Printf("[📥CHK] -> Queued %s work %s for agent %s\n", workType, work.ID, agentUUID)
+	return work
+}
+
+// Wait returns the agent's pending work, blocking until some arrives or the context is done.
+// An empty result means the context ended before any work was published.
+func (h *Hub) Wait(ctx context.Context, agentUUID string) []Work {
+	h.mutex.Lock()
+	h.holding[agentUUID]++
+	h.mutex.Unlock()
+
+	defer func() {
+		h.mutex.Lock()
+		if h.holding[agentUUID]--; h.holding[agentUUID] <= 0 {
+			delete(h.holding, agentUUID)
+		}
+		h.mutex.Unlock()
+	}()
+
+	for {
+		h.mutex.Lock()
+		if work := h.pending[agentUUID]; len(work) > 0 {
+			delete(h.pending, agentUUID)
+			h.mutex.Unlock()
+			return work
+		}
+
+		// Share the wake channel if another check-in from this agent is already waiting
+		wake, exists := h.waiters[agentUUID]
+		if !exists {
+			wake = make(chan struct{})
+			h.waiters[agentUUID] = wake
+		}
+		h.mutex.Unlock()
+
+		select {
+		case <-wake:
+			// Loop to collect whatever was published
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// IsWaiting reports whether the agent currently has a check-in held open
+func (h *Hub) IsWaiting(agentUUID string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.holding[agentUUID] > 0
+}
+
+// PendingCount returns the number of work items waiting for the agent
+func (h *Hub) PendingCount(agentUUID string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.pending[agentUUID])
+}
+
+// wakeLocked releases all check-ins waiting for the agent, the caller must hold the mutex
+func (h *Hub) wakeLocked(agentUUID string) {
+	if wake, exists := h.waiters[agentUUID]; exists {
+		close(wake)
+		delete(h.waiters, agentUUID)
+	}
+}
+
+// InitializeCheckInHub creates the global check-in hub
+func InitializeCheckInHub() {
+	if GlobalCheckInHub == nil {
+		GlobalCheckInHub = NewHub()
+	}
+}
+
+// GetCheckInHub returns the global check-in hub
+func GetCheckInHub() *Hub {
+	return GlobalCheckInHub
+}
@@ -0,0 +1,28 @@
+package checkin
+
+import (
+	"firestarter/internal/e2e"
+	"fmt"
+)
+
+// ForAgent prepares work for delivery to an agent, sealing its payload when the agent was
+// built with an end-to-end key
+func (w Work) ForAgent(agentUUID string) (Work, error) {
+	keyStore := e2e.GetKeyStore()
+	if keyStore == nil || len(w.Payload) == 0 {
+		return w, nil
+	}
+
+	key, err := keyStore.KeyForAgent(agentUUID)
+	if err != nil || key == nil {
+		return w, err
+	}
+
+	sealed, err := key.Seal(e2e.ToAgent, w.Payload)
+	if err != nil {
+		return w, fmt.Errorf("failed to seal work %s: %w", w.ID, err)
+	}
+	w.Payload = sealed
+	w.Sealed = true
+	return w, nil
+}
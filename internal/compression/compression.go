@@ -0,0 +1,167 @@
+// Package compression negotiates and applies payload compression between agents and the
+// server. Agents announce the codecs they accept and compress what they send with the codec
+// their build selected; the server answers with the first codec the agent accepts. Only
+// bodies of at least the threshold are compressed, and only when that makes them smaller.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Codecs, named as in HTTP Content-Encoding
+const (
+	Identity = "identity" // Sent as is
+	Gzip     = "gzip"
+	Deflate  = "deflate" // The zlib-wrapped stream HTTP means by deflate
+)
+
+// DefaultThreshold is the smallest body worth compressing, below it the codec's framing
+// outweighs what is saved
+const DefaultThreshold = 1024
+
+// codecs lists the supported codecs in order of preference
+var codecs = []string{Gzip, Deflate}
+
+// Writer compresses everything written to it. Flush pushes out what has been compressed so
+// far, so streamed bodies keep moving; Close must be called to finish the stream.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// ParseCodec validates a codec name, accepting "none" and "" for Identity
+func ParseCodec(name string) (string, error) {
+	switch codec := strings.ToLower(strings.TrimSpace(name)); codec {
+	case "", "none", Identity:
+		return Identity, nil
+	case Gzip, Deflate:
+		return codec, nil
+	default:
+		return "", fmt.Errorf("unsupported compression codec '%s' (gzip, deflate or none)", name)
+	}
+}
+
+// IsSupported reports whether a Content-Encoding can be decoded by this build
+func IsSupported(codec string) bool {
+	return codec == Gzip || codec == Deflate
+}
+
+// AcceptEncoding returns the Accept-Encoding an agent announces, listing its own codec first.
+// Agents built without compression announce nothing and are always answered uncompressed.
+func AcceptEncoding(preferred string) string {
+	if !IsSupported(preferred) {
+		return ""
+	}
+	accepted := []string{preferred}
+	for _, codec := range codecs {
+		if codec != preferred {
+			accepted = append(accepted, codec)
+		}
+	}
+	return strings.Join(accepted, ", ")
+}
+
+// Negotiate picks the codec to answer with from an Accept-Encoding, taking the first supported
+// codec in the order listed and skipping any refused with q=0. It returns Identity when none fits.
+func Negotiate(acceptEncoding string) string {
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		codec, params, _ := strings.Cut(entry, ";")
+		codec = strings.ToLower(strings.TrimSpace(codec))
+		if !IsSupported(codec) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return codec
+	}
+	return Identity
+}
+
+// Compress compresses data with codec if it is at least threshold bytes long and the result is
+// smaller, returning the body to send and the codec it is encoded with (Identity when unchanged)
+func Compress(codec string, threshold int, data []byte) ([]byte, string, error) {
+	if !IsSupported(codec) || len(data) == 0 || len(data) < threshold {
+		return data, Identity, nil
+	}
+
+	var buf bytes.Buffer
+	writer, err := NewWriter(codec, &buf)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, "", fmt.Errorf("%s compression failed: %w", codec, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("%s compression failed: %w", codec, err)
+	}
+
+	// Already compressed or encrypted data only grows
+	if buf.Len() >= len(data) {
+		return data, Identity, nil
+	}
+	return buf.Bytes(), codec, nil
+}
+
+// Decompress decodes a body encoded with codec, refusing to inflate it beyond limit bytes
+func Decompress(codec string, data []byte, limit int64) ([]byte, error) {
+	if codec == "" || codec == Identity {
+		return data, nil
+	}
+
+	reader, err := NewReader(codec, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s decompression failed: %w", codec, err)
+	}
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("decompressed body exceeds %d bytes", limit)
+	}
+	return decoded, nil
+}
+
+// NewWriter returns a Writer compressing into w with codec
+func NewWriter(codec string, w io.Writer) (Writer, error) {
+	switch codec {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Deflate:
+		return zlib.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression codec '%s'", codec)
+	}
+}
+
+// NewReader returns a reader decompressing r, which was encoded with codec
+func NewReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case Gzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip decompression failed: %w", err)
+		}
+		return reader, nil
+	case Deflate:
+		reader, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("deflate decompression failed: %w", err)
+		}
+		return reader, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression codec '%s'", codec)
+	}
+}
@@ -0,0 +1,131 @@
+package compression
+
+import (
+	"sync"
+	"time"
+)
+
+// notifyInterval limits how often an agent's traffic is passed to the notifier, so a busy
+// agent doesn't flood the UI with an update per request. Updates in between are folded into
+// one passed on when the interval is over.
+const notifyInterval = time.Second
+
+// Traffic counts the bodies exchanged with one agent, before and after compression
+type Traffic struct {
+	AgentUUID          string    `json:"agentUUID"`
+	RawBytes           int64     `json:"rawBytes"`           // Uncompressed size of every body
+	WireBytes          int64     `json:"wireBytes"`          // Size actually sent over the wire
+	Messages           int64     `json:"messages"`           // Bodies exchanged in either direction
+	CompressedMessages int64     `json:"compressedMessages"` // Of which went over the wire compressed
+	LastCodec          string    `json:"lastCodec,omitempty"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// Global traffic stats instance
+var GlobalTrafficStats *TrafficStats
+
+// TrafficStats keeps the traffic counters of every agent and hands updates to a notifier
+type TrafficStats struct {
+	agents   map[string]*Traffic
+	notified map[string]time.Time // Agent UUID : last time its counters were passed on
+	deferred map[string]bool      // Agent UUID : an update is waiting for the interval to pass
+	notifier func(Traffic)
+	mutex    sync.Mutex
+}
+
+// InitializeTrafficStats sets up the global traffic stats
+func InitializeTrafficStats() {
+	GlobalTrafficStats = &TrafficStats{
+		agents:   make(map[string]*Traffic),
+		notified: make(map[string]time.Time),
+		deferred: make(map[string]bool),
+	}
+}
+
+// GetTrafficStats returns the global traffic stats instance
+func GetTrafficStats() *TrafficStats {
+	return GlobalTrafficStats
+}
+
+// SetNotifier sets the function updated counters are passed to, e.g. to broadcast them to the UI.
+// Updates are passed on at most once a second per agent.
+func (s *TrafficStats) SetNotifier(notifier func(Traffic)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notifier = notifier
+}
+
+// Record counts a body exchanged with an agent, raw being its uncompressed size and wire the
+// size it travelled at. codec is how it was encoded on the wire.
+func (s *TrafficStats) Record(agentUUID string, raw int64, wire int64, codec string) {
+	if agentUUID == "" || (raw == 0 && wire == 0) {
+		return
+	}
+
+	s.mutex.Lock()
+	traffic, exists := s.agents[agentUUID]
+	if !exists {
+		traffic = &Traffic{AgentUUID: agentUUID}
+		s.agents[agentUUID] = traffic
+	}
+	traffic.RawBytes += raw
+	traffic.WireBytes += wire
+	traffic.Messages++
+	if IsSupported(codec) {
+		traffic.CompressedMessages++
+		traffic.LastCodec = codec
+	}
+	traffic.UpdatedAt = time.Now()
+
+	var update *Traffic
+	if s.notifier != nil && !s.deferred[agentUUID] {
+		if wait := notifyInterval - traffic.UpdatedAt.Sub(s.notified[agentUUID]); wait > 0 {
+			s.deferred[agentUUID] = true
+			time.AfterFunc(wait, func() { s.notifyDeferred(agentUUID) })
+		} else {
+			s.notified[agentUUID] = traffic.UpdatedAt
+			copied := *traffic
+			update = &copied
+		}
+	}
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	if update != nil {
+		notifier(*update)
+	}
+}
+
+// notifyDeferred passes on the counters of an agent whose updates were held back
+func (s *TrafficStats) notifyDeferred(agentUUID string) {
+	s.mutex.Lock()
+	delete(s.deferred, agentUUID)
+	s.notified[agentUUID] = time.Now()
+	update := *s.agents[agentUUID]
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	if notifier != nil {
+		notifier(update)
+	}
+}
+
+// Snapshot returns the counters of every agent
+func (s *TrafficStats) Snapshot() []Traffic {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := make([]Traffic, 0, len(s.agents))
+	for _, traffic := range s.agents {
+		snapshot = append(snapshot, *traffic)
+	}
+	return snapshot
+}
+
+// RecordTraffic counts a body exchanged with an agent in the global traffic stats
+func RecordTraffic(agentUUID string, raw int64, wire int64, codec string) {
+	if GlobalTrafficStats == nil {
+		return
+	}
+	GlobalTrafficStats.Record(agentUUID, raw, wire, codec)
+}
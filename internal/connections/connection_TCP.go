@@ -0,0 +1,36 @@
+package connections
+
+import (
+	"firestarter/internal/interfaces"
+	"net"
+	"time"
+)
+
+// TCPConnection represents a raw TCP specific connection
+type TCPConnection struct {
+	BaseConnection
+	Conn net.Conn
+}
+
+// NewTCPConnection creates a new raw TCP connection
+func NewTCPConnection(conn net.Conn, port string) *TCPConnection {
+	return &TCPConnection{
+		BaseConnection: BaseConnection{
+			ID:        GenerateUniqueID(),
+			Protocol:  interfaces.TCP,
+			Port:      port,
+			CreatedAt: time.Now().UTC(),
+		},
+		Conn: conn,
+	}
+}
+
+// Connection interface implementation
+func (c *TCPConnection) GetID() string { return c.ID }
+func (c *TCPConnection) GetProtocol() interfaces.ProtocolType {
+	return c.Protocol
+}
+func (c *TCPConnection) GetCreatedAt() time.Time { return c.CreatedAt }
+func (c *TCPConnection) GetPort() string         { return c.Port }
+func (c *TCPConnection) Close() error            { return c.Conn.Close() }
+func (c *TCPConnection) GetAgentUUID() string    { return c.AgentUUID }
@@ -0,0 +1,36 @@
+package connections
+
+import (
+	"firestarter/internal/interfaces"
+	"net"
+	"time"
+)
+
+// WebSocketConnection represents a WebSocket specific connection
+type WebSocketConnection struct {
+	BaseConnection
+	Conn net.Conn
+}
+
+// NewWebSocketConnection creates a new WebSocket connection
+func NewWebSocketConnection(conn net.Conn, port string) *WebSocketConnection {
+	return &WebSocketConnection{
+		BaseConnection: BaseConnection{
+			ID:        GenerateUniqueID(),
+			Protocol:  interfaces.WS,
+			Port:      port,
+			CreatedAt: time.Now().UTC(),
+		},
+		Conn: conn,
+	}
+}
+
+// Connection interface implementation
+func (c *WebSocketConnection) GetID() string { return c.ID }
+func (c *WebSocketConnection) GetProtocol() interfaces.ProtocolType {
+	return c.Protocol
+}
+func (c *WebSocketConnection) GetCreatedAt() time.Time { return c.CreatedAt }
+func (c *WebSocketConnection) GetPort() string         { return c.Port }
+func (c *WebSocketConnection) Close() error            { return c.Conn.Close() }
+func (c *WebSocketConnection) GetAgentUUID() string    { return c.AgentUUID }
@@ -32,6 +32,12 @@ func (o *QuicConnectionObserver) OnConnectionEstablished(conn quic.Connection, p
 	// Register with connection manager
 	o.connManager.AddConnection(trackedConn)
 
+	// Requests carry the QUIC connection's remote address, which lets the registry tie agent
+	// identities to this connection just as it does for TCP-based listeners
+	if connectionRegistry != nil {
+		connectionRegistry.RegisterConnectionForRemoteAddr(conn.RemoteAddr().String(), trackedConn.GetID())
+	}
+
 	fmt.Printf("[H3-OBSERVER-DEBUG] HTTP3Connection %s registered with connection manager\n", trackedConn.GetID())
 
 	// Set up connection close monitoring
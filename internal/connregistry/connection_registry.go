@@ -17,6 +17,7 @@ type ConnectionRegistry struct {
 	uuidMap        map[string]string            // Map from connection ID to agent UUID
 	connManager    interfaces.ConnectionManager // Connection manager reference
 	processedPairs map[string]bool              // Tracks already processed remoteAddr:UUID pairs
+	certified      map[string]bool              // Connection IDs whose UUID comes from a client certificate
 	mutex          sync.RWMutex
 }
 
@@ -27,6 +28,7 @@ func NewConnectionRegistry() *ConnectionRegistry {
 		connMap:        make(map[string]string), // remoteAddr : conn ID
 		uuidMap:        make(map[string]string), // conn ID : UUID
 		processedPairs: make(map[string]bool),   // remoteAddr : UUID
+		certified:      make(map[string]bool),   // conn ID : identity from certificate
 	}
 }
 
@@ -40,10 +42,15 @@ func (cr *ConnectionRegistry) SetConnectionManager(cm interfaces.ConnectionManag
 
 // RegisterConnection associates a TCP connection with a connection ID
 func (cr *ConnectionRegistry) RegisterConnection(conn net.Conn, connID string) {
+	cr.RegisterConnectionForRemoteAddr(conn.RemoteAddr().String(), connID)
+}
+
+// RegisterConnectionForRemoteAddr associates a connection ID with the remote address its
+// requests arrive from, for connections that are not a net.Conn (QUIC)
+func (cr *ConnectionRegistry) RegisterConnectionForRemoteAddr(remoteAddr string, connID string) {
 	cr.mutex.Lock()
 	defer cr.mutex.Unlock()
 
-	remoteAddr := conn.RemoteAddr().String()
 	cr.connMap[remoteAddr] = connID
 
 	fmt.Printf("[🟢NEW] -> New Connection from: %s\n", remoteAddr)
@@ -53,8 +60,14 @@ func (cr *ConnectionRegistry) RegisterConnection(conn net.Conn, connID string) {
 
 // RegisterUUID associates a connection ID with an agent UUID from an HTTP request
 func (cr *ConnectionRegistry) RegisterUUID(req *http.Request, agentUUID string) {
-	// Create a unique key for this request+UUID combination
-	pairKey := req.RemoteAddr + ":" + agentUUID
+	cr.RegisterUUIDForRemoteAddr(req.RemoteAddr, agentUUID)
+}
+
+// RegisterUUIDForRemoteAddr associates the connection from remoteAddr with an agent UUID,
+// for transports where the agent identifies itself outside of HTTP headers
+func (cr *ConnectionRegistry) RegisterUUIDForRemoteAddr(remoteAddr string, agentUUID string) {
+	// Create a unique key for this remote address+UUID combination
+	pairKey := remoteAddr + ":" + agentUUID
 
 	cr.mutex.RLock()
 	alreadyProcessed := cr.processedPairs[pairKey]
@@ -71,13 +84,44 @@ func (cr *ConnectionRegistry) RegisterUUID(req *http.Request, agentUUID string)
 	// Mark as processed
 	cr.processedPairs[pairKey] = true
 
-	remoteAddr := req.RemoteAddr
 	connID, exists := cr.connMap[remoteAddr]
 	if !exists {
 		fmt.Printf("Warning: No connection ID found for remote address: %s\n", remoteAddr)
 		return
 	}
 
+	// A certificate identity is authoritative, a claimed UUID never replaces it
+	if cr.certified[connID] {
+		if cr.uuidMap[connID] != agentUUID {
+			fmt.Printf("[❌ERR] -> Ignoring UUID %s claimed on connection %s, certified as agent %s\n",
+				agentUUID, connID, cr.uuidMap[connID])
+		}
+		return
+	}
+
+	cr.setUUID(connID, agentUUID)
+}
+
+// RegisterCertifiedUUID associates the connection from remoteAddr with the agent named by its
+// verified client certificate. Unlike a UUID claimed in a header, it cannot be rebound later.
+func (cr *ConnectionRegistry) RegisterCertifiedUUID(remoteAddr string, agentUUID string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	connID, exists := cr.connMap[remoteAddr]
+	if !exists {
+		fmt.Printf("Warning: No connection ID found for remote address: %s\n", remoteAddr)
+		return
+	}
+
+	cr.certified[connID] = true
+	fmt.Printf("[🔐TLS] -> Connection %s authenticated by client certificate as agent %s\n", connID, agentUUID)
+	cr.setUUID(connID, agentUUID)
+}
+
+// setUUID records the UUID of a connection and updates the tracked connection object.
+// The caller must hold the write lock.
+func (cr *ConnectionRegistry) setUUID(connID string, agentUUID string) {
 	// Update our UUID map
 	cr.uuidMap[connID] = agentUUID
 	fmt.Printf("Registry: Associated connection %s with UUID %s\n", connID, agentUUID)
@@ -133,3 +177,11 @@ func ConnectRegistryToManager(manager interfaces.ConnectionManager) {
 		fmt.Println("[❌ERR] -> Cannot connect registry to manager - registry not initialized")
 	}
 }
+
+// RegisterCertificateIdentity binds a TLS connection to the agent named by its client
+// certificate, for use with certificates.IdentifyAgents
+func RegisterCertificateIdentity(conn net.Conn, agentUUID string) {
+	if GlobalConnectionRegistry != nil {
+		GlobalConnectionRegistry.RegisterCertifiedUUID(conn.RemoteAddr().String(), agentUUID)
+	}
+}
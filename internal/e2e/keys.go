@@ -0,0 +1,142 @@
+package e2e
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files kept in the key directory
+const (
+	serverPrivateKeyFile = "server.key"  // Base64 X25519 private key, never leaves the server
+	serverPublicKeyFile  = "server.pub"  // Base64 X25519 public key, embedded into agents
+	agentKeyringFile     = "agents.json" // Agent UUID : base64 public key, written by cmd/build
+)
+
+// DefaultKeyPath returns the default directory for end-to-end keys
+func DefaultKeyPath() string {
+	// Relative to the project root, like the certificates
+	return "keys"
+}
+
+// GenerateKey creates a new X25519 private key
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// EncodePrivateKey encodes a private key as base64, suitable for -ldflags
+func EncodePrivateKey(key *ecdh.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+// EncodePublicKey encodes a public key as base64, suitable for -ldflags
+func EncodePublicKey(key *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+// ParsePrivateKey decodes a base64 X25519 private key
+func ParsePrivateKey(encoded string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key encoding: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey decodes a base64 X25519 public key
+func ParsePublicKey(encoded string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return key, nil
+}
+
+// LoadOrCreateServerKey reads the server's private key from dir, generating and saving a new
+// keypair the first time. The server and cmd/build both call it, whichever runs first creates it.
+func LoadOrCreateServerKey(dir string) (*ecdh.PrivateKey, error) {
+	privatePath := filepath.Join(dir, serverPrivateKeyFile)
+
+	encoded, err := os.ReadFile(privatePath)
+	if err == nil {
+		key, err := ParsePrivateKey(string(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", privatePath, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read server key: %w", err)
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate server key: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(privatePath, []byte(EncodePrivateKey(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save server key: %w", err)
+	}
+	publicPath := filepath.Join(dir, serverPublicKeyFile)
+	if err := os.WriteFile(publicPath, []byte(EncodePublicKey(key.PublicKey())+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save server public key: %w", err)
+	}
+
+	return key, nil
+}
+
+// RegisterAgentKey records the public key of a newly built agent in dir's keyring, so the
+// server accepts messages sealed with it
+func RegisterAgentKey(dir string, agentUUID string, key *ecdh.PublicKey) error {
+	keyring, err := readKeyring(dir)
+	if err != nil {
+		return err
+	}
+	keyring[agentUUID] = EncodePublicKey(key)
+
+	encoded, err := json.MarshalIndent(keyring, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, a running server may be reading the keyring
+	path := filepath.Join(dir, agentKeyringFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0600); err != nil {
+		return fmt.Errorf("failed to save agent keyring: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readKeyring reads the agent keyring, which is empty until the first agent is built
+func readKeyring(dir string) (map[string]string, error) {
+	keyring := make(map[string]string)
+
+	encoded, err := os.ReadFile(filepath.Join(dir, agentKeyringFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return keyring, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent keyring: %w", err)
+	}
+
+	if err := json.Unmarshal(encoded, &keyring); err != nil {
+		return nil, fmt.Errorf("corrupt agent keyring: %w", err)
+	}
+	return keyring, nil
+}
@@ -0,0 +1,136 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Global key store instance
+var GlobalKeyStore *KeyStore
+
+// KeyStore holds the server's private key and the public keys of every agent built for it
+type KeyStore struct {
+	dir     string
+	private *ecdh.PrivateKey
+
+	agents        map[string]*ecdh.PublicKey // Agent UUID : public key from the keyring
+	keys          map[string]*Key            // Agent UUID : key derived from its current public key
+	keyringLoaded time.Time                  // Modification time of the keyring last read
+	mutex         sync.Mutex
+}
+
+// InitializeKeyStore loads (or creates) the server key from dir and sets up the global key store
+func InitializeKeyStore(dir string) error {
+	private, err := LoadOrCreateServerKey(dir)
+	if err != nil {
+		return err
+	}
+
+	GlobalKeyStore = &KeyStore{
+		dir:     dir,
+		private: private,
+		agents:  make(map[string]*ecdh.PublicKey),
+		keys:    make(map[string]*Key),
+	}
+
+	fmt.Printf("[🔐E2E] -> Server key loaded, agents embed public key %s\n", EncodePublicKey(private.PublicKey()))
+	return nil
+}
+
+// GetKeyStore returns the global key store instance
+func GetKeyStore() *KeyStore {
+	return GlobalKeyStore
+}
+
+// KeyForAgent returns the key shared with an agent, or nil when the agent was built without one
+// and therefore talks in the clear
+func (ks *KeyStore) KeyForAgent(agentUUID string) (*Key, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	// Agents may be built or rebuilt while the server runs, so pick up keyring changes before
+	// trusting a cached key
+	if err := ks.reloadKeyring(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.keys[agentUUID]; ok {
+		return key, nil
+	}
+
+	public, ok := ks.agents[agentUUID]
+	if !ok {
+		return nil, nil
+	}
+
+	key, err := ServerKey(ks.private, public)
+	if err != nil {
+		return nil, err
+	}
+	ks.keys[agentUUID] = key
+	return key, nil
+}
+
+// OpenFromAgent opens a message sealed by an agent, returning the key to seal the reply with
+func (ks *KeyStore) OpenFromAgent(agentUUID string, sealed []byte) ([]byte, *Key, error) {
+	key, err := ks.KeyForAgent(agentUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("agent %s has no registered key", agentUUID)
+	}
+
+	sender, err := SenderKey(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(sender.Bytes(), key.agentKey) {
+		return nil, nil, fmt.Errorf("message is sealed with a key not registered to agent %s", agentUUID)
+	}
+
+	plaintext, err := key.Open(ToServer, sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, key, nil
+}
+
+// reloadKeyring rereads the keyring when it changed since it was last read, forgetting the
+// derived keys of agents whose public key was replaced or removed
+func (ks *KeyStore) reloadKeyring() error {
+	info, err := os.Stat(filepath.Join(ks.dir, agentKeyringFile))
+	if err != nil || !info.ModTime().After(ks.keyringLoaded) {
+		return nil // No agents built yet, or nothing new
+	}
+
+	keyring, err := readKeyring(ks.dir)
+	if err != nil {
+		return err
+	}
+
+	agents := make(map[string]*ecdh.PublicKey, len(keyring))
+	for agentUUID, encoded := range keyring {
+		public, err := ParsePublicKey(encoded)
+		if err != nil {
+			fmt.Printf("[❌ERR] -> Ignoring invalid keyring entry for agent %s: %v\n", agentUUID, err)
+			continue
+		}
+		agents[agentUUID] = public
+	}
+
+	for agentUUID, key := range ks.keys {
+		if public, ok := agents[agentUUID]; !ok || !bytes.Equal(public.Bytes(), key.agentKey) {
+			delete(ks.keys, agentUUID)
+		}
+	}
+
+	ks.agents = agents
+	ks.keyringLoaded = info.ModTime()
+	return nil
+}
@@ -0,0 +1,119 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testAgentUUID = "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b"
+
+// newTestKeyStore sets up the global key store in a fresh directory
+func newTestKeyStore(t *testing.T) *KeyStore {
+	t.Helper()
+
+	if err := InitializeKeyStore(t.TempDir()); err != nil {
+		t.Fatalf("InitializeKeyStore: %v", err)
+	}
+	t.Cleanup(func() { GlobalKeyStore = nil })
+	return GetKeyStore()
+}
+
+// buildAgent registers a new key for agentUUID as cmd/build does, returning the agent's key
+func buildAgent(t *testing.T, ks *KeyStore, agentUUID string, builtAt time.Time) *Key {
+	t.Helper()
+
+	private := generateKey(t)
+	if err := RegisterAgentKey(ks.dir, agentUUID, private.PublicKey()); err != nil {
+		t.Fatalf("RegisterAgentKey: %v", err)
+	}
+	// Modification times may be coarse, make every build visibly newer than the last
+	if err := os.Chtimes(filepath.Join(ks.dir, agentKeyringFile), builtAt, builtAt); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	key, err := AgentKey(private, ks.private.PublicKey())
+	if err != nil {
+		t.Fatalf("AgentKey: %v", err)
+	}
+	return key
+}
+
+func TestKeyStoreOpenFromAgent(t *testing.T) {
+	ks := newTestKeyStore(t)
+	now := time.Now()
+	agentKey := buildAgent(t, ks, testAgentUUID, now)
+	otherKey := buildAgent(t, ks, "other-agent", now.Add(time.Second))
+
+	sealed, _ := agentKey.Seal(ToServer, []byte("register"))
+	plaintext, replyKey, err := ks.OpenFromAgent(testAgentUUID, sealed)
+	if err != nil {
+		t.Fatalf("OpenFromAgent: %v", err)
+	}
+	if string(plaintext) != "register" {
+		t.Errorf("opened %q, want %q", plaintext, "register")
+	}
+
+	// The reply key seals messages only this agent opens
+	reply, _ := replyKey.Seal(ToAgent, []byte("registered"))
+	if opened, err := agentKey.Open(ToAgent, reply); err != nil || string(opened) != "registered" {
+		t.Errorf("agent opened the reply as %q, %v", opened, err)
+	}
+
+	// Another agent's message is refused, even when it claims this agent's UUID
+	forged, _ := otherKey.Seal(ToServer, []byte("register"))
+	if _, _, err := ks.OpenFromAgent(testAgentUUID, forged); err == nil {
+		t.Error("OpenFromAgent accepted a message sealed with another agent's key")
+	}
+
+	if _, _, err := ks.OpenFromAgent("unknown-agent", sealed); err == nil {
+		t.Error("OpenFromAgent accepted a message from an unknown agent")
+	}
+}
+
+func TestKeyStoreReloadReplacesCachedKey(t *testing.T) {
+	ks := newTestKeyStore(t)
+	now := time.Now()
+	oldKey := buildAgent(t, ks, testAgentUUID, now)
+
+	// Derive and cache the key of the first build
+	sealed, _ := oldKey.Seal(ToServer, []byte("old build"))
+	if _, _, err := ks.OpenFromAgent(testAgentUUID, sealed); err != nil {
+		t.Fatalf("OpenFromAgent, first build: %v", err)
+	}
+
+	// Rebuilding the agent under the same UUID replaces its key
+	newKey := buildAgent(t, ks, testAgentUUID, now.Add(time.Second))
+
+	sealed, _ = newKey.Seal(ToServer, []byte("new build"))
+	if _, _, err := ks.OpenFromAgent(testAgentUUID, sealed); err != nil {
+		t.Errorf("OpenFromAgent, rebuilt agent: %v", err)
+	}
+	sealed, _ = oldKey.Seal(ToServer, []byte("old build"))
+	if _, _, err := ks.OpenFromAgent(testAgentUUID, sealed); err == nil {
+		t.Error("OpenFromAgent still accepts the replaced key")
+	}
+}
+
+func TestKeyStoreReloadForgetsRemovedAgent(t *testing.T) {
+	ks := newTestKeyStore(t)
+	now := time.Now()
+	buildAgent(t, ks, testAgentUUID, now)
+
+	if key, err := ks.KeyForAgent(testAgentUUID); err != nil || key == nil {
+		t.Fatalf("KeyForAgent = %v, %v, want a key", key, err)
+	}
+
+	// Drop the agent from the keyring, as if its build was deleted
+	path := filepath.Join(ks.dir, agentKeyringFile)
+	if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	later := now.Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	if key, err := ks.KeyForAgent(testAgentUUID); err != nil || key != nil {
+		t.Errorf("KeyForAgent = %v, %v, want no key once the agent left the keyring", key, err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package e2e seals agent payloads end to end, so that only the team server can read them
+// whatever transport carries them and wherever TLS is terminated.
+//
+// Every agent build gets its own X25519 keypair and embeds the server's public key. Both
+// sides derive the same ChaCha20-Poly1305 key from their static keys, so a sealed message
+// also proves it came from the holder of the agent's (or server's) private key.
+package e2e
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Direction distinguishes the two ways a message can travel, so a sealed message can never
+// be reflected back to its sender
+type Direction byte
+
+const (
+	ToServer Direction = 'S'
+	ToAgent  Direction = 'A'
+)
+
+// A sealed message is laid out as
+//
+//	magic       2 bytes   "FX"
+//	version     1 byte
+//	agent key  32 bytes   X25519 public key of the agent, identifies the key to open it with
+//	nonce      24 bytes
+//	ciphertext            XChaCha20-Poly1305 over the plaintext
+//
+// The header and direction are authenticated as additional data.
+var magic = [2]byte{'F', 'X'}
+
+const (
+	sealVersion = 1
+	keySize     = 32
+	headerSize  = len(magic) + 1 + keySize
+)
+
+// ContentType announces a sealed message body
+const ContentType = "application/x-firestarter-sealed"
+
+// hkdfInfo binds derived keys to this scheme and version
+var hkdfInfo = []byte("firestarter e2e v1")
+
+// ErrOpen is returned when a sealed message fails to authenticate
+var ErrOpen = errors.New("sealed message failed to authenticate")
+
+// Key is the symmetric key shared by one agent and the server
+type Key struct {
+	aead     cipher.AEAD
+	agentKey []byte // Public key of the agent, written into every sealed message
+}
+
+// AgentKey derives the key an agent seals its messages with
+func AgentKey(agentPrivate *ecdh.PrivateKey, serverPublic *ecdh.PublicKey) (*Key, error) {
+	return deriveKey(agentPrivate, serverPublic, agentPrivate.PublicKey(), serverPublic)
+}
+
+// ServerKey derives the key the server shares with an agent
+func ServerKey(serverPrivate *ecdh.PrivateKey, agentPublic *ecdh.PublicKey) (*Key, error) {
+	return deriveKey(serverPrivate, agentPublic, agentPublic, serverPrivate.PublicKey())
+}
+
+func deriveKey(private *ecdh.PrivateKey, peer *ecdh.PublicKey, agentPublic *ecdh.PublicKey, serverPublic *ecdh.PublicKey) (*Key, error) {
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+
+	// Both public keys salt the derivation, tying the key to this exact pair
+	salt := append(append([]byte{}, agentPublic.Bytes()...), serverPublic.Bytes()...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, hkdfInfo), key); err != nil {
+		return nil, fmt.Errorf("key derivation failed: %w", err)
+	}
+
+	// The extended nonce variant makes random nonces safe for the lifetime of a build
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{aead: aead, agentKey: agentPublic.Bytes()}, nil
+}
+
+// Seal encrypts and authenticates plaintext for the given direction
+func (k *Key) Seal(direction Direction, plaintext []byte) ([]byte, error) {
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic[:]...)
+	header = append(header, sealVersion)
+	header = append(header, k.agentKey...)
+
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+k.aead.Overhead())
+	sealed = append(sealed, header...)
+	sealed = append(sealed, nonce...)
+	return k.aead.Seal(sealed, nonce, plaintext, additionalData(header, direction)), nil
+}
+
+// Open authenticates and decrypts a message sealed for the given direction
+func (k *Key) Open(direction Direction, sealed []byte) ([]byte, error) {
+	agentKey, err := SenderKey(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(agentKey.Bytes(), k.agentKey) {
+		return nil, fmt.Errorf("sealed message belongs to a different agent key")
+	}
+
+	nonceSize := k.aead.NonceSize()
+	if len(sealed) < headerSize+nonceSize+k.aead.Overhead() {
+		return nil, fmt.Errorf("truncated sealed message")
+	}
+
+	header := sealed[:headerSize]
+	nonce := sealed[headerSize : headerSize+nonceSize]
+	plaintext, err := k.aead.Open(nil, nonce, sealed[headerSize+nonceSize:], additionalData(header, direction))
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plaintext, nil
+}
+
+func additionalData(header []byte, direction Direction) []byte {
+	return append(append([]byte{}, header...), byte(direction))
+}
+
+// IsSealed reports whether data is a sealed message
+func IsSealed(data []byte) bool {
+	return len(data) >= len(magic) && data[0] == magic[0] && data[1] == magic[1]
+}
+
+// SenderKey returns the agent public key named in a sealed message's header, which tells the
+// server which key to open it with. It is not authenticated until the message is opened.
+func SenderKey(sealed []byte) (*ecdh.PublicKey, error) {
+	if !IsSealed(sealed) {
+		return nil, fmt.Errorf("not a sealed message")
+	}
+	if len(sealed) < headerSize {
+		return nil, fmt.Errorf("truncated sealed message")
+	}
+	if sealed[2] != sealVersion {
+		return nil, fmt.Errorf("unsupported sealed message version %d", sealed[2])
+	}
+
+	agentKey, err := ecdh.X25519().NewPublicKey(sealed[3:headerSize])
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent key in sealed message: %w", err)
+	}
+	return agentKey, nil
+}
@@ -0,0 +1,147 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"testing"
+)
+
+// keyPair derives the agent's and the server's side of the key they share
+func keyPair(t *testing.T, agentPrivate *ecdh.PrivateKey, serverPrivate *ecdh.PrivateKey) (*Key, *Key) {
+	t.Helper()
+
+	agentKey, err := AgentKey(agentPrivate, serverPrivate.PublicKey())
+	if err != nil {
+		t.Fatalf("AgentKey: %v", err)
+	}
+	serverKey, err := ServerKey(serverPrivate, agentPrivate.PublicKey())
+	if err != nil {
+		t.Fatalf("ServerKey: %v", err)
+	}
+	return agentKey, serverKey
+}
+
+func generateKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	agentKey, serverKey := keyPair(t, generateKey(t), generateKey(t))
+
+	tests := []struct {
+		name      string
+		sealer    *Key
+		opener    *Key
+		direction Direction
+		plaintext []byte
+	}{
+		{"agent to server", agentKey, serverKey, ToServer, []byte("task result")},
+		{"server to agent", serverKey, agentKey, ToAgent, []byte("queued task")},
+		{"empty", agentKey, serverKey, ToServer, nil},
+		{"large", serverKey, agentKey, ToAgent, bytes.Repeat([]byte{0xa5}, 1<<20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tt.sealer.Seal(tt.direction, tt.plaintext)
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			if !IsSealed(sealed) {
+				t.Fatal("IsSealed = false for a sealed message")
+			}
+			if len(tt.plaintext) > 0 && bytes.Contains(sealed, tt.plaintext) {
+				t.Error("sealed message contains the plaintext")
+			}
+
+			opened, err := tt.opener.Open(tt.direction, sealed)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			if !bytes.Equal(opened, tt.plaintext) {
+				t.Errorf("opened %d bytes, want the %d sealed", len(opened), len(tt.plaintext))
+			}
+		})
+	}
+}
+
+func TestSealNoncesDiffer(t *testing.T) {
+	agentKey, _ := keyPair(t, generateKey(t), generateKey(t))
+
+	first, _ := agentKey.Seal(ToServer, []byte("same"))
+	second, _ := agentKey.Seal(ToServer, []byte("same"))
+	if bytes.Equal(first, second) {
+		t.Error("sealing the same plaintext twice gave the same message")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	serverPrivate := generateKey(t)
+	agentKey, serverKey := keyPair(t, generateKey(t), serverPrivate)
+	otherAgentKey, otherServerKey := keyPair(t, generateKey(t), serverPrivate)
+
+	sealed, err := agentKey.Seal(ToServer, []byte("task result"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	nonceSize := serverKey.aead.NonceSize()
+	tampered := func(i int) []byte {
+		data := bytes.Clone(sealed)
+		data[i] ^= 0x01
+		return data
+	}
+
+	// A message from another agent, claiming to come from this one
+	forged, _ := otherAgentKey.Seal(ToServer, []byte("forged"))
+	copy(forged[3:headerSize], agentKey.agentKey)
+
+	tests := []struct {
+		name      string
+		opener    *Key
+		direction Direction
+		sealed    []byte
+		want      error // nil when any error will do
+	}{
+		{"direction swapped", serverKey, ToAgent, sealed, ErrOpen},
+		{"reflected to its sender", agentKey, ToAgent, sealed, ErrOpen},
+		{"wrong sender key", otherServerKey, ToServer, sealed, nil},
+		{"sender key rewritten", serverKey, ToServer, forged, ErrOpen},
+		{"tampered ciphertext", serverKey, ToServer, tampered(len(sealed) - 1), ErrOpen},
+		{"tampered nonce", serverKey, ToServer, tampered(headerSize), ErrOpen},
+		{"tampered last nonce byte", serverKey, ToServer, tampered(headerSize + nonceSize - 1), ErrOpen},
+		{"unsupported version", serverKey, ToServer, tampered(2), nil},
+		{"truncated", serverKey, ToServer, sealed[:headerSize+nonceSize], nil},
+		{"not sealed", serverKey, ToServer, []byte("plaintext"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, err := tt.opener.Open(tt.direction, tt.sealed)
+			if err == nil {
+				t.Fatalf("Open = %q, want an error", opened)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Open error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSenderKey(t *testing.T) {
+	agentPrivate := generateKey(t)
+	agentKey, _ := keyPair(t, agentPrivate, generateKey(t))
+
+	sealed, _ := agentKey.Seal(ToServer, []byte("hello"))
+	sender, err := SenderKey(sealed)
+	if err != nil {
+		t.Fatalf("SenderKey: %v", err)
+	}
+	if !sender.Equal(agentPrivate.PublicKey()) {
+		t.Error("SenderKey does not name the agent's public key")
+	}
+}
@@ -0,0 +1,174 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Encoding selects how an envelope is written on the wire
+type Encoding int
+
+const (
+	// Binary is the compact encoding used in normal operation
+	Binary Encoding = iota
+
+	// JSON is a readable encoding for debugging, accepted wherever Binary is
+	JSON
+)
+
+// Content types announcing each encoding
+const (
+	ContentTypeBinary = "application/x-firestarter-envelope"
+	ContentTypeJSON   = "application/x-firestarter-envelope+json"
+)
+
+// ContentType returns the content type announcing the encoding
+func (enc Encoding) ContentType() string {
+	if enc == JSON {
+		return ContentTypeJSON
+	}
+	return ContentTypeBinary
+}
+
+// The binary encoding is laid out as
+//
+//	magic      2 bytes  "FE"
+//	version    1 byte
+//	type       1 byte
+//	sequence   8 bytes  big-endian
+//	agent ID   1 byte length, then the ID
+//	payload    4 bytes big-endian length, then the payload
+//
+// The magic and version come first and never move, so any future version can be recognised
+// and rejected before the rest is parsed.
+var magic = [2]byte{'F', 'E'}
+
+const (
+	// headerSize is the fixed part of a binary envelope, before the agent ID
+	headerSize = 2 + 1 + 1 + 8 + 1
+
+	// MaxAgentIDLength is the longest agent ID the binary encoding can carry
+	MaxAgentIDLength = 255
+
+	// MaxPayloadSize bounds the payload of a single envelope
+	MaxPayloadSize = 64 << 20
+)
+
+// Marshal encodes an envelope
+func Marshal(e *Envelope, enc Encoding) ([]byte, error) {
+	if err := CheckVersion(e.Version); err != nil {
+		return nil, err
+	}
+	if _, known := messageTypeNames[e.Type]; !known {
+		return nil, fmt.Errorf("unknown message type %d", uint8(e.Type))
+	}
+	if len(e.AgentID) > MaxAgentIDLength {
+		return nil, fmt.Errorf("agent ID of %d bytes exceeds the %d byte limit", len(e.AgentID), MaxAgentIDLength)
+	}
+	if len(e.Payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds the %d byte limit", len(e.Payload), MaxPayloadSize)
+	}
+
+	if enc == JSON {
+		return json.Marshal(e)
+	}
+
+	buf := make([]byte, 0, headerSize+len(e.AgentID)+4+len(e.Payload))
+	buf = append(buf, magic[:]...)
+	buf = append(buf, e.Version, uint8(e.Type))
+	buf = binary.BigEndian.AppendUint64(buf, e.Sequence)
+	buf = append(buf, uint8(len(e.AgentID)))
+	buf = append(buf, e.AgentID...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(e.Payload)))
+	buf = append(buf, e.Payload...)
+	return buf, nil
+}
+
+// Unmarshal decodes an envelope in either encoding, telling which one was used so a reply
+// can be written the same way. It returns ErrNotEnvelope for data in neither encoding and a
+// *VersionError for envelopes of an incompatible version.
+func Unmarshal(data []byte) (*Envelope, Encoding, error) {
+	switch {
+	case len(data) >= len(magic) && data[0] == magic[0] && data[1] == magic[1]:
+		e, err := unmarshalBinary(data)
+		return e, Binary, err
+	case bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("{")):
+		e, err := unmarshalJSON(data)
+		return e, JSON, err
+	default:
+		return nil, Binary, ErrNotEnvelope
+	}
+}
+
+func unmarshalBinary(data []byte) (*Envelope, error) {
+	if len(data) < len(magic)+1 {
+		return nil, fmt.Errorf("truncated envelope")
+	}
+	if err := CheckVersion(data[2]); err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("truncated envelope header")
+	}
+
+	e := &Envelope{
+		Version:  data[2],
+		Type:     MessageType(data[3]),
+		Sequence: binary.BigEndian.Uint64(data[4:12]),
+	}
+	if _, known := messageTypeNames[e.Type]; !known {
+		return nil, fmt.Errorf("unknown message type %d", data[3])
+	}
+
+	rest := data[headerSize:]
+	idLength := int(data[headerSize-1])
+	if len(rest) < idLength+4 {
+		return nil, fmt.Errorf("truncated envelope agent ID")
+	}
+	e.AgentID = string(rest[:idLength])
+	rest = rest[idLength:]
+
+	payloadLength := binary.BigEndian.Uint32(rest[:4])
+	rest = rest[4:]
+	if payloadLength > MaxPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds the %d byte limit", payloadLength, MaxPayloadSize)
+	}
+	if uint32(len(rest)) != payloadLength {
+		return nil, fmt.Errorf("envelope payload is %d bytes, header announces %d", len(rest), payloadLength)
+	}
+	if payloadLength > 0 {
+		e.Payload = rest
+	}
+
+	return e, nil
+}
+
+func unmarshalJSON(data []byte) (*Envelope, error) {
+	// Check the version first, a newer version may not decode into this layout at all
+	var header struct {
+		Version *uint8 `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("malformed JSON envelope: %w", err)
+	}
+	if header.Version == nil {
+		return nil, fmt.Errorf("JSON envelope has no version")
+	}
+	if err := CheckVersion(*header.Version); err != nil {
+		return nil, err
+	}
+
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("malformed JSON envelope: %w", err)
+	}
+	if _, known := messageTypeNames[e.Type]; !known {
+		return nil, fmt.Errorf("JSON envelope has no message type")
+	}
+	if len(e.AgentID) > MaxAgentIDLength {
+		return nil, fmt.Errorf("agent ID of %d bytes exceeds the %d byte limit", len(e.AgentID), MaxAgentIDLength)
+	}
+	return &e, nil
+}
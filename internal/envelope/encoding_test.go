@@ -0,0 +1,111 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	envelopes := []*Envelope{
+		New(TypePing, "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b", 1, []byte("ping")),
+		New(TypeTaskResult, "agent", 1<<63+7, bytes.Repeat([]byte{0, 0xff}, 1024)),
+		New(TypeShutdown, "agent", 0, nil),
+		New(TypeFileData, strings.Repeat("a", MaxAgentIDLength), 42, []byte{0}),
+	}
+
+	for _, enc := range []Encoding{Binary, JSON} {
+		for _, e := range envelopes {
+			t.Run(enc.ContentType()+"/"+e.Type.String(), func(t *testing.T) {
+				data, err := Marshal(e, enc)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				if enc == Binary && !bytes.HasPrefix(data, []byte("FE")) {
+					t.Errorf("binary envelope starts with %q, want the FE magic", data[:2])
+				}
+
+				decoded, detected, err := Unmarshal(data)
+				if err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if detected != enc {
+					t.Errorf("detected encoding %v, want %v", detected, enc)
+				}
+				if !reflect.DeepEqual(decoded, e) {
+					t.Errorf("decoded %+v, want %+v", decoded, e)
+				}
+			})
+		}
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	valid, err := Marshal(New(TypePing, "agent", 9, []byte("ping")), Binary)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	withByte := func(i int, b byte) []byte {
+		data := bytes.Clone(valid)
+		data[i] = b
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    error // Matched with errors.Is, nil to only expect an error
+		version bool  // Whether a *VersionError is expected
+	}{
+		{name: "empty", data: nil, want: ErrNotEnvelope},
+		{name: "bad magic", data: withByte(1, 'X'), want: ErrNotEnvelope},
+		{name: "not JSON either", data: []byte("hello"), want: ErrNotEnvelope},
+		{name: "magic only", data: valid[:2]},
+		{name: "truncated header", data: valid[:headerSize-1]},
+		{name: "truncated agent ID", data: valid[:headerSize+2]},
+		{name: "truncated payload", data: valid[:len(valid)-1]},
+		{name: "trailing data", data: append(bytes.Clone(valid), 0)},
+		{name: "unknown type", data: withByte(3, 0xee)},
+		{name: "unsupported version", data: withByte(2, Version+1), version: true},
+		{name: "version zero", data: withByte(2, 0), version: true},
+		{name: "unsupported JSON version", data: []byte(`{"version":99,"type":"ping","agentId":"agent"}`), version: true},
+		{name: "JSON without version", data: []byte(`{"type":"ping","agentId":"agent"}`)},
+		{name: "JSON unknown type", data: []byte(`{"version":1,"type":"bogus","agentId":"agent"}`)},
+		{name: "malformed JSON", data: []byte(`{"version":1,`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, err := Unmarshal(tt.data)
+			if err == nil {
+				t.Fatalf("Unmarshal = %+v, want an error", e)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Unmarshal error = %v, want %v", err, tt.want)
+			}
+			var versionErr *VersionError
+			if got := errors.As(err, &versionErr); got != tt.version {
+				t.Errorf("Unmarshal error = %v, version error %v, want %v", err, got, tt.version)
+			}
+		})
+	}
+}
+
+func TestMarshalRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		e    *Envelope
+	}{
+		{"unsupported version", &Envelope{Version: Version + 1, Type: TypePing}},
+		{"unknown type", &Envelope{Version: Version, Type: MessageType(0)}},
+		{"agent ID too long", New(TypePing, strings.Repeat("a", MaxAgentIDLength+1), 0, nil)},
+	}
+	for _, tt := range tests {
+		for _, enc := range []Encoding{Binary, JSON} {
+			if _, err := Marshal(tt.e, enc); err == nil {
+				t.Errorf("%s: Marshal(%s) succeeded, want an error", tt.name, enc.ContentType())
+			}
+		}
+	}
+}
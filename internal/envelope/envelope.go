@@ -0,0 +1,155 @@
+// Package envelope defines the versioned message envelope exchanged between agents and the
+// server. Both sides encode every message the same way, so it is the wire contract that
+// new features build on.
+package envelope
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// Version is the envelope version written by this build
+	Version uint8 = 1
+
+	// MinVersion is the oldest envelope version this build still accepts
+	MinVersion uint8 = 1
+)
+
+// MessageType identifies what an envelope carries
+type MessageType uint8
+
+// Message types, never renumber these since they are part of the wire format
+const (
+	TypePing         MessageType = 1 // Agent -> server, connectivity test
+	TypePong         MessageType = 2 // Server -> agent, answers a ping
+	TypeRegister     MessageType = 3 // Agent -> server, host metadata sent on connecting
+	TypeRegistered   MessageType = 4 // Server -> agent, acknowledges a registration
+	TypeTelemetry    MessageType = 5 // Agent -> server, status record sent with health checks
+	TypeTelemetryAck MessageType = 6 // Server -> agent, acknowledges a status record
+	TypeShutdown     MessageType = 7 // Agent -> server, notice of a clean shutdown
+	TypeShutdownAck  MessageType = 8 // Server -> agent, acknowledges a shutdown notice
+
+	TypeConfigReport    MessageType = 9  // Agent -> server, configuration in effect and the outcome of an update
+	TypeConfigReportAck MessageType = 10 // Server -> agent, acknowledges a configuration report
+
+	TypeTaskFetch   MessageType = 11 // Agent -> server, asks for the next queued tasks
+	TypeTaskBatch   MessageType = 12 // Server -> agent, tasks handed to the agent
+	TypeTaskAck     MessageType = 13 // Agent -> server, acknowledges tasks received
+	TypeTaskResult  MessageType = 14 // Agent -> server, a task started running or its outcome
+	TypeTaskReceipt MessageType = 15 // Server -> agent, tasks an acknowledgement or result was accepted for
+
+	TypeUploadBegin  MessageType = 16 // Agent -> server, starts or resumes the chunked upload of a task's output
+	TypeUploadChunk  MessageType = 17 // Agent -> server, a single chunk of a task's output
+	TypeUploadStatus MessageType = 18 // Server -> agent, the chunks the server holds so far
+
+	TypeFileOffer  MessageType = 19 // Agent -> server, starts or resumes sending a file to the server's file store
+	TypeFileChunk  MessageType = 20 // Agent -> server, a single chunk of a file
+	TypeFileStatus MessageType = 21 // Server -> agent, the chunks of a file the server holds so far
+	TypeFileFetch  MessageType = 22 // Agent -> server, asks for a chunk of a file staged for the agent
+	TypeFileData   MessageType = 23 // Server -> agent, the chunk asked for
+)
+
+// messageTypeNames maps message types to the names used by the JSON encoding
+var messageTypeNames = map[MessageType]string{
+	TypePing:         "ping",
+	TypePong:         "pong",
+	TypeRegister:     "register",
+	TypeRegistered:   "registered",
+	TypeTelemetry:    "telemetry",
+	TypeTelemetryAck: "telemetry_ack",
+	TypeShutdown:     "shutdown",
+	TypeShutdownAck:  "shutdown_ack",
+
+	TypeConfigReport:    "config_report",
+	TypeConfigReportAck: "config_report_ack",
+
+	TypeTaskFetch:   "task_fetch",
+	TypeTaskBatch:   "task_batch",
+	TypeTaskAck:     "task_ack",
+	TypeTaskResult:  "task_result",
+	TypeTaskReceipt: "task_receipt",
+
+	TypeUploadBegin:  "upload_begin",
+	TypeUploadChunk:  "upload_chunk",
+	TypeUploadStatus: "upload_status",
+
+	TypeFileOffer:  "file_offer",
+	TypeFileChunk:  "file_chunk",
+	TypeFileStatus: "file_status",
+	TypeFileFetch:  "file_fetch",
+	TypeFileData:   "file_data",
+}
+
+// String returns the name of the message type
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", uint8(t))
+}
+
+// MarshalText encodes the message type by name
+func (t MessageType) MarshalText() ([]byte, error) {
+	if _, ok := messageTypeNames[t]; !ok {
+		return nil, fmt.Errorf("unknown message type %d", uint8(t))
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a message type from its name
+func (t *MessageType) UnmarshalText(text []byte) error {
+	for messageType, name := range messageTypeNames {
+		if name == string(text) {
+			*t = messageType
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown message type '%s'", text)
+}
+
+// Envelope is a single message between an agent and the server
+type Envelope struct {
+	Version  uint8       `json:"version"`
+	Type     MessageType `json:"type"`
+	AgentID  string      `json:"agentId"`
+	Sequence uint64      `json:"sequence"` // Set by the sender, replies echo the sequence they answer
+	Payload  []byte      `json:"payload,omitempty"`
+}
+
+// New creates an envelope of the current version
+func New(messageType MessageType, agentID string, sequence uint64, payload []byte) *Envelope {
+	return &Envelope{
+		Version:  Version,
+		Type:     messageType,
+		AgentID:  agentID,
+		Sequence: sequence,
+		Payload:  payload,
+	}
+}
+
+// Reply creates the answer to an envelope, addressed to the same agent and echoing its sequence
+func (e *Envelope) Reply(messageType MessageType, payload []byte) *Envelope {
+	return New(messageType, e.AgentID, e.Sequence, payload)
+}
+
+// ErrNotEnvelope is returned when decoding data that is not an envelope in either encoding
+var ErrNotEnvelope = errors.New("not a message envelope")
+
+// VersionError is returned when decoding an envelope of a version this build cannot handle
+type VersionError struct {
+	Version uint8
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("incompatible envelope version %d, supported versions are %d to %d",
+		e.Version, MinVersion, Version)
+}
+
+// CheckVersion reports whether an envelope version can be handled by this build
+func CheckVersion(version uint8) error {
+	if version < MinVersion || version > Version {
+		return &VersionError{Version: version}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package factory
 
 import (
+	"crypto/x509"
 	"firestarter/internal/certificates"
 	"firestarter/internal/connections"
 	"firestarter/internal/interfaces"
@@ -9,6 +10,8 @@ import (
 	"firestarter/internal/protocols/h2c"
 	"firestarter/internal/protocols/h2tls"
 	"firestarter/internal/protocols/h3"
+	"firestarter/internal/protocols/tcp"
+	"firestarter/internal/protocols/ws"
 	"firestarter/internal/types"
 	"fmt"
 	"math/rand"
@@ -31,6 +34,8 @@ func NewAbstractFactory(connManager *connections.ConnectionManager) *AbstractFac
 			factories: map[interfaces.ProtocolType]types.ListenerFactory{
 				interfaces.H1C: &h1c.Factory{},
 				interfaces.H2C: &h2c.Factory{},
+				interfaces.WS:  &ws.Factory{},
+				interfaces.TCP: &tcp.Factory{},
 			},
 			connManager: connManager,
 		}
@@ -51,11 +56,24 @@ func NewAbstractFactory(connManager *connections.ConnectionManager) *AbstractFac
 			interfaces.H1TLS: h1tlsFactory,
 			interfaces.H2TLS: h2tlsFactory,
 			interfaces.H3:    h3Factory,
+			interfaces.WS:    &ws.Factory{},
+			interfaces.TCP:   &tcp.Factory{},
 		},
 		connManager: connManager,
 	}
 }
 
+// RequireClientCertificates makes the TLS and QUIC listeners created from now on demand agent
+// certificates issued by one of the CAs in pool
+func (af *AbstractFactory) RequireClientCertificates(pool *x509.CertPool) {
+	for protocol, factory := range af.factories {
+		if mtls, ok := factory.(interface{ RequireClientCertificates(*x509.CertPool) }); ok {
+			mtls.RequireClientCertificates(pool)
+			fmt.Printf("[🔐TLS] -> %s listeners require agent client certificates.\n", interfaces.GetProtocolName(protocol))
+		}
+	}
+}
+
 // CreateListener creates a listener with the specified protocol type
 func (af *AbstractFactory) CreateListener(protocol interfaces.ProtocolType, port string, customID string) (types.Listener, error) {
 	factory, ok := af.factories[protocol]
@@ -0,0 +1,447 @@
+// Package filestore keeps the files moved between operators and agents: files retrieved from
+// agents by download tasks, and files operators stage for upload tasks to write to agents. Each
+// file is recorded with where it came from or went to, its size and hash, and how far its
+// transfer got, so the UI can list transfers and serve retrieved files.
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Direction is which way a file went
+type Direction string
+
+const (
+	DirectionDownload Direction = "download" // Retrieved from an agent
+	DirectionUpload   Direction = "upload"   // Staged by an operator, written to an agent
+)
+
+// State is how far a file's transfer got
+type State string
+
+const (
+	StateStaged       State = "staged"       // Waiting for the agent to fetch it
+	StateTransferring State = "transferring" // Chunks are moving
+	StateComplete     State = "complete"     // Transferred and verified against its hash
+	StateFailed       State = "failed"       // Its task settled before the transfer finished
+)
+
+const (
+	// reconcileInterval is how often transfers are checked against their task's state
+	reconcileInterval = 15 * time.Second
+
+	// partialRetention is how long the chunks of a failed download are kept, so a new download
+	// of the same file resumes rather than starts over
+	partialRetention = 24 * time.Hour
+
+	// indexFile holds the record of every file, so they survive a server restart
+	indexFile = "index.json"
+
+	// blobsDir holds the content of complete and staged files, one file per record
+	blobsDir = "blobs"
+
+	// partialDir holds the chunks of downloads under way, one directory per record
+	partialDir = "partial"
+)
+
+// DefaultStorePath returns the directory files are kept in
+func DefaultStorePath() string {
+	// Relative to the project root, like the keys and task queues
+	return "files"
+}
+
+// Record describes a file and its transfer
+type Record struct {
+	ID        string    `json:"id"`
+	Direction Direction `json:"direction"`
+	AgentUUID string    `json:"agentUUID"`
+	TaskID    string    `json:"taskId,omitempty"` // The task moving the file, the latest one if retried
+	Name      string    `json:"name"`             // Base name, given to browsers downloading the file
+	Path      string    `json:"path"`             // On the agent, where the file was read from or written to
+
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ChunkSize int    `json:"chunkSize"`
+
+	State            State  `json:"state"`
+	BytesTransferred int64  `json:"bytesTransferred"`
+	Error            string `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// manifest returns what the record's content is made of
+func (r *Record) manifest() transfer.Manifest {
+	return transfer.Manifest{Size: r.Size, ChunkSize: r.ChunkSize, SHA256: r.SHA256}
+}
+
+// Global store instance
+var GlobalStore *Store
+
+// Store keeps files and their records, saving the records to disk on every change and handing
+// every change to a notifier
+type Store struct {
+	dir      string
+	records  map[string]*Record  // File ID : record
+	inbound  map[string]*inbound // File ID : download being received
+	notifier func(Record)
+	mutex    sync.Mutex
+}
+
+// inbound is a download being received
+type inbound struct {
+	partial *transfer.Partial
+	mutex   sync.Mutex // Held while chunks are written, never while holding the store's mutex
+}
+
+// InitializeStore sets up the global store, loading the records saved in dir
+func InitializeStore(dir string) error {
+	store := &Store{
+		dir:     dir,
+		records: make(map[string]*Record),
+		inbound: make(map[string]*inbound),
+	}
+	if err := store.load(); err != nil {
+		return err
+	}
+
+	GlobalStore = store
+	go store.reconcileLoop()
+
+	fmt.Printf("[📁FIL] -> File store initialized with %d files.\n", len(store.records))
+	return nil
+}
+
+// GetStore returns the global store instance
+func GetStore() *Store {
+	return GlobalStore
+}
+
+// SetNotifier sets the function changed records are passed to, e.g. to broadcast them to the UI
+func (s *Store) SetNotifier(notifier func(Record)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notifier = notifier
+}
+
+// Get returns a record by ID
+func (s *Store) Get(id string) (Record, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if record, exists := s.records[id]; exists {
+		return *record, true
+	}
+	return Record{}, false
+}
+
+// Snapshot returns every record, oldest first
+func (s *Store) Snapshot() []Record {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		snapshot = append(snapshot, *record)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].CreatedAt.Before(snapshot[j].CreatedAt)
+	})
+	return snapshot
+}
+
+// Open opens the content of a retrieved or staged file for reading
+func (s *Store) Open(id string) (*os.File, Record, error) {
+	record, exists := s.Get(id)
+	if !exists {
+		return nil, Record{}, fmt.Errorf("unknown file %s", id)
+	}
+	if record.Direction == DirectionDownload && record.State != StateComplete {
+		return nil, Record{}, fmt.Errorf("file %s has not been retrieved yet", id)
+	}
+	file, err := os.Open(s.blobPath(id))
+	if err != nil {
+		return nil, Record{}, fmt.Errorf("failed to open file %s: %w", id, err)
+	}
+	return file, record, nil
+}
+
+// Stage keeps a file an operator wants written to an agent, reading it from content. Its task is
+// attached with Attach once queued.
+func (s *Store) Stage(agentUUID string, name string, path string, chunkSize int, content io.Reader) (Record, error) {
+	if agentUUID == "" || path == "" {
+		return Record{}, fmt.Errorf("no agent UUID or destination path given")
+	}
+	if chunkSize == 0 {
+		chunkSize = transfer.DefaultChunkSize
+	}
+	if err := transfer.ValidateChunkSize(chunkSize); err != nil {
+		return Record{}, err
+	}
+	if name == "" {
+		name = path
+	}
+
+	now := time.Now()
+	record := &Record{
+		ID:        uuid.New().String(),
+		Direction: DirectionUpload,
+		AgentUUID: agentUUID,
+		Name:      baseName(name),
+		Path:      path,
+		ChunkSize: chunkSize,
+		State:     StateStaged,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, blobsDir), 0700); err != nil {
+		return Record{}, fmt.Errorf("failed to create file directory: %w", err)
+	}
+	blob := s.blobPath(record.ID)
+	file, err := os.OpenFile(blob, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return Record{}, err
+	}
+	// One byte past the limit tells a file that is too large from one that just fits
+	sum, size, err := transfer.SumReader(io.TeeReader(io.LimitReader(content, transfer.MaxSize+1), file))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > transfer.MaxSize {
+		err = fmt.Errorf("file exceeds the maximum of %d bytes", int64(transfer.MaxSize))
+	}
+	if err != nil {
+		_ = os.Remove(blob)
+		return Record{}, err
+	}
+	record.Size = size
+	record.SHA256 = sum
+
+	s.mutex.Lock()
+	s.records[record.ID] = record
+	s.saveLocked()
+	staged := *record
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	fmt.Printf("[📁FIL] -> Staged %s (%d bytes) for agent %s\n", staged.Name, staged.Size, agentUUID)
+	s.notify(notifier, staged)
+	return staged, nil
+}
+
+// Attach records the task moving a staged file
+func (s *Store) Attach(id string, taskID string) error {
+	s.mutex.Lock()
+	record, exists := s.records[id]
+	if !exists {
+		s.mutex.Unlock()
+		return fmt.Errorf("unknown file %s", id)
+	}
+	record.TaskID = taskID
+	record.UpdatedAt = time.Now()
+	s.saveLocked()
+	changed := *record
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	s.notify(notifier, changed)
+	return nil
+}
+
+// Delete forgets a file and removes its content. Files still moving are refused, cancel their
+// task first.
+func (s *Store) Delete(id string) error {
+	s.mutex.Lock()
+	record, exists := s.records[id]
+	if !exists {
+		s.mutex.Unlock()
+		return fmt.Errorf("unknown file %s", id)
+	}
+	if record.State == StateTransferring || (record.State == StateStaged && record.TaskID != "") {
+		s.mutex.Unlock()
+		return fmt.Errorf("file %s is still being transferred", id)
+	}
+	delete(s.records, id)
+	delete(s.inbound, id)
+	s.saveLocked()
+	s.mutex.Unlock()
+
+	_ = os.Remove(s.blobPath(id))
+	_ = os.RemoveAll(s.partialPath(id))
+	fmt.Printf("[📁FIL] -> Deleted file %s (%s)\n", id, record.Name)
+	return nil
+}
+
+// blobPath returns where the content of a file is kept
+func (s *Store) blobPath(id string) string {
+	return filepath.Join(s.dir, blobsDir, id)
+}
+
+// partialPath returns where the chunks of a download under way are kept
+func (s *Store) partialPath(id string) string {
+	return filepath.Join(s.dir, partialDir, id)
+}
+
+// notify hands a changed record to the notifier, outside the mutex
+func (s *Store) notify(notifier func(Record), changed ...Record) {
+	if notifier == nil {
+		return
+	}
+	for _, record := range changed {
+		notifier(record)
+	}
+}
+
+// reconcileLoop settles transfers whose task settled, and discards the chunks of downloads that
+// failed long enough ago
+func (s *Store) reconcileLoop() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.reconcile(time.Now())
+	}
+}
+
+// reconcile checks the transfers under way against the state of their task. Uploads are only
+// complete once the agent verified the file and its task completed.
+func (s *Store) reconcile(now time.Time) {
+	queue := tasking.GetQueue()
+	if queue == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	var changed []Record
+	for _, record := range s.records {
+		if record.State == StateFailed && record.Direction == DirectionDownload && now.Sub(record.UpdatedAt) > partialRetention {
+			if _, exists := s.inbound[record.ID]; exists || dirExists(s.partialPath(record.ID)) {
+				delete(s.inbound, record.ID)
+				_ = os.RemoveAll(s.partialPath(record.ID))
+			}
+			continue
+		}
+		if record.TaskID == "" || (record.State != StateStaged && record.State != StateTransferring) {
+			continue
+		}
+
+		task, exists := queue.Get(record.TaskID)
+		if exists && !task.State.Terminal() {
+			continue
+		}
+		switch {
+		case !exists:
+			record.State = StateFailed
+			record.Error = "task no longer tracked"
+		case task.State == tasking.StateCompleted && record.Direction == DirectionUpload:
+			record.State = StateComplete
+			record.BytesTransferred = record.Size
+		case task.State == tasking.StateCompleted:
+			// A download completes when its content is reassembled, its task finishing first
+			// means the agent gave up on the transfer without saying so
+			record.State = StateFailed
+			record.Error = "task completed before the transfer finished"
+		default:
+			record.State = StateFailed
+			record.Error = fmt.Sprintf("task %s", task.State)
+			if task.Error != "" {
+				record.Error = task.Error
+			}
+		}
+		record.UpdatedAt = now
+		changed = append(changed, *record)
+	}
+	if len(changed) > 0 {
+		s.saveLocked()
+	}
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	for _, record := range changed {
+		fmt.Printf("[📁FIL] -> %s of %s for agent %s %s\n", directionLabel(record.Direction), record.Path, record.AgentUUID, record.State)
+	}
+	s.notify(notifier, changed...)
+}
+
+// directionLabel names a direction in log lines
+func directionLabel(direction Direction) string {
+	if direction == DirectionUpload {
+		return "Upload"
+	}
+	return "Download"
+}
+
+// dirExists reports whether path is a directory
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// saveLocked writes every record to disk, the caller must hold the mutex. Failures are logged
+// rather than returned, the store keeps working from memory.
+func (s *Store) saveLocked() {
+	if err := s.writeLocked(); err != nil {
+		fmt.Printf("[❌ERR] -> Failed to save file index: %v\n", err)
+	}
+}
+
+func (s *Store) writeLocked() error {
+	records := make([]*Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	encoded, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create file directory: %w", err)
+	}
+
+	// Write then rename, so a crash never leaves a truncated file behind
+	path := filepath.Join(s.dir, indexFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// load reads the records saved by a previous run, there are none on the first run
+func (s *Store) load() error {
+	encoded, err := os.ReadFile(filepath.Join(s.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file index: %w", err)
+	}
+
+	var records []*Record
+	if err := json.Unmarshal(encoded, &records); err != nil {
+		return fmt.Errorf("corrupt file index: %w", err)
+	}
+	for _, record := range records {
+		if record.ID == "" {
+			continue
+		}
+		s.records[record.ID] = record
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknown is returned when an agent asks for a file it has no task under way for, it should
+// give up on the transfer rather than retry
+var ErrUnknown = errors.New("no transfer under way")
+
+// Offer starts or resumes receiving a file an agent sends for a download task. The same file
+// offered again, by the same task or a later download of the same path, resumes with the chunks
+// still missing. The status names the file chunks are to be sent for.
+func (s *Store) Offer(agentUUID string, offer transfer.Offer) (transfer.Status, error) {
+	if _, err := taskFor(agentUUID, offer.TaskID, transfer.TaskDownload); err != nil {
+		return transfer.Status{ID: offer.TaskID, Unknown: true}, nil
+	}
+
+	s.mutex.Lock()
+	record := s.resumableLocked(agentUUID, offer)
+	if record != nil && record.State == StateComplete {
+		// The result may have been lost on the way back after the file arrived
+		status := transfer.Status{ID: record.ID, Complete: true}
+		s.mutex.Unlock()
+		return status, nil
+	}
+
+	now := time.Now()
+	if record == nil {
+		record = &Record{
+			ID:        uuid.New().String(),
+			Direction: DirectionDownload,
+			AgentUUID: agentUUID,
+			Name:      baseName(offer.Path),
+			Path:      offer.Path,
+			Size:      offer.Size,
+			SHA256:    offer.SHA256,
+			ChunkSize: offer.ChunkSize,
+			CreatedAt: now,
+		}
+		s.records[record.ID] = record
+	}
+	record.TaskID = offer.TaskID
+	record.State = StateTransferring
+	record.Error = ""
+	record.UpdatedAt = now
+	changed := []Record{*record}
+	for _, other := range s.records {
+		// The file changed since the task first offered it
+		if other.TaskID == offer.TaskID && other.ID != record.ID && other.State == StateTransferring {
+			other.State = StateFailed
+			other.Error = "the file changed while it was being sent"
+			other.UpdatedAt = now
+			changed = append(changed, *other)
+		}
+	}
+	in := s.inboundLocked(record.ID)
+	s.saveLocked()
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	s.notify(notifier, changed...)
+
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	restarted, err := in.partial.Begin(offer.Manifest)
+	if err != nil {
+		return transfer.Status{}, err
+	}
+	if restarted {
+		fmt.Printf("[📁FIL] -> Agent %s is sending %s (%d bytes) in %d chunk(s)\n", agentUUID, offer.Path, offer.Size, offer.Chunks())
+	} else {
+		fmt.Printf("[📁FIL] -> Agent %s resumed sending %s with %d/%d chunk(s) received\n", agentUUID, offer.Path, len(in.partial.Received()), offer.Chunks())
+	}
+
+	// Empty files have no chunks to wait for
+	if in.partial.Done() {
+		return s.assemble(record.ID, in)
+	}
+	s.recordProgress(record.ID, in.partial.Progress().BytesReceived)
+	return transfer.Status{ID: record.ID, Received: in.partial.Received()}, nil
+}
+
+// StoreChunk keeps a chunk of a file an agent is sending, and reassembles the file once every
+// chunk arrived. Chunks already held are accepted again without being written twice.
+func (s *Store) StoreChunk(agentUUID string, chunk transfer.Chunk) (transfer.Status, error) {
+	s.mutex.Lock()
+	record, exists := s.records[chunk.ID]
+	if !exists || record.AgentUUID != agentUUID || record.Direction != DirectionDownload {
+		s.mutex.Unlock()
+		return transfer.Status{ID: chunk.ID, Unknown: true}, nil
+	}
+	if record.State == StateComplete {
+		s.mutex.Unlock()
+		return transfer.Status{ID: chunk.ID, Complete: true}, nil
+	}
+	if record.State != StateTransferring {
+		s.mutex.Unlock()
+		return transfer.Status{ID: chunk.ID, Unknown: true}, nil
+	}
+	in := s.inboundLocked(chunk.ID)
+	s.mutex.Unlock()
+
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	if err := in.partial.Put(chunk.Index, chunk.Data); err != nil {
+		return transfer.Status{}, fmt.Errorf("file %s: %w", chunk.ID, err)
+	}
+	if in.partial.Done() {
+		return s.assemble(chunk.ID, in)
+	}
+	s.recordProgress(chunk.ID, in.partial.Progress().BytesReceived)
+	return transfer.Status{ID: chunk.ID, Received: in.partial.Received()}, nil
+}
+
+// ReadChunk reads a chunk of a file staged for an agent's upload task
+func (s *Store) ReadChunk(agentUUID string, fetch transfer.Fetch) (transfer.Chunk, error) {
+	task, err := taskFor(agentUUID, fetch.TaskID, transfer.TaskUpload)
+	if err != nil {
+		return transfer.Chunk{}, err
+	}
+	var args transfer.UploadArgs
+	if err := json.Unmarshal(task.Args, &args); err != nil || args.FileID != fetch.FileID {
+		return transfer.Chunk{}, fmt.Errorf("task %s does not upload file %s: %w", fetch.TaskID, fetch.FileID, ErrUnknown)
+	}
+
+	s.mutex.Lock()
+	record, exists := s.records[fetch.FileID]
+	if !exists || record.Direction != DirectionUpload {
+		s.mutex.Unlock()
+		return transfer.Chunk{}, fmt.Errorf("file %s: %w", fetch.FileID, ErrUnknown)
+	}
+	manifest := record.manifest()
+	s.mutex.Unlock()
+
+	if fetch.Index < 0 || fetch.Index >= manifest.Chunks() {
+		return transfer.Chunk{}, fmt.Errorf("chunk %d is outside the %d chunk(s) of file %s", fetch.Index, manifest.Chunks(), fetch.FileID)
+	}
+
+	file, err := os.Open(s.blobPath(fetch.FileID))
+	if err != nil {
+		return transfer.Chunk{}, fmt.Errorf("failed to open file %s: %w", fetch.FileID, err)
+	}
+	defer file.Close()
+
+	data := make([]byte, manifest.ChunkLen(fetch.Index))
+	if _, err := file.ReadAt(data, manifest.ChunkOffset(fetch.Index)); err != nil && !errors.Is(err, io.EOF) {
+		return transfer.Chunk{}, fmt.Errorf("failed to read chunk %d of file %s: %w", fetch.Index, fetch.FileID, err)
+	}
+
+	s.mutex.Lock()
+	if fetch.Index == 0 && record.State == StateStaged {
+		fmt.Printf("[📁FIL] -> Agent %s is fetching %s (%d bytes) in %d chunk(s)\n", agentUUID, record.Name, record.Size, manifest.Chunks())
+	}
+	if record.State == StateStaged || record.State == StateTransferring {
+		record.State = StateTransferring
+		record.BytesTransferred = max(record.BytesTransferred, manifest.ChunkOffset(fetch.Index)+int64(len(data)))
+		record.UpdatedAt = time.Now()
+	}
+	changed := *record
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	s.notify(notifier, changed)
+	return transfer.NewChunk(fetch.FileID, fetch.Index, data), nil
+}
+
+// resumableLocked finds the record of a file offered before: by the same task, or by an earlier
+// download of the same file whose chunks are still kept. The caller must hold the mutex.
+func (s *Store) resumableLocked(agentUUID string, offer transfer.Offer) *Record {
+	var found *Record
+	for _, record := range s.records {
+		if record.Direction != DirectionDownload || record.AgentUUID != agentUUID {
+			continue
+		}
+		if record.Path != offer.Path || record.manifest() != offer.Manifest {
+			continue
+		}
+		if record.TaskID == offer.TaskID {
+			return record
+		}
+		if record.State != StateComplete && (found == nil || record.UpdatedAt.After(found.UpdatedAt)) {
+			found = record
+		}
+	}
+	return found
+}
+
+// inboundLocked returns the download being received into a record, picking up chunks kept on
+// disk by an earlier run. The caller must hold the mutex.
+func (s *Store) inboundLocked(id string) *inbound {
+	if in, exists := s.inbound[id]; exists {
+		return in
+	}
+	in := &inbound{partial: transfer.OpenPartial(s.partialPath(id))}
+	s.inbound[id] = in
+	return in
+}
+
+// assemble reassembles a complete download into the file's content. A mismatch with the hash the
+// agent gave discards every chunk, the agent then sends the file again. The caller must hold the
+// download's mutex.
+func (s *Store) assemble(id string, in *inbound) (transfer.Status, error) {
+	if err := in.partial.Assemble(s.blobPath(id)); err != nil {
+		if errors.Is(err, transfer.ErrMismatch) {
+			err = fmt.Errorf("reassembled file does not match its hash, sending again")
+		}
+		fmt.Printf("[❌ERR] -> Download of file %s failed: %v\n", id, err)
+		return transfer.Status{}, err
+	}
+
+	s.mutex.Lock()
+	record, exists := s.records[id]
+	if !exists {
+		s.mutex.Unlock()
+		_ = os.Remove(s.blobPath(id))
+		return transfer.Status{ID: id, Unknown: true}, nil
+	}
+	record.State = StateComplete
+	record.BytesTransferred = record.Size
+	record.UpdatedAt = time.Now()
+	delete(s.inbound, id)
+	s.saveLocked()
+	changed := *record
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	fmt.Printf("[📁FIL] -> Retrieved %s (%d bytes) from agent %s\n", changed.Path, changed.Size, changed.AgentUUID)
+	s.notify(notifier, changed)
+	return transfer.Status{ID: id, Complete: true}, nil
+}
+
+// recordProgress updates how much of a download was received and passes it on to the notifier,
+// without saving it since it is rebuilt from the chunks on disk
+func (s *Store) recordProgress(id string, received int64) {
+	s.mutex.Lock()
+	record, exists := s.records[id]
+	if !exists {
+		s.mutex.Unlock()
+		return
+	}
+	record.BytesTransferred = received
+	changed := *record
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	s.notify(notifier, changed)
+}
+
+// taskFor returns an agent's task moving a file, as long as it is still under way
+func taskFor(agentUUID string, taskID string, taskType string) (tasking.Task, error) {
+	queue := tasking.GetQueue()
+	if queue == nil {
+		return tasking.Task{}, fmt.Errorf("tasking not available")
+	}
+	task, exists := queue.Get(taskID)
+	if !exists || task.AgentUUID != agentUUID || task.Type != taskType {
+		return tasking.Task{}, fmt.Errorf("no %s task %s for agent %s: %w", taskType, taskID, agentUUID, ErrUnknown)
+	}
+	if task.State.Terminal() {
+		return tasking.Task{}, fmt.Errorf("task %s is %s: %w", taskID, task.State, ErrUnknown)
+	}
+	return task, nil
+}
+
+// baseName returns the last element of a path from any agent, whichever separator its
+// platform uses
+func baseName(path string) string {
+	path = strings.TrimRight(path, `/\`)
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		path = path[i+1:]
+	}
+	if path == "" {
+		return "file"
+	}
+	return path
+}
@@ -0,0 +1,163 @@
+package filestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"testing"
+)
+
+const testAgentUUID = "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b"
+
+// newTestStore sets up the global store and task queue, which file transfers check their
+// tasks against, in fresh directories
+func newTestStore(t *testing.T) (*Store, *tasking.Queue) {
+	t.Helper()
+
+	if err := tasking.InitializeQueue(t.TempDir()); err != nil {
+		t.Fatalf("InitializeQueue: %v", err)
+	}
+	queue := tasking.GetQueue()
+	t.Cleanup(func() {
+		tasking.GlobalQueue = nil
+		// Saved in the background, done before the directory goes
+		queue.Flush()
+	})
+
+	if err := InitializeStore(t.TempDir()); err != nil {
+		t.Fatalf("InitializeStore: %v", err)
+	}
+	t.Cleanup(func() { GlobalStore = nil })
+	return GetStore(), queue
+}
+
+// stageUpload stages content for an agent and queues its upload task, as the file service does
+func stageUpload(t *testing.T, s *Store, q *tasking.Queue, agentUUID string, content []byte) (Record, tasking.Task) {
+	t.Helper()
+
+	record, err := s.Stage(agentUUID, "tool.exe", `C:\Temp\tool.exe`, transfer.MinChunkSize, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	args, _ := json.Marshal(transfer.UploadArgs{FileID: record.ID, Path: record.Path, Manifest: record.manifest()})
+	task, err := q.Enqueue(agentUUID, transfer.TaskUpload, args, 0, 0)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := s.Attach(record.ID, task.ID); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	return record, task
+}
+
+// fileContent returns a file spanning a few of the smallest chunks, the last one short
+func fileContent() []byte {
+	content := make([]byte, 2*transfer.MinChunkSize+10)
+	for i := range content {
+		content[i] = byte(i*13 + i/transfer.MinChunkSize)
+	}
+	return content
+}
+
+func TestReadChunk(t *testing.T) {
+	s, q := newTestStore(t)
+	content := fileContent()
+	record, task := stageUpload(t, s, q, testAgentUUID, content)
+
+	for index := 0; index < record.manifest().Chunks(); index++ {
+		chunk, err := s.ReadChunk(testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: record.ID, Index: index})
+		if err != nil {
+			t.Fatalf("ReadChunk(%d): %v", index, err)
+		}
+		offset := record.manifest().ChunkOffset(index)
+		want := content[offset : offset+record.manifest().ChunkLen(index)]
+		if !bytes.Equal(chunk.Data, want) || chunk.SHA256 != transfer.Sum(want) {
+			t.Errorf("chunk %d holds %d bytes that differ from the file's %d", index, len(chunk.Data), len(want))
+		}
+	}
+
+	fetched, _ := s.Get(record.ID)
+	if fetched.State != StateTransferring || fetched.BytesTransferred != int64(len(content)) {
+		t.Errorf("record = %s with %d bytes moved, want transferring with %d", fetched.State, fetched.BytesTransferred, len(content))
+	}
+}
+
+func TestReadChunkOnlyServesTheTasksFile(t *testing.T) {
+	s, q := newTestStore(t)
+	content := fileContent()
+	record, task := stageUpload(t, s, q, testAgentUUID, content)
+
+	// Other files an agent could learn the ID of
+	sibling, _ := stageUpload(t, s, q, testAgentUUID, content)
+	foreign, foreignTask := stageUpload(t, s, q, "other-agent", content)
+	unattached, err := s.Stage(testAgentUUID, "notes.txt", "/tmp/notes.txt", 0, bytes.NewReader([]byte("notes")))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	download, err := q.Enqueue(testAgentUUID, transfer.TaskDownload, json.RawMessage(`{"path":"/etc/passwd"}`), 0, 0)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		agentUUID string
+		fetch     transfer.Fetch
+	}{
+		{"another file of the same agent", testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: sibling.ID}},
+		{"another agent's file", testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: foreign.ID}},
+		{"a file with no task", testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: unattached.ID}},
+		{"an unknown file", testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: "unknown-file"}},
+		{"another agent's task", testAgentUUID, transfer.Fetch{TaskID: foreignTask.ID, FileID: foreign.ID}},
+		{"its task claimed by another agent", "other-agent", transfer.Fetch{TaskID: task.ID, FileID: record.ID}},
+		{"a download task", testAgentUUID, transfer.Fetch{TaskID: download.ID, FileID: record.ID}},
+		{"an unknown task", testAgentUUID, transfer.Fetch{TaskID: "unknown-task", FileID: record.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, err := s.ReadChunk(tt.agentUUID, tt.fetch)
+			if !errors.Is(err, ErrUnknown) {
+				t.Errorf("ReadChunk = %d bytes, %v, want %v", len(chunk.Data), err, ErrUnknown)
+			}
+		})
+	}
+
+	// Nothing was handed out, so nothing is moving
+	for _, id := range []string{sibling.ID, foreign.ID, unattached.ID} {
+		if other, _ := s.Get(id); other.State != StateStaged {
+			t.Errorf("file %s is %s, want it still staged", id, other.State)
+		}
+	}
+}
+
+func TestReadChunkRefusesSettledTask(t *testing.T) {
+	s, q := newTestStore(t)
+	record, task := stageUpload(t, s, q, testAgentUUID, fileContent())
+
+	q.Fetch(testAgentUUID, 0)
+	q.Acknowledge(testAgentUUID, []string{task.ID})
+	q.Report(testAgentUUID, tasking.Result{ID: task.ID, State: tasking.StateCompleted})
+
+	if _, err := s.ReadChunk(testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: record.ID}); !errors.Is(err, ErrUnknown) {
+		t.Errorf("ReadChunk for a completed task = %v, want %v", err, ErrUnknown)
+	}
+}
+
+func TestReadChunkRejectsOutOfRangeIndex(t *testing.T) {
+	s, q := newTestStore(t)
+	record, task := stageUpload(t, s, q, testAgentUUID, fileContent())
+	chunks := record.manifest().Chunks()
+
+	for _, index := range []int{-1, chunks, chunks + 1, 1 << 30} {
+		chunk, err := s.ReadChunk(testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: record.ID, Index: index})
+		if err == nil {
+			t.Errorf("ReadChunk(%d) = %d bytes, want an error", index, len(chunk.Data))
+		}
+	}
+
+	if fetched, _ := s.Get(record.ID); fetched.State != StateStaged || fetched.BytesTransferred != 0 {
+		t.Errorf("record = %s with %d bytes moved, want it untouched", fetched.State, fetched.BytesTransferred)
+	}
+}
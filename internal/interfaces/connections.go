@@ -11,6 +11,8 @@ const (
 	H2C
 	H2TLS
 	H3
+	WS
+	TCP
 )
 
 // Connection defines what all protocol-specific connections must implement
@@ -46,6 +48,10 @@ func GetProtocolName(protocol ProtocolType) string {
 		return "HTTP/2 TLS"
 	case H3:
 		return "HTTP/3"
+	case WS:
+		return "WebSocket"
+	case TCP:
+		return "Raw TCP"
 	default:
 		return "Unknown"
 	}
@@ -138,6 +138,8 @@ func (l *ConcreteListener) GetProtocol() string {
 		return "HTTP/2 TLS"
 	case interfaces.H3:
 		return "HTTP/3"
+	case interfaces.WS:
+		return "WebSocket"
 	default:
 		return "Unknown Protocol"
 	}
@@ -57,6 +57,10 @@ func (ctl *ConnectionTrackingListener) Accept() (net.Conn, error) {
 		managedConn = connections.NewHTTP1TLSConnection(conn, ctl.port)
 	case interfaces.H2TLS:
 		managedConn = connections.NewHTTP2TLSConnection(conn, ctl.port)
+	case interfaces.WS:
+		managedConn = connections.NewWebSocketConnection(conn, ctl.port)
+	case interfaces.TCP:
+		managedConn = connections.NewTCPConnection(conn, ctl.port)
 	default:
 		log.Printf("Unsupported protocol type: %v", ctl.protocol)
 		return conn, nil
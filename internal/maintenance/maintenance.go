@@ -0,0 +1,97 @@
+// Package maintenance keeps which listeners are in maintenance. Agents reaching a listener in
+// maintenance are turned away with a hint of when to come back, instead of hammering it with
+// their reconnect backoff.
+package maintenance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetryAfter is how long agents are told to stay away when no delay is given
+	DefaultRetryAfter = 5 * time.Minute
+
+	// MaxRetryAfter caps the delay agents can be told to wait
+	MaxRetryAfter = 24 * time.Hour
+)
+
+// Window describes a listener's maintenance
+type Window struct {
+	Since      time.Time
+	RetryAfter time.Duration // Delay hinted to agents turned away
+}
+
+// Global registry instance
+var GlobalRegistry *Registry
+
+// Registry keeps the maintenance windows of listeners, keyed by listener ID
+type Registry struct {
+	listeners map[string]Window
+	mutex     sync.RWMutex
+}
+
+// InitializeRegistry sets up the global registry
+func InitializeRegistry() {
+	GlobalRegistry = &Registry{
+		listeners: make(map[string]Window),
+	}
+}
+
+// GetRegistry returns the global registry instance
+func GetRegistry() *Registry {
+	return GlobalRegistry
+}
+
+// Enable puts a listener in maintenance, agents being told to retry after the given delay.
+// Enabling it again only updates the delay.
+func (r *Registry) Enable(listenerID string, retryAfter time.Duration) (Window, error) {
+	if retryAfter <= 0 {
+		retryAfter = DefaultRetryAfter
+	}
+	if retryAfter > MaxRetryAfter {
+		return Window{}, fmt.Errorf("retry delay %v exceeds the maximum of %v", retryAfter, MaxRetryAfter)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	window, exists := r.listeners[listenerID]
+	if !exists {
+		window.Since = time.Now()
+	}
+	window.RetryAfter = retryAfter
+	r.listeners[listenerID] = window
+	return window, nil
+}
+
+// Disable takes a listener out of maintenance
+func (r *Registry) Disable(listenerID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.listeners, listenerID)
+}
+
+// Get returns a listener's maintenance window, false when it is not in maintenance
+func (r *Registry) Get(listenerID string) (Window, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	window, exists := r.listeners[listenerID]
+	return window, exists
+}
+
+// Active returns a listener's maintenance window from the global registry, false when it is
+// not in maintenance
+func Active(listenerID string) (Window, bool) {
+	if GlobalRegistry == nil {
+		return Window{}, false
+	}
+	return GlobalRegistry.Get(listenerID)
+}
+
+// RetryAfterHeader formats a delay as the seconds of a Retry-After header, rounded up
+func RetryAfterHeader(delay time.Duration) string {
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	return fmt.Sprintf("%d", seconds)
+}
@@ -0,0 +1,92 @@
+package framed
+
+import (
+	"bytes"
+	"firestarter/internal/checkin"
+	"firestarter/internal/signing"
+	"net/http"
+	"time"
+)
+
+// Frame types exchanged with agents over persistent framed transports (WebSocket, raw TCP)
+const (
+	FrameHello    = "hello"    // Agent -> server, identifies the agent on transports without HTTP headers
+	FrameWelcome  = "welcome"  // Server -> agent, accepts a hello
+	FrameRequest  = "request"  // Agent -> server, dispatched to the listener's router
+	FrameResponse = "response" // Server -> agent, answers a request with the same ID
+	FrameWork     = "work"     // Server -> agent, pushed as soon as work is published
+	FramePing     = "ping"     // Server -> agent keep-alive on transports without control frames
+	FramePong     = "pong"     // Agent -> server, answers a ping
+)
+
+// MaxFrameSize caps a single frame on every framed transport, so a peer can't make the other
+// side buffer a frame of any size
+const MaxFrameSize = 16 << 20
+
+// Frame is a single message on a framed agent transport
+type Frame struct {
+	Type      string        `json:"type"`
+	ID        string        `json:"id,omitempty"` // Correlates a response with its request
+	AgentUUID string        `json:"agentUUID,omitempty"`
+	Method    string        `json:"method,omitempty"`
+	Endpoint  string        `json:"endpoint,omitempty"`
+	Status    int           `json:"status,omitempty"`
+	Payload   []byte        `json:"payload,omitempty"`
+	Work      *checkin.Work `json:"work,omitempty"`
+
+	// Signature authenticates hello and request frames. Request frames are signed like an
+	// HTTP request to the same endpoint and verified by the router's middleware.
+	Signature *signing.Signature `json:"signature,omitempty"`
+
+	// Compression, negotiated per request like over HTTP: Encoding is the codec the payload is
+	// compressed with, Accept lists the codecs the agent takes for the response
+	Encoding string `json:"encoding,omitempty"`
+	Accept   string `json:"accept,omitempty"`
+
+	// RetryAfter carries the Retry-After hint of a response, or of a welcome refusing the
+	// agent while the listener is in maintenance (with Status 503)
+	RetryAfter string `json:"retryAfter,omitempty"`
+}
+
+// Conn is a transport that carries whole frames
+type Conn interface {
+	ReadFrame(frame *Frame) error
+	WriteFrame(frame Frame) error
+
+	// Ping sends a transport-level keep-alive
+	Ping() error
+
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+
+	// Close ends the transport, notifying the agent where the transport allows it
+	Close() error
+}
+
+// frameResponseWriter captures a router response so it can be sent back as a frame
+type frameResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newFrameResponseWriter() *frameResponseWriter {
+	return &frameResponseWriter{header: make(http.Header)}
+}
+
+func (w *frameResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *frameResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *frameResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
@@ -0,0 +1,256 @@
+package framed
+
+import (
+	"bytes"
+	"context"
+	"firestarter/internal/checkin"
+	"firestarter/internal/scope"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// PingInterval is how often the server pings an idle agent
+	PingInterval = 30 * time.Second
+
+	// PongWait is how long the server waits for any traffic before considering the agent gone
+	PongWait = 75 * time.Second
+
+	// WriteWait bounds a single frame write
+	WriteWait = 10 * time.Second
+)
+
+// Session serves a single agent over a framed transport. Request frames are dispatched to
+// the listener's router, so every HTTP endpoint is reachable, and work published for the
+// agent is pushed the moment it arrives.
+type Session struct {
+	conn       Conn
+	agentUUID  string
+	remoteAddr string
+	transport  string // Used in log lines, e.g. "WebSocket"
+	handler    http.Handler
+
+	// Frames must not be written concurrently
+	writeLock sync.Mutex
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// NewSession creates a session that dispatches agent requests to handler
+func NewSession(conn Conn, agentUUID string, remoteAddr string, transport string, handler http.Handler) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Session{
+		conn:       conn,
+		agentUUID:  agentUUID,
+		remoteAddr: remoteAddr,
+		transport:  transport,
+		handler:    handler,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Run serves the session until the agent disconnects or the session is closed
+func (s *Session) Run() {
+	defer s.Close()
+
+	fmt.Printf("[🔌CON] -> %s session opened for agent %s (Remote: %s)\n", s.transport, s.agentUUID, s.remoteAddr)
+
+	go s.pushWork()
+	go s.keepAlive()
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(PongWait))
+
+		var frame Frame
+		if err := s.conn.ReadFrame(&frame); err != nil {
+			select {
+			case <-s.ctx.Done():
+				// Closed by us
+			default:
+				fmt.Printf("[🛑STP] -> %s session for agent %s ended: %v\n", s.transport, s.agentUUID, err)
+			}
+			return
+		}
+
+		switch frame.Type {
+		case FrameRequest:
+			// Handle requests concurrently so a slow endpoint doesn't block the transport
+			go s.dispatch(frame)
+		case FramePong:
+			// Reading it already extended the deadline
+		default:
+			fmt.Printf("[❌ERR] -> Ignoring unexpected %s frame from agent %s\n", frame.Type, s.agentUUID)
+		}
+	}
+}
+
+// dispatch runs a request frame through the listener's router and answers with a response frame
+func (s *Session) dispatch(frame Frame) {
+	method := frame.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, method, frame.Endpoint, bytes.NewReader(frame.Payload))
+	if err != nil {
+		s.Write(Frame{Type: FrameResponse, ID: frame.ID, Status: http.StatusBadRequest})
+		return
+	}
+	req.RemoteAddr = s.remoteAddr
+	setFrameHeaders(req.Header, s.agentUUID, frame)
+
+	recorder := newFrameResponseWriter()
+	s.handler.ServeHTTP(recorder, req)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	s.Write(Frame{
+		Type:       FrameResponse,
+		ID:         frame.ID,
+		Status:     recorder.status,
+		Payload:    recorder.body.Bytes(),
+		Encoding:   recorder.header.Get("Content-Encoding"),
+		RetryAfter: recorder.header.Get("Retry-After"),
+	})
+}
+
+// setFrameHeaders gives the request made from a request frame the headers an HTTP agent
+// would have sent, so the router's middleware treats both alike
+func setFrameHeaders(header http.Header, agentUUID string, frame Frame) {
+	header.Set("X-Agent-UUID", agentUUID)
+	header.Set("Content-Type", "application/octet-stream")
+	if frame.Signature != nil {
+		frame.Signature.SetHeaders(header)
+	}
+	if frame.Encoding != "" {
+		header.Set("Content-Encoding", frame.Encoding)
+	}
+	if frame.Accept != "" {
+		header.Set("Accept-Encoding", frame.Accept)
+	}
+}
+
+// pushWork forwards work published for the agent the moment it arrives
+func (s *Session) pushWork() {
+	hub := checkin.GetCheckInHub()
+	if hub == nil || s.agentUUID == "" {
+		return
+	}
+
+	// Agents quarantined for being outside the authorized scope are never handed work
+	if authorized := scope.GetScope(); authorized != nil && !authorized.Allows(s.remoteAddr) {
+		return
+	}
+
+	for {
+		work := hub.Wait(s.ctx, s.agentUUID)
+		if len(work) == 0 {
+			return // Session closed
+		}
+
+		for i := range work {
+			item, err := work[i].ForAgent(s.agentUUID)
+			if err != nil {
+				// Never fall back to delivering the payload in the clear
+				fmt.Printf("[❌ERR] -> Dropping work %s for agent %s: %v\n", item.ID, s.agentUUID, err)
+				continue
+			}
+			if err := s.Write(Frame{Type: FrameWork, Work: &item}); err != nil {
+				fmt.Printf("[❌ERR] -> Failed to push work %s to agent %s: %v\n", work[i].ID, s.agentUUID, err)
+				return
+			}
+		}
+		fmt.Printf("[📥CHK] -> Pushed %d work item(s) to agent %s over %s\n", len(work), s.agentUUID, s.transport)
+	}
+}
+
+// keepAlive pings the agent so dead transports are noticed even when no frames flow
+func (s *Session) keepAlive() {
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.writeLock.Lock()
+			s.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+			err := s.conn.Ping()
+			s.writeLock.Unlock()
+
+			if err != nil {
+				s.Close()
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Write sends a single frame
+func (s *Session) Write(frame Frame) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+	return s.conn.WriteFrame(frame)
+}
+
+// Close ends the session, closing the underlying (tracked) connection
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		s.cancel()
+
+		s.writeLock.Lock()
+		s.conn.SetWriteDeadline(time.Now().Add(WriteWait))
+		s.conn.Close()
+		s.writeLock.Unlock()
+
+		fmt.Printf("[🛑STP] -> %s session closed for agent %s\n", s.transport, s.agentUUID)
+	})
+}
+
+// Sessions tracks the open sessions of a listener so they can be closed when it stops,
+// since hijacked or raw connections are not closed by the listener's server
+type Sessions struct {
+	sessions map[*Session]struct{}
+	mutex    sync.Mutex
+}
+
+// NewSessions creates an empty session set
+func NewSessions() *Sessions {
+	return &Sessions{sessions: make(map[*Session]struct{})}
+}
+
+// Serve runs the session, tracking it until it ends
+func (ss *Sessions) Serve(s *Session) {
+	ss.mutex.Lock()
+	ss.sessions[s] = struct{}{}
+	ss.mutex.Unlock()
+
+	s.Run()
+
+	ss.mutex.Lock()
+	delete(ss.sessions, s)
+	ss.mutex.Unlock()
+}
+
+// CloseAll closes every open session
+func (ss *Sessions) CloseAll() {
+	ss.mutex.Lock()
+	open := make([]*Session, 0, len(ss.sessions))
+	for s := range ss.sessions {
+		open = append(open, s)
+	}
+	ss.mutex.Unlock()
+
+	for _, s := range open {
+		s.Close()
+	}
+}
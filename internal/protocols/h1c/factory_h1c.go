@@ -14,7 +14,7 @@ type Factory struct{}
 
 func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
 	r := chi.NewRouter()
-	router.SetupRoutes(r)
+	router.SetupRoutes(r, id)
 	fmt.Printf("[👂🏻LSN] -> Listener (%s) created on port %s, protocol %s\n",
 		id, port, interfaces.GetProtocolName(interfaces.H1C))
 	return listener.NewConcreteListener(id, port, interfaces.H1C, r, connManager), nil
@@ -1,7 +1,9 @@
 package h1tls
 
 import (
+	"crypto/x509"
 	"firestarter/internal/certificates"
+	"firestarter/internal/connregistry"
 	"firestarter/internal/interfaces"
 	"firestarter/internal/listener"
 	"firestarter/internal/router"
@@ -13,6 +15,7 @@ import (
 // Factory creates HTTP/1.1 TLS listeners
 type Factory struct {
 	certProvider certificates.CertificateProvider
+	clientCAs    *x509.CertPool // Set when agents must present a client certificate
 }
 
 // NewFactory creates a new H1TLS factory with the given certificate provider
@@ -22,6 +25,12 @@ func NewFactory(certProvider certificates.CertificateProvider) *Factory {
 	}
 }
 
+// RequireClientCertificates makes listeners created from now on demand a client certificate
+// issued by one of the CAs in pool. The certificate then identifies the agent on its connection.
+func (f *Factory) RequireClientCertificates(pool *x509.CertPool) {
+	f.clientCAs = pool
+}
+
 // CreateListener creates and configures an HTTP/1.1 TLS listener
 func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
 	// Get TLS configuration
@@ -30,9 +39,15 @@ func (f *Factory) CreateListener(id string, port string, connManager interfaces.
 		return nil, fmt.Errorf("failed to get TLS configuration: %w", err)
 	}
 
+	// Bind the certificate identity to the tracked connection during the handshake
+	if f.clientCAs != nil {
+		certificates.RequireClientCertificates(tlsConfig, f.clientCAs)
+		certificates.IdentifyAgents(tlsConfig, connregistry.RegisterCertificateIdentity)
+	}
+
 	// Create router and set up routes
 	r := chi.NewRouter()
-	router.SetupRoutes(r)
+	router.SetupRoutes(r, id)
 
 	// Create the listener
 	concreteListener := listener.NewConcreteListener(id, port, interfaces.H1TLS, r, connManager)
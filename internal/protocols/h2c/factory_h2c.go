@@ -16,7 +16,7 @@ type Factory struct{}
 
 func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
 	r := chi.NewRouter()
-	router.SetupRoutes(r)
+	router.SetupRoutes(r, id)
 
 	h2s := &http2.Server{}
 
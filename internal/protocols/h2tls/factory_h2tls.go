@@ -1,7 +1,9 @@
 package h2tls
 
 import (
+	"crypto/x509"
 	"firestarter/internal/certificates"
+	"firestarter/internal/connregistry"
 	"firestarter/internal/interfaces"
 	"firestarter/internal/listener"
 	"firestarter/internal/router"
@@ -15,6 +17,7 @@ import (
 // Factory creates HTTP/2 TLS listeners
 type Factory struct {
 	certProvider certificates.CertificateProvider
+	clientCAs    *x509.CertPool // Set when agents must present a client certificate
 }
 
 // NewFactory creates a new H2TLS factory with the given certificate provider
@@ -24,6 +27,12 @@ func NewFactory(certProvider certificates.CertificateProvider) *Factory {
 	}
 }
 
+// RequireClientCertificates makes listeners created from now on demand a client certificate
+// issued by one of the CAs in pool. The certificate then identifies the agent on its connection.
+func (f *Factory) RequireClientCertificates(pool *x509.CertPool) {
+	f.clientCAs = pool
+}
+
 // CreateListener creates and configures an HTTP/2 TLS listener
 func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
 	// Get TLS configuration
@@ -32,12 +41,18 @@ func (f *Factory) CreateListener(id string, port string, connManager interfaces.
 		return nil, fmt.Errorf("failed to get TLS configuration: %w", err)
 	}
 
+	// Bind the certificate identity to the tracked connection during the handshake
+	if f.clientCAs != nil {
+		certificates.RequireClientCertificates(tlsConfig, f.clientCAs)
+		certificates.IdentifyAgents(tlsConfig, connregistry.RegisterCertificateIdentity)
+	}
+
 	// Configure for HTTP/2
 	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
 
 	// Create router and set up routes
 	r := chi.NewRouter()
-	router.SetupRoutes(r)
+	router.SetupRoutes(r, id)
 
 	concreteListener := listener.NewConcreteListener(id, port, interfaces.H2TLS, r, connManager)
 
@@ -1,13 +1,14 @@
 package h3
 
 import (
+	"crypto/tls"
+	"firestarter/internal/certificates"
 	"firestarter/internal/connections"
+	"firestarter/internal/connregistry"
 	"fmt"
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 	"net"
-	"net/http"
-	"sync"
 )
 
 // EnhancedHTTP3Server extends the standard HTTP/3 server with connection tracking
@@ -16,7 +17,9 @@ type EnhancedHTTP3Server struct {
 	observer *connections.QuicConnectionObserver
 }
 
-// NewEnhancedHTTP3Server creates a new HTTP/3 server with connection tracking
+// NewEnhancedHTTP3Server creates a new HTTP/3 server with connection tracking.
+// Agents are identified by the UUID header middleware like on every other listener, or by
+// their client certificate when the listener requires one.
 func NewEnhancedHTTP3Server(server *http3.Server, observer *connections.QuicConnectionObserver) *EnhancedHTTP3Server {
 	return &EnhancedHTTP3Server{
 		Server:   server,
@@ -29,28 +32,6 @@ func (s *EnhancedHTTP3Server) ServeQUICConn(conn quic.Connection) error {
 	fmt.Printf("[H3-DEBUG] ServeQUICConn called for connection from: %s\n",
 		conn.RemoteAddr().String())
 
-	// Store connection in a map with empty UUID initially
-	h3ConnectionUUIDs.Store(conn.RemoteAddr().String(), "")
-
-	// Install header extractor
-	s.Server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract UUID from headers in HTTP/3 requests
-		agentUUID := r.Header.Get("X-Agent-UUID")
-		if agentUUID != "" {
-			// We found a UUID, associate it with this QUIC connection
-			fmt.Printf("[HTTP/3] Extracted agent UUID: %s from QUIC connection\n", agentUUID)
-
-			// Update the UUID map
-			h3ConnectionUUIDs.Store(conn.RemoteAddr().String(), agentUUID)
-
-			// Update any existing tracked connections
-			// This is more complex for HTTP/3 and would need custom implementation
-		}
-
-		// Call the original handler
-		s.Server.Handler.ServeHTTP(w, r)
-	})
-
 	// Get port from listening address
 	port := "unknown"
 	if s.Server.Addr != "" {
@@ -64,9 +45,37 @@ func (s *EnhancedHTTP3Server) ServeQUICConn(conn quic.Connection) error {
 
 	fmt.Printf("[H3-SERVER-DEBUG] Observer notified, continuing with standard HTTP/3 handling\n")
 
+	// With client certificates the agent is known once the handshake completes, so wait for it
+	// rather than serve 0-RTT requests before the identity is verified
+	if s.Server.TLSConfig != nil && s.Server.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		if err := s.identify(conn); err != nil {
+			conn.CloseWithError(0, "client certificate required")
+			return err
+		}
+	}
+
 	// Continue with normal HTTP/3 handling and return its error
 	return s.Server.ServeQUICConn(conn)
 }
 
-// Add a global map to track HTTP/3 connection UUIDs
-var h3ConnectionUUIDs sync.Map
+// identify binds the connection to the agent named by its verified client certificate
+func (s *EnhancedHTTP3Server) identify(conn quic.Connection) error {
+	if early, ok := conn.(quic.EarlyConnection); ok {
+		select {
+		case <-early.HandshakeComplete():
+		case <-conn.Context().Done():
+			return fmt.Errorf("connection from %s closed during handshake", conn.RemoteAddr())
+		}
+	}
+
+	state := conn.ConnectionState().TLS
+	agentUUID := certificates.AgentIdentity(&state)
+	if agentUUID == "" {
+		return fmt.Errorf("connection from %s presented no verified client certificate", conn.RemoteAddr())
+	}
+
+	if registry := connregistry.GetConnectionRegistry(); registry != nil {
+		registry.RegisterCertifiedUUID(conn.RemoteAddr().String(), agentUUID)
+	}
+	return nil
+}
@@ -1,17 +1,19 @@
 package h3
 
 import (
+	"crypto/x509"
 	"firestarter/internal/certificates"
 	"firestarter/internal/interfaces"
+	"firestarter/internal/router"
 	"firestarter/internal/types"
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"net/http"
 )
 
 // Factory creates HTTP/3 listeners
 type Factory struct {
 	certProvider certificates.CertificateProvider
+	clientCAs    *x509.CertPool // Set when agents must present a client certificate
 }
 
 // NewFactory creates a new HTTP/3 factory with the given certificate provider
@@ -21,6 +23,12 @@ func NewFactory(certProvider certificates.CertificateProvider) *Factory {
 	}
 }
 
+// RequireClientCertificates makes listeners created from now on demand a client certificate
+// issued by one of the CAs in pool. The certificate then identifies the agent on its connection.
+func (f *Factory) RequireClientCertificates(pool *x509.CertPool) {
+	f.clientCAs = pool
+}
+
 // CreateListener implements the ListenerFactory interface
 func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
 	// Verify we have a certificate provider
@@ -33,21 +41,19 @@ func (f *Factory) CreateListener(id string, port string, connManager interfaces.
 	if err != nil {
 		return nil, fmt.Errorf("failed to get TLS configuration for HTTP/3: %w", err)
 	}
-	
+
 	// Configure for HTTP/3 (ALPN)
 	tlsConfig.NextProtos = []string{"h3", "h3-29"}
 
-	// Create a router and set up routes
-	r := chi.NewRouter()
-
-	// Import the router setup function from your existing code
-	// This ensures all HTTP/3 routes match your other protocols
-	if setupRoutes, err := importRouterSetup(); err == nil {
-		setupRoutes(r)
-	} else {
-		return nil, fmt.Errorf("failed to set up routes for HTTP/3: %w", err)
+	// The QUIC handshake verifies the certificate, the server reads the identity once it completes
+	if f.clientCAs != nil {
+		certificates.RequireClientCertificates(tlsConfig, f.clientCAs)
 	}
 
+	// Create router and set up routes, matching all other protocols
+	r := chi.NewRouter()
+	router.SetupRoutes(r, id)
+
 	// Create the HTTP/3 listener
 	listener := NewHTTP3Listener(
 		id,
@@ -65,15 +71,3 @@ func (f *Factory) CreateListener(id string, port string, connManager interfaces.
 
 	return listener, nil
 }
-
-// Helper function to import the router setup function
-// This needs to match how your project handles routes
-func importRouterSetup() (func(chi.Router), error) {
-	// In a real implementation, you would return your actual router setup function
-	// For now, we'll use this placeholder that you'll need to replace with your actual routing
-	return func(r chi.Router) {
-		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("HTTP/3 Server"))
-		})
-	}, nil
-}
@@ -64,6 +64,7 @@ func (l *HTTP3Listener) Start() error {
 	l.quicConfig = &quic.Config{
 		MaxIdleTimeout:  30 * time.Second,
 		EnableDatagrams: true,
+		Allow0RTT:       true, // Lets agents resuming a session send requests in the first flight
 	}
 
 	// Create the HTTP/3 server - Removed Versions field
@@ -92,7 +93,8 @@ func (l *HTTP3Listener) Start() error {
 
 	// MODIFICATION: Instead of using l.server.Serve, we'll create a QUIC listener
 	// and manually handle connections to ensure they pass through our enhanced server
-	quicListener, err := quic.Listen(udpConn, l.tlsConfig, l.quicConfig)
+	// An early listener is required to accept 0-RTT data from resumed sessions
+	quicListener, err := quic.ListenEarly(udpConn, l.tlsConfig, l.quicConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create QUIC listener: %w", err)
 	}
@@ -116,7 +118,7 @@ func (l *HTTP3Listener) Start() error {
 
 			// Explicitly pass the connection to our enhanced server to ensure
 			// our connection tracking hooks are called
-			go func(c quic.Connection) {
+			go func(c quic.EarlyConnection) {
 				if err := l.server.ServeQUICConn(c); err != nil {
 					fmt.Printf("HTTP/3 connection serving error: %v\n", err)
 				}
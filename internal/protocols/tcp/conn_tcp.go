@@ -0,0 +1,78 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"firestarter/internal/protocols/framed"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// tcpConn carries frames as JSON prefixed with a 4-byte big-endian length
+type tcpConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTCPConn(conn net.Conn) *tcpConn {
+	return &tcpConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func (c *tcpConn) ReadFrame(frame *framed.Frame) error {
+	var header [4]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > framed.MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the %d byte limit", size, framed.MaxFrameSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, frame)
+}
+
+func (c *tcpConn) WriteFrame(frame framed.Frame) error {
+	body, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	if len(body) > framed.MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the %d byte limit", len(body), framed.MaxFrameSize)
+	}
+
+	// Header and body go out in a single write
+	buf := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	copy(buf[4:], body)
+
+	_, err = c.conn.Write(buf)
+	return err
+}
+
+func (c *tcpConn) Ping() error {
+	return c.WriteFrame(framed.Frame{Type: framed.FramePing})
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *tcpConn) Close() error {
+	return c.conn.Close()
+}
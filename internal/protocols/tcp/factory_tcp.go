@@ -0,0 +1,23 @@
+package tcp
+
+import (
+	"firestarter/internal/interfaces"
+	"firestarter/internal/router"
+	"firestarter/internal/types"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+)
+
+// Factory creates raw TCP listeners carrying length-prefixed frames
+type Factory struct{}
+
+func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
+	// There is no HTTP on the wire, but request frames are still served by the usual routes
+	r := chi.NewRouter()
+	router.SetupRoutes(r, id)
+
+	fmt.Printf("[👂🏻LSN] -> Listener (%s) created on port %s, protocol %s\n",
+		id, port, interfaces.GetProtocolName(interfaces.TCP))
+
+	return NewTCPListener(id, port, r, connManager), nil
+}
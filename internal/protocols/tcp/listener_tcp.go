@@ -0,0 +1,179 @@
+package tcp
+
+import (
+	"errors"
+	"firestarter/internal/connregistry"
+	"firestarter/internal/interfaces"
+	"firestarter/internal/listener"
+	"firestarter/internal/maintenance"
+	"firestarter/internal/protocols/framed"
+	"firestarter/internal/scope"
+	"firestarter/internal/security"
+	"firestarter/internal/signing"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// helloTimeout is how long a new connection has to identify itself
+const helloTimeout = 10 * time.Second
+
+// Listener accepts raw TCP connections carrying length-prefixed frames.
+// Each connection must start with a hello frame carrying the agent UUID.
+type Listener struct {
+	ID          string
+	Port        string
+	Protocol    interfaces.ProtocolType
+	CreatedAt   time.Time
+	handler     http.Handler
+	connManager interfaces.ConnectionManager
+
+	netListener net.Listener
+	sessions    *framed.Sessions
+	mutex       sync.Mutex
+}
+
+// NewTCPListener creates a raw TCP listener that dispatches agent requests to handler
+func NewTCPListener(id string, port string, handler http.Handler, connManager interfaces.ConnectionManager) *Listener {
+	return &Listener{
+		ID:          id,
+		Port:        port,
+		Protocol:    interfaces.TCP,
+		CreatedAt:   time.Now(),
+		handler:     handler,
+		connManager: connManager,
+		sessions:    framed.NewSessions(),
+	}
+}
+
+// Start accepts connections until the listener is stopped
+func (l *Listener) Start() error {
+	addr := fmt.Sprintf(":%s", l.Port)
+
+	fmt.Printf("[👂🏻LSN] -> Listener (%s) serving on %s, protocol %s\n", l.ID, addr, l.GetProtocol())
+	fmt.Println()
+
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to create TCP listener: %v", err)
+	}
+
+	// Wrap with our connection tracking listener
+	trackingListener := listener.NewConnectionTrackingListener(tcpListener, l.connManager, l.Protocol, l.Port)
+
+	l.mutex.Lock()
+	l.netListener = trackingListener
+	l.mutex.Unlock()
+
+	for {
+		conn, err := trackingListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil // Stopped
+			}
+			return fmt.Errorf("failed to accept connection: %v", err)
+		}
+
+		go l.serve(conn)
+	}
+}
+
+// serve performs the hello handshake and runs the agent session
+func (l *Listener) serve(conn net.Conn) {
+	frameConn := newTCPConn(conn)
+
+	var hello framed.Frame
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := frameConn.ReadFrame(&hello); err != nil || hello.Type != framed.FrameHello || hello.AgentUUID == "" {
+		fmt.Printf("[❌ERR] -> Rejecting TCP connection from %s: no valid hello frame\n", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	// Authenticate the hello before trusting its UUID, as the signature middleware does for HTTP
+	if verifier := signing.GetVerifier(); verifier != nil {
+		if err := verifier.Verify(hello.AgentUUID, framed.FrameHello, "", hello.Signature); err != nil {
+			security.RecordRejectedRequest(hello.AgentUUID, conn.RemoteAddr().String(), "HELLO", "", err)
+			conn.Close()
+			return
+		}
+	}
+
+	// Associate the tracked connection with the agent, as the UUID header middleware does for HTTP
+	if registry := connregistry.GetConnectionRegistry(); registry != nil {
+		registry.RegisterUUIDForRemoteAddr(conn.RemoteAddr().String(), hello.AgentUUID)
+	}
+
+	// The hello never reaches the router, so turn the agent away here while in maintenance
+	conn.SetWriteDeadline(time.Now().Add(framed.WriteWait))
+	if window, active := maintenance.Active(l.ID); active {
+		frameConn.WriteFrame(framed.Frame{
+			Type:       framed.FrameWelcome,
+			Status:     http.StatusServiceUnavailable,
+			RetryAfter: maintenance.RetryAfterHeader(window.RetryAfter),
+		})
+		conn.Close()
+		return
+	}
+
+	// Likewise for agents refused for being outside the authorized scope, quarantined ones are
+	// dealt with by the router and the session
+	if authorized := scope.GetScope(); authorized != nil && !authorized.Allows(conn.RemoteAddr().String()) {
+		mode, first := authorized.RecordViolation(hello.AgentUUID, conn.RemoteAddr().String())
+		if first {
+			security.RecordOutOfScope(hello.AgentUUID, conn.RemoteAddr().String(), "HELLO", "", mode)
+		}
+		if mode == scope.ModeRefuse {
+			frameConn.WriteFrame(framed.Frame{Type: framed.FrameWelcome, Status: http.StatusForbidden})
+			conn.Close()
+			return
+		}
+	}
+
+	if err := frameConn.WriteFrame(framed.Frame{Type: framed.FrameWelcome}); err != nil {
+		conn.Close()
+		return
+	}
+
+	l.sessions.Serve(framed.NewSession(frameConn, hello.AgentUUID, conn.RemoteAddr().String(), "TCP", l.handler))
+}
+
+// Stop closes the listening socket and all open sessions
+func (l *Listener) Stop() error {
+	l.mutex.Lock()
+	netListener := l.netListener
+	l.mutex.Unlock()
+
+	if netListener == nil {
+		return fmt.Errorf("server not started")
+	}
+
+	fmt.Printf("|STOP| Shutting down listener %s on port %s\n", l.ID, l.Port)
+
+	err := netListener.Close()
+	l.sessions.CloseAll()
+	if err != nil {
+		return fmt.Errorf("error shutting down listener %s: %v", l.ID, err)
+	}
+
+	fmt.Printf("|STOP| Listener %s on port %s shut down successfully\n", l.ID, l.Port)
+	return nil
+}
+
+func (l *Listener) GetProtocol() string {
+	return "Raw TCP"
+}
+
+func (l *Listener) GetPort() string {
+	return l.Port
+}
+
+func (l *Listener) GetID() string {
+	return l.ID
+}
+
+func (l *Listener) GetCreatedAt() time.Time {
+	return l.CreatedAt
+}
@@ -0,0 +1,203 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"firestarter/internal/protocols/framed"
+	"firestarter/internal/signing"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testAgentUUID = "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b"
+
+// withVerifier installs a global verifier knowing a single agent, returning its secret
+func withVerifier(t *testing.T) []byte {
+	t.Helper()
+
+	dir := t.TempDir()
+	secret, err := signing.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	if err := signing.RegisterAgentSecret(dir, testAgentUUID, secret); err != nil {
+		t.Fatalf("RegisterAgentSecret: %v", err)
+	}
+	if err := signing.InitializeVerifier(dir, true); err != nil {
+		t.Fatalf("InitializeVerifier: %v", err)
+	}
+	t.Cleanup(func() { signing.GlobalVerifier = nil })
+	return secret
+}
+
+// servePipe runs the listener's handshake and session on one end of a pipe, returning the
+// agent's end
+func servePipe(t *testing.T, handler http.Handler) *tcpConn {
+	t.Helper()
+
+	server, client := net.Pipe()
+	l := NewTCPListener("test", "0", handler, nil)
+	go l.serve(server)
+
+	t.Cleanup(func() {
+		client.Close()
+		l.sessions.CloseAll()
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return newTCPConn(client)
+}
+
+// hello sends a hello frame and returns the answer, or the error reading it
+func hello(t *testing.T, conn *tcpConn, sig *signing.Signature) (framed.Frame, error) {
+	t.Helper()
+
+	if err := conn.WriteFrame(framed.Frame{Type: framed.FrameHello, AgentUUID: testAgentUUID, Signature: sig}); err != nil {
+		t.Fatalf("write hello: %v", err)
+	}
+	var welcome framed.Frame
+	err := conn.ReadFrame(&welcome)
+	return welcome, err
+}
+
+func signHello(t *testing.T, secret []byte, agentUUID string) *signing.Signature {
+	t.Helper()
+
+	sig, err := signing.Sign(secret, agentUUID, framed.FrameHello, "", nil)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return sig
+}
+
+func TestHelloHandshake(t *testing.T) {
+	secret := withVerifier(t)
+	other, _ := signing.GenerateSecret()
+
+	tests := []struct {
+		name    string
+		sig     *signing.Signature
+		welcome bool
+	}{
+		{"valid signature", signHello(t, secret, testAgentUUID), true},
+		{"wrong secret", signHello(t, other, testAgentUUID), false},
+		{"signed for another agent", signHello(t, secret, "another-agent"), false},
+		{"unsigned", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := servePipe(t, http.NotFoundHandler())
+
+			welcome, err := hello(t, conn, tt.sig)
+			if !tt.welcome {
+				if err == nil {
+					t.Fatalf("got a %s frame, want the connection closed", welcome.Type)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read welcome: %v", err)
+			}
+			if welcome.Type != framed.FrameWelcome || welcome.Status != 0 {
+				t.Errorf("got %s frame with status %d, want an accepting welcome", welcome.Type, welcome.Status)
+			}
+		})
+	}
+}
+
+func TestHelloReplayRefused(t *testing.T) {
+	secret := withVerifier(t)
+	sig := signHello(t, secret, testAgentUUID)
+
+	if _, err := hello(t, servePipe(t, http.NotFoundHandler()), sig); err != nil {
+		t.Fatalf("first hello: %v", err)
+	}
+	if welcome, err := hello(t, servePipe(t, http.NotFoundHandler()), sig); err == nil {
+		t.Fatalf("replayed hello got a %s frame, want the connection closed", welcome.Type)
+	}
+}
+
+func TestOversizedFrameRejected(t *testing.T) {
+	secret := withVerifier(t)
+	conn := servePipe(t, http.NotFoundHandler())
+	if _, err := hello(t, conn, signHello(t, secret, testAgentUUID)); err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+
+	// Announce a frame one byte over the limit, the session must end without reading it
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], framed.MaxFrameSize+1)
+	if _, err := conn.conn.Write(header[:]); err != nil {
+		t.Fatalf("write header: %v", err)
+	}
+
+	var frame framed.Frame
+	if err := conn.ReadFrame(&frame); err != io.EOF && err != io.ErrClosedPipe {
+		t.Fatalf("read after oversized frame = %v, want the connection closed", err)
+	}
+}
+
+func TestOversizedFrameNotWritten(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// The write must fail before anything reaches the pipe, which would block with no reader
+	conn := newTCPConn(client)
+	err := conn.WriteFrame(framed.Frame{Type: framed.FrameRequest, Payload: make([]byte, framed.MaxFrameSize)})
+	if err == nil {
+		t.Fatal("WriteFrame succeeded, want the frame refused")
+	}
+}
+
+func TestRequestResponseRoundTrip(t *testing.T) {
+	secret := withVerifier(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/echo" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Agent-UUID") != testAgentUUID {
+			http.Error(w, "missing agent UUID", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusAccepted)
+		io.Copy(w, r.Body)
+	})
+
+	conn := servePipe(t, handler)
+	if _, err := hello(t, conn, signHello(t, secret, testAgentUUID)); err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+
+	request := framed.Frame{
+		Type:     framed.FrameRequest,
+		ID:       "42",
+		Method:   http.MethodPost,
+		Endpoint: "/echo",
+		Payload:  []byte("round trip"),
+	}
+	if err := conn.WriteFrame(request); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+
+	var response framed.Frame
+	if err := conn.ReadFrame(&response); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if response.Type != framed.FrameResponse || response.ID != request.ID {
+		t.Fatalf("got %s frame %q, want the response to %q", response.Type, response.ID, request.ID)
+	}
+	if response.Status != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", response.Status, http.StatusAccepted)
+	}
+	if string(response.Payload) != "round trip" {
+		t.Errorf("payload = %q, want %q", response.Payload, "round trip")
+	}
+	if response.RetryAfter != "7" {
+		t.Errorf("retry after = %q, want %q", response.RetryAfter, "7")
+	}
+}
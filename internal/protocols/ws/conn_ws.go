@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"firestarter/internal/protocols/framed"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn carries frames as JSON WebSocket messages
+type wsConn struct {
+	conn *websocket.Conn
+}
+
+// newWSConn wraps an upgraded connection, treating pongs as proof of life
+func newWSConn(conn *websocket.Conn) *wsConn {
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(framed.PongWait))
+	})
+	return &wsConn{conn: conn}
+}
+
+func (c *wsConn) ReadFrame(frame *framed.Frame) error {
+	return c.conn.ReadJSON(frame)
+}
+
+func (c *wsConn) WriteFrame(frame framed.Frame) error {
+	return c.conn.WriteJSON(frame)
+}
+
+func (c *wsConn) Ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(framed.WriteWait))
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *wsConn) Close() error {
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(framed.WriteWait))
+	return c.conn.Close()
+}
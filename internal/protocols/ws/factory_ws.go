@@ -0,0 +1,32 @@
+package ws
+
+import (
+	"firestarter/internal/interfaces"
+	"firestarter/internal/listener"
+	"firestarter/internal/protocols/framed"
+	"firestarter/internal/router"
+	"firestarter/internal/types"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+)
+
+// Factory creates WebSocket listeners
+type Factory struct{}
+
+func (f *Factory) CreateListener(id string, port string, connManager interfaces.ConnectionManager) (types.Listener, error) {
+	r := chi.NewRouter()
+	router.SetupRoutes(r, id)
+
+	wsListener := &Listener{
+		ConcreteListener: listener.NewConcreteListener(id, port, interfaces.WS, r, connManager),
+		sessions:         framed.NewSessions(),
+	}
+
+	// Agents upgrade here, every other route stays reachable through request frames
+	r.Get(UpgradePath, wsListener.handleUpgrade)
+
+	fmt.Printf("[👂🏻LSN] -> Listener (%s) created on port %s, protocol %s\n",
+		id, port, interfaces.GetProtocolName(interfaces.WS))
+
+	return wsListener, nil
+}
@@ -0,0 +1,51 @@
+package ws
+
+import (
+	"firestarter/internal/listener"
+	"firestarter/internal/protocols/framed"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// UpgradePath is the endpoint agents upgrade to WebSocket on
+const UpgradePath = "/ws"
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// Listener is an HTTP/1.1 listener whose agents upgrade to a persistent WebSocket.
+// Upgraded connections are hijacked from the HTTP server, so the listener keeps
+// track of its sessions itself in order to close them on Stop.
+type Listener struct {
+	*listener.ConcreteListener
+	sessions *framed.Sessions
+}
+
+// handleUpgrade upgrades an agent request and serves the session until it ends
+func (l *Listener) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	agentUUID := r.Header.Get("X-Agent-UUID")
+	if agentUUID == "" {
+		http.Error(w, "missing agent UUID", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Failed to upgrade agent %s to WebSocket: %v\n", agentUUID, err)
+		return
+	}
+	// gorilla buffers whole messages, so bound them to what any framed transport accepts
+	conn.SetReadLimit(framed.MaxFrameSize)
+
+	l.sessions.Serve(framed.NewSession(newWSConn(conn), agentUUID, r.RemoteAddr, "WebSocket", l.Router))
+}
+
+// Stop closes all open sessions and shuts down the HTTP server
+func (l *Listener) Stop() error {
+	l.sessions.CloseAll()
+	return l.ConcreteListener.Stop()
+}
@@ -0,0 +1,147 @@
+// Package recon describes what the agent's built-in host information tasks report. Their outputs
+// are typed JSON rather than free text, so the server can lay them out as tables for the UI and
+// tell what changed between two runs of the same task, see Tabulate and Diff.
+package recon
+
+import "time"
+
+// Read-only host information task types, built into Linux agents
+const (
+	TaskSystem    = "sysinfo"   // Hostname, kernel and distribution, reported as System
+	TaskUsers     = "users"     // Local users and groups, reported as Accounts
+	TaskProcesses = "processes" // Running processes, reported as Processes
+	TaskNetwork   = "network"   // Network interfaces and routes, reported as Network
+	TaskSockets   = "sockets"   // Listening sockets, reported as Sockets
+	TaskListing   = "ls"        // A directory's entries, reported as Listing
+)
+
+// MaxEntries bounds how many entries a directory listing reports
+const MaxEntries = 10000
+
+// System describes the host and its kernel
+type System struct {
+	Hostname     string `json:"hostname"`
+	Domain       string `json:"domain,omitempty"`       // NIS domain, "(none)" is left out
+	Kernel       string `json:"kernel"`                 // e.g. Linux
+	Release      string `json:"release"`                // e.g. 6.1.0-18-amd64
+	Version      string `json:"version"`                // Build string, e.g. #1 SMP PREEMPT_DYNAMIC ...
+	Distribution string `json:"distribution,omitempty"` // PRETTY_NAME from os-release
+	Arch         string `json:"arch"`
+	MachineID    string `json:"machineId,omitempty"`
+	CPUs         int    `json:"cpus"`
+	MemoryBytes  int64  `json:"memoryBytes"`
+
+	BootTime      time.Time `json:"bootTime"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+}
+
+// User is a local account from the password database
+type User struct {
+	Name  string `json:"name"`
+	UID   int    `json:"uid"`
+	GID   int    `json:"gid"` // Primary group
+	Gecos string `json:"gecos,omitempty"`
+	Home  string `json:"home"`
+	Shell string `json:"shell"`
+}
+
+// Group is a local group from the group database
+type Group struct {
+	Name    string   `json:"name"`
+	GID     int      `json:"gid"`
+	Members []string `json:"members"` // Supplementary members only
+}
+
+// Accounts lists the host's local users and groups
+type Accounts struct {
+	Users  []User  `json:"users"`
+	Groups []Group `json:"groups"`
+}
+
+// Process is a running process as seen in /proc
+type Process struct {
+	PID        int    `json:"pid"`
+	PPID       int    `json:"ppid"`
+	UID        int    `json:"uid"`
+	User       string `json:"user,omitempty"`
+	Name       string `json:"name"`
+	State      string `json:"state"` // e.g. R, S, D, Z
+	Threads    int    `json:"threads"`
+	RSSBytes   int64  `json:"rssBytes"`
+	Executable string `json:"executable,omitempty"` // Empty when not readable by the agent
+	Command    string `json:"command,omitempty"`    // Empty for kernel threads
+
+	StartTime time.Time `json:"startTime"`
+}
+
+// Processes lists the running processes, by PID
+type Processes struct {
+	Processes []Process `json:"processes"`
+}
+
+// Interface is a network interface and the addresses assigned to it
+type Interface struct {
+	Name      string   `json:"name"`
+	Index     int      `json:"index"`
+	MAC       string   `json:"mac,omitempty"`
+	MTU       int      `json:"mtu"`
+	Flags     []string `json:"flags"`
+	Addresses []string `json:"addresses"` // CIDR notation
+}
+
+// Route is an entry of the kernel's main routing table
+type Route struct {
+	Interface   string `json:"interface"`
+	Destination string `json:"destination"` // CIDR notation
+	Gateway     string `json:"gateway,omitempty"`
+	Metric      int    `json:"metric"`
+	Flags       string `json:"flags"` // U, G and H as shown by route(8)
+}
+
+// Network lists the host's interfaces and routes, IPv4 and IPv6
+type Network struct {
+	Interfaces []Interface `json:"interfaces"`
+	Routes     []Route     `json:"routes"`
+}
+
+// Socket is a socket accepting traffic: a listening TCP socket or a bound, unconnected UDP one
+type Socket struct {
+	Protocol string `json:"protocol"` // tcp, tcp6, udp or udp6
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	UID      int    `json:"uid"`
+	Inode    uint64 `json:"inode"`
+	PID      int    `json:"pid,omitempty"`     // 0 when the owning process isn't visible to the agent
+	Process  string `json:"process,omitempty"` // Name of the owning process
+}
+
+// Sockets lists the host's listening sockets
+type Sockets struct {
+	Sockets []Socket `json:"sockets"`
+}
+
+// ListingArgs are the arguments of a directory listing task
+type ListingArgs struct {
+	Path string `json:"path"`
+}
+
+// Entry is a directory entry and its metadata, symbolic links are not followed
+type Entry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // file, dir, symlink, device, pipe, socket or other
+	Mode    string    `json:"mode"` // e.g. -rwxr-xr-x
+	Size    int64     `json:"size"`
+	UID     int       `json:"uid"`
+	GID     int       `json:"gid"`
+	Owner   string    `json:"owner,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	ModTime time.Time `json:"modTime"`
+	Target  string    `json:"target,omitempty"` // Of a symbolic link
+}
+
+// Listing lists a directory's entries by name
+type Listing struct {
+	Path      string  `json:"path"` // Absolute
+	Entries   []Entry `json:"entries"`
+	Truncated bool    `json:"truncated,omitempty"` // Set when the directory has over MaxEntries
+}
@@ -0,0 +1,355 @@
+package recon
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change is how a row differs from the run it is compared with
+type Change string
+
+// Row changes, rows left alone by a diff have none
+const (
+	ChangeAdded   Change = "added"
+	ChangeRemoved Change = "removed"
+	ChangeChanged Change = "changed"
+)
+
+// Column heads a table column
+type Column struct {
+	Name string `json:"name"`
+
+	// Key columns identify a row between runs, together. Volatile columns change from one run to
+	// the next by nature, a process's memory for instance, and are left out of diffs.
+	Key      bool `json:"key,omitempty"`
+	Volatile bool `json:"volatile,omitempty"`
+}
+
+// Row is a table row, one cell per column
+type Row struct {
+	Cells  []string `json:"cells"`
+	Change Change   `json:"change,omitempty"`
+	Before []string `json:"before,omitempty"` // The row's cells in the earlier run, when changed
+}
+
+// Table is part of a task's output laid out for display
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+	Rows    []Row    `json:"rows"`
+	Note    string   `json:"note,omitempty"` // Shown with the table, e.g. when it was cut short
+}
+
+// Report is a task's output as tables, compared with an earlier run of the same task when
+// Against is set
+type Report struct {
+	TaskID  string  `json:"taskId"`
+	Against string  `json:"against,omitempty"`
+	Tables  []Table `json:"tables,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Supported reports whether the output of a task type can be laid out as tables
+func Supported(taskType string) bool {
+	switch taskType {
+	case TaskSystem, TaskUsers, TaskProcesses, TaskNetwork, TaskSockets, TaskListing:
+		return true
+	}
+	return false
+}
+
+// Tabulate lays out the output of a host information task as tables
+func Tabulate(taskType string, output []byte) ([]Table, error) {
+	switch taskType {
+	case TaskSystem:
+		var system System
+		if err := json.Unmarshal(output, &system); err != nil {
+			return nil, fmt.Errorf("invalid %s output: %w", taskType, err)
+		}
+		return []Table{system.table()}, nil
+	case TaskUsers:
+		var accounts Accounts
+		if err := json.Unmarshal(output, &accounts); err != nil {
+			return nil, fmt.Errorf("invalid %s output: %w", taskType, err)
+		}
+		return accounts.tables(), nil
+	case TaskProcesses:
+		var processes Processes
+		if err := json.Unmarshal(output, &processes); err != nil {
+			return nil, fmt.Errorf("invalid %s output: %w", taskType, err)
+		}
+		return []Table{processes.table()}, nil
+	case TaskNetwork:
+		var network Network
+		if err := json.Unmarshal(output, &network); err != nil {
+			return nil, fmt.Errorf("invalid %s output: %w", taskType, err)
+		}
+		return network.tables(), nil
+	case TaskSockets:
+		var sockets Sockets
+		if err := json.Unmarshal(output, &sockets); err != nil {
+			return nil, fmt.Errorf("invalid %s output: %w", taskType, err)
+		}
+		return []Table{sockets.table()}, nil
+	case TaskListing:
+		var listing Listing
+		if err := json.Unmarshal(output, &listing); err != nil {
+			return nil, fmt.Errorf("invalid %s output: %w", taskType, err)
+		}
+		return []Table{listing.table()}, nil
+	default:
+		return nil, fmt.Errorf("task type '%s' has no tabular output", taskType)
+	}
+}
+
+// Diff marks the rows of a run's tables that were added or changed since an earlier run of the
+// same task, and adds the rows that were removed since. Rows are matched by their key columns,
+// changes to volatile columns are ignored.
+func Diff(before []Table, after []Table) []Table {
+	result := make([]Table, 0, len(after))
+	for _, table := range after {
+		var earlier *Table
+		for i := range before {
+			if before[i].Name == table.Name {
+				earlier = &before[i]
+				break
+			}
+		}
+		if earlier == nil {
+			result = append(result, table)
+			continue
+		}
+		result = append(result, diffTable(*earlier, table))
+	}
+	return result
+}
+
+// diffTable compares two runs of a table with the same columns
+func diffTable(before Table, after Table) Table {
+	// Several rows may share a key, they are matched in order
+	earlier := make(map[string][]Row)
+	for _, row := range before.Rows {
+		key := rowKey(after.Columns, row.Cells)
+		earlier[key] = append(earlier[key], row)
+	}
+
+	diffed := Table{Name: after.Name, Columns: after.Columns, Rows: make([]Row, 0, len(after.Rows)), Note: after.Note}
+	for _, row := range after.Rows {
+		key := rowKey(after.Columns, row.Cells)
+		matches := earlier[key]
+		if len(matches) == 0 {
+			diffed.Rows = append(diffed.Rows, Row{Cells: row.Cells, Change: ChangeAdded})
+			continue
+		}
+		match := matches[0]
+		earlier[key] = matches[1:]
+
+		if sameRow(after.Columns, match.Cells, row.Cells) {
+			diffed.Rows = append(diffed.Rows, Row{Cells: row.Cells})
+		} else {
+			diffed.Rows = append(diffed.Rows, Row{Cells: row.Cells, Change: ChangeChanged, Before: match.Cells})
+		}
+	}
+
+	// Whatever was not matched is gone, listed in its earlier order
+	for _, row := range before.Rows {
+		key := rowKey(after.Columns, row.Cells)
+		if len(earlier[key]) > 0 {
+			diffed.Rows = append(diffed.Rows, Row{Cells: earlier[key][0].Cells, Change: ChangeRemoved})
+			earlier[key] = earlier[key][1:]
+		}
+	}
+	return diffed
+}
+
+// rowKey joins the cells of a row's key columns
+func rowKey(columns []Column, cells []string) string {
+	var key strings.Builder
+	for i, column := range columns {
+		if column.Key && i < len(cells) {
+			key.WriteString(cells[i])
+			key.WriteByte(0)
+		}
+	}
+	return key.String()
+}
+
+// sameRow reports whether two rows agree on every column that isn't volatile
+func sameRow(columns []Column, before []string, after []string) bool {
+	if len(before) != len(after) {
+		return false
+	}
+	for i, column := range columns {
+		if !column.Volatile && i < len(after) && before[i] != after[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s System) table() Table {
+	fields := [][2]string{
+		{"Hostname", s.Hostname},
+		{"Domain", s.Domain},
+		{"Kernel", s.Kernel},
+		{"Release", s.Release},
+		{"Version", s.Version},
+		{"Distribution", s.Distribution},
+		{"Architecture", s.Arch},
+		{"Machine ID", s.MachineID},
+		{"CPUs", strconv.Itoa(s.CPUs)},
+		{"Memory (bytes)", strconv.FormatInt(s.MemoryBytes, 10)},
+		{"Boot time", formatTime(s.BootTime)}, // Rather than the uptime, which changes every run
+	}
+
+	table := Table{
+		Name:    "System",
+		Columns: []Column{{Name: "Field", Key: true}, {Name: "Value"}},
+	}
+	for _, field := range fields {
+		table.Rows = append(table.Rows, Row{Cells: []string{field[0], field[1]}})
+	}
+	return table
+}
+
+func (a Accounts) tables() []Table {
+	users := Table{
+		Name: "Users",
+		Columns: []Column{
+			{Name: "Name", Key: true}, {Name: "UID"}, {Name: "GID"}, {Name: "GECOS"}, {Name: "Home"}, {Name: "Shell"},
+		},
+	}
+	for _, user := range a.Users {
+		users.Rows = append(users.Rows, Row{Cells: []string{
+			user.Name, strconv.Itoa(user.UID), strconv.Itoa(user.GID), user.Gecos, user.Home, user.Shell,
+		}})
+	}
+
+	groups := Table{
+		Name:    "Groups",
+		Columns: []Column{{Name: "Name", Key: true}, {Name: "GID"}, {Name: "Members"}},
+	}
+	for _, group := range a.Groups {
+		groups.Rows = append(groups.Rows, Row{Cells: []string{
+			group.Name, strconv.Itoa(group.GID), strings.Join(group.Members, ", "),
+		}})
+	}
+	return []Table{users, groups}
+}
+
+func (p Processes) table() Table {
+	table := Table{
+		Name: "Processes",
+		Columns: []Column{
+			// A PID reused by a new process shows as a change of its start time
+			{Name: "PID", Key: true}, {Name: "PPID"}, {Name: "User"}, {Name: "Name"},
+			{Name: "State", Volatile: true}, {Name: "Threads", Volatile: true}, {Name: "RSS (bytes)", Volatile: true},
+			{Name: "Started"}, {Name: "Executable"}, {Name: "Command"},
+		},
+	}
+	for _, process := range p.Processes {
+		user := process.User
+		if user == "" {
+			user = strconv.Itoa(process.UID)
+		}
+		table.Rows = append(table.Rows, Row{Cells: []string{
+			strconv.Itoa(process.PID), strconv.Itoa(process.PPID), user, process.Name,
+			process.State, strconv.Itoa(process.Threads), strconv.FormatInt(process.RSSBytes, 10),
+			formatTime(process.StartTime), process.Executable, process.Command,
+		}})
+	}
+	return table
+}
+
+func (n Network) tables() []Table {
+	interfaces := Table{
+		Name: "Interfaces",
+		Columns: []Column{
+			{Name: "Name", Key: true}, {Name: "Index"}, {Name: "MAC"}, {Name: "MTU"}, {Name: "Flags"}, {Name: "Addresses"},
+		},
+	}
+	for _, iface := range n.Interfaces {
+		interfaces.Rows = append(interfaces.Rows, Row{Cells: []string{
+			iface.Name, strconv.Itoa(iface.Index), iface.MAC, strconv.Itoa(iface.MTU),
+			strings.Join(iface.Flags, ", "), strings.Join(iface.Addresses, ", "),
+		}})
+	}
+
+	routes := Table{
+		Name: "Routes",
+		Columns: []Column{
+			{Name: "Destination", Key: true}, {Name: "Gateway", Key: true}, {Name: "Interface", Key: true},
+			{Name: "Metric", Key: true}, {Name: "Flags"},
+		},
+	}
+	for _, route := range n.Routes {
+		routes.Rows = append(routes.Rows, Row{Cells: []string{
+			route.Destination, route.Gateway, route.Interface, strconv.Itoa(route.Metric), route.Flags,
+		}})
+	}
+	return []Table{interfaces, routes}
+}
+
+func (s Sockets) table() Table {
+	table := Table{
+		Name: "Listening sockets",
+		Columns: []Column{
+			{Name: "Protocol", Key: true}, {Name: "Address", Key: true}, {Name: "Port", Key: true},
+			{Name: "UID"}, {Name: "PID"}, {Name: "Process"}, {Name: "Inode", Volatile: true},
+		},
+	}
+	for _, socket := range s.Sockets {
+		pid := ""
+		if socket.PID != 0 {
+			pid = strconv.Itoa(socket.PID)
+		}
+		table.Rows = append(table.Rows, Row{Cells: []string{
+			socket.Protocol, socket.Address, strconv.Itoa(socket.Port),
+			strconv.Itoa(socket.UID), pid, socket.Process, strconv.FormatUint(socket.Inode, 10),
+		}})
+	}
+	return table
+}
+
+func (l Listing) table() Table {
+	table := Table{
+		Name: l.Path,
+		Columns: []Column{
+			{Name: "Name", Key: true}, {Name: "Type"}, {Name: "Mode"}, {Name: "Size"},
+			{Name: "Owner"}, {Name: "Group"}, {Name: "Modified"}, {Name: "Target"},
+		},
+	}
+
+	if l.Truncated {
+		table.Note = fmt.Sprintf("Only the first %d entries were listed", MaxEntries)
+	}
+
+	entries := append([]Entry(nil), l.Entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for _, entry := range entries {
+		owner, group := entry.Owner, entry.Group
+		if owner == "" {
+			owner = strconv.Itoa(entry.UID)
+		}
+		if group == "" {
+			group = strconv.Itoa(entry.GID)
+		}
+		table.Rows = append(table.Rows, Row{Cells: []string{
+			entry.Name, entry.Type, entry.Mode, strconv.FormatInt(entry.Size, 10),
+			owner, group, formatTime(entry.ModTime), entry.Target,
+		}})
+	}
+	return table
+}
+
+// formatTime formats a time for a table cell, in UTC so runs compare equal wherever they ran
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
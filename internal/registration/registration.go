@@ -0,0 +1,88 @@
+// Package registration carries the host metadata an agent reports when it connects, and keeps
+// what the server learned about each agent so the UI can tell them apart by more than a UUID.
+package registration
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Limits on a registration, so an agent can't make the server hold arbitrary amounts of data
+const (
+	maxInterfaces = 64
+	maxAddresses  = 32 // Per interface
+	maxFieldLen   = 1024
+)
+
+// Interface is a network interface of the agent's host and the addresses assigned to it
+type Interface struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac,omitempty"`
+	Addresses []string `json:"addresses"` // CIDR notation
+}
+
+// HostInfo describes the host and process an agent runs as
+type HostInfo struct {
+	Hostname      string      `json:"hostname"`
+	OS            string      `json:"os"`
+	Arch          string      `json:"arch"`
+	Username      string      `json:"username"`
+	PID           int         `json:"pid"`
+	ProcessPath   string      `json:"processPath"`
+	Interfaces    []Interface `json:"interfaces"`
+	BuildTime     string      `json:"buildTime,omitempty"`
+	BuildProtocol string      `json:"buildProtocol,omitempty"`
+	KillDate      string      `json:"killDate,omitempty"` // RFC 3339, when the agent stops for good
+}
+
+// Marshal encodes host info as the payload of a register message
+func (h HostInfo) Marshal() ([]byte, error) {
+	return json.Marshal(h)
+}
+
+// Unmarshal decodes the payload of a register message, refusing one that exceeds the limits
+func Unmarshal(payload []byte) (HostInfo, error) {
+	var info HostInfo
+	if err := json.Unmarshal(payload, &info); err != nil {
+		return HostInfo{}, fmt.Errorf("invalid registration: %w", err)
+	}
+	if err := info.validate(); err != nil {
+		return HostInfo{}, fmt.Errorf("invalid registration: %w", err)
+	}
+	return info, nil
+}
+
+// validate checks the limits of host info received from an agent
+func (h HostInfo) validate() error {
+	for _, field := range []string{h.Hostname, h.OS, h.Arch, h.Username, h.ProcessPath, h.BuildTime, h.BuildProtocol, h.KillDate} {
+		if len(field) > maxFieldLen {
+			return fmt.Errorf("field exceeds %d bytes", maxFieldLen)
+		}
+	}
+	if len(h.Interfaces) > maxInterfaces {
+		return fmt.Errorf("%d interfaces reported, at most %d allowed", len(h.Interfaces), maxInterfaces)
+	}
+	for _, iface := range h.Interfaces {
+		if len(iface.Name) > maxFieldLen || len(iface.MAC) > maxFieldLen {
+			return fmt.Errorf("interface field exceeds %d bytes", maxFieldLen)
+		}
+		if len(iface.Addresses) > maxAddresses {
+			return fmt.Errorf("interface %s reports %d addresses, at most %d allowed", iface.Name, len(iface.Addresses), maxAddresses)
+		}
+		for _, address := range iface.Addresses {
+			if len(address) > maxFieldLen {
+				return fmt.Errorf("interface address exceeds %d bytes", maxFieldLen)
+			}
+		}
+	}
+	return nil
+}
+
+// Registration is what the server knows about an agent from its latest registration
+type Registration struct {
+	AgentUUID    string    `json:"agentUUID"`
+	Host         HostInfo  `json:"host"`
+	RemoteAddr   string    `json:"remoteAddr"` // Address the registration arrived from
+	RegisteredAt time.Time `json:"registeredAt"`
+}
@@ -0,0 +1,75 @@
+package registration
+
+import (
+	"sync"
+	"time"
+)
+
+// Global registry instance
+var GlobalRegistry *Registry
+
+// Registry keeps the latest registration of every agent and hands new ones to a notifier
+type Registry struct {
+	agents   map[string]Registration // Agent UUID : latest registration
+	notifier func(Registration)
+	mutex    sync.RWMutex
+}
+
+// InitializeRegistry sets up the global registry
+func InitializeRegistry() {
+	GlobalRegistry = &Registry{
+		agents: make(map[string]Registration),
+	}
+}
+
+// GetRegistry returns the global registry instance
+func GetRegistry() *Registry {
+	return GlobalRegistry
+}
+
+// SetNotifier sets the function new registrations are passed to, e.g. to broadcast them to the UI
+func (r *Registry) SetNotifier(notifier func(Registration)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.notifier = notifier
+}
+
+// Register stores the host info an agent reported, replacing what it reported before
+func (r *Registry) Register(agentUUID string, host HostInfo, remoteAddr string) Registration {
+	registration := Registration{
+		AgentUUID:    agentUUID,
+		Host:         host,
+		RemoteAddr:   remoteAddr,
+		RegisteredAt: time.Now(),
+	}
+
+	r.mutex.Lock()
+	r.agents[agentUUID] = registration
+	notifier := r.notifier
+	r.mutex.Unlock()
+
+	if notifier != nil {
+		notifier(registration)
+	}
+	return registration
+}
+
+// Get returns the latest registration of an agent
+func (r *Registry) Get(agentUUID string) (Registration, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	registration, exists := r.agents[agentUUID]
+	return registration, exists
+}
+
+// Snapshot returns the latest registration of every agent
+func (r *Registry) Snapshot() []Registration {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	snapshot := make([]Registration, 0, len(r.agents))
+	for _, registration := range r.agents {
+		snapshot = append(snapshot, registration)
+	}
+	return snapshot
+}
@@ -0,0 +1,134 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"firestarter/internal/checkin"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultCheckInHold is how long a check-in is held open when the agent doesn't ask for a duration
+	DefaultCheckInHold = 30 * time.Second
+
+	// MaxCheckInHold caps the hold duration an agent may request
+	MaxCheckInHold = 5 * time.Minute
+)
+
+// CheckInHandler holds an agent's check-in open until work is available or the hold expires.
+//
+// Work is written as newline-delimited JSON. HTTP/1.1 check-ins return as soon as the first
+// batch of work is written, while HTTP/2 and HTTP/3 check-ins keep the stream open and keep
+// streaming work until the hold expires. A hold that expires without work on HTTP/1.1 is
+// answered with 204 No Content.
+func CheckInHandler(w http.ResponseWriter, r *http.Request) {
+	agentUUID := r.Header.Get("X-Agent-UUID")
+	if agentUUID == "" {
+		http.Error(w, "missing agent UUID", http.StatusBadRequest)
+		return
+	}
+
+	// Quarantined agents are kept waiting, but never handed work
+	if Quarantined(r) {
+		holdQuarantined(w, r)
+		return
+	}
+
+	hub := checkin.GetCheckInHub()
+	if hub == nil {
+		http.Error(w, "check-in not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	hold := DefaultCheckInHold
+	if requested, err := strconv.Atoi(r.Header.Get("X-Poll-Timeout")); err == nil && requested > 0 {
+		hold = time.Duration(requested) * time.Second
+	}
+	if hold > MaxCheckInHold {
+		hold = MaxCheckInHold
+	}
+
+	flusher, canFlush := w.(http.Flusher)
+	held := r.ProtoMajor >= 2 && canFlush
+
+	ctx, cancel := context.WithTimeout(r.Context(), hold)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if held {
+		// Commit the response so the agent knows the stream is established
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+	delivered := 0
+
+	for {
+		work := hub.Wait(ctx, agentUUID)
+		if len(work) == 0 {
+			break
+		}
+
+		// Only work actually encoded counts, a batch whose items were all dropped delivers nothing
+		sent := 0
+		for _, item := range work {
+			sealed, err := item.ForAgent(agentUUID)
+			if err != nil {
+				// Never fall back to delivering the payload in the clear
+				fmt.Printf("[❌ERR] -> Dropping work %s for agent %s: %v\n", item.ID, agentUUID, err)
+				continue
+			}
+			if delivered == 0 && sent == 0 && !held {
+				w.WriteHeader(http.StatusOK)
+			}
+			if err := encoder.Encode(sealed); err != nil {
+				fmt.Printf("[❌ERR] -> Failed to deliver work %s to agent %s: %v\n", item.ID, agentUUID, err)
+				return
+			}
+			sent++
+		}
+		if sent == 0 {
+			if !held {
+				break
+			}
+			continue
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+
+		delivered += sent
+		fmt.Printf("[📥CHK] -> Delivered %d work item(s) to agent %s\n", sent, agentUUID)
+
+		if !held {
+			return
+		}
+	}
+
+	if delivered == 0 && !held {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// holdQuarantined keeps a quarantined agent's check-in open as if no work was available, so it
+// neither learns it was quarantined nor reconnects in a hurry
+func holdQuarantined(w http.ResponseWriter, r *http.Request) {
+	hold := DefaultCheckInHold
+	if requested, err := strconv.Atoi(r.Header.Get("X-Poll-Timeout")); err == nil && requested > 0 && time.Duration(requested)*time.Second < hold {
+		hold = time.Duration(requested) * time.Second
+	}
+
+	select {
+	case <-time.After(hold):
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
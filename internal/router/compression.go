@@ -0,0 +1,247 @@
+package router
+
+import (
+	"firestarter/internal/compression"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CompressionMiddleware negotiates payload compression with agents. Request bodies sent with a
+// Content-Encoding are decompressed before handlers read them, and responses are compressed
+// with the first codec the agent's Accept-Encoding lists once they reach the threshold. Every
+// agent body is counted before and after compression, so the UI can show what is saved.
+func CompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgraded connections carry their own framing, and hijacking needs the original writer
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		agentUUID := r.Header.Get("X-Agent-UUID")
+
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == compression.Identity {
+			encoding = ""
+		}
+		if encoding != "" && !compression.IsSupported(encoding) {
+			http.Error(w, fmt.Sprintf("unsupported content encoding '%s'", encoding), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body := &decompressingBody{wire: &countingReader{reader: r.Body}, encoding: encoding, original: r.Body}
+		r.Body = body
+		if encoding != "" {
+			// Handlers see the body as the agent meant it
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		}
+
+		writer := newCompressingResponseWriter(w, compression.Negotiate(r.Header.Get("Accept-Encoding")))
+		next.ServeHTTP(writer, r)
+		if err := writer.finish(); err != nil {
+			fmt.Printf("[❌ERR] -> Failed to finish compressed response to agent %s: %v\n", agentUUID, err)
+		}
+
+		compression.RecordTraffic(agentUUID, body.raw, body.wire.count, encoding)
+		compression.RecordTraffic(agentUUID, writer.raw, writer.wire.count, writer.encoding)
+	})
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.count += int64(n)
+	return n, err
+}
+
+// decompressingBody is a request body decoded as it is read. The decompressor is only created
+// on the first read, since it reads the codec's header and a streamed body may not have
+// arrived yet when the middleware runs.
+type decompressingBody struct {
+	wire     *countingReader // The body as it came over the wire
+	encoding string          // Empty when the body is not compressed
+	original io.Closer
+
+	decoded io.ReadCloser
+	err     error
+	raw     int64 // Decoded bytes handed to the handler
+}
+
+func (b *decompressingBody) Read(p []byte) (int, error) {
+	if b.encoding == "" {
+		n, err := b.wire.Read(p)
+		b.raw += int64(n)
+		return n, err
+	}
+
+	if b.decoded == nil && b.err == nil {
+		b.decoded, b.err = compression.NewReader(b.encoding, b.wire)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.decoded.Read(p)
+	b.raw += int64(n)
+	return n, err
+}
+
+func (b *decompressingBody) Close() error {
+	if b.decoded != nil {
+		b.decoded.Close()
+	}
+	return b.original.Close()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.writer.Write(b)
+	w.count += int64(n)
+	return n, err
+}
+
+// compressingResponseWriter holds a response back until it is known whether it is worth
+// compressing: as soon as the body reaches the threshold it is compressed with the negotiated
+// codec, while a response that ends below it is sent as is. A response flushed before any
+// body is written is a stream of unknown length and is compressed from the start.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	codec     string // Negotiated with the agent, Identity when it accepts none
+	threshold int
+
+	status  int
+	buffer  []byte
+	decided bool
+
+	encoding string             // Codec the response is sent with, once decided
+	encoder  compression.Writer // Nil when sent as is
+	wire     *countingWriter    // Bytes written to the agent
+	raw      int64              // Bytes written by the handler
+}
+
+func newCompressingResponseWriter(w http.ResponseWriter, codec string) *compressingResponseWriter {
+	return &compressingResponseWriter{
+		ResponseWriter: w,
+		codec:          codec,
+		threshold:      compression.DefaultThreshold,
+		wire:           &countingWriter{writer: w},
+	}
+}
+
+func (w *compressingResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressingResponseWriter) Write(b []byte) (int, error) {
+	w.raw += int64(len(b))
+
+	if !w.decided {
+		w.buffer = append(w.buffer, b...)
+		if len(w.buffer) >= w.threshold {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.wire.Write(b)
+}
+
+// Flush sends what has been written so far, letting handlers stream progressively
+func (w *compressingResponseWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(len(w.buffer) == 0 || len(w.buffer) >= w.threshold); err != nil {
+			return
+		}
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide commits the response headers, compressed or not, then writes what was held back
+func (w *compressingResponseWriter) decide(compress bool) error {
+	w.decided = true
+	w.encoding = compression.Identity
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := w.Header()
+	bodyAllowed := status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+	if compress && bodyAllowed && compression.IsSupported(w.codec) && header.Get("Content-Encoding") == "" {
+		encoder, err := compression.NewWriter(w.codec, w.wire)
+		if err != nil {
+			return err
+		}
+		w.encoder = encoder
+		w.encoding = w.codec
+
+		header.Set("Content-Encoding", w.codec)
+		header.Del("Content-Length")
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if w.status != 0 || len(w.buffer) > 0 {
+		w.ResponseWriter.WriteHeader(status)
+	}
+
+	buffered := w.buffer
+	w.buffer = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buffered)
+	} else {
+		_, err = w.wire.Write(buffered)
+	}
+	return err
+}
+
+// finish sends a response that stayed below the threshold, or completes the compressed stream
+func (w *compressingResponseWriter) finish() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+	return nil
+}
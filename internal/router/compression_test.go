@@ -0,0 +1,173 @@
+package router
+
+import (
+	"bytes"
+	"firestarter/internal/compression"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveCompressed starts a server running handler behind the compression middleware
+func serveCompressed(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(CompressionMiddleware(handler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fetch sends a request and returns the response with its body as received, never decoded
+func fetch(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return resp, body
+}
+
+func TestCompressingResponseWriter(t *testing.T) {
+	small := []byte("pong")
+	large := bytes.Repeat([]byte("queued task output "), 512)
+
+	tests := []struct {
+		name           string
+		body           []byte
+		status         int
+		acceptEncoding string
+		wantEncoding   string // Empty when sent as is
+	}{
+		{"small body stays identity", small, http.StatusOK, "gzip, deflate", ""},
+		{"large body gzip", large, http.StatusOK, "gzip, deflate", compression.Gzip},
+		{"large body deflate", large, http.StatusOK, "deflate, gzip", compression.Deflate},
+		{"gzip refused with q=0", large, http.StatusOK, "gzip;q=0, deflate", compression.Deflate},
+		{"agent accepts no codec", large, http.StatusOK, "", ""},
+		{"agent accepts unknown codec", large, http.StatusOK, "br", ""},
+		{"error status compressed too", large, http.StatusNotFound, "gzip", compression.Gzip},
+		{"threshold exactly", large[:compression.DefaultThreshold], http.StatusOK, "gzip", compression.Gzip},
+		{"below threshold", large[:compression.DefaultThreshold-1], http.StatusOK, "gzip", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := serveCompressed(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				// Written in pieces, so the threshold is crossed midway
+				for rest := tt.body; len(rest) > 0; {
+					n := min(len(rest), 100)
+					w.Write(rest[:n])
+					rest = rest[n:]
+				}
+			})
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			resp, body := fetch(t, req)
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+
+			if tt.wantEncoding != "" {
+				if len(body) >= len(tt.body) {
+					t.Errorf("compressed body is %d bytes, not smaller than the %d sent", len(body), len(tt.body))
+				}
+				decoded, err := compression.Decompress(tt.wantEncoding, body, int64(len(tt.body))+1)
+				if err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				body = decoded
+			}
+			if !bytes.Equal(body, tt.body) {
+				t.Errorf("response body is %d bytes, want the %d written", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareDecodesRequests(t *testing.T) {
+	sent := bytes.Repeat([]byte("task result "), 512)
+
+	server := serveCompressed(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Errorf("handler sees Content-Encoding %q, want it removed", r.Header.Get("Content-Encoding"))
+		}
+		received, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !bytes.Equal(received, sent) {
+			http.Error(w, "body differs", http.StatusBadRequest)
+		}
+	})
+
+	for _, codec := range []string{compression.Gzip, compression.Deflate} {
+		t.Run(codec, func(t *testing.T) {
+			compressed, used, err := compression.Compress(codec, 0, sent)
+			if err != nil || used != codec {
+				t.Fatalf("Compress = %s, %v", used, err)
+			}
+
+			req, _ := http.NewRequest("POST", server.URL, bytes.NewReader(compressed))
+			req.Header.Set("Content-Encoding", codec)
+			if resp, body := fetch(t, req); resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d (%s), want %d", resp.StatusCode, strings.TrimSpace(string(body)), http.StatusOK)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", server.URL, bytes.NewReader(sent))
+		req.Header.Set("Content-Encoding", "br")
+		if resp, _ := fetch(t, req); resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+		}
+	})
+}
+
+func TestCompressionMiddlewarePassesUpgrades(t *testing.T) {
+	server := serveCompressed(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, wrapped := w.(*compressingResponseWriter); wrapped {
+			t.Error("upgrade request got the compressing writer")
+		}
+
+		conn, buffered, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack the upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		buffered.WriteString(strings.Repeat("framed ", 512))
+		buffered.Flush()
+	})
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, body := fetch(t, req)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if string(body) != strings.Repeat("framed ", 512) {
+		t.Errorf("upgraded connection carried %d bytes, want the %d written as is", len(body), len("framed ")*512)
+	}
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"firestarter/internal/envelope"
+	"firestarter/internal/runtimeconfig"
+	"fmt"
+	"net/http"
+)
+
+// ConfigHandler stores the configuration an agent reports as in effect, on connecting and after
+// each configuration update it receives, and acknowledges it
+func ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	store := runtimeconfig.GetStore()
+	if store == nil {
+		http.Error(w, "runtime configuration not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	msg, codec, ok := readEnvelope(w, r)
+	if !ok {
+		return
+	}
+	if msg.Type != envelope.TypeConfigReport {
+		http.Error(w, fmt.Sprintf("expected a config report message, got %s", msg.Type), http.StatusBadRequest)
+		return
+	}
+
+	report, err := runtimeconfig.UnmarshalReport(msg.Payload)
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Rejected configuration report from agent %s: %v\n", msg.AgentID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	store.Report(msg.AgentID, report)
+	writeEnvelope(w, msg.Reply(envelope.TypeConfigReportAck, nil), codec)
+}
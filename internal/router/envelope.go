@@ -0,0 +1,105 @@
+package router
+
+import (
+	"errors"
+	"firestarter/internal/e2e"
+	"firestarter/internal/envelope"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// envelopeCodec remembers how an agent's message arrived, so the reply goes back the same way
+type envelopeCodec struct {
+	encoding envelope.Encoding
+	key      *e2e.Key // Set when the agent sealed its message
+}
+
+// readEnvelope decodes the message envelope carried in a request body, opening it first when
+// the agent sealed it. Requests that don't carry a valid envelope from the agent making them are
+// answered with 400 Bad Request and readEnvelope returns false; incompatible versions are told
+// which versions are supported.
+func readEnvelope(w http.ResponseWriter, r *http.Request) (*envelope.Envelope, envelopeCodec, bool) {
+	agentUUID := r.Header.Get("X-Agent-UUID")
+	var codec envelopeCodec
+	if agentUUID == "" {
+		http.Error(w, "missing agent UUID", http.StatusBadRequest)
+		return nil, codec, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, envelope.MaxPayloadSize+1024))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return nil, codec, false
+	}
+
+	body, codec.key, err = openFromAgent(agentUUID, body)
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Rejected message from agent %s on %s: %v\n", agentUUID, r.URL.Path, err)
+		http.Error(w, "message rejected", http.StatusBadRequest)
+		return nil, codec, false
+	}
+
+	msg, encoding, err := envelope.Unmarshal(body)
+	codec.encoding = encoding
+	if err == nil && msg.AgentID != agentUUID {
+		err = fmt.Errorf("envelope agent ID %s does not match the requesting agent", msg.AgentID)
+	}
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Rejected message from agent %s on %s: %v\n", agentUUID, r.URL.Path, err)
+
+		var versionErr *envelope.VersionError
+		if errors.As(err, &versionErr) {
+			w.Header().Set("X-Envelope-Version", strconv.Itoa(int(envelope.Version)))
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, codec, false
+	}
+
+	return msg, codec, true
+}
+
+// openFromAgent opens a sealed request body. Agents built with an end-to-end key must seal
+// everything they send, so a body in the clear from such an agent is refused.
+func openFromAgent(agentUUID string, body []byte) ([]byte, *e2e.Key, error) {
+	keyStore := e2e.GetKeyStore()
+
+	if e2e.IsSealed(body) {
+		if keyStore == nil {
+			return nil, nil, fmt.Errorf("sealed message received but end-to-end encryption is not available")
+		}
+		return keyStore.OpenFromAgent(agentUUID, body)
+	}
+
+	if keyStore != nil {
+		key, err := keyStore.KeyForAgent(agentUUID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key != nil {
+			return nil, nil, fmt.Errorf("agent has an end-to-end key but sent its message in the clear")
+		}
+	}
+	return body, nil, nil
+}
+
+// writeEnvelope answers a request with an envelope, encoded and sealed the way the agent's was
+func writeEnvelope(w http.ResponseWriter, msg *envelope.Envelope, codec envelopeCodec) {
+	body, err := envelope.Marshal(msg, codec.encoding)
+	if err == nil && codec.key != nil {
+		body, err = codec.key.Seal(e2e.ToAgent, body)
+	}
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Failed to encode %s message for agent %s: %v\n", msg.Type, msg.AgentID, err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	if codec.key != nil {
+		w.Header().Set("Content-Type", e2e.ContentType)
+	} else {
+		w.Header().Set("Content-Type", codec.encoding.ContentType())
+	}
+	w.Write(body)
+}
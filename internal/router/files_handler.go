@@ -0,0 +1,96 @@
+package router
+
+import (
+	"errors"
+	"firestarter/internal/envelope"
+	"firestarter/internal/filestore"
+	"firestarter/internal/transfer"
+	"fmt"
+	"net/http"
+)
+
+// FilesHandler moves files between agents and the file store for their file tasks. Files sent by
+// download tasks are offered, then sent chunk by chunk, each answered with the chunks the store
+// holds so far. Files written by upload tasks are fetched chunk by chunk. Agents with no such
+// task under way, or quarantined, are answered with an unknown status so they give up.
+func FilesHandler(w http.ResponseWriter, r *http.Request) {
+	store := filestore.GetStore()
+	if store == nil {
+		http.Error(w, "file store not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	msg, codec, ok := readEnvelope(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		replyType envelope.MessageType
+		reply     interface{ Marshal() ([]byte, error) }
+	)
+	switch msg.Type {
+	case envelope.TypeFileOffer:
+		offer, err := transfer.UnmarshalOffer(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		status := transfer.Status{ID: offer.TaskID, Unknown: true}
+		if !Quarantined(r) {
+			if status, err = store.Offer(msg.AgentID, offer); err != nil {
+				rejectTaskMessage(w, msg, err)
+				return
+			}
+		}
+		replyType, reply = envelope.TypeFileStatus, status
+
+	case envelope.TypeFileChunk:
+		chunk, err := transfer.UnmarshalChunk(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		status := transfer.Status{ID: chunk.ID, Unknown: true}
+		if !Quarantined(r) {
+			if status, err = store.StoreChunk(msg.AgentID, chunk); err != nil {
+				rejectTaskMessage(w, msg, err)
+				return
+			}
+		}
+		replyType, reply = envelope.TypeFileStatus, status
+
+	case envelope.TypeFileFetch:
+		fetch, err := transfer.UnmarshalFetch(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		if Quarantined(r) {
+			replyType, reply = envelope.TypeFileStatus, transfer.Status{ID: fetch.FileID, Unknown: true}
+			break
+		}
+		chunk, err := store.ReadChunk(msg.AgentID, fetch)
+		if errors.Is(err, filestore.ErrUnknown) {
+			fmt.Printf("[❌ERR] -> Refused file fetch from agent %s: %v\n", msg.AgentID, err)
+			replyType, reply = envelope.TypeFileStatus, transfer.Status{ID: fetch.FileID, Unknown: true}
+			break
+		}
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		replyType, reply = envelope.TypeFileData, chunk
+
+	default:
+		http.Error(w, fmt.Sprintf("expected a file offer, chunk or fetch message, got %s", msg.Type), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := reply.Marshal()
+	if err != nil {
+		http.Error(w, "failed to encode reply", http.StatusInternalServerError)
+		return
+	}
+	writeEnvelope(w, msg.Reply(replyType, payload), codec)
+}
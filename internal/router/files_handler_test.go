@@ -0,0 +1,134 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"firestarter/internal/envelope"
+	"firestarter/internal/filestore"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withFileStore sets up the global file store and the task queue its transfers are checked
+// against, with a file staged for the test agent's upload task. It returns the file and the task.
+func withFileStore(t *testing.T, content []byte) (filestore.Record, tasking.Task) {
+	t.Helper()
+
+	if err := tasking.InitializeQueue(t.TempDir()); err != nil {
+		t.Fatalf("InitializeQueue: %v", err)
+	}
+	queue := tasking.GetQueue()
+	t.Cleanup(func() {
+		tasking.GlobalQueue = nil
+		// Saved in the background, done before the directory goes
+		queue.Flush()
+	})
+	if err := filestore.InitializeStore(t.TempDir()); err != nil {
+		t.Fatalf("InitializeStore: %v", err)
+	}
+	t.Cleanup(func() { filestore.GlobalStore = nil })
+	store := filestore.GetStore()
+
+	record, err := store.Stage(testAgentUUID, "tool", "/tmp/tool", transfer.MinChunkSize, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	args, _ := json.Marshal(transfer.UploadArgs{
+		FileID:   record.ID,
+		Path:     record.Path,
+		Manifest: transfer.Manifest{Size: record.Size, ChunkSize: record.ChunkSize, SHA256: record.SHA256},
+	})
+	task, err := queue.Enqueue(testAgentUUID, transfer.TaskUpload, args, 0, 0)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := store.Attach(record.ID, task.ID); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	return record, task
+}
+
+// postFileFetch sends a fetch from agentUUID to FilesHandler, returning the status code and the
+// reply envelope when there is one
+func postFileFetch(t *testing.T, agentUUID string, fetch transfer.Fetch) (int, *envelope.Envelope) {
+	t.Helper()
+
+	payload, _ := fetch.Marshal()
+	body, err := envelope.Marshal(envelope.New(envelope.TypeFileFetch, agentUUID, 1, payload), envelope.Binary)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/files", bytes.NewReader(body))
+	req.Header.Set("X-Agent-UUID", agentUUID)
+	rec := httptest.NewRecorder()
+	FilesHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	reply, _, err := envelope.Unmarshal(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("failed to decode reply: %v", err)
+	}
+	return rec.Code, reply
+}
+
+func TestFilesHandlerFetch(t *testing.T) {
+	// Two chunks, the last one short
+	content := append(bytes.Repeat([]byte("a"), transfer.MinChunkSize), "the end"...)
+	record, task := withFileStore(t, content)
+
+	// A file staged for another agent, whose ID the test agent learnt
+	foreign, err := filestore.GetStore().Stage("other-agent", "tool", "/tmp/tool", 0, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+
+	t.Run("own file", func(t *testing.T) {
+		code, reply := postFileFetch(t, testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: record.ID, Index: 1})
+		if code != http.StatusOK || reply.Type != envelope.TypeFileData {
+			t.Fatalf("reply = %d %v, want %d with file data", code, reply, http.StatusOK)
+		}
+		chunk, err := transfer.UnmarshalChunk(reply.Payload)
+		if err != nil {
+			t.Fatalf("UnmarshalChunk: %v", err)
+		}
+		if want := content[transfer.MinChunkSize:]; !bytes.Equal(chunk.Data, want) {
+			t.Errorf("chunk holds %d bytes that differ from the file's last %d", len(chunk.Data), len(want))
+		}
+	})
+
+	unknown := []struct {
+		name      string
+		agentUUID string
+		fetch     transfer.Fetch
+	}{
+		{"file not bound to the task", testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: foreign.ID}},
+		{"task of another agent", "other-agent", transfer.Fetch{TaskID: task.ID, FileID: record.ID}},
+	}
+	for _, tt := range unknown {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reply := postFileFetch(t, tt.agentUUID, tt.fetch)
+			if code != http.StatusOK || reply.Type != envelope.TypeFileStatus {
+				t.Fatalf("reply = %d %v, want %d with a file status", code, reply, http.StatusOK)
+			}
+			status, err := transfer.UnmarshalStatus(reply.Payload)
+			if err != nil {
+				t.Fatalf("UnmarshalStatus: %v", err)
+			}
+			if !status.Unknown || status.ID != tt.fetch.FileID {
+				t.Errorf("status = %+v, want file %s unknown", status, tt.fetch.FileID)
+			}
+		})
+	}
+
+	for _, index := range []int{-1, 2, 1 << 20} {
+		code, _ := postFileFetch(t, testAgentUUID, transfer.Fetch{TaskID: task.ID, FileID: record.ID, Index: index})
+		if code != http.StatusBadRequest {
+			t.Errorf("fetch of chunk %d answered %d, want %d", index, code, http.StatusBadRequest)
+		}
+	}
+}
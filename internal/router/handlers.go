@@ -1,6 +1,7 @@
 package router
 
 import (
+	"firestarter/internal/envelope"
 	"fmt"
 	"net/http"
 	"time"
@@ -31,15 +32,20 @@ func SlowResponseHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Slow response completed after 10 seconds"))
 }
 
-// PingHandler responds to ping requests from agents
+// PingHandler answers an agent's ping envelope with a pong echoing its sequence number
 func PingHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Ping request received from:", r.RemoteAddr)
 
-	// Extract and log the agent UUID for debugging
-	agentUUID := r.Header.Get("X-Agent-UUID")
-	if agentUUID != "" {
-		fmt.Printf("Ping from agent with UUID: %s\n", agentUUID)
+	msg, codec, ok := readEnvelope(w, r)
+	if !ok {
+		return
 	}
+	if msg.Type != envelope.TypePing {
+		http.Error(w, fmt.Sprintf("expected a ping message, got %s", msg.Type), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Printf("Ping from agent with UUID: %s (sequence %d)\n", msg.AgentID, msg.Sequence)
 
-	w.Write([]byte("pong"))
+	writeEnvelope(w, msg.Reply(envelope.TypePong, []byte("pong")), codec)
 }
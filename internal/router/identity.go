@@ -0,0 +1,33 @@
+package router
+
+import (
+	"firestarter/internal/certificates"
+	"firestarter/internal/security"
+	"fmt"
+	"net/http"
+)
+
+// AgentCertificateMiddleware ties requests on connections authenticated by a client certificate
+// to the agent the certificate names, which the registry already bound to the connection during
+// the handshake. A request claiming any other UUID is rejected and recorded as a security event.
+func AgentCertificateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		certified := certificates.AgentIdentity(r.TLS)
+		if certified == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claimed := r.Header.Get("X-Agent-UUID")
+		if claimed != "" && claimed != certified {
+			security.RecordRejectedRequest(claimed, r.RemoteAddr, r.Method, r.URL.Path,
+				fmt.Errorf("client certificate identifies agent %s", certified))
+			http.Error(w, "request rejected", http.StatusUnauthorized)
+			return
+		}
+
+		// Handlers downstream only look at the header
+		r.Header.Set("X-Agent-UUID", certified)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,23 @@
+package router
+
+import (
+	"firestarter/internal/maintenance"
+	"net/http"
+)
+
+// MaintenanceMiddleware turns agents away while the listener is in maintenance, answering
+// 503 Service Unavailable with a Retry-After hint of when to come back
+func MaintenanceMiddleware(listenerID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			window, active := maintenance.Active(listenerID)
+			if !active {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Retry-After", maintenance.RetryAfterHeader(window.RetryAfter))
+			http.Error(w, "listener in maintenance", http.StatusServiceUnavailable)
+		})
+	}
+}
@@ -2,16 +2,9 @@ package router
 
 import (
 	"context"
+	"firestarter/internal/certificates"
 	"firestarter/internal/connregistry"
-	"fmt"
 	"net/http"
-	"sync"
-)
-
-var (
-	// Track which UUIDs we've already logged for each connection
-	processedUUIDs    = make(map[string]bool)
-	processedUUIDsMux sync.RWMutex
 )
 
 // Key type for connregistry values
@@ -27,31 +20,12 @@ func AgentUUIDHeaderMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract UUID from header
 		agentUUID := r.Header.Get("X-Agent-UUID")
-		
-		// Log the extraction
-		if agentUUID != "" {
-			// Generate a unique key for this connection+UUID combination
-			connUUIDKey := r.RemoteAddr + ":" + agentUUID
-
-			processedUUIDsMux.RLock()
-			alreadyProcessed := processedUUIDs[connUUIDKey]
-			processedUUIDsMux.RUnlock()
-
-			if !alreadyProcessed {
-				processedUUIDsMux.Lock()
-				processedUUIDs[connUUIDKey] = true
-				processedUUIDsMux.Unlock()
-
-				fmt.Printf("[UUID-Track-DEBUG] Middleware: Extracted agent UUID: %s from request to %s (Remote: %s)\n",
-					agentUUID, r.URL.Path, r.RemoteAddr)
-			}
-		}
 
 		// Store in request connregistry
 		ctx := context.WithValue(r.Context(), AgentUUIDKey, agentUUID)
 
-		// Look up the connection from our registry
-		if agentUUID != "" && connregistry.GlobalConnectionRegistry != nil {
+		// Look up the connection from our registry, unless a client certificate already identified it
+		if agentUUID != "" && certificates.AgentIdentity(r.TLS) == "" && connregistry.GlobalConnectionRegistry != nil {
 			connregistry.GlobalConnectionRegistry.RegisterUUID(r, agentUUID)
 		}
 
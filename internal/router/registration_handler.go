@@ -0,0 +1,39 @@
+package router
+
+import (
+	"firestarter/internal/envelope"
+	"firestarter/internal/registration"
+	"fmt"
+	"net/http"
+)
+
+// RegisterHandler stores the host metadata an agent reports when it connects and acknowledges it
+func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	registry := registration.GetRegistry()
+	if registry == nil {
+		http.Error(w, "registration not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	msg, codec, ok := readEnvelope(w, r)
+	if !ok {
+		return
+	}
+	if msg.Type != envelope.TypeRegister {
+		http.Error(w, fmt.Sprintf("expected a register message, got %s", msg.Type), http.StatusBadRequest)
+		return
+	}
+
+	host, err := registration.Unmarshal(msg.Payload)
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Rejected registration from agent %s: %v\n", msg.AgentID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	registry.Register(msg.AgentID, host, r.RemoteAddr)
+	fmt.Printf("[🪪HST] -> Agent %s registered: %s@%s (%s/%s, PID %d)\n",
+		msg.AgentID, host.Username, host.Hostname, host.OS, host.Arch, host.PID)
+
+	writeEnvelope(w, msg.Reply(envelope.TypeRegistered, nil), codec)
+}
@@ -4,11 +4,18 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-// SetupRoutes configures all routes for the application
-func SetupRoutes(r chi.Router) {
+// SetupRoutes configures all routes for the listener with the given ID
+func SetupRoutes(r chi.Router, listenerID string) {
 
-	// Apply middleware to all routes
+	// Apply middleware to all routes, authenticating agents before their UUID is trusted.
+	// Signatures cover the body as sent, so it is only decompressed once they are verified.
+	// Only authenticated agents in scope learn that the listener is in maintenance.
+	r.Use(AgentCertificateMiddleware)
+	r.Use(AgentSignatureMiddleware)
 	r.Use(AgentUUIDHeaderMiddleware)
+	r.Use(ScopeMiddleware)
+	r.Use(MaintenanceMiddleware(listenerID))
+	r.Use(CompressionMiddleware)
 
 	// Define our root endpoint
 	r.Get("/", RootHandler)
@@ -18,4 +25,22 @@ func SetupRoutes(r chi.Router) {
 	r.Get("/slow", SlowResponseHandler)
 
 	r.Post("/ping", PingHandler)
+
+	// Host metadata agents report every time they connect
+	r.Post("/register", RegisterHandler)
+
+	// Status records sent with health checks, and the notice of a clean shutdown
+	r.Post("/telemetry", TelemetryHandler)
+
+	// Configuration in effect on the agent, and whether it applied the last update
+	r.Post("/config", ConfigHandler)
+
+	// Tasks queued for the agent: fetching them, acknowledging them and reporting their outcome
+	r.Post("/tasks", TasksHandler)
+
+	// Files moved by file tasks: sent to the file store, or fetched from it
+	r.Post("/files", FilesHandler)
+
+	// Long-poll check-in, held open until the server has work for the agent
+	r.Get("/checkin", CheckInHandler)
 }
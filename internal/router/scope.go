@@ -0,0 +1,43 @@
+package router
+
+import (
+	"context"
+	"firestarter/internal/scope"
+	"firestarter/internal/security"
+	"net/http"
+)
+
+// quarantinedKey marks requests from quarantined agents in the request context
+const quarantinedKey contextKey = "quarantined"
+
+// ScopeMiddleware deals with agents checking in from outside the authorized scope. In refuse mode
+// they are answered 403 Forbidden, in quarantine mode the request goes through marked as
+// quarantined so no work is handed to the agent. The first request seen from each agent and
+// address is recorded as a security event.
+func ScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorized := scope.GetScope()
+		if authorized == nil || authorized.Allows(r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		agentUUID := r.Header.Get("X-Agent-UUID")
+		mode, first := authorized.RecordViolation(agentUUID, r.RemoteAddr)
+		if first {
+			security.RecordOutOfScope(agentUUID, r.RemoteAddr, r.Method, r.URL.Path, mode)
+		}
+
+		if mode == scope.ModeRefuse {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), quarantinedKey, true)))
+	})
+}
+
+// Quarantined reports whether a request comes from an agent quarantined for being out of scope
+func Quarantined(r *http.Request) bool {
+	quarantined, _ := r.Context().Value(quarantinedKey).(bool)
+	return quarantined
+}
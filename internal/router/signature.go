@@ -0,0 +1,73 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"firestarter/internal/security"
+	"firestarter/internal/signing"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxSignedBody bounds how much of a signed body is read to check its hash
+const maxSignedBody = 64 << 20
+
+var errMissingAgentUUID = errors.New("request carries no agent UUID")
+
+// AgentSignatureMiddleware authenticates agent requests before anything trusts their
+// X-Agent-UUID header, so a rejected request never registers or rebinds the UUID. When
+// signatures are required, requests that don't say which agent they come from are refused
+// outright, otherwise only signed requests are checked.
+// Rejections are answered with a bare 401 and recorded as security events.
+func AgentSignatureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifier := signing.GetVerifier()
+		agentUUID := r.Header.Get("X-Agent-UUID")
+
+		var err error
+		switch {
+		case verifier == nil:
+			// Signing unavailable, nothing to check against
+		case agentUUID == "" && verifier.Required():
+			err = errMissingAgentUUID
+		case agentUUID != "":
+			err = verifyAgentRequest(verifier, r, agentUUID)
+		}
+		if err != nil {
+			security.RecordRejectedRequest(agentUUID, r.RemoteAddr, r.Method, r.URL.Path, err)
+
+			// Don't tell the sender which check failed
+			http.Error(w, "request rejected", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifyAgentRequest checks the request's signature and that the body is the one that was
+// signed. The body is read whole to be hashed, then replaced so handlers can still read it.
+func verifyAgentRequest(verifier *signing.Verifier, r *http.Request, agentUUID string) error {
+	sig, err := signing.FromHeaders(r.Header)
+	if errors.Is(err, signing.ErrUnsigned) {
+		sig = nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := verifier.Verify(agentUUID, r.Method, r.URL.RequestURI(), sig); err != nil || sig == nil {
+		return err // Unsigned requests only get this far when signatures are optional
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read signed body: %w", err)
+	}
+	if len(body) > maxSignedBody || signing.HashBody(body) != sig.ContentHash {
+		return signing.ErrContentHash
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"bytes"
+	"firestarter/internal/signing"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testAgentUUID = "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b"
+
+// withVerifier installs a global verifier knowing a single agent, returning its secret
+func withVerifier(t *testing.T, required bool) []byte {
+	t.Helper()
+
+	dir := t.TempDir()
+	secret, err := signing.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	if err := signing.RegisterAgentSecret(dir, testAgentUUID, secret); err != nil {
+		t.Fatalf("RegisterAgentSecret: %v", err)
+	}
+	if err := signing.InitializeVerifier(dir, required); err != nil {
+		t.Fatalf("InitializeVerifier: %v", err)
+	}
+	t.Cleanup(func() { signing.GlobalVerifier = nil })
+	return secret
+}
+
+// agentRequest builds a request from the test agent, signing signedBody when secret is set
+func agentRequest(t *testing.T, secret []byte, method string, target string, body []byte, signedBody []byte) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.Header.Set("X-Agent-UUID", testAgentUUID)
+	if secret != nil {
+		sig, err := signing.Sign(secret, testAgentUUID, method, req.URL.RequestURI(), signedBody)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		sig.SetHeaders(req.Header)
+	}
+	return req
+}
+
+// echoHandler answers with the request body, showing what handlers behind the middleware read
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.Copy(w, r.Body)
+})
+
+func TestAgentSignatureMiddleware(t *testing.T) {
+	body := []byte(`{"type":"task_result"}`)
+
+	tests := []struct {
+		name     string
+		required bool
+		request  func(secret []byte) *http.Request
+		want     int
+	}{
+		{
+			name: "signed", required: true,
+			request: func(secret []byte) *http.Request {
+				return agentRequest(t, secret, "POST", "/tasks", body, body)
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "body does not match its hash", required: true,
+			request: func(secret []byte) *http.Request {
+				return agentRequest(t, secret, "POST", "/tasks", []byte(`{"type":"forged"}`), body)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "signed for another path", required: true,
+			request: func(secret []byte) *http.Request {
+				req := agentRequest(t, secret, "POST", "/tasks", body, body)
+				req.URL.Path = "/files"
+				return req
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "unsigned", required: true,
+			request: func(secret []byte) *http.Request {
+				return agentRequest(t, nil, "POST", "/tasks", body, nil)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "no agent UUID", required: true,
+			request: func(secret []byte) *http.Request {
+				return httptest.NewRequest("GET", "/", nil)
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "optional, no agent UUID", required: false,
+			request: func(secret []byte) *http.Request {
+				return httptest.NewRequest("GET", "/", nil)
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "optional, unsigned", required: false,
+			request: func(secret []byte) *http.Request {
+				return agentRequest(t, nil, "POST", "/tasks", body, nil)
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "optional, body does not match its hash", required: false,
+			request: func(secret []byte) *http.Request {
+				return agentRequest(t, secret, "POST", "/tasks", []byte(`{"type":"forged"}`), body)
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := withVerifier(t, tt.required)
+			req := tt.request(secret)
+			sent, _ := io.ReadAll(req.Body)
+			req.Body = io.NopCloser(bytes.NewReader(sent))
+
+			recorder := httptest.NewRecorder()
+			AgentSignatureMiddleware(echoHandler).ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.want)
+			}
+			if recorder.Code == http.StatusOK && !bytes.Equal(recorder.Body.Bytes(), sent) {
+				t.Errorf("handler read %q, want the body as sent %q", recorder.Body.Bytes(), sent)
+			}
+		})
+	}
+}
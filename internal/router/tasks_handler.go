@@ -0,0 +1,110 @@
+package router
+
+import (
+	"firestarter/internal/envelope"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"fmt"
+	"net/http"
+)
+
+// TasksHandler serves agents their tasks. A fetch is answered with the next tasks in the agent's
+// queue, and acknowledgements and results with a receipt of the tasks they were accepted for.
+// Tasks are handed out again until acknowledged, so a lost answer costs a repeat, never a task.
+// Outputs too large for a result are uploaded chunk by chunk first, each answered with the
+// chunks the server holds so far.
+func TasksHandler(w http.ResponseWriter, r *http.Request) {
+	queue := tasking.GetQueue()
+	if queue == nil {
+		http.Error(w, "tasking not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	msg, codec, ok := readEnvelope(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		replyType envelope.MessageType
+		reply     interface{ Marshal() ([]byte, error) }
+	)
+	switch msg.Type {
+	case envelope.TypeTaskFetch:
+		request, err := tasking.UnmarshalFetchRequest(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+
+		// Quarantined agents are never handed work
+		batch := tasking.Batch{Tasks: []tasking.Delivery{}}
+		if !Quarantined(r) {
+			batch.Tasks = queue.Fetch(msg.AgentID, request.Max)
+		}
+		replyType, reply = envelope.TypeTaskBatch, batch
+
+	case envelope.TypeTaskAck:
+		ack, err := tasking.UnmarshalAck(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		replyType, reply = envelope.TypeTaskReceipt, tasking.Receipt{IDs: queue.Acknowledge(msg.AgentID, ack.IDs)}
+
+	case envelope.TypeTaskResult:
+		result, err := tasking.UnmarshalResult(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		receipt := tasking.Receipt{IDs: []string{}}
+		if queue.Report(msg.AgentID, result) {
+			receipt.IDs = append(receipt.IDs, result.ID)
+		}
+		replyType, reply = envelope.TypeTaskReceipt, receipt
+
+	case envelope.TypeUploadBegin:
+		begin, err := tasking.UnmarshalUploadBegin(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		status, err := queue.BeginUpload(msg.AgentID, begin)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		replyType, reply = envelope.TypeUploadStatus, status
+
+	case envelope.TypeUploadChunk:
+		chunk, err := transfer.UnmarshalChunk(msg.Payload)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		status, err := queue.StoreChunk(msg.AgentID, chunk)
+		if err != nil {
+			rejectTaskMessage(w, msg, err)
+			return
+		}
+		replyType, reply = envelope.TypeUploadStatus, status
+
+	default:
+		http.Error(w, fmt.Sprintf("expected a task fetch, acknowledgement, result or upload message, got %s", msg.Type), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := reply.Marshal()
+	if err != nil {
+		http.Error(w, "failed to encode reply", http.StatusInternalServerError)
+		return
+	}
+	writeEnvelope(w, msg.Reply(replyType, payload), codec)
+}
+
+// rejectTaskMessage answers a task message that could not be decoded
+func rejectTaskMessage(w http.ResponseWriter, msg *envelope.Envelope, err error) {
+	fmt.Printf("[❌ERR] -> Rejected %s message from agent %s: %v\n", msg.Type, msg.AgentID, err)
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
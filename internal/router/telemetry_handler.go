@@ -0,0 +1,70 @@
+package router
+
+import (
+	"encoding/json"
+	"firestarter/internal/envelope"
+	"firestarter/internal/runtimeconfig"
+	"firestarter/internal/tasking"
+	"firestarter/internal/telemetry"
+	"fmt"
+	"net/http"
+)
+
+// TelemetryHandler records the status record agents send with their health checks, and the
+// notice they send when shutting down cleanly. Both are acknowledged with the server's clock,
+// status records also with any configuration update and the number of tasks waiting for the agent.
+func TelemetryHandler(w http.ResponseWriter, r *http.Request) {
+	monitor := telemetry.GetMonitor()
+	if monitor == nil {
+		http.Error(w, "telemetry not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	msg, codec, ok := readEnvelope(w, r)
+	if !ok {
+		return
+	}
+
+	var replyType envelope.MessageType
+	switch msg.Type {
+	case envelope.TypeTelemetry:
+		replyType = envelope.TypeTelemetryAck
+	case envelope.TypeShutdown:
+		replyType = envelope.TypeShutdownAck
+	default:
+		http.Error(w, fmt.Sprintf("expected a telemetry or shutdown message, got %s", msg.Type), http.StatusBadRequest)
+		return
+	}
+
+	status, err := telemetry.Unmarshal(msg.Payload)
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Rejected %s message from agent %s: %v\n", msg.Type, msg.AgentID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ack telemetry.Ack
+	if msg.Type == envelope.TypeShutdown {
+		ack = monitor.RecordShutdown(msg.AgentID, status)
+	} else {
+		ack = monitor.Record(msg.AgentID, status)
+
+		// Hand over a configuration update the agent hasn't acknowledged yet, unless it is quarantined
+		if store := runtimeconfig.GetStore(); store != nil && !Quarantined(r) {
+			ack.ConfigUpdate = store.PendingUpdate(msg.AgentID)
+		}
+
+		// Tell the agent tasks are waiting, it fetches them itself
+		if queue := tasking.GetQueue(); queue != nil && !Quarantined(r) {
+			ack.PendingTasks = queue.Pending(msg.AgentID)
+			ack.CancelTasks = queue.CancelRequests(msg.AgentID)
+		}
+	}
+
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		http.Error(w, "failed to encode acknowledgement", http.StatusInternalServerError)
+		return
+	}
+	writeEnvelope(w, msg.Reply(replyType, payload), codec)
+}
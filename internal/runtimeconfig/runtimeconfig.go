@@ -0,0 +1,146 @@
+// Package runtimeconfig carries the configuration updates the server pushes to running agents,
+// and the reports agents send back about the configuration in effect.
+//
+// An update is signed with the agent's request signing secret, so only the team server can
+// change how an agent behaves, and carries a version that only ever grows, so a captured update
+// can't be replayed over a later one.
+package runtimeconfig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheme is the first line of every signed update, binding signatures to this format and
+// keeping them apart from request signatures made with the same secret
+const scheme = "FS-CONFIG-HMAC-SHA256-1"
+
+// maxErrorLen bounds the reason an agent gives for rejecting an update
+const maxErrorLen = 1024
+
+// ErrBadSignature is returned when an update was not signed with the agent's secret
+var ErrBadSignature = errors.New("configuration update signature does not verify")
+
+// Settings are the parts of an agent's configuration that can be changed while it runs.
+// Durations are in milliseconds.
+type Settings struct {
+	// Target server, and the backup servers tried in order (proto://host:port)
+	TargetHost        string   `json:"targetHost"`
+	TargetPort        string   `json:"targetPort"`
+	Protocol          string   `json:"protocol"`
+	FallbackEndpoints []string `json:"fallbackEndpoints,omitempty"`
+
+	// Connection management
+	ReconnectAttempts       int   `json:"reconnectAttempts"`
+	ReconnectDelayMillis    int64 `json:"reconnectDelayMs"`
+	ConnectionTimeoutMillis int64 `json:"connectionTimeoutMs"`
+	RequestTimeoutMillis    int64 `json:"requestTimeoutMs"`
+
+	// Health checks and long-poll check-ins
+	HealthCheckIntervalMillis int64 `json:"healthCheckIntervalMs"`
+	LongPoll                  bool  `json:"longPoll"`
+	LongPollTimeoutMillis     int64 `json:"longPollTimeoutMs"`
+}
+
+// Update is a signed configuration update for a single agent
+type Update struct {
+	Version  uint64          `json:"version"` // Grows with every update, never reused
+	IssuedAt time.Time       `json:"issuedAt"`
+	Settings json.RawMessage `json:"settings"` // Signed as sent
+	MAC      string          `json:"mac"`
+}
+
+// Report is what an agent tells the server about its configuration, on connecting and after
+// every update it receives
+type Report struct {
+	Version  uint64   `json:"version"`  // Update in effect, 0 while running the build-time configuration
+	Settings Settings `json:"settings"` // Settings in effect
+
+	// Update that was refused, and why
+	RejectedVersion uint64 `json:"rejectedVersion,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// NewUpdate signs settings for an agent as update version
+func NewUpdate(secret []byte, agentUUID string, version uint64, settings Settings) (Update, error) {
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return Update{}, fmt.Errorf("failed to encode settings: %w", err)
+	}
+
+	update := Update{
+		Version:  version,
+		IssuedAt: time.Now(),
+		Settings: encoded,
+	}
+	update.MAC = update.mac(secret, agentUUID)
+	return update, nil
+}
+
+// Verify checks the update was signed with secret for this agent and returns its settings
+func (u Update) Verify(secret []byte, agentUUID string) (Settings, error) {
+	if !hmac.Equal([]byte(u.mac(secret, agentUUID)), []byte(u.MAC)) {
+		return Settings{}, ErrBadSignature
+	}
+
+	var settings Settings
+	if err := json.Unmarshal(u.Settings, &settings); err != nil {
+		return Settings{}, fmt.Errorf("invalid settings in update %d: %w", u.Version, err)
+	}
+	return settings, nil
+}
+
+func (u Update) mac(secret []byte, agentUUID string) string {
+	canonical := strings.Join([]string{
+		scheme,
+		agentUUID,
+		strconv.FormatUint(u.Version, 10),
+		strconv.FormatInt(u.IssuedAt.UnixNano(), 10),
+		string(u.Settings),
+	}, "\n")
+
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(canonical))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Marshal encodes an update for delivery to the agent
+func (u Update) Marshal() ([]byte, error) {
+	return json.Marshal(u)
+}
+
+// UnmarshalUpdate decodes an update received from the server
+func UnmarshalUpdate(payload []byte) (Update, error) {
+	var update Update
+	if err := json.Unmarshal(payload, &update); err != nil {
+		return Update{}, fmt.Errorf("invalid configuration update: %w", err)
+	}
+	if update.Version == 0 || update.MAC == "" {
+		return Update{}, fmt.Errorf("invalid configuration update: missing version or signature")
+	}
+	return update, nil
+}
+
+// Marshal encodes a report as an envelope payload
+func (r Report) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalReport decodes a report received from an agent
+func UnmarshalReport(payload []byte) (Report, error) {
+	var report Report
+	if err := json.Unmarshal(payload, &report); err != nil {
+		return Report{}, fmt.Errorf("invalid configuration report: %w", err)
+	}
+	if len(report.Error) > maxErrorLen {
+		report.Error = report.Error[:maxErrorLen]
+	}
+	return report, nil
+}
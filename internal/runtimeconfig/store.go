@@ -0,0 +1,175 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AgentConfig is what the server knows of an agent's configuration
+type AgentConfig struct {
+	AgentUUID  string    `json:"agentUUID"`
+	Version    uint64    `json:"version"`            // Update in effect, 0 for the build-time configuration
+	Settings   *Settings `json:"settings,omitempty"` // In effect, nil until the agent reports
+	ReportedAt time.Time `json:"reportedAt,omitempty"`
+
+	// Update waiting to be acknowledged by the agent
+	PendingVersion  uint64    `json:"pendingVersion,omitempty"`
+	PendingSettings *Settings `json:"pendingSettings,omitempty"`
+
+	// Why the agent refused the last update, cleared once an update is applied
+	LastError string `json:"lastError,omitempty"`
+
+	pendingUpdate []byte // Encoded signed update, delivered until acknowledged
+}
+
+// Global store instance
+var GlobalStore *Store
+
+// Store keeps the configuration of every agent along with the update pushed to it, and hands
+// every change to a notifier
+type Store struct {
+	agents      map[string]*AgentConfig
+	lastVersion uint64
+	notifier    func(AgentConfig)
+	mutex       sync.Mutex
+}
+
+// InitializeStore sets up the global store
+func InitializeStore() {
+	GlobalStore = &Store{
+		agents: make(map[string]*AgentConfig),
+	}
+}
+
+// GetStore returns the global store instance
+func GetStore() *Store {
+	return GlobalStore
+}
+
+// SetNotifier sets the function changed agent configurations are passed to, e.g. to broadcast
+// them to the UI
+func (s *Store) SetNotifier(notifier func(AgentConfig)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notifier = notifier
+}
+
+// Push signs settings for an agent and keeps the update until the agent acknowledges it,
+// replacing any update still pending. It returns the encoded update to deliver.
+func (s *Store) Push(agentUUID string, settings Settings, secret []byte) ([]byte, error) {
+	s.mutex.Lock()
+
+	// Versions follow the clock so they keep growing across server restarts
+	version := uint64(time.Now().UnixNano())
+	if version <= s.lastVersion {
+		version = s.lastVersion + 1
+	}
+	s.lastVersion = version
+
+	update, err := NewUpdate(secret, agentUUID, version, settings)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	encoded, err := update.Marshal()
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("failed to encode configuration update: %w", err)
+	}
+
+	agent := s.agentLocked(agentUUID)
+	agent.PendingVersion = version
+	agent.PendingSettings = &settings
+	agent.pendingUpdate = encoded
+	changed := *agent
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	fmt.Printf("[⚙️CFG] -> Configuration update %d pushed to agent %s\n", version, agentUUID)
+	if notifier != nil {
+		notifier(changed)
+	}
+	return encoded, nil
+}
+
+// PendingUpdate returns the encoded update the agent has not acknowledged yet, nil when there is none
+func (s *Store) PendingUpdate(agentUUID string) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if agent, exists := s.agents[agentUUID]; exists {
+		return agent.pendingUpdate
+	}
+	return nil
+}
+
+// Report stores the configuration an agent reported, settling the pending update once the
+// agent applied or refused it
+func (s *Store) Report(agentUUID string, report Report) {
+	s.mutex.Lock()
+	agent := s.agentLocked(agentUUID)
+	agent.Version = report.Version
+	agent.Settings = &report.Settings
+	agent.ReportedAt = time.Now()
+
+	var outcome string
+	switch {
+	case report.RejectedVersion != 0 && report.RejectedVersion >= agent.PendingVersion:
+		agent.LastError = report.Error
+		outcome = fmt.Sprintf("Agent %s refused configuration update %d: %s", agentUUID, report.RejectedVersion, report.Error)
+		agent.clearPending()
+	case agent.PendingVersion != 0 && report.Version >= agent.PendingVersion:
+		agent.LastError = ""
+		outcome = fmt.Sprintf("Agent %s applied configuration update %d", agentUUID, report.Version)
+		agent.clearPending()
+	}
+	changed := *agent
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	if outcome != "" {
+		fmt.Printf("[⚙️CFG] -> %s\n", outcome)
+	}
+	if notifier != nil {
+		notifier(changed)
+	}
+}
+
+// Get returns what the server knows of an agent's configuration
+func (s *Store) Get(agentUUID string) (AgentConfig, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if agent, exists := s.agents[agentUUID]; exists {
+		return *agent, true
+	}
+	return AgentConfig{}, false
+}
+
+// Snapshot returns the configuration of every agent
+func (s *Store) Snapshot() []AgentConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := make([]AgentConfig, 0, len(s.agents))
+	for _, agent := range s.agents {
+		snapshot = append(snapshot, *agent)
+	}
+	return snapshot
+}
+
+// agentLocked returns the agent's entry, creating it if needed, the caller must hold the mutex
+func (s *Store) agentLocked(agentUUID string) *AgentConfig {
+	agent, exists := s.agents[agentUUID]
+	if !exists {
+		agent = &AgentConfig{AgentUUID: agentUUID}
+		s.agents[agentUUID] = agent
+	}
+	return agent
+}
+
+// clearPending forgets the pending update
+func (a *AgentConfig) clearPending() {
+	a.PendingVersion = 0
+	a.PendingSettings = nil
+	a.pendingUpdate = nil
+}
@@ -0,0 +1,209 @@
+// Package scope keeps the networks the engagement is authorized to operate in. Agents checking
+// in from anywhere else are either refused outright or quarantined: still answered, so they
+// stay reachable for the operator to look into, but never handed any work.
+package scope
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of dealing with agents outside the authorized scope
+const (
+	ModeRefuse     = "refuse"     // Requests are turned away with 403 Forbidden
+	ModeQuarantine = "quarantine" // Requests are answered, but no work is delivered
+)
+
+// Violation aggregates the requests an agent made from one address outside the scope
+type Violation struct {
+	AgentUUID  string    `json:"agentUUID"` // As claimed by the request
+	RemoteAddr string    `json:"remoteAddr"`
+	Mode       string    `json:"mode"` // How the requests were dealt with
+	FirstSeen  time.Time `json:"firstSeen"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Count      int       `json:"count"`
+}
+
+// Status describes the authorized scope and the violations seen so far
+type Status struct {
+	CIDRs      []string    `json:"cidrs"` // Empty when every address is in scope
+	Mode       string      `json:"mode"`
+	Violations []Violation `json:"violations"`
+}
+
+// Global scope instance
+var GlobalScope *Scope
+
+// Scope decides whether agent addresses are in scope and keeps track of those that aren't
+type Scope struct {
+	prefixes   []netip.Prefix
+	mode       string
+	violations map[string]*Violation // Keyed by agent UUID and host
+	notifier   func(Violation)
+	mutex      sync.RWMutex
+}
+
+// InitializeScope sets up the global scope from a list of CIDRs or bare addresses. An empty list
+// puts every address in scope.
+func InitializeScope(cidrs []string, mode string) error {
+	if mode == "" {
+		mode = ModeRefuse
+	}
+	if mode != ModeRefuse && mode != ModeQuarantine {
+		return fmt.Errorf("unknown scope mode '%s': expected %s or %s", mode, ModeRefuse, ModeQuarantine)
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	GlobalScope = &Scope{
+		prefixes:   prefixes,
+		mode:       mode,
+		violations: make(map[string]*Violation),
+	}
+	return nil
+}
+
+// GetScope returns the global scope instance
+func GetScope() *Scope {
+	return GlobalScope
+}
+
+// parsePrefix parses a CIDR, a bare address being a prefix of its own
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid scope address '%s': %w", cidr, err)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid scope CIDR '%s': %w", cidr, err)
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// SetNotifier sets the function new and updated violations are passed to, e.g. to broadcast
+// them to the UI
+func (s *Scope) SetNotifier(notifier func(Violation)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.notifier = notifier
+}
+
+// Restricted reports whether a scope was set at all
+func (s *Scope) Restricted() bool {
+	return len(s.prefixes) > 0
+}
+
+// Mode returns how agents outside the scope are dealt with
+func (s *Scope) Mode() string {
+	return s.mode
+}
+
+// Allows reports whether a remote address (host or host:port) is in scope. Addresses that
+// can't be parsed are never in scope once a scope is set.
+func (s *Scope) Allows(remoteAddr string) bool {
+	if !s.Restricted() {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(hostOf(remoteAddr))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range s.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordViolation records a request from outside the scope, returning how it must be dealt with
+// and whether this is the first request seen from this agent and address
+func (s *Scope) RecordViolation(agentUUID string, remoteAddr string) (string, bool) {
+	host := hostOf(remoteAddr)
+	key := agentUUID + "|" + host
+	now := time.Now()
+
+	s.mutex.Lock()
+	violation, exists := s.violations[key]
+	if !exists {
+		violation = &Violation{
+			AgentUUID:  agentUUID,
+			RemoteAddr: host,
+			Mode:       s.mode,
+			FirstSeen:  now,
+		}
+		s.violations[key] = violation
+	}
+	violation.LastSeen = now
+	violation.Count++
+	changed := *violation
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	if !exists {
+		fmt.Printf("[🎯SCP] -> Agent %s checked in from %s, outside the authorized scope (%s)\n",
+			agentUUID, host, s.mode)
+	}
+	if notifier != nil {
+		notifier(changed)
+	}
+	return s.mode, !exists
+}
+
+// Status returns the authorized scope and the violations seen so far, most recent first
+func (s *Scope) Status() Status {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := Status{
+		CIDRs:      make([]string, 0, len(s.prefixes)),
+		Mode:       s.mode,
+		Violations: make([]Violation, 0, len(s.violations)),
+	}
+	for _, prefix := range s.prefixes {
+		status.CIDRs = append(status.CIDRs, prefix.String())
+	}
+	for _, violation := range s.violations {
+		status.Violations = append(status.Violations, *violation)
+	}
+	sort.Slice(status.Violations, func(i, j int) bool {
+		return status.Violations[i].LastSeen.After(status.Violations[j].LastSeen)
+	})
+	return status
+}
+
+// hostOf strips the port from a remote address, if it has one
+func hostOf(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return strings.Trim(remoteAddr, "[]")
+}
@@ -0,0 +1,125 @@
+package scope
+
+import (
+	"testing"
+)
+
+// newTestScope sets up the global scope, failing the test when the CIDRs are rejected
+func newTestScope(t *testing.T, cidrs []string, mode string) *Scope {
+	t.Helper()
+
+	if err := InitializeScope(cidrs, mode); err != nil {
+		t.Fatalf("InitializeScope(%v): %v", cidrs, err)
+	}
+	t.Cleanup(func() { GlobalScope = nil })
+	return GetScope()
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name       string
+		cidrs      []string
+		remoteAddr string
+		want       bool
+	}{
+		// Empty scope, everything is allowed, even what can't be parsed
+		{"empty scope, IPv4", nil, "203.0.113.7:443", true},
+		{"empty scope, IPv6", nil, "[2001:db8::1]:443", true},
+		{"empty scope, hostname", nil, "agent.example.com:443", true},
+		{"blank entries only", []string{"", " "}, "203.0.113.7:443", true},
+
+		// IPv4
+		{"IPv4 in range", []string{"10.0.0.0/8"}, "10.20.30.40:5555", true},
+		{"IPv4 out of range", []string{"10.0.0.0/8"}, "11.0.0.1:5555", false},
+		{"IPv4 without port", []string{"10.0.0.0/8"}, "10.1.2.3", true},
+		{"IPv4 range boundary", []string{"192.168.1.0/24"}, "192.168.1.255:80", true},
+		{"IPv4 past boundary", []string{"192.168.1.0/24"}, "192.168.2.0:80", false},
+		{"IPv4 bare address", []string{"198.51.100.9"}, "198.51.100.9:1234", true},
+		{"IPv4 bare address, neighbour", []string{"198.51.100.9"}, "198.51.100.10:1234", false},
+		{"IPv4 unmasked CIDR", []string{"172.16.5.4/16"}, "172.16.200.1:80", true},
+		{"IPv4-mapped IPv6 address", []string{"10.0.0.0/8"}, "[::ffff:10.0.0.1]:80", true},
+		{"IPv4-mapped IPv6 CIDR", []string{"::ffff:10.0.0.0/104"}, "10.9.9.9:80", true},
+		{"second CIDR matches", []string{"10.0.0.0/8", "192.0.2.0/24"}, "192.0.2.1:80", true},
+
+		// IPv6
+		{"IPv6 in range", []string{"2001:db8::/32"}, "[2001:db8:1::5]:443", true},
+		{"IPv6 out of range", []string{"2001:db8::/32"}, "[2001:db9::5]:443", false},
+		{"IPv6 without port", []string{"2001:db8::/32"}, "2001:db8::5", true},
+		{"IPv6 bracketed without port", []string{"2001:db8::/32"}, "[2001:db8::5]", true},
+		{"IPv6 with zone", []string{"fe80::/10"}, "[fe80::1%eth0]:443", true},
+		{"IPv6 bare address", []string{"2001:db8::1"}, "[2001:db8::1]:443", true},
+		{"IPv6 against IPv4 scope", []string{"10.0.0.0/8"}, "[2001:db8::1]:443", false},
+		{"IPv4 against IPv6 scope", []string{"2001:db8::/32"}, "10.0.0.1:443", false},
+
+		// Hostnames are never resolved, so never in a set scope
+		{"hostname", []string{"10.0.0.0/8"}, "agent.example.com:443", false},
+		{"localhost", []string{"127.0.0.0/8"}, "localhost:443", false},
+		{"garbage", []string{"10.0.0.0/8"}, "not an address", false},
+		{"empty address", []string{"10.0.0.0/8"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScope(t, tt.cidrs, ModeRefuse)
+			if got := s.Allows(tt.remoteAddr); got != tt.want {
+				t.Errorf("Allows(%q) with scope %v = %v, want %v", tt.remoteAddr, tt.cidrs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializeScopeRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+		mode  string
+	}{
+		{"hostname", []string{"corp.example.com"}, ModeRefuse},
+		{"bad CIDR", []string{"10.0.0.0/33"}, ModeRefuse},
+		{"bad address", []string{"10.0.0.256"}, ModeRefuse},
+		{"one bad entry among good ones", []string{"10.0.0.0/8", "nope"}, ModeRefuse},
+		{"unknown mode", []string{"10.0.0.0/8"}, "ignore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { GlobalScope = nil })
+			if err := InitializeScope(tt.cidrs, tt.mode); err == nil {
+				t.Errorf("InitializeScope(%v, %q) succeeded, want an error", tt.cidrs, tt.mode)
+			}
+		})
+	}
+}
+
+func TestRecordViolation(t *testing.T) {
+	s := newTestScope(t, []string{"10.0.0.0/8"}, ModeQuarantine)
+
+	var notified []Violation
+	s.SetNotifier(func(v Violation) { notified = append(notified, v) })
+
+	mode, first := s.RecordViolation("agent-a", "203.0.113.7:5000")
+	if mode != ModeQuarantine || !first {
+		t.Errorf("first violation = %s, %v, want %s, true", mode, first, ModeQuarantine)
+	}
+	// Another port from the same host is the same violation
+	if _, first := s.RecordViolation("agent-a", "203.0.113.7:5001"); first {
+		t.Error("second violation from the same host reported as the first")
+	}
+	if _, first := s.RecordViolation("agent-b", "203.0.113.7:5000"); !first {
+		t.Error("violation from another agent not reported as its first")
+	}
+
+	status := s.Status()
+	if len(status.Violations) != 2 {
+		t.Fatalf("status has %d violations, want 2", len(status.Violations))
+	}
+	for _, v := range status.Violations {
+		if v.AgentUUID == "agent-a" && (v.Count != 2 || v.RemoteAddr != "203.0.113.7") {
+			t.Errorf("agent-a violation = %+v, want 2 requests from 203.0.113.7", v)
+		}
+	}
+	if len(notified) != 3 {
+		t.Errorf("notifier called %d times, want 3", len(notified))
+	}
+	if len(status.CIDRs) != 1 || status.CIDRs[0] != "10.0.0.0/8" || status.Mode != ModeQuarantine {
+		t.Errorf("status = %v %s, want [10.0.0.0/8] %s", status.CIDRs, status.Mode, ModeQuarantine)
+	}
+}
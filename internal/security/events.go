@@ -0,0 +1,117 @@
+// Package security keeps a log of security-relevant events, such as rejected agent requests,
+// so operators can see them in the UI as they happen
+package security
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of security events
+const (
+	KindRejectedRequest = "rejected_request" // An agent request failed authentication
+	KindOutOfScope      = "out_of_scope"     // An agent checked in from outside the authorized scope
+)
+
+// maxEvents is how many recent events are kept for clients that connect later
+const maxEvents = 500
+
+// Event is a single security event
+type Event struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	AgentUUID  string    `json:"agentUUID"` // As claimed by the request, not necessarily genuine
+	RemoteAddr string    `json:"remoteAddr"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Reason     string    `json:"reason"`
+}
+
+// Global event log instance
+var GlobalEventLog *EventLog
+
+// EventLog keeps the most recent security events and hands new ones to a notifier
+type EventLog struct {
+	events   []Event
+	notifier func(Event)
+	mutex    sync.RWMutex
+}
+
+// InitializeEventLog sets up the global event log
+func InitializeEventLog() {
+	GlobalEventLog = &EventLog{}
+}
+
+// GetEventLog returns the global event log instance
+func GetEventLog() *EventLog {
+	return GlobalEventLog
+}
+
+// SetNotifier sets the function every new event is passed to, e.g. to broadcast it to the UI
+func (l *EventLog) SetNotifier(notifier func(Event)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.notifier = notifier
+}
+
+// Record logs an event, filling in its ID and time
+func (l *EventLog) Record(event Event) {
+	event.ID = uuid.New().String()
+	event.Time = time.Now()
+
+	fmt.Printf("[🚨SEC] -> %s from %s (agent %s, %s %s): %s\n",
+		event.Kind, event.RemoteAddr, event.AgentUUID, event.Method, event.Path, event.Reason)
+
+	l.mutex.Lock()
+	l.events = append(l.events, event)
+	if len(l.events) > maxEvents {
+		l.events = append([]Event(nil), l.events[len(l.events)-maxEvents:]...)
+	}
+	notifier := l.notifier
+	l.mutex.Unlock()
+
+	if notifier != nil {
+		notifier(event)
+	}
+}
+
+// Events returns the recorded events, oldest first
+func (l *EventLog) Events() []Event {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return append([]Event(nil), l.events...)
+}
+
+// RecordOutOfScope records an agent checking in from outside the authorized scope
+func RecordOutOfScope(agentUUID string, remoteAddr string, method string, path string, mode string) {
+	if GlobalEventLog == nil {
+		return
+	}
+	GlobalEventLog.Record(Event{
+		Kind:       KindOutOfScope,
+		AgentUUID:  agentUUID,
+		RemoteAddr: remoteAddr,
+		Method:     method,
+		Path:       path,
+		Reason:     fmt.Sprintf("address outside the authorized scope (%s)", mode),
+	})
+}
+
+// RecordRejectedRequest records an agent request that failed authentication
+func RecordRejectedRequest(agentUUID string, remoteAddr string, method string, path string, reason error) {
+	if GlobalEventLog == nil {
+		return
+	}
+	GlobalEventLog.Record(Event{
+		Kind:       KindRejectedRequest,
+		AgentUUID:  agentUUID,
+		RemoteAddr: remoteAddr,
+		Method:     method,
+		Path:       path,
+		Reason:     reason.Error(),
+	})
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"firestarter/internal/filestore"
+	"firestarter/internal/tasking"
+	"firestarter/internal/transfer"
+	"firestarter/internal/websocket"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FileService moves files between operators and agents through the file store, queueing the
+// file tasks that do the moving
+type FileService struct {
+	store *filestore.Store
+	tasks *TaskingService
+}
+
+// NewFileService creates a new file service on top of a file store, queueing tasks through tasks
+func NewFileService(store *filestore.Store, tasks *TaskingService) *FileService {
+	fmt.Println("[📁FIL] -> File Service initialized.")
+
+	return &FileService{
+		store: store,
+		tasks: tasks,
+	}
+}
+
+// PushFile stages a file and queues an upload task writing it to path on the agent. A chunk size
+// of 0 means transfer.DefaultChunkSize, a timeout of 0 leaves it to the agent.
+func (s *FileService) PushFile(agentUUID string, name string, path string, chunkSize int, timeout time.Duration, content io.Reader) (filestore.Record, error) {
+	record, err := s.store.Stage(agentUUID, name, path, chunkSize, content)
+	if err != nil {
+		return filestore.Record{}, err
+	}
+
+	args, err := json.Marshal(transfer.UploadArgs{
+		FileID: record.ID,
+		Path:   record.Path,
+		Manifest: transfer.Manifest{
+			Size:      record.Size,
+			ChunkSize: record.ChunkSize,
+			SHA256:    record.SHA256,
+		},
+	})
+	if err != nil {
+		_ = s.store.Delete(record.ID)
+		return filestore.Record{}, fmt.Errorf("failed to encode upload task: %w", err)
+	}
+	task, err := s.tasks.QueueTask(agentUUID, transfer.TaskUpload, args, 0, timeout)
+	if err != nil {
+		_ = s.store.Delete(record.ID)
+		return filestore.Record{}, err
+	}
+
+	if err := s.store.Attach(record.ID, task.ID); err != nil {
+		return filestore.Record{}, err
+	}
+	record.TaskID = task.ID
+	return record, nil
+}
+
+// RetrieveFile queues a download task sending the file at path on the agent to the file store
+func (s *FileService) RetrieveFile(agentUUID string, path string, chunkSize int, timeout time.Duration) (tasking.Task, error) {
+	if path == "" {
+		return tasking.Task{}, fmt.Errorf("no path given")
+	}
+	if chunkSize != 0 {
+		if err := transfer.ValidateChunkSize(chunkSize); err != nil {
+			return tasking.Task{}, err
+		}
+	}
+
+	args, err := json.Marshal(transfer.DownloadArgs{Path: path, ChunkSize: chunkSize})
+	if err != nil {
+		return tasking.Task{}, fmt.Errorf("failed to encode download task: %w", err)
+	}
+	return s.tasks.QueueTask(agentUUID, transfer.TaskDownload, args, 0, timeout)
+}
+
+// DeleteFile forgets a file and removes its content from the store
+func (s *FileService) DeleteFile(id string) error {
+	return s.store.Delete(id)
+}
+
+// ConnectToWebSocket registers this service with the WebSocket server
+func (s *FileService) ConnectToWebSocket() {
+	websocket.RegisterFileBridge(s)
+	fmt.Println("[🔗LNK] -> File Service registered with WebSocket.")
+}
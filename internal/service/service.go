@@ -2,10 +2,14 @@
 package service
 
 import (
+	"firestarter/internal/checkin"
 	"firestarter/internal/connections"
 	"firestarter/internal/factory"
 	"firestarter/internal/interfaces"
+	"firestarter/internal/maintenance"
 	"firestarter/internal/manager"
+	"firestarter/internal/runtimeconfig"
+	"firestarter/internal/signing"
 	"firestarter/internal/types"
 	"firestarter/internal/websocket"
 	"fmt"
@@ -104,6 +108,44 @@ func (s *ListenerService) StopListener(id string) error {
 		return fmt.Errorf("failed to remove listener from manager: %w", err)
 	}
 
+	// A new listener reusing the ID must not start out in maintenance
+	if registry := maintenance.GetRegistry(); registry != nil {
+		registry.Disable(id)
+	}
+
+	return nil
+}
+
+// SetListenerMaintenance puts a listener in maintenance, agents being told to retry after the
+// given delay (the default when zero), or takes it out of maintenance
+func (s *ListenerService) SetListenerMaintenance(id string, enabled bool, retryAfter time.Duration) error {
+	listener, err := s.manager.GetListener(id)
+	if err != nil {
+		return err
+	}
+
+	registry := maintenance.GetRegistry()
+	if registry == nil {
+		return fmt.Errorf("maintenance registry not initialized")
+	}
+
+	if enabled {
+		if _, err := registry.Enable(id, retryAfter); err != nil {
+			return err
+		}
+	} else {
+		registry.Disable(id)
+	}
+
+	// Broadcast the new state to WebSocket clients
+	wsServer := websocket.GetGlobalWSServer()
+	if wsServer != nil {
+		wsServer.Broadcast(websocket.Message{
+			Type:    websocket.ListenerUpdated,
+			Payload: websocket.ConvertListener(listener),
+		})
+	}
+
 	return nil
 }
 
@@ -272,6 +314,58 @@ func (s *ListenerService) BroadcastConnectionStatus() {
 	})
 }
 
+// PingAgent asks an agent to ping back, delivered on its next (or currently held) check-in
+func (s *ListenerService) PingAgent(agentUUID string) error {
+	if agentUUID == "" {
+		return fmt.Errorf("no agent UUID known for this connection")
+	}
+
+	hub := checkin.GetCheckInHub()
+	if hub == nil {
+		return fmt.Errorf("check-in hub not initialized")
+	}
+
+	hub.Publish(agentUUID, "ping", nil)
+	if !hub.IsWaiting(agentUUID) {
+		fmt.Printf("[📥CHK] -> Agent %s has no held check-in, ping will be delivered when it next checks in\n", agentUUID)
+	}
+	return nil
+}
+
+// PushAgentConfig signs new settings for an agent and delivers them on its next (or currently
+// held) check-in, or with the acknowledgement of its next health check. The agent validates
+// the settings before applying them and reports back either way.
+func (s *ListenerService) PushAgentConfig(agentUUID string, settings runtimeconfig.Settings) error {
+	if agentUUID == "" {
+		return fmt.Errorf("no agent UUID known for this connection")
+	}
+
+	store := runtimeconfig.GetStore()
+	if store == nil {
+		return fmt.Errorf("runtime configuration store not initialized")
+	}
+
+	// Agents only trust updates signed with their own secret
+	verifier := signing.GetVerifier()
+	if verifier == nil {
+		return fmt.Errorf("request signing not available, configuration updates cannot be signed")
+	}
+	secret, ok := verifier.Secret(agentUUID)
+	if !ok {
+		return fmt.Errorf("agent %s was built without a signing secret, its configuration cannot be changed", agentUUID)
+	}
+
+	update, err := store.Push(agentUUID, settings, secret)
+	if err != nil {
+		return err
+	}
+
+	if hub := checkin.GetCheckInHub(); hub != nil {
+		hub.Publish(agentUUID, "config", update)
+	}
+	return nil
+}
+
 // IsPortAvailable checks if the specified port is available for binding
 func (s *ListenerService) IsPortAvailable(port string) bool {
 	// Try to bind to the port to see if it's available
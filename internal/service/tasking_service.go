@@ -0,0 +1,120 @@
+package service
+
+import (
+	"encoding/json"
+	"firestarter/internal/checkin"
+	"firestarter/internal/recon"
+	"firestarter/internal/tasking"
+	"firestarter/internal/websocket"
+	"fmt"
+	"time"
+)
+
+// maxTableOutput bounds the outputs laid out as tables for the UI, they are read whole
+const maxTableOutput = 32 << 20
+
+// TaskingService queues tasks for agents and lets them know there is something to fetch
+type TaskingService struct {
+	queue *tasking.Queue
+}
+
+// NewTaskingService creates a new tasking service on top of a task queue
+func NewTaskingService(queue *tasking.Queue) *TaskingService {
+	fmt.Println("[📋TSK] -> Tasking Service initialized.")
+
+	return &TaskingService{
+		queue: queue,
+	}
+}
+
+// QueueTask adds a task at the back of the agent's queue and wakes the agent's held check-in, if
+// any, so it fetches the task right away. Agents that don't hold check-ins learn about it with
+// their next health check. A ttl of 0 means tasking.DefaultTTL, a timeout of 0 leaves it to the
+// agent.
+func (s *TaskingService) QueueTask(agentUUID string, taskType string, args json.RawMessage, ttl time.Duration, timeout time.Duration) (tasking.Task, error) {
+	task, err := s.queue.Enqueue(agentUUID, taskType, args, ttl, timeout)
+	if err != nil {
+		return tasking.Task{}, err
+	}
+
+	if hub := checkin.GetCheckInHub(); hub != nil {
+		hub.Publish(agentUUID, "tasks", nil)
+		if !hub.IsWaiting(agentUUID) {
+			fmt.Printf("[📥CHK] -> Agent %s has no held check-in, task %s will be fetched when it next checks in\n", agentUUID, task.ID)
+		}
+	}
+	return task, nil
+}
+
+// CancelTask cancels a task. When the agent already has it, the agent's held check-in is woken
+// so it stops the task right away, otherwise it learns about it with its next health check.
+func (s *TaskingService) CancelTask(id string) error {
+	task, err := s.queue.Cancel(id)
+	if err != nil {
+		return err
+	}
+
+	if task.CancelRequested {
+		if hub := checkin.GetCheckInHub(); hub != nil {
+			payload, err := json.Marshal(task.ID)
+			if err != nil {
+				return fmt.Errorf("failed to encode cancellation: %w", err)
+			}
+			hub.Publish(task.AgentUUID, "cancel", payload)
+		}
+	}
+	return nil
+}
+
+// TaskTables lays out the output of a completed host information task as tables. When against
+// names an earlier run of the same task on the same agent, the tables show what changed since.
+func (s *TaskingService) TaskTables(id string, against string) (recon.Report, error) {
+	task, output, err := s.completedOutput(id)
+	if err != nil {
+		return recon.Report{}, err
+	}
+	tables, err := recon.Tabulate(task.Type, output)
+	if err != nil {
+		return recon.Report{}, err
+	}
+	report := recon.Report{TaskID: id, Tables: tables}
+	if against == "" {
+		return report, nil
+	}
+
+	earlier, earlierOutput, err := s.completedOutput(against)
+	if err != nil {
+		return recon.Report{}, err
+	}
+	if earlier.AgentUUID != task.AgentUUID || earlier.Type != task.Type {
+		return recon.Report{}, fmt.Errorf("task %s is not a %s task of the same agent", against, task.Type)
+	}
+	earlierTables, err := recon.Tabulate(earlier.Type, earlierOutput)
+	if err != nil {
+		return recon.Report{}, err
+	}
+	report.Against = against
+	report.Tables = recon.Diff(earlierTables, tables)
+	return report, nil
+}
+
+// completedOutput returns a completed task with its whole output
+func (s *TaskingService) completedOutput(id string) (tasking.Task, []byte, error) {
+	task, output, err := s.queue.Output(id, maxTableOutput)
+	if err != nil {
+		return tasking.Task{}, nil, err
+	}
+	if task.State != tasking.StateCompleted {
+		return tasking.Task{}, nil, fmt.Errorf("task %s is %s, not completed", id, task.State)
+	}
+	if !recon.Supported(task.Type) {
+		return tasking.Task{}, nil, fmt.Errorf("task type '%s' has no tabular output", task.Type)
+	}
+	return task, output, nil
+}
+
+// ConnectToWebSocket registers this service with the WebSocket server
+func (s *TaskingService) ConnectToWebSocket() {
+	websocket.RegisterTaskingBridge(s)
+	fmt.Println("[🔗LNK] -> Tasking Service registered with WebSocket.")
+}
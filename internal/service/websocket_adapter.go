@@ -2,10 +2,12 @@ package service
 
 import (
 	"firestarter/internal/interfaces"
+	"firestarter/internal/runtimeconfig"
 	"firestarter/internal/types"
 	"firestarter/internal/websocket"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // ConnectToWebSocket registers this service with the WebSocket server
@@ -67,6 +69,21 @@ func (a *websocketAdapter) IsPortAvailable(port string) bool {
 	return a.service.IsPortAvailable(port)
 }
 
+// PingAgent implements ServiceBridge.PingAgent
+func (a *websocketAdapter) PingAgent(agentUUID string) error {
+	return a.service.PingAgent(agentUUID)
+}
+
+// PushAgentConfig implements ServiceBridge.PushAgentConfig
+func (a *websocketAdapter) PushAgentConfig(agentUUID string, settings runtimeconfig.Settings) error {
+	return a.service.PushAgentConfig(agentUUID, settings)
+}
+
+// SetListenerMaintenance implements ServiceBridge.SetListenerMaintenance
+func (a *websocketAdapter) SetListenerMaintenance(id string, enabled bool, retryAfter time.Duration) error {
+	return a.service.SetListenerMaintenance(id, enabled, retryAfter)
+}
+
 // CreateListener implements ServiceBridge.CreateListener
 func (a *websocketAdapter) CreateListener(id string, protocol int, port string) (types.Listener, error) {
 	// Convert the protocol integer to the corresponding ProtocolType
@@ -82,6 +99,10 @@ func (a *websocketAdapter) CreateListener(id string, protocol int, port string)
 		protocolType = interfaces.H2TLS
 	case 5:
 		protocolType = interfaces.H3
+	case 6:
+		protocolType = interfaces.WS
+	case 7:
+		protocolType = interfaces.TCP
 	default:
 		return nil, fmt.Errorf("[❌ERR] -> Invalid protocol type: %d", protocol)
 	}
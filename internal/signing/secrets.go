@@ -0,0 +1,89 @@
+package signing
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// agentSecretsFile maps agent UUIDs to their base64 signing secrets, written by cmd/build into
+// the same directory as the end-to-end keys
+const agentSecretsFile = "secrets.json"
+
+// SecretSize is the length of a signing secret in bytes
+const SecretSize = 32
+
+// GenerateSecret creates a new random signing secret
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate signing secret: %w", err)
+	}
+	return secret, nil
+}
+
+// EncodeSecret encodes a secret as base64, suitable for -ldflags
+func EncodeSecret(secret []byte) string {
+	return base64.StdEncoding.EncodeToString(secret)
+}
+
+// ParseSecret decodes a base64 signing secret
+func ParseSecret(encoded string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing secret encoding: %w", err)
+	}
+	if len(secret) != SecretSize {
+		return nil, fmt.Errorf("invalid signing secret: want %d bytes, got %d", SecretSize, len(secret))
+	}
+	return secret, nil
+}
+
+// RegisterAgentSecret records the signing secret of a newly built agent in dir, so the server
+// can verify its requests
+func RegisterAgentSecret(dir string, agentUUID string, secret []byte) error {
+	secrets, err := readSecrets(dir)
+	if err != nil {
+		return err
+	}
+	secrets[agentUUID] = EncodeSecret(secret)
+
+	encoded, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	// Write then rename, a running server may be reading the file
+	path := filepath.Join(dir, agentSecretsFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0600); err != nil {
+		return fmt.Errorf("failed to save agent secrets: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readSecrets reads the agent secrets, which are empty until the first agent is built
+func readSecrets(dir string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	encoded, err := os.ReadFile(filepath.Join(dir, agentSecretsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent secrets: %w", err)
+	}
+
+	if err := json.Unmarshal(encoded, &secrets); err != nil {
+		return nil, fmt.Errorf("corrupt agent secrets: %w", err)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,255 @@
+package signing
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testAgentUUID = "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b"
+
+// newTestVerifier returns a verifier knowing a single agent, and that agent's secret
+func newTestVerifier(t *testing.T) (*Verifier, []byte) {
+	t.Helper()
+
+	dir := t.TempDir()
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	if err := RegisterAgentSecret(dir, testAgentUUID, secret); err != nil {
+		t.Fatalf("RegisterAgentSecret: %v", err)
+	}
+	if err := InitializeVerifier(dir, true); err != nil {
+		t.Fatalf("InitializeVerifier: %v", err)
+	}
+	t.Cleanup(func() { GlobalVerifier = nil })
+	return GetVerifier(), secret
+}
+
+// signAt signs a request as if at the given time
+func signAt(t *testing.T, secret []byte, at time.Time, method string, uri string, body []byte) *Signature {
+	t.Helper()
+
+	sig, err := Sign(secret, testAgentUUID, method, uri, body)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	sig.Timestamp = at.Unix()
+	sig.MAC = sig.mac(secret, testAgentUUID, method, uri)
+	return sig
+}
+
+func TestVerify(t *testing.T) {
+	verifier, secret := newTestVerifier(t)
+	body := []byte(`{"result":"ok"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		agentUUID string
+		method    string
+		uri       string
+		sig       func() *Signature
+		want      error
+	}{
+		{
+			name: "valid", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig:  func() *Signature { return signAt(t, secret, now, "POST", "/tasks", body) },
+			want: nil,
+		},
+		{
+			name: "method is case insensitive", agentUUID: testAgentUUID, method: "post", uri: "/tasks",
+			sig:  func() *Signature { return signAt(t, secret, now, "POST", "/tasks", body) },
+			want: nil,
+		},
+		{
+			name: "tampered method", agentUUID: testAgentUUID, method: "GET", uri: "/tasks",
+			sig:  func() *Signature { return signAt(t, secret, now, "POST", "/tasks", body) },
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered path", agentUUID: testAgentUUID, method: "POST", uri: "/files",
+			sig:  func() *Signature { return signAt(t, secret, now, "POST", "/tasks", body) },
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered query", agentUUID: testAgentUUID, method: "POST", uri: "/tasks?id=2",
+			sig:  func() *Signature { return signAt(t, secret, now, "POST", "/tasks?id=1", body) },
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered body hash", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig: func() *Signature {
+				sig := signAt(t, secret, now, "POST", "/tasks", body)
+				sig.ContentHash = HashBody([]byte(`{"result":"forged"}`))
+				return sig
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered timestamp", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig: func() *Signature {
+				sig := signAt(t, secret, now, "POST", "/tasks", body)
+				sig.Timestamp++
+				return sig
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "wrong secret", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig: func() *Signature {
+				other, _ := GenerateSecret()
+				return signAt(t, other, now, "POST", "/tasks", body)
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "stale timestamp", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig: func() *Signature {
+				return signAt(t, secret, now.Add(-MaxClockSkew-time.Minute), "POST", "/tasks", body)
+			},
+			want: ErrStale,
+		},
+		{
+			name: "future timestamp", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig: func() *Signature {
+				return signAt(t, secret, now.Add(MaxClockSkew+time.Minute), "POST", "/tasks", body)
+			},
+			want: ErrStale,
+		},
+		{
+			name: "within clock skew", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig: func() *Signature {
+				return signAt(t, secret, now.Add(-MaxClockSkew+time.Minute), "POST", "/tasks", body)
+			},
+			want: nil,
+		},
+		{
+			name: "unsigned", agentUUID: testAgentUUID, method: "POST", uri: "/tasks",
+			sig:  func() *Signature { return nil },
+			want: ErrUnsigned,
+		},
+		{
+			name: "unknown agent", agentUUID: "unknown-agent", method: "POST", uri: "/tasks",
+			sig:  func() *Signature { return signAt(t, secret, now, "POST", "/tasks", body) },
+			want: ErrUnknownAgent,
+		},
+		{
+			name: "unknown agent unsigned", agentUUID: "unknown-agent", method: "POST", uri: "/tasks",
+			sig:  func() *Signature { return nil },
+			want: ErrUnknownAgent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.Verify(tt.agentUUID, tt.method, tt.uri, tt.sig())
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	verifier, secret := newTestVerifier(t)
+
+	sig, err := Sign(secret, testAgentUUID, "POST", "/checkin", nil)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := verifier.Verify(testAgentUUID, "POST", "/checkin", sig); err != nil {
+		t.Fatalf("first Verify = %v, want nil", err)
+	}
+	if err := verifier.Verify(testAgentUUID, "POST", "/checkin", sig); !errors.Is(err, ErrReplay) {
+		t.Errorf("replayed Verify = %v, want %v", err, ErrReplay)
+	}
+
+	// A forged request reusing the nonce must not be mistaken for a replay, nor use it up
+	fresh, _ := Sign(secret, testAgentUUID, "POST", "/checkin", nil)
+	forged := *fresh
+	forged.MAC = sig.MAC
+	if err := verifier.Verify(testAgentUUID, "POST", "/checkin", &forged); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("forged Verify = %v, want %v", err, ErrBadSignature)
+	}
+	if err := verifier.Verify(testAgentUUID, "POST", "/checkin", fresh); err != nil {
+		t.Errorf("Verify after a forgery = %v, want nil", err)
+	}
+}
+
+func TestVerifyPicksUpNewAgents(t *testing.T) {
+	verifier, _ := newTestVerifier(t)
+
+	secret, _ := GenerateSecret()
+	if err := RegisterAgentSecret(verifier.dir, "new-agent", secret); err != nil {
+		t.Fatalf("RegisterAgentSecret: %v", err)
+	}
+	// The reload compares modification times, make sure the rewrite is seen as newer
+	verifier.secretsLoaded = verifier.secretsLoaded.Add(-time.Second)
+
+	sig, _ := Sign(secret, "new-agent", "GET", "/", nil)
+	if err := verifier.Verify("new-agent", "GET", "/", sig); err != nil {
+		t.Errorf("Verify = %v, want nil", err)
+	}
+}
+
+func TestHeadersRoundTrip(t *testing.T) {
+	sig, err := Sign([]byte("secret"), testAgentUUID, "POST", "/files", []byte("chunk"))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	header := make(http.Header)
+	sig.SetHeaders(header)
+	parsed, err := FromHeaders(header)
+	if err != nil {
+		t.Fatalf("FromHeaders: %v", err)
+	}
+	if *parsed != *sig {
+		t.Errorf("FromHeaders = %+v, want %+v", *parsed, *sig)
+	}
+
+	if _, err := FromHeaders(make(http.Header)); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("FromHeaders without a signature = %v, want %v", err, ErrUnsigned)
+	}
+
+	header.Del(HeaderNonce)
+	if _, err := FromHeaders(header); err == nil {
+		t.Error("FromHeaders without a nonce succeeded, want an error")
+	}
+}
+
+func TestVerifyOptional(t *testing.T) {
+	dir := t.TempDir()
+	secret, _ := GenerateSecret()
+	if err := RegisterAgentSecret(dir, testAgentUUID, secret); err != nil {
+		t.Fatalf("RegisterAgentSecret: %v", err)
+	}
+	if err := InitializeVerifier(dir, false); err != nil {
+		t.Fatalf("InitializeVerifier: %v", err)
+	}
+	t.Cleanup(func() { GlobalVerifier = nil })
+	verifier := GetVerifier()
+
+	forged, _ := Sign(secret, testAgentUUID, "POST", "/tasks", nil)
+	forged.MAC = "AAAA"
+
+	tests := []struct {
+		name      string
+		agentUUID string
+		sig       *Signature
+		want      error
+	}{
+		{"unsigned", testAgentUUID, nil, nil},
+		{"unsigned unknown agent", "dev-build", nil, nil},
+		{"forged", testAgentUUID, forged, ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifier.Verify(tt.agentUUID, "POST", "/tasks", tt.sig); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
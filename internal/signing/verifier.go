@@ -0,0 +1,180 @@
+package signing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MaxClockSkew is how far a request's timestamp may be from the server's clock. Nonces are
+// remembered for as long as their timestamp is acceptable, which is what makes them single use.
+const MaxClockSkew = 5 * time.Minute
+
+// sweepInterval is how often expired nonces are forgotten
+const sweepInterval = time.Minute
+
+// Reasons a request is rejected
+var (
+	ErrUnknownAgent = errors.New("agent has no registered signing secret")
+	ErrStale        = errors.New("request timestamp outside the allowed clock skew")
+	ErrBadSignature = errors.New("signature does not match the request")
+	ErrReplay       = errors.New("nonce already used, request replayed")
+	ErrContentHash  = errors.New("body does not match the signed content hash")
+)
+
+// Global verifier instance
+var GlobalVerifier *Verifier
+
+// Verifier checks agent request signatures against the secrets of every agent built for the
+// server and remembers recent nonces to refuse replays
+type Verifier struct {
+	dir      string
+	required bool // Whether unsigned requests are turned away
+
+	secrets       map[string][]byte // Agent UUID : signing secret
+	secretsLoaded time.Time         // Modification time of the secrets file last read
+
+	nonces    map[string]time.Time // Agent UUID + nonce : when it may be forgotten
+	lastSweep time.Time
+
+	mutex sync.Mutex
+}
+
+// InitializeVerifier sets up the global verifier with the agent secrets kept in dir. Unless
+// required is set, unsigned requests are let through, for agents built without a secret.
+func InitializeVerifier(dir string, required bool) error {
+	v := &Verifier{
+		dir:       dir,
+		required:  required,
+		secrets:   make(map[string][]byte),
+		nonces:    make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+	if err := v.reloadSecrets(); err != nil {
+		return err
+	}
+	GlobalVerifier = v
+
+	if required {
+		fmt.Printf("[🔏SIG] -> Request signing required, %d agent secret(s) loaded\n", len(v.secrets))
+	} else {
+		fmt.Printf("[🔏SIG] -> Request signing optional, unsigned requests are accepted, %d agent secret(s) loaded\n", len(v.secrets))
+	}
+	return nil
+}
+
+// GetVerifier returns the global verifier instance
+func GetVerifier() *Verifier {
+	return GlobalVerifier
+}
+
+// Required reports whether every request must be signed
+func (v *Verifier) Required() bool {
+	return v.required
+}
+
+// Verify checks the signature of a request from agentUUID, sig being nil when the request
+// carried none. A signed request must verify with the secret registered for its agent. When
+// signatures are required, unsigned requests and agents built without a secret are turned away
+// rather than let through.
+func (v *Verifier) Verify(agentUUID string, method string, uri string, sig *Signature) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	secret, ok := v.secrets[agentUUID]
+	if !ok {
+		// Agents may be built while the server runs, so pick up new secrets
+		if err := v.reloadSecrets(); err != nil {
+			return err
+		}
+		secret, ok = v.secrets[agentUUID]
+	}
+
+	switch {
+	case sig == nil && !v.required:
+		return nil
+	case !ok:
+		return ErrUnknownAgent
+	case sig == nil:
+		return ErrUnsigned
+	}
+
+	now := time.Now()
+	signedAt := time.Unix(sig.Timestamp, 0)
+	if signedAt.Before(now.Add(-MaxClockSkew)) || signedAt.After(now.Add(MaxClockSkew)) {
+		return ErrStale
+	}
+
+	// Check the signature before the nonce, so forged requests cannot use up nonces
+	if !sig.Valid(secret, agentUUID, method, uri) {
+		return ErrBadSignature
+	}
+
+	v.sweepNonces(now)
+	key := agentUUID + ":" + sig.Nonce
+	if _, seen := v.nonces[key]; seen {
+		return ErrReplay
+	}
+	v.nonces[key] = signedAt.Add(MaxClockSkew)
+
+	return nil
+}
+
+// Secret returns the signing secret registered for an agent, false when it was built without one
+func (v *Verifier) Secret(agentUUID string) ([]byte, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	secret, ok := v.secrets[agentUUID]
+	if !ok {
+		if err := v.reloadSecrets(); err != nil {
+			fmt.Printf("[❌ERR] -> Failed to reload agent secrets: %v\n", err)
+			return nil, false
+		}
+		secret, ok = v.secrets[agentUUID]
+	}
+	return secret, ok
+}
+
+// sweepNonces forgets nonces whose timestamp can no longer pass the skew check
+func (v *Verifier) sweepNonces(now time.Time) {
+	if now.Sub(v.lastSweep) < sweepInterval {
+		return
+	}
+	for key, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, key)
+		}
+	}
+	v.lastSweep = now
+}
+
+// reloadSecrets rereads the agent secrets when the file changed since it was last read
+func (v *Verifier) reloadSecrets() error {
+	info, err := os.Stat(filepath.Join(v.dir, agentSecretsFile))
+	if err != nil || !info.ModTime().After(v.secretsLoaded) {
+		return nil // No agents built yet, or nothing new
+	}
+
+	encoded, err := readSecrets(v.dir)
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[string][]byte, len(encoded))
+	for agentUUID, value := range encoded {
+		secret, err := ParseSecret(value)
+		if err != nil {
+			fmt.Printf("[❌ERR] -> Ignoring invalid signing secret for agent %s: %v\n", agentUUID, err)
+			continue
+		}
+		secrets[agentUUID] = secret
+	}
+
+	v.secrets = secrets
+	v.secretsLoaded = info.ModTime()
+	return nil
+}
@@ -0,0 +1,608 @@
+package tasking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultTTL is how long a task waits to be acknowledged when no expiry is given
+	DefaultTTL = 24 * time.Hour
+
+	// MaxTTL caps how long a task may wait to be acknowledged
+	MaxTTL = 30 * 24 * time.Hour
+
+	// MaxTimeout caps how long an agent may be told to run a task
+	MaxTimeout = 24 * time.Hour
+
+	// AckTimeout is how long a task handed to an agent waits for its acknowledgement before it
+	// is handed out again
+	AckTimeout = 30 * time.Second
+
+	// DefaultFetch is how many tasks a fetch hands out when the agent doesn't say
+	DefaultFetch = 8
+
+	// maxSettled is how many completed, failed or expired tasks are kept per agent for the UI
+	maxSettled = 200
+
+	// expiryInterval is how often tasks are checked for expiry when nothing else touches them
+	expiryInterval = 30 * time.Second
+
+	// queueFile holds the tasks of every agent, so they survive a server restart. Outputs are
+	// kept out of it, in resultsDir.
+	queueFile = "tasks.json"
+)
+
+// DefaultQueuePath returns the directory the task queues are kept in
+func DefaultQueuePath() string {
+	// Relative to the project root, like the keys and certificates
+	return "tasks"
+}
+
+// Global queue instance
+var GlobalQueue *Queue
+
+// Queue keeps a FIFO queue of tasks per agent, saved to disk in the background after every
+// change, and hands every change to a notifier
+type Queue struct {
+	dir      string
+	tasks    map[string]*Task    // Task ID : task
+	agents   map[string][]string // Agent UUID : task IDs, oldest first
+	uploads  map[string]*upload  // Task ID : output being uploaded
+	notifier func(Task)
+	mutex    sync.Mutex
+
+	// Changes not written to disk yet, saveLoop writes them outside the mutex
+	dirty      bool
+	saveSignal chan struct{}
+	writeMutex sync.Mutex // Held while the queue file is written, so writes never interleave
+}
+
+// newQueue returns an empty queue kept in dir
+func newQueue(dir string) *Queue {
+	return &Queue{
+		dir:        dir,
+		tasks:      make(map[string]*Task),
+		agents:     make(map[string][]string),
+		uploads:    make(map[string]*upload),
+		saveSignal: make(chan struct{}, 1),
+	}
+}
+
+// InitializeQueue sets up the global queue, loading the tasks saved in dir
+func InitializeQueue(dir string) error {
+	queue := newQueue(dir)
+	if err := queue.load(); err != nil {
+		return err
+	}
+
+	GlobalQueue = queue
+	go queue.expireLoop()
+	go queue.saveLoop()
+
+	fmt.Printf("[📋TSK] -> Task queue initialized with %d tasks.\n", len(queue.tasks))
+	return nil
+}
+
+// GetQueue returns the global queue instance
+func GetQueue() *Queue {
+	return GlobalQueue
+}
+
+// SetNotifier sets the function changed tasks are passed to, e.g. to broadcast them to the UI
+func (q *Queue) SetNotifier(notifier func(Task)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.notifier = notifier
+}
+
+// Enqueue adds a task at the back of the agent's queue. A ttl of 0 means DefaultTTL, a timeout of
+// 0 leaves it to the agent.
+func (q *Queue) Enqueue(agentUUID string, taskType string, args json.RawMessage, ttl time.Duration, timeout time.Duration) (Task, error) {
+	if agentUUID == "" {
+		return Task{}, fmt.Errorf("no agent UUID given")
+	}
+	if taskType == "" || len(taskType) > maxTypeLen {
+		return Task{}, fmt.Errorf("task type must be 1 to %d characters", maxTypeLen)
+	}
+	if len(args) > 0 && !json.Valid(args) {
+		return Task{}, fmt.Errorf("task arguments must be valid JSON")
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if ttl > MaxTTL {
+		return Task{}, fmt.Errorf("task expiry %v exceeds the maximum of %v", ttl, MaxTTL)
+	}
+	if timeout < 0 || timeout > MaxTimeout {
+		return Task{}, fmt.Errorf("task timeout %v must be between 0 and %v", timeout, MaxTimeout)
+	}
+
+	now := time.Now()
+	task := &Task{
+		ID:        uuid.New().String(),
+		AgentUUID: agentUUID,
+		Type:      taskType,
+		Args:      args,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(ttl),
+
+		TimeoutSeconds: int(timeout / time.Second),
+	}
+
+	q.mutex.Lock()
+	q.tasks[task.ID] = task
+	q.agents[agentUUID] = append(q.agents[agentUUID], task.ID)
+	q.saveLocked()
+	queued := *task
+	notifier := q.notifier
+	q.mutex.Unlock()
+
+	fmt.Printf("[📋TSK] -> Queued %s task %s for agent %s\n", taskType, task.ID, agentUUID)
+	if notifier != nil {
+		notifier(queued)
+	}
+	return queued, nil
+}
+
+// Fetch hands the agent its next tasks, oldest first: those still queued, and those handed out
+// before but not acknowledged within AckTimeout
+func (q *Queue) Fetch(agentUUID string, max int) []Delivery {
+	if max <= 0 {
+		max = DefaultFetch
+	}
+	if max > MaxFetch {
+		max = MaxFetch
+	}
+
+	q.mutex.Lock()
+	now := time.Now()
+	changed := q.expireLocked(now)
+
+	deliveries := make([]Delivery, 0)
+	for _, id := range q.agents[agentUUID] {
+		if len(deliveries) >= max {
+			break
+		}
+		task := q.tasks[id]
+		if task.State != StateQueued && (task.State != StateSent || now.Sub(task.SentAt) < AckTimeout) {
+			continue
+		}
+
+		task.State = StateSent
+		task.SentAt = now
+		task.UpdatedAt = now
+		task.Attempts++
+		deliveries = append(deliveries, Delivery{
+			ID:      task.ID,
+			Type:    task.Type,
+			Args:    task.Args,
+			Attempt: task.Attempts,
+
+			TimeoutSeconds: task.TimeoutSeconds,
+		})
+		changed = append(changed, *task)
+	}
+	if len(changed) > 0 {
+		q.saveLocked()
+	}
+	notifier := q.notifier
+	q.mutex.Unlock()
+
+	if len(deliveries) > 0 {
+		fmt.Printf("[📋TSK] -> Handed %d task(s) to agent %s\n", len(deliveries), agentUUID)
+	}
+	q.notify(notifier, changed)
+	return deliveries
+}
+
+// Acknowledge records that the agent received tasks, returning those it may run. Tasks that are
+// unknown, another agent's, or settled before the acknowledgement arrived are left out.
+func (q *Queue) Acknowledge(agentUUID string, ids []string) []string {
+	q.mutex.Lock()
+	now := time.Now()
+	changed := q.expireLocked(now)
+
+	accepted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		task, exists := q.tasks[id]
+		// Settled tasks have either expired, been cancelled, or already run
+		if !exists || task.AgentUUID != agentUUID || task.State.Terminal() {
+			continue
+		}
+		accepted = append(accepted, id)
+
+		// Acknowledgements of tasks already further along are repeats
+		if task.State == StateQueued || task.State == StateSent {
+			task.State = StateAcknowledged
+			task.UpdatedAt = now
+			changed = append(changed, *task)
+		}
+	}
+	if len(changed) > 0 {
+		q.saveLocked()
+	}
+	notifier := q.notifier
+	q.mutex.Unlock()
+
+	q.notify(notifier, changed)
+	return accepted
+}
+
+// Report records that the agent started running a task, or its outcome, returning whether the
+// report was accepted. Repeated reports of a settled task are accepted but change nothing.
+func (q *Queue) Report(agentUUID string, result Result) bool {
+	// Written before taking the mutex, and moved into place only if the result settles the task
+	var spooled *spooledOutput
+	if result.State.Terminal() && !result.Chunked {
+		spooled = q.spoolOutput(result.ID, result.Output)
+	}
+
+	q.mutex.Lock()
+	now := time.Now()
+	changed := q.expireLocked(now)
+
+	task, exists := q.tasks[result.ID]
+	accepted := exists && task.AgentUUID == agentUUID && task.State != StateExpired
+	if accepted && result.Chunked && task.OutputFile == "" {
+		// The output has to be uploaded in full before the result refers to it
+		accepted = false
+	}
+	settled := false
+	if accepted && !task.State.Terminal() && !(task.State == StateRunning && result.State == StateRunning) {
+		// A result is as good as an acknowledgement that never arrived
+		task.State = result.State
+		task.UpdatedAt = now
+		if result.State.Terminal() {
+			task.Error = result.Error
+			if result.Chunked {
+				q.finishUploadLocked(task)
+			} else {
+				q.discardOutputLocked(task)
+				q.storeOutputLocked(task, result.Output, spooled)
+			}
+			q.trimLocked(agentUUID)
+			settled = true
+		}
+		changed = append(changed, *task)
+	}
+	if len(changed) > 0 {
+		q.saveLocked()
+	}
+	notifier := q.notifier
+	q.mutex.Unlock()
+	spooled.remove()
+
+	if settled {
+		fmt.Printf("[📋TSK] -> Agent %s reported task %s %s\n", agentUUID, result.ID, result.State)
+	}
+	q.notify(notifier, changed)
+	return accepted
+}
+
+// Cancel cancels a task. Tasks the agent doesn't have yet fail right away, tasks it has are
+// flagged so the agent is told to stop them, and settle once it reports back.
+func (q *Queue) Cancel(id string) (Task, error) {
+	q.mutex.Lock()
+	now := time.Now()
+	changed := q.expireLocked(now)
+
+	task, exists := q.tasks[id]
+	if !exists {
+		notifier := q.notifier
+		q.mutex.Unlock()
+		q.notify(notifier, changed)
+		return Task{}, fmt.Errorf("unknown task %s", id)
+	}
+
+	var err error
+	switch {
+	case task.State.Terminal():
+		err = fmt.Errorf("task %s already %s", id, task.State)
+	case task.State == StateQueued || task.State == StateSent:
+		// A sent task the agent acknowledges later is left out of the receipt, so it never runs
+		task.State = StateFailed
+		task.Error = "cancelled before the agent received it"
+		task.UpdatedAt = now
+		q.trimLocked(task.AgentUUID)
+		changed = append(changed, *task)
+	case !task.CancelRequested:
+		task.CancelRequested = true
+		task.UpdatedAt = now
+		changed = append(changed, *task)
+	}
+	if len(changed) > 0 {
+		q.saveLocked()
+	}
+	cancelled := *task
+	notifier := q.notifier
+	q.mutex.Unlock()
+
+	if err == nil {
+		fmt.Printf("[📋TSK] -> Cancelled task %s for agent %s\n", id, cancelled.AgentUUID)
+	}
+	q.notify(notifier, changed)
+	return cancelled, err
+}
+
+// CancelRequests returns the tasks the agent should stop, those cancelled while it had them
+func (q *Queue) CancelRequests(agentUUID string) []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var ids []string
+	for _, id := range q.agents[agentUUID] {
+		task := q.tasks[id]
+		if task.CancelRequested && !task.State.Terminal() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Pending returns how many tasks are waiting to be handed to the agent
+func (q *Queue) Pending(agentUUID string) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	pending := 0
+	for _, id := range q.agents[agentUUID] {
+		task := q.tasks[id]
+		if task.State == StateQueued || (task.State == StateSent && now.Sub(task.SentAt) >= AckTimeout) {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Get returns a task by ID
+func (q *Queue) Get(id string) (Task, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if task, exists := q.tasks[id]; exists {
+		return *task, true
+	}
+	return Task{}, false
+}
+
+// Output returns a task along with its whole output, read back from its output file. Outputs
+// larger than max bytes are refused rather than read.
+func (q *Queue) Output(id string, max int64) (Task, []byte, error) {
+	task, exists := q.Get(id)
+	if !exists {
+		return Task{}, nil, fmt.Errorf("task %s not found", id)
+	}
+	if task.OutputFile == "" {
+		return task, task.Output, nil
+	}
+	if task.OutputSize > max {
+		return Task{}, nil, fmt.Errorf("output of task %s is %d bytes, over the %d allowed", id, task.OutputSize, max)
+	}
+
+	output, err := os.ReadFile(task.OutputFile)
+	if err != nil {
+		return Task{}, nil, fmt.Errorf("failed to read the output of task %s: %w", id, err)
+	}
+	return task, output, nil
+}
+
+// Snapshot returns every task, oldest first
+func (q *Queue) Snapshot() []Task {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	snapshot := make([]Task, 0, len(q.tasks))
+	for _, task := range q.tasks {
+		snapshot = append(snapshot, *task)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].CreatedAt.Before(snapshot[j].CreatedAt)
+	})
+	return snapshot
+}
+
+// expireLoop expires tasks nobody fetches, so the UI sees them expire
+func (q *Queue) expireLoop() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.mutex.Lock()
+		changed := q.expireLocked(time.Now())
+		if len(changed) > 0 {
+			q.saveLocked()
+		}
+		notifier := q.notifier
+		q.mutex.Unlock()
+
+		q.notify(notifier, changed)
+	}
+}
+
+// expireLocked expires the tasks not acknowledged by their expiry, fails those the agent has but
+// never reported an outcome for by their run deadline, and returns them. The caller must hold
+// the mutex.
+func (q *Queue) expireLocked(now time.Time) []Task {
+	var expired []Task
+	for _, task := range q.tasks {
+		switch task.State {
+		case StateQueued, StateSent:
+			if !now.After(task.ExpiresAt) {
+				continue
+			}
+			task.State = StateExpired
+			fmt.Printf("[📋TSK] -> Task %s for agent %s expired after %d attempt(s)\n", task.ID, task.AgentUUID, task.Attempts)
+		case StateAcknowledged, StateRunning:
+			if !now.After(task.RunDeadline()) {
+				continue
+			}
+			// Whatever was uploaded of its output will never be claimed by a result
+			q.discardOutputLocked(task)
+			task.OutputFile, task.OutputSize, task.OutputSHA256 = "", 0, ""
+			task.Upload = nil
+			task.State = StateFailed
+			task.Error = "the agent never reported an outcome"
+			fmt.Printf("[📋TSK] -> Task %s for agent %s failed, no outcome reported by %s\n", task.ID, task.AgentUUID, task.RunDeadline().Format(time.RFC3339))
+		default:
+			continue
+		}
+		task.UpdatedAt = now
+		expired = append(expired, *task)
+	}
+	for _, task := range expired {
+		q.trimLocked(task.AgentUUID)
+	}
+	return expired
+}
+
+// trimLocked forgets the agent's oldest settled tasks beyond maxSettled, the caller must hold
+// the mutex
+func (q *Queue) trimLocked(agentUUID string) {
+	ids := q.agents[agentUUID]
+
+	settled := 0
+	for _, id := range ids {
+		if q.tasks[id].State.Terminal() {
+			settled++
+		}
+	}
+
+	kept := ids[:0]
+	for _, id := range ids {
+		if settled > maxSettled && q.tasks[id].State.Terminal() {
+			q.discardOutputLocked(q.tasks[id])
+			delete(q.tasks, id)
+			settled--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	q.agents[agentUUID] = kept
+}
+
+// notify hands changed tasks to the notifier, outside the mutex
+func (q *Queue) notify(notifier func(Task), changed []Task) {
+	if notifier == nil {
+		return
+	}
+	for _, task := range changed {
+		notifier(task)
+	}
+}
+
+// saveLocked marks the queue as changed and wakes saveLoop to write it, the caller must hold the
+// mutex. Only the tasks' metadata is written, their outputs are saved once in their own files.
+func (q *Queue) saveLocked() {
+	q.dirty = true
+	select {
+	case q.saveSignal <- struct{}{}:
+	default: // A save is already due, it picks this change up too
+	}
+}
+
+// saveLoop writes the queue to disk whenever it changed, changes made while a write is under way
+// are batched into the next one. Failures are logged rather than returned, the queue keeps
+// working from memory and retries on the next change.
+func (q *Queue) saveLoop() {
+	for range q.saveSignal {
+		if err := q.Flush(); err != nil {
+			fmt.Printf("[❌ERR] -> Failed to save task queue: %v\n", err)
+		}
+	}
+}
+
+// Flush writes any change not saved yet to disk, e.g. before the server exits
+func (q *Queue) Flush() error {
+	q.writeMutex.Lock()
+	defer q.writeMutex.Unlock()
+
+	q.mutex.Lock()
+	if !q.dirty {
+		q.mutex.Unlock()
+		return nil
+	}
+	encoded, err := q.encodeLocked()
+	q.dirty = false
+	q.mutex.Unlock()
+
+	if err == nil {
+		err = q.write(encoded)
+	}
+	if err != nil {
+		// Written again with the next change
+		q.mutex.Lock()
+		q.dirty = true
+		q.mutex.Unlock()
+	}
+	return err
+}
+
+// encodeLocked encodes every task for the queue file, the caller must hold the mutex
+func (q *Queue) encodeLocked() ([]byte, error) {
+	tasks := make([]*Task, 0, len(q.tasks))
+	for _, ids := range q.agents {
+		for _, id := range ids {
+			saved := *q.tasks[id]
+			saved.Output = nil // Read back from the output file on load
+			tasks = append(tasks, &saved)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+	return json.MarshalIndent(tasks, "", "  ")
+}
+
+// write replaces the queue file, the caller must hold writeMutex
+func (q *Queue) write(encoded []byte) error {
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create task directory: %w", err)
+	}
+
+	// Write then rename, so a crash never leaves a truncated file behind
+	path := filepath.Join(q.dir, queueFile)
+	if err := os.WriteFile(path+".tmp", encoded, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// load reads the tasks saved by a previous run, there are none on the first run
+func (q *Queue) load() error {
+	encoded, err := os.ReadFile(filepath.Join(q.dir, queueFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read task queue: %w", err)
+	}
+
+	var tasks []*Task
+	if err := json.Unmarshal(encoded, &tasks); err != nil {
+		return fmt.Errorf("corrupt task queue: %w", err)
+	}
+
+	// Saved oldest first, so appending rebuilds each agent's queue in order
+	for _, task := range tasks {
+		if task.ID == "" || task.AgentUUID == "" {
+			continue
+		}
+		if task.OutputFile != "" {
+			task.Output = readPreview(task)
+		}
+		q.tasks[task.ID] = task
+		q.agents[task.AgentUUID] = append(q.agents[task.AgentUUID], task.ID)
+	}
+	return nil
+}
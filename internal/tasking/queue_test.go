@@ -0,0 +1,277 @@
+package tasking
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testAgentUUID = "6f1c2b9e-3d4a-4e5f-8a7b-0c1d2e3f4a5b"
+
+// newTestQueue returns a queue in a fresh directory, without the background loops of the global
+// one: tests expire and flush it themselves
+func newTestQueue(t *testing.T) *Queue {
+	t.Helper()
+	return newQueue(t.TempDir())
+}
+
+// enqueue queues a task for the agent, failing the test when it is refused
+func enqueue(t *testing.T, q *Queue, agentUUID string, taskType string, ttl time.Duration, timeout time.Duration) Task {
+	t.Helper()
+
+	task, err := q.Enqueue(agentUUID, taskType, nil, ttl, timeout)
+	if err != nil {
+		t.Fatalf("Enqueue(%s): %v", taskType, err)
+	}
+	return task
+}
+
+// deliveryIDs returns the IDs of the tasks handed out, in order
+func deliveryIDs(deliveries []Delivery) []string {
+	ids := make([]string, 0, len(deliveries))
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestFetchOrder(t *testing.T) {
+	q := newTestQueue(t)
+
+	var want []string
+	for _, taskType := range []string{"first", "second", "third", "fourth", "fifth"} {
+		want = append(want, enqueue(t, q, testAgentUUID, taskType, 0, 0).ID)
+	}
+	other := enqueue(t, q, "other-agent", "other", 0, 0)
+
+	if got := deliveryIDs(q.Fetch(testAgentUUID, 3)); !reflect.DeepEqual(got, want[:3]) {
+		t.Errorf("first fetch = %v, want %v", got, want[:3])
+	}
+	// Tasks handed out and awaiting their acknowledgement are skipped
+	if got := deliveryIDs(q.Fetch(testAgentUUID, 0)); !reflect.DeepEqual(got, want[3:]) {
+		t.Errorf("second fetch = %v, want %v", got, want[3:])
+	}
+	if got := q.Fetch(testAgentUUID, 0); len(got) != 0 {
+		t.Errorf("third fetch = %v, want nothing", deliveryIDs(got))
+	}
+
+	// Another agent's queue is its own
+	if got := deliveryIDs(q.Fetch("other-agent", 0)); !reflect.DeepEqual(got, []string{other.ID}) {
+		t.Errorf("other agent's fetch = %v, want %v", got, []string{other.ID})
+	}
+}
+
+func TestFetchRedeliversAfterAckTimeout(t *testing.T) {
+	q := newTestQueue(t)
+	unacknowledged := enqueue(t, q, testAgentUUID, "unacknowledged", 0, 0)
+	acknowledged := enqueue(t, q, testAgentUUID, "acknowledged", 0, 0)
+
+	deliveries := q.Fetch(testAgentUUID, 0)
+	if len(deliveries) != 2 || deliveries[0].Attempt != 1 {
+		t.Fatalf("first fetch = %+v, want both tasks on their first attempt", deliveries)
+	}
+	if accepted := q.Acknowledge(testAgentUUID, []string{acknowledged.ID}); len(accepted) != 1 {
+		t.Fatalf("Acknowledge = %v, want %s accepted", accepted, acknowledged.ID)
+	}
+
+	// Not yet due again
+	if got := q.Fetch(testAgentUUID, 0); len(got) != 0 {
+		t.Fatalf("fetch within AckTimeout = %v, want nothing", deliveryIDs(got))
+	}
+	if pending := q.Pending(testAgentUUID); pending != 0 {
+		t.Errorf("Pending within AckTimeout = %d, want 0", pending)
+	}
+
+	// The acknowledgement of the first task never arrived
+	for _, task := range q.tasks {
+		task.SentAt = task.SentAt.Add(-AckTimeout - time.Second)
+	}
+	if pending := q.Pending(testAgentUUID); pending != 1 {
+		t.Errorf("Pending past AckTimeout = %d, want 1", pending)
+	}
+	deliveries = q.Fetch(testAgentUUID, 0)
+	if len(deliveries) != 1 || deliveries[0].ID != unacknowledged.ID || deliveries[0].Attempt != 2 {
+		t.Errorf("fetch past AckTimeout = %+v, want %s on its second attempt", deliveries, unacknowledged.ID)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		state     State
+		expiresAt time.Time
+		timeout   time.Duration
+		want      State
+	}{
+		{"queued before expiry", StateQueued, now.Add(time.Minute), 0, StateQueued},
+		{"queued past expiry", StateQueued, past, 0, StateExpired},
+		{"sent past expiry", StateSent, past, 0, StateExpired},
+		{"acknowledged past expiry, within the default timeout", StateAcknowledged, past, 0, StateAcknowledged},
+		{"acknowledged past the default timeout", StateAcknowledged, past.Add(-MaxTimeout), 0, StateFailed},
+		{"running within its timeout", StateRunning, past, 2 * time.Minute, StateRunning},
+		{"running past its timeout", StateRunning, past, 30 * time.Second, StateFailed},
+		{"completed long ago", StateCompleted, past.Add(-MaxTTL), 0, StateCompleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t)
+			queued := enqueue(t, q, testAgentUUID, "task", 0, tt.timeout)
+			task := q.tasks[queued.ID]
+			task.State = tt.state
+			task.ExpiresAt = tt.expiresAt
+
+			expired := q.expireLocked(now)
+			if task.State != tt.want {
+				t.Fatalf("state = %s, want %s", task.State, tt.want)
+			}
+			if changed := len(expired) > 0; changed != (tt.state != tt.want) {
+				t.Errorf("expireLocked returned %d task(s), changed %v", len(expired), tt.state != tt.want)
+			}
+			if tt.want == StateFailed && task.Error == "" {
+				t.Error("task failed without a reason")
+			}
+		})
+	}
+}
+
+func TestExpiredTasksAreNotHandedOut(t *testing.T) {
+	q := newTestQueue(t)
+	task := enqueue(t, q, testAgentUUID, "task", time.Hour, 0)
+	q.tasks[task.ID].ExpiresAt = time.Now().Add(-time.Second)
+
+	if got := q.Fetch(testAgentUUID, 0); len(got) != 0 {
+		t.Errorf("Fetch = %v, want nothing once the task expired", deliveryIDs(got))
+	}
+	if accepted := q.Acknowledge(testAgentUUID, []string{task.ID}); len(accepted) != 0 {
+		t.Errorf("Acknowledge = %v, want the expired task left out", accepted)
+	}
+	if q.Report(testAgentUUID, Result{ID: task.ID, State: StateCompleted}) {
+		t.Error("Report of an expired task was accepted")
+	}
+}
+
+func TestReportIsRecordedOnce(t *testing.T) {
+	q := newTestQueue(t)
+	var notified []Task
+	q.SetNotifier(func(task Task) { notified = append(notified, task) })
+
+	task := enqueue(t, q, testAgentUUID, "task", 0, 0)
+	q.Fetch(testAgentUUID, 0)
+	q.Acknowledge(testAgentUUID, []string{task.ID})
+
+	output := bytes.Repeat([]byte("output "), PreviewSize)
+	if !q.Report(testAgentUUID, Result{ID: task.ID, State: StateCompleted, Output: output}) {
+		t.Fatal("Report was refused")
+	}
+	notifications := len(notified)
+
+	// The agent retries when the receipt is lost, with whatever it has by then
+	retries := []Result{
+		{ID: task.ID, State: StateCompleted, Output: []byte("second run")},
+		{ID: task.ID, State: StateFailed, Error: "second run failed"},
+		{ID: task.ID, State: StateRunning},
+	}
+	for _, retry := range retries {
+		if !q.Report(testAgentUUID, retry) {
+			t.Errorf("repeated %s report was refused", retry.State)
+		}
+	}
+	if q.Report("other-agent", Result{ID: task.ID, State: StateFailed}) {
+		t.Error("another agent's report was accepted")
+	}
+	if len(notified) != notifications {
+		t.Errorf("repeated reports notified %d change(s), want none", len(notified)-notifications)
+	}
+
+	settled, stored, err := q.Output(task.ID, int64(len(output)))
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	if settled.State != StateCompleted || settled.Error != "" {
+		t.Errorf("task is %s (%q), want completed", settled.State, settled.Error)
+	}
+	if !bytes.Equal(stored, output) {
+		t.Errorf("stored output is %d bytes, want the %d first reported", len(stored), len(output))
+	}
+	if len(settled.Output) != PreviewSize {
+		t.Errorf("preview is %d bytes, want %d", len(settled.Output), PreviewSize)
+	}
+
+	// Outputs of the retries, spooled before they were ignored, are not left behind
+	entries, err := os.ReadDir(filepath.Join(q.dir, resultsDir))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != task.ID {
+		t.Errorf("results directory holds %d file(s), want only the output of %s", len(entries), task.ID)
+	}
+}
+
+func TestQueueReload(t *testing.T) {
+	q := newTestQueue(t)
+
+	done := enqueue(t, q, testAgentUUID, "done", 0, 0)
+	running := enqueue(t, q, testAgentUUID, "running", 0, time.Minute)
+	q.Fetch(testAgentUUID, 0)
+	q.Acknowledge(testAgentUUID, []string{done.ID, running.ID})
+	q.Report(testAgentUUID, Result{ID: done.ID, State: StateCompleted, Output: []byte("whoami")})
+	q.Report(testAgentUUID, Result{ID: running.ID, State: StateRunning})
+	var queued []string
+	for _, taskType := range []string{"first", "second", "third"} {
+		queued = append(queued, enqueue(t, q, testAgentUUID, taskType, 0, 0).ID)
+	}
+
+	if err := q.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(q.dir, queueFile+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary queue file left behind: %v", err)
+	}
+
+	reloaded := newQueue(q.dir)
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// Compared as saved, times lose their monotonic reading on the way
+	got, _ := json.Marshal(reloaded.Snapshot())
+	want, _ := json.Marshal(q.Snapshot())
+	if !bytes.Equal(got, want) {
+		t.Errorf("reloaded tasks = %s\nwant %s", got, want)
+	}
+	if got := deliveryIDs(reloaded.Fetch(testAgentUUID, 0)); !reflect.DeepEqual(got, queued) {
+		t.Errorf("fetch after reload = %v, want %v", got, queued)
+	}
+	if accepted := reloaded.Acknowledge(testAgentUUID, []string{done.ID}); len(accepted) != 0 {
+		t.Errorf("Acknowledge after reload = %v, want the completed task left out", accepted)
+	}
+}
+
+func TestFlushWritesOnlyChanges(t *testing.T) {
+	q := newTestQueue(t)
+	path := filepath.Join(q.dir, queueFile)
+
+	if err := q.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged queue was written: %v", err)
+	}
+
+	enqueue(t, q, testAgentUUID, "task", 0, 0)
+	if err := q.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("changed queue was not written: %v", err)
+	}
+	if q.dirty {
+		t.Error("queue still marked changed after Flush")
+	}
+}
@@ -0,0 +1,219 @@
+// Package tasking keeps the tasks operators queue for agents. Each agent has its own FIFO queue,
+// whichever listener it checks in through, and tasks are delivered at least once: a task handed
+// to an agent is handed out again until the agent acknowledges it, so agents must recognise
+// tasks they already have by their ID.
+package tasking
+
+import (
+	"encoding/json"
+	"firestarter/internal/transfer"
+	"fmt"
+	"time"
+)
+
+// State is where a task is in its lifecycle
+type State string
+
+// Task states, a task only ever moves forward through them
+const (
+	StateQueued       State = "queued"       // Waiting for the agent to fetch it
+	StateSent         State = "sent"         // Handed to the agent, not acknowledged yet
+	StateAcknowledged State = "acknowledged" // The agent has it and will run it
+	StateRunning      State = "running"      // The agent started running it
+	StateCompleted    State = "completed"    // Ran to completion, the output is in
+	StateFailed       State = "failed"       // The agent could not run it, it failed, or it never reported back
+	StateExpired      State = "expired"      // Never acknowledged before it expired
+)
+
+// Terminal reports whether a task in this state is settled for good
+func (s State) Terminal() bool {
+	return s == StateCompleted || s == StateFailed || s == StateExpired
+}
+
+const (
+	// maxTypeLen bounds the task type an operator can give
+	maxTypeLen = 64
+
+	// maxErrorLen bounds the reason an agent gives for a failed task
+	maxErrorLen = 4096
+
+	// MaxFetch caps how many tasks a single fetch hands out
+	MaxFetch = 32
+)
+
+// Task is a single unit of work queued for an agent
+type Task struct {
+	ID        string          `json:"id"`
+	AgentUUID string          `json:"agentUUID"`
+	Type      string          `json:"type"`           // What the agent should do
+	Args      json.RawMessage `json:"args,omitempty"` // Type-specific parameters
+	State     State           `json:"state"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ExpiresAt time.Time `json:"expiresAt"` // Expired when not acknowledged by then, see also RunDeadline
+
+	// Delivery tracking, a task is handed out again when it isn't acknowledged in time
+	SentAt   time.Time `json:"sentAt,omitempty"`
+	Attempts int       `json:"attempts"`
+
+	// How long the agent may run the task, 0 for the agent's default
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+	// Set when an operator cancels a task the agent already has, the agent is told to stop it
+	// until it reports back
+	CancelRequested bool `json:"cancelRequested,omitempty"`
+
+	// Outcome reported by the agent. Outputs are kept in OutputFile, Output only holds their
+	// first PreviewSize bytes.
+	Output       []byte `json:"output,omitempty"`
+	Error        string `json:"error,omitempty"`
+	OutputSize   int64  `json:"outputSize,omitempty"`
+	OutputSHA256 string `json:"outputSha256,omitempty"`
+	OutputFile   string `json:"outputFile,omitempty"`
+
+	// Progress of the output's chunked upload while it is under way
+	Upload *transfer.Progress `json:"upload,omitempty"`
+}
+
+// RunDeadline returns when a task the agent acknowledged fails if the agent never reported its
+// outcome: its expiry, plus as long as it may run. Tasks left to the agent's default timeout get
+// MaxTimeout.
+func (t Task) RunDeadline() time.Time {
+	timeout := MaxTimeout
+	if t.TimeoutSeconds > 0 {
+		timeout = time.Duration(t.TimeoutSeconds) * time.Second
+	}
+	return t.ExpiresAt.Add(timeout)
+}
+
+// Delivery is a task as handed to an agent
+type Delivery struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Args    json.RawMessage `json:"args,omitempty"`
+	Attempt int             `json:"attempt"` // Above 1 when the task is handed out again
+
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"` // 0 for the agent's default
+}
+
+// FetchRequest asks for the next tasks queued for the agent
+type FetchRequest struct {
+	Max int `json:"max,omitempty"` // At most MaxFetch, 0 for the default
+}
+
+// Batch answers a fetch with the tasks handed to the agent, oldest first
+type Batch struct {
+	Tasks []Delivery `json:"tasks"`
+}
+
+// Ack acknowledges tasks the agent received
+type Ack struct {
+	IDs []string `json:"ids"`
+}
+
+// Result reports a task the agent started running, or its outcome
+type Result struct {
+	ID     string `json:"id"`
+	State  State  `json:"state"` // running, completed or failed
+	Output []byte `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Set instead of Output once the output was uploaded in chunks
+	Chunked bool `json:"chunked,omitempty"`
+}
+
+// Receipt answers an acknowledgement or a result with the tasks the server accepted it for.
+// Tasks left out are unknown to the server, another agent's, expired or cancelled: agents must
+// not run them, and need not retry reporting them.
+type Receipt struct {
+	IDs []string `json:"ids"`
+}
+
+// Marshal encodes a fetch request as an envelope payload
+func (f FetchRequest) Marshal() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+// UnmarshalFetchRequest decodes a fetch request received from an agent, an empty payload
+// asking for the default
+func UnmarshalFetchRequest(payload []byte) (FetchRequest, error) {
+	var request FetchRequest
+	if len(payload) == 0 {
+		return request, nil
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return FetchRequest{}, fmt.Errorf("invalid task fetch request: %w", err)
+	}
+	return request, nil
+}
+
+// Marshal encodes a batch as an envelope payload
+func (b Batch) Marshal() ([]byte, error) {
+	return json.Marshal(b)
+}
+
+// UnmarshalBatch decodes a batch received from the server
+func UnmarshalBatch(payload []byte) (Batch, error) {
+	var batch Batch
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return Batch{}, fmt.Errorf("invalid task batch: %w", err)
+	}
+	return batch, nil
+}
+
+// Marshal encodes an acknowledgement as an envelope payload
+func (a Ack) Marshal() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// UnmarshalAck decodes an acknowledgement received from an agent
+func UnmarshalAck(payload []byte) (Ack, error) {
+	var ack Ack
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return Ack{}, fmt.Errorf("invalid task acknowledgement: %w", err)
+	}
+	if len(ack.IDs) > MaxFetch {
+		return Ack{}, fmt.Errorf("invalid task acknowledgement: %d tasks exceed the limit of %d", len(ack.IDs), MaxFetch)
+	}
+	return ack, nil
+}
+
+// Marshal encodes a result as an envelope payload
+func (r Result) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalResult decodes a result received from an agent
+func UnmarshalResult(payload []byte) (Result, error) {
+	var result Result
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return Result{}, fmt.Errorf("invalid task result: %w", err)
+	}
+	if result.ID == "" {
+		return Result{}, fmt.Errorf("invalid task result: missing task ID")
+	}
+	switch result.State {
+	case StateRunning, StateCompleted, StateFailed:
+	default:
+		return Result{}, fmt.Errorf("invalid task result: agents cannot report state '%s'", result.State)
+	}
+	if len(result.Error) > maxErrorLen {
+		result.Error = result.Error[:maxErrorLen]
+	}
+	return result, nil
+}
+
+// Marshal encodes a receipt as an envelope payload
+func (r Receipt) Marshal() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalReceipt decodes a receipt received from the server
+func UnmarshalReceipt(payload []byte) (Receipt, error) {
+	var receipt Receipt
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		return Receipt{}, fmt.Errorf("invalid task receipt: %w", err)
+	}
+	return receipt, nil
+}
@@ -0,0 +1,313 @@
+package tasking
+
+import (
+	"encoding/json"
+	"errors"
+	"firestarter/internal/transfer"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Outputs too large for a single result are uploaded in chunks and reassembled by the server, see
+// package transfer. Outputs up to transfer.DefaultChunkSize are sent with their result.
+const (
+	// PreviewSize is how much of an output is kept with its task for the UI
+	PreviewSize = 64 << 10
+
+	// uploadsDir holds the chunks of uploads under way, one directory per task
+	uploadsDir = "uploads"
+
+	// resultsDir holds every output, reassembled or sent with its result, one file per task
+	resultsDir = "results"
+)
+
+// UploadBegin starts the chunked upload of a task's output, or resumes it when the server already
+// holds some of its chunks
+type UploadBegin struct {
+	ID string `json:"id"` // Task ID
+	transfer.Manifest
+}
+
+// Marshal encodes the start of an upload as an envelope payload
+func (b UploadBegin) Marshal() ([]byte, error) {
+	return json.Marshal(b)
+}
+
+// UnmarshalUploadBegin decodes the start of an upload received from an agent
+func UnmarshalUploadBegin(payload []byte) (UploadBegin, error) {
+	var begin UploadBegin
+	if err := json.Unmarshal(payload, &begin); err != nil {
+		return UploadBegin{}, fmt.Errorf("invalid upload: %w", err)
+	}
+	if begin.ID == "" {
+		return UploadBegin{}, fmt.Errorf("invalid upload: missing task ID")
+	}
+	if begin.Size == 0 {
+		return UploadBegin{}, fmt.Errorf("invalid upload: empty outputs are sent with their result")
+	}
+	if err := begin.Validate(); err != nil {
+		return UploadBegin{}, fmt.Errorf("invalid upload: %w", err)
+	}
+	return begin, nil
+}
+
+// upload is an output being uploaded
+type upload struct {
+	id       string // Task ID
+	partial  *transfer.Partial
+	complete bool
+	mutex    sync.Mutex // Held while chunks are written, never while holding the queue's mutex
+}
+
+// BeginUpload starts or resumes the upload of a task's output. An upload of a different output
+// for the same task, e.g. after the agent ran the task again, starts over.
+func (q *Queue) BeginUpload(agentUUID string, begin UploadBegin) (transfer.Status, error) {
+	q.mutex.Lock()
+	task, exists := q.tasks[begin.ID]
+	if !exists || task.AgentUUID != agentUUID {
+		q.mutex.Unlock()
+		return transfer.Status{ID: begin.ID, Unknown: true}, nil
+	}
+	if task.OutputFile != "" && task.OutputSHA256 == begin.SHA256 {
+		// The result may have been lost on the way back after the output arrived
+		q.mutex.Unlock()
+		return transfer.Status{ID: begin.ID, Complete: true}, nil
+	}
+	if task.State.Terminal() {
+		q.mutex.Unlock()
+		return transfer.Status{ID: begin.ID, Unknown: true}, nil
+	}
+	u := q.uploadLocked(begin.ID)
+	q.mutex.Unlock()
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	restarted, err := u.partial.Begin(begin.Manifest)
+	if err != nil {
+		return transfer.Status{}, err
+	}
+	if restarted {
+		u.complete = false
+		fmt.Printf("[📤UPL] -> Agent %s is uploading %d bytes for task %s in %d chunk(s)\n", agentUUID, begin.Size, begin.ID, begin.Chunks())
+	} else if received := len(u.partial.Received()); received > 0 {
+		fmt.Printf("[📤UPL] -> Agent %s resumed the upload of task %s with %d/%d chunk(s) received\n", agentUUID, begin.ID, received, begin.Chunks())
+	}
+
+	q.recordProgress(u)
+	return u.status(), nil
+}
+
+// StoreChunk keeps a chunk of an output, and reassembles the output once every chunk arrived.
+// Chunks already held are accepted again without being written twice.
+func (q *Queue) StoreChunk(agentUUID string, chunk transfer.Chunk) (transfer.Status, error) {
+	q.mutex.Lock()
+	task, exists := q.tasks[chunk.ID]
+	if !exists || task.AgentUUID != agentUUID || task.State.Terminal() {
+		q.mutex.Unlock()
+		return transfer.Status{ID: chunk.ID, Unknown: true}, nil
+	}
+	u := q.uploadLocked(chunk.ID)
+	q.mutex.Unlock()
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.complete {
+		// Resent after reassembly, when the status of the last chunk was lost
+		return u.status(), nil
+	}
+	if u.partial.Manifest().ChunkSize == 0 {
+		return transfer.Status{}, fmt.Errorf("no upload begun for task %s", chunk.ID)
+	}
+	if err := u.partial.Put(chunk.Index, chunk.Data); err != nil {
+		return transfer.Status{}, fmt.Errorf("task %s: %w", chunk.ID, err)
+	}
+
+	if u.partial.Done() {
+		size := u.partial.Manifest().Size
+		if err := q.assemble(u); err != nil {
+			fmt.Printf("[❌ERR] -> Upload of task %s failed: %v\n", chunk.ID, err)
+			return transfer.Status{}, err
+		}
+		fmt.Printf("[📤UPL] -> Reassembled the %d byte output of task %s\n", size, chunk.ID)
+		return u.status(), nil
+	}
+
+	q.recordProgress(u)
+	return u.status(), nil
+}
+
+// uploadLocked returns the upload of a task's output, picking up chunks kept on disk by an
+// earlier run. The caller must hold the queue's mutex.
+func (q *Queue) uploadLocked(id string) *upload {
+	if u, exists := q.uploads[id]; exists {
+		return u
+	}
+	u := &upload{id: id, partial: transfer.OpenPartial(filepath.Join(q.dir, uploadsDir, id))}
+	q.uploads[id] = u
+	return u
+}
+
+// assemble reassembles a complete upload into the task's output file. A mismatch with the hash
+// the agent gave discards every chunk, the agent then uploads the output again. The caller must
+// hold the upload's mutex.
+func (q *Queue) assemble(u *upload) error {
+	manifest := u.partial.Manifest()
+	path := filepath.Join(q.dir, resultsDir, u.id)
+	if err := u.partial.Assemble(path); err != nil {
+		if errors.Is(err, transfer.ErrMismatch) {
+			return fmt.Errorf("reassembled output does not match its hash, uploading again")
+		}
+		return err
+	}
+	u.complete = true
+
+	q.mutex.Lock()
+	task, exists := q.tasks[u.id]
+	if !exists {
+		q.mutex.Unlock()
+		return nil
+	}
+	task.OutputFile = path
+	task.OutputSize = manifest.Size
+	task.OutputSHA256 = manifest.SHA256
+	task.Upload = &transfer.Progress{Chunks: manifest.Chunks(), ChunksDone: manifest.Chunks(), Size: manifest.Size, BytesReceived: manifest.Size}
+	q.saveLocked()
+	changed := *task
+	notifier := q.notifier
+	q.mutex.Unlock()
+
+	q.notify(notifier, []Task{changed})
+	return nil
+}
+
+// recordProgress updates the task's upload progress and passes it on to the notifier, without
+// saving it since it is rebuilt from the chunks on disk
+func (q *Queue) recordProgress(u *upload) {
+	progress := u.partial.Progress()
+
+	q.mutex.Lock()
+	task, exists := q.tasks[u.id]
+	if !exists {
+		q.mutex.Unlock()
+		return
+	}
+	task.Upload = &progress
+	changed := *task
+	notifier := q.notifier
+	q.mutex.Unlock()
+
+	q.notify(notifier, []Task{changed})
+}
+
+// finishUploadLocked settles a task whose output was uploaded in chunks, keeping the start of
+// the output with the task for the UI. The caller must hold the queue's mutex.
+func (q *Queue) finishUploadLocked(task *Task) {
+	task.Upload = nil
+	delete(q.uploads, task.ID)
+	task.Output = readPreview(task)
+}
+
+// spooledOutput is an output sent with its result, written to a temporary file before the result
+// is recorded so the queue's mutex is never held while writing it
+type spooledOutput struct {
+	path   string
+	size   int64
+	sha256 string
+}
+
+// spoolOutput writes an output to a temporary file in the results directory, returning nil for
+// an empty output or when writing fails
+func (q *Queue) spoolOutput(id string, output []byte) *spooledOutput {
+	if len(output) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(q.dir, resultsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Printf("[❌ERR] -> Failed to create results directory: %v\n", err)
+		return nil
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(id)+".*.tmp")
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Failed to save the output of task %s: %v\n", id, err)
+		return nil
+	}
+	_, err = file.Write(output)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		fmt.Printf("[❌ERR] -> Failed to save the output of task %s: %v\n", id, err)
+		return nil
+	}
+	return &spooledOutput{path: file.Name(), size: int64(len(output)), sha256: transfer.Sum(output)}
+}
+
+// remove deletes the temporary file unless it was moved into place
+func (s *spooledOutput) remove() {
+	if s != nil {
+		_ = os.Remove(s.path)
+	}
+}
+
+// storeOutputLocked moves an output sent with its result, spooled beforehand, into the task's
+// output file, keeping its start with the task like an uploaded output. Should saving fail, the
+// whole output stays with the task until the server restarts. The caller must hold the queue's
+// mutex.
+func (q *Queue) storeOutputLocked(task *Task, output []byte, spooled *spooledOutput) {
+	task.OutputFile, task.OutputSize, task.OutputSHA256 = "", 0, ""
+	task.Output = output
+	if spooled == nil {
+		return
+	}
+
+	// A rename within the results directory, cheap enough to do under the mutex
+	path := filepath.Join(q.dir, resultsDir, task.ID)
+	if err := os.Rename(spooled.path, path); err != nil {
+		fmt.Printf("[❌ERR] -> Failed to save the output of task %s: %v\n", task.ID, err)
+		return
+	}
+	task.OutputFile = path
+	task.OutputSize = spooled.size
+	task.OutputSHA256 = spooled.sha256
+	task.Output = output[:min(len(output), PreviewSize)]
+}
+
+// readPreview reads the start of a task's output from its output file, for the UI
+func readPreview(task *Task) []byte {
+	file, err := os.Open(task.OutputFile)
+	if err != nil {
+		fmt.Printf("[❌ERR] -> Failed to read the output of task %s: %v\n", task.ID, err)
+		return nil
+	}
+	defer file.Close()
+
+	preview := make([]byte, min(task.OutputSize, PreviewSize))
+	n, err := io.ReadFull(file, preview)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		fmt.Printf("[❌ERR] -> Failed to read the output of task %s: %v\n", task.ID, err)
+		return nil
+	}
+	return preview[:n]
+}
+
+// discardOutputLocked removes everything kept on disk for a task's output. The caller must hold
+// the queue's mutex.
+func (q *Queue) discardOutputLocked(task *Task) {
+	if task.OutputFile != "" {
+		_ = os.Remove(task.OutputFile)
+	}
+	_ = os.RemoveAll(filepath.Join(q.dir, uploadsDir, task.ID))
+	delete(q.uploads, task.ID)
+}
+
+// status reports the chunks received so far
+func (u *upload) status() transfer.Status {
+	return transfer.Status{ID: u.id, Received: u.partial.Received(), Complete: u.complete}
+}
@@ -0,0 +1,195 @@
+package tasking
+
+import (
+	"bytes"
+	"firestarter/internal/transfer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// uploadData returns an output spanning several of the smallest chunks, the last one short
+func uploadData() []byte {
+	data := make([]byte, 3*transfer.MinChunkSize+123)
+	for i := range data {
+		data[i] = byte(i*31 + i/transfer.MinChunkSize)
+	}
+	return data
+}
+
+// beginUpload queues a task, hands it to the agent, and starts uploading data as its output
+func beginUpload(t *testing.T, q *Queue, data []byte, sha256 string) (Task, transfer.Manifest) {
+	t.Helper()
+
+	task := enqueue(t, q, testAgentUUID, "task", 0, 0)
+	q.Fetch(testAgentUUID, 0)
+	q.Acknowledge(testAgentUUID, []string{task.ID})
+
+	manifest := transfer.Manifest{Size: int64(len(data)), ChunkSize: transfer.MinChunkSize, SHA256: sha256}
+	status, err := q.BeginUpload(testAgentUUID, UploadBegin{ID: task.ID, Manifest: manifest})
+	if err != nil {
+		t.Fatalf("BeginUpload: %v", err)
+	}
+	if status.Unknown || status.Complete || len(status.Received) != 0 {
+		t.Fatalf("BeginUpload status = %+v, want a fresh upload", status)
+	}
+	return task, manifest
+}
+
+// storeChunks sends the chunks given, in that order, returning the status after the last one
+func storeChunks(t *testing.T, q *Queue, id string, m transfer.Manifest, data []byte, indexes ...int) transfer.Status {
+	t.Helper()
+
+	var status transfer.Status
+	for _, index := range indexes {
+		offset := m.ChunkOffset(index)
+		chunk := transfer.NewChunk(id, index, data[offset:offset+m.ChunkLen(index)])
+
+		var err error
+		if status, err = q.StoreChunk(testAgentUUID, chunk); err != nil {
+			t.Fatalf("StoreChunk(%d): %v", index, err)
+		}
+	}
+	return status
+}
+
+func TestUploadOutOfOrderAndDuplicateChunks(t *testing.T) {
+	q := newTestQueue(t)
+	data := uploadData()
+	task, m := beginUpload(t, q, data, transfer.Sum(data))
+
+	status := storeChunks(t, q, task.ID, m, data, 3, 1, 3, 1)
+	if want := []int{1, 3}; !reflect.DeepEqual(status.Received, want) || status.Complete {
+		t.Errorf("status = %+v, want chunks %v received", status, want)
+	}
+	if progress := q.tasks[task.ID].Upload; progress == nil || progress.ChunksDone != 2 {
+		t.Errorf("task progress = %+v, want 2 chunks done", progress)
+	}
+
+	// The result only refers to the output once all of it arrived
+	if q.Report(testAgentUUID, Result{ID: task.ID, State: StateCompleted, Chunked: true}) {
+		t.Fatal("chunked result accepted before its output was uploaded")
+	}
+
+	if status := storeChunks(t, q, task.ID, m, data, 0, 2); !status.Complete {
+		t.Fatalf("status = %+v, want the upload complete", status)
+	}
+	// Chunks arriving late, after reassembly, change nothing
+	if status := storeChunks(t, q, task.ID, m, data, 2); !status.Complete {
+		t.Errorf("status of a late chunk = %+v, want the upload complete", status)
+	}
+
+	if !q.Report(testAgentUUID, Result{ID: task.ID, State: StateCompleted, Chunked: true}) {
+		t.Fatal("chunked result refused once its output was uploaded")
+	}
+	settled, output, err := q.Output(task.ID, int64(len(data)))
+	if err != nil {
+		t.Fatalf("Output: %v", err)
+	}
+	if !bytes.Equal(output, data) {
+		t.Errorf("output is %d bytes that differ from the %d uploaded", len(output), len(data))
+	}
+	if settled.State != StateCompleted || settled.OutputSHA256 != m.SHA256 || settled.Upload != nil {
+		t.Errorf("task = %s, hash %s, upload %+v, want completed with the output's hash", settled.State, settled.OutputSHA256, settled.Upload)
+	}
+	if _, err := os.Stat(filepath.Join(q.dir, uploadsDir, task.ID)); !os.IsNotExist(err) {
+		t.Errorf("chunks kept after reassembly: %v", err)
+	}
+}
+
+func TestUploadResumesAfterRestart(t *testing.T) {
+	q := newTestQueue(t)
+	data := uploadData()
+	task, m := beginUpload(t, q, data, transfer.Sum(data))
+	storeChunks(t, q, task.ID, m, data, 0, 2)
+
+	if err := q.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	restarted := newQueue(q.dir)
+	if err := restarted.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	// The agent begins again after reconnecting, and only sends what is missing
+	status, err := restarted.BeginUpload(testAgentUUID, UploadBegin{ID: task.ID, Manifest: m})
+	if err != nil {
+		t.Fatalf("BeginUpload after restart: %v", err)
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(status.Received, want) {
+		t.Fatalf("status after restart = %+v, want chunks %v received", status, want)
+	}
+	if status := storeChunks(t, restarted, task.ID, m, data, 1, 3); !status.Complete {
+		t.Fatalf("status = %+v, want the upload complete", status)
+	}
+
+	if !restarted.Report(testAgentUUID, Result{ID: task.ID, State: StateCompleted, Chunked: true}) {
+		t.Fatal("chunked result refused once its output was uploaded")
+	}
+	if _, output, err := restarted.Output(task.ID, int64(len(data))); err != nil || !bytes.Equal(output, data) {
+		t.Errorf("Output = %d bytes, %v, want the %d uploaded", len(output), err, len(data))
+	}
+}
+
+func TestUploadMismatchStartsOver(t *testing.T) {
+	q := newTestQueue(t)
+	data := uploadData()
+	task, m := beginUpload(t, q, data, transfer.Sum([]byte("another output")))
+	storeChunks(t, q, task.ID, m, data, 0, 1, 2)
+
+	last := m.Chunks() - 1
+	offset := m.ChunkOffset(last)
+	if _, err := q.StoreChunk(testAgentUUID, transfer.NewChunk(task.ID, last, data[offset:])); err == nil {
+		t.Fatal("StoreChunk of the last chunk succeeded, want the hash mismatch")
+	}
+
+	settled, _ := q.Get(task.ID)
+	if settled.OutputFile != "" {
+		t.Errorf("output file %s kept despite the mismatch", settled.OutputFile)
+	}
+	for _, leftover := range []string{filepath.Join(q.dir, uploadsDir, task.ID), filepath.Join(q.dir, resultsDir, task.ID)} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after the mismatch: %v", leftover, err)
+		}
+	}
+
+	// Chunks sent before the agent begins again are refused, then it starts from nothing
+	if _, err := q.StoreChunk(testAgentUUID, transfer.NewChunk(task.ID, 0, data[:m.ChunkSize])); err == nil {
+		t.Error("StoreChunk succeeded before the upload began again")
+	}
+	status, err := q.BeginUpload(testAgentUUID, UploadBegin{ID: task.ID, Manifest: m})
+	if err != nil || len(status.Received) != 0 {
+		t.Errorf("BeginUpload after the mismatch = %+v, %v, want a fresh upload", status, err)
+	}
+}
+
+func TestUploadRefusesOtherTasks(t *testing.T) {
+	q := newTestQueue(t)
+	data := uploadData()
+	task, m := beginUpload(t, q, data, transfer.Sum(data))
+
+	tests := []struct {
+		name      string
+		agentUUID string
+		id        string
+	}{
+		{"another agent's task", "other-agent", task.ID},
+		{"unknown task", testAgentUUID, "unknown-task"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := q.BeginUpload(tt.agentUUID, UploadBegin{ID: tt.id, Manifest: m})
+			if err != nil || !status.Unknown {
+				t.Errorf("BeginUpload = %+v, %v, want the task unknown", status, err)
+			}
+			status, err = q.StoreChunk(tt.agentUUID, transfer.NewChunk(tt.id, 0, data[:m.ChunkSize]))
+			if err != nil || !status.Unknown {
+				t.Errorf("StoreChunk = %+v, %v, want the task unknown", status, err)
+			}
+		})
+	}
+	if progress := q.tasks[task.ID].Upload; progress.ChunksDone != 0 {
+		t.Errorf("task progress = %+v, want no chunk stored", progress)
+	}
+}
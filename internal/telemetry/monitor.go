@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SkewThreshold is how far an agent's clock may drift from the server's before it is flagged,
+// well before signed requests start being refused as stale
+const SkewThreshold = 30 * time.Second
+
+const (
+	// missedReports is how many expected status records an agent may miss before it is
+	// considered lost
+	missedReports = 3
+
+	// watchInterval is how often agents are checked for missed status records
+	watchInterval = 5 * time.Second
+)
+
+// State is what the server makes of an agent from its status records
+type State string
+
+const (
+	StateOnline State = "online" // Reporting on schedule
+	StateExited State = "exited" // Sent a shutdown notice
+	StateLost   State = "lost"   // Stopped reporting without one, the network or host went away
+)
+
+// AgentHealth is the latest status of an agent and what the server concluded from it
+type AgentHealth struct {
+	AgentUUID      string    `json:"agentUUID"`
+	State          State     `json:"state"`
+	Status         Status    `json:"status"` // Latest status record or shutdown notice
+	ReceivedAt     time.Time `json:"receivedAt"`
+	ClockSkewMs    int64     `json:"clockSkewMs"` // How far the agent's clock is ahead of the server's
+	SkewFlagged    bool      `json:"skewFlagged"` // Skew exceeds SkewThreshold
+	StateChangedAt time.Time `json:"stateChangedAt"`
+}
+
+// Global monitor instance
+var GlobalMonitor *Monitor
+
+// Monitor follows the health of every agent from its status records, marking agents lost when
+// they stop reporting, and hands every change to a notifier
+type Monitor struct {
+	agents   map[string]*AgentHealth
+	notifier func(AgentHealth)
+	mutex    sync.Mutex
+}
+
+// InitializeMonitor sets up the global monitor and starts watching for agents that stop reporting
+func InitializeMonitor() {
+	GlobalMonitor = &Monitor{
+		agents: make(map[string]*AgentHealth),
+	}
+	go GlobalMonitor.watch()
+}
+
+// GetMonitor returns the global monitor instance
+func GetMonitor() *Monitor {
+	return GlobalMonitor
+}
+
+// SetNotifier sets the function changed agent health is passed to, e.g. to broadcast it to the UI
+func (m *Monitor) SetNotifier(notifier func(AgentHealth)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.notifier = notifier
+}
+
+// Record stores a status record from an agent, returning the acknowledgement to send back
+func (m *Monitor) Record(agentUUID string, status Status) Ack {
+	return m.update(agentUUID, status, StateOnline)
+}
+
+// RecordShutdown stores the notice an agent sends when it shuts down cleanly
+func (m *Monitor) RecordShutdown(agentUUID string, status Status) Ack {
+	return m.update(agentUUID, status, StateExited)
+}
+
+// update stores a status record and the state it puts the agent in
+func (m *Monitor) update(agentUUID string, status Status, state State) Ack {
+	now := time.Now()
+
+	// The record was stamped when sent, about half a round trip before it arrived
+	skew := status.AgentTime.Add(time.Duration(status.RTTMillis) * time.Millisecond / 2).Sub(now)
+	flagged := skew > SkewThreshold || skew < -SkewThreshold
+
+	m.mutex.Lock()
+	health, exists := m.agents[agentUUID]
+	if !exists {
+		health = &AgentHealth{AgentUUID: agentUUID}
+		m.agents[agentUUID] = health
+	}
+
+	// A status record sent before the shutdown notice but arriving after it doesn't bring the agent back
+	if health.State == StateExited && state == StateOnline && status.AgentTime.Before(health.Status.AgentTime) {
+		m.mutex.Unlock()
+		return Ack{ServerTime: now, ClockSkewMs: skew.Milliseconds()}
+	}
+	previous := health.State
+	wasFlagged := health.SkewFlagged
+
+	health.Status = status
+	health.ReceivedAt = now
+	health.ClockSkewMs = skew.Milliseconds()
+	health.SkewFlagged = flagged
+	if previous != state {
+		health.State = state
+		health.StateChangedAt = now
+	}
+	update := *health
+	notifier := m.notifier
+	m.mutex.Unlock()
+
+	switch {
+	case state == StateExited:
+		fmt.Printf("[🩺TEL] -> Agent %s shut down cleanly: %s\n", agentUUID, status.Reason)
+	case previous == StateLost:
+		fmt.Printf("[🩺TEL] -> Agent %s is reporting again\n", agentUUID)
+	}
+	if flagged && !wasFlagged {
+		fmt.Printf("[🩺TEL] -> Agent %s clock is off by %v\n", agentUUID, skew.Round(time.Millisecond))
+	}
+
+	if notifier != nil {
+		notifier(update)
+	}
+	return Ack{ServerTime: now, ClockSkewMs: skew.Milliseconds()}
+}
+
+// watch periodically marks agents that stopped reporting as lost
+func (m *Monitor) watch() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		m.markLost(now)
+	}
+}
+
+// markLost marks online agents whose status records are overdue as lost
+func (m *Monitor) markLost(now time.Time) {
+	m.mutex.Lock()
+	var lost []AgentHealth
+	for _, health := range m.agents {
+		if health.State == StateOnline && now.Sub(health.ReceivedAt) > missedReports*reportInterval(health.Status) {
+			health.State = StateLost
+			health.StateChangedAt = now
+			lost = append(lost, *health)
+		}
+	}
+	notifier := m.notifier
+	m.mutex.Unlock()
+
+	for _, health := range lost {
+		fmt.Printf("[🩺TEL] -> Agent %s stopped reporting without shutting down, last seen %s\n",
+			health.AgentUUID, health.ReceivedAt.Format(time.RFC3339))
+		if notifier != nil {
+			notifier(health)
+		}
+	}
+}
+
+// reportInterval is how often an agent is expected to send a status record. Agents holding
+// long-poll check-ins report once the interval has passed and the check-in returned.
+func reportInterval(status Status) time.Duration {
+	interval := time.Duration(status.HealthCheckIntervalMillis) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if status.LongPoll {
+		interval += time.Duration(status.LongPollTimeoutMillis) * time.Millisecond
+	}
+	return interval
+}
+
+// Snapshot returns the health of every agent
+func (m *Monitor) Snapshot() []AgentHealth {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snapshot := make([]AgentHealth, 0, len(m.agents))
+	for _, health := range m.agents {
+		snapshot = append(snapshot, *health)
+	}
+	return snapshot
+}
@@ -0,0 +1,74 @@
+// Package telemetry carries the status record agents send with their health checks and the
+// notice they send when shutting down cleanly. The server uses them to follow each agent's
+// health, flag clock skew, and tell an agent that exited apart from one whose network was lost.
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxErrorLen bounds the last error an agent reports
+const maxErrorLen = 1024
+
+// Status is the compact status record an agent reports on every health check. Durations are
+// in milliseconds.
+type Status struct {
+	AgentTime      time.Time  `json:"agentTime"` // Agent's clock when the record was sent
+	StartedAt      time.Time  `json:"startedAt"`
+	UptimeSeconds  int64      `json:"uptimeSeconds"`
+	Protocol       string     `json:"protocol"`
+	Reconnects     int        `json:"reconnects"`     // Connections re-established since starting
+	FailedConnects int        `json:"failedConnects"` // Connection attempts that failed since starting
+	LastError      string     `json:"lastError,omitempty"`
+	LastErrorAt    *time.Time `json:"lastErrorAt,omitempty"`
+	RTTMillis      int64      `json:"rttMs"` // Round trip of the agent's last exchange with the server
+
+	// Configured intervals
+	HealthCheckIntervalMillis int64 `json:"healthCheckIntervalMs"`
+	LongPoll                  bool  `json:"longPoll"`
+	LongPollTimeoutMillis     int64 `json:"longPollTimeoutMs"`
+	ReconnectDelayMillis      int64 `json:"reconnectDelayMs"`
+
+	Reason string `json:"reason,omitempty"` // Why the agent shut down, only set on shutdown notices
+}
+
+// Ack is the server's answer to a status record or shutdown notice
+type Ack struct {
+	ServerTime  time.Time `json:"serverTime"`
+	ClockSkewMs int64     `json:"clockSkewMs"` // How far the agent's clock is ahead of the server's
+
+	// Signed configuration update the agent has not acknowledged yet, so agents that don't
+	// hold check-ins pick it up with their next health check
+	ConfigUpdate []byte `json:"configUpdate,omitempty"`
+
+	// Tasks waiting to be fetched, so agents that don't hold check-ins know to fetch them
+	PendingTasks int `json:"pendingTasks,omitempty"`
+
+	// Tasks the agent has that an operator cancelled, the agent stops them and reports them failed
+	CancelTasks []string `json:"cancelTasks,omitempty"`
+}
+
+// Marshal encodes a status record as an envelope payload
+func (s Status) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// Unmarshal decodes a status record received from an agent
+func Unmarshal(payload []byte) (Status, error) {
+	var status Status
+	if err := json.Unmarshal(payload, &status); err != nil {
+		return Status{}, fmt.Errorf("invalid status record: %w", err)
+	}
+	if status.AgentTime.IsZero() {
+		return Status{}, fmt.Errorf("invalid status record: missing agent time")
+	}
+	if len(status.LastError) > maxErrorLen {
+		status.LastError = status.LastError[:maxErrorLen]
+	}
+	if len(status.Reason) > maxErrorLen {
+		status.Reason = status.Reason[:maxErrorLen]
+	}
+	return status, nil
+}
@@ -0,0 +1,80 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Task types moving files between operators and agents, named from the operator's side
+const (
+	TaskDownload = "download" // Sends a file from the agent to the server's file store
+	TaskUpload   = "upload"   // Writes a file staged in the server's file store to the agent
+)
+
+// DownloadArgs are the arguments of a download task
+type DownloadArgs struct {
+	Path      string `json:"path"`                // File to send, on the agent
+	ChunkSize int    `json:"chunkSize,omitempty"` // 0 for DefaultChunkSize
+}
+
+// UploadArgs are the arguments of an upload task
+type UploadArgs struct {
+	FileID string `json:"fileId"` // File staged in the server's file store
+	Path   string `json:"path"`   // Where to write it, on the agent
+	Manifest
+}
+
+// Offer starts or resumes sending a file to the server's file store for a download task. The
+// server answers with a Status whose ID names the file, chunks are then sent under that ID.
+type Offer struct {
+	TaskID string `json:"taskId"`
+	Path   string `json:"path"`
+	Manifest
+}
+
+// Fetch asks for a chunk of a file staged for an upload task, answered with the Chunk
+type Fetch struct {
+	TaskID string `json:"taskId"`
+	FileID string `json:"fileId"`
+	Index  int    `json:"index"`
+}
+
+// Marshal encodes an offer as an envelope payload
+func (o Offer) Marshal() ([]byte, error) {
+	return json.Marshal(o)
+}
+
+// UnmarshalOffer decodes an offer received from an agent
+func UnmarshalOffer(payload []byte) (Offer, error) {
+	var offer Offer
+	if err := json.Unmarshal(payload, &offer); err != nil {
+		return Offer{}, fmt.Errorf("invalid file offer: %w", err)
+	}
+	if offer.TaskID == "" || offer.Path == "" {
+		return Offer{}, fmt.Errorf("invalid file offer: missing task ID or path")
+	}
+	if err := offer.Validate(); err != nil {
+		return Offer{}, fmt.Errorf("invalid file offer: %w", err)
+	}
+	return offer, nil
+}
+
+// Marshal encodes a fetch as an envelope payload
+func (f Fetch) Marshal() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+// UnmarshalFetch decodes a fetch received from an agent
+func UnmarshalFetch(payload []byte) (Fetch, error) {
+	var fetch Fetch
+	if err := json.Unmarshal(payload, &fetch); err != nil {
+		return Fetch{}, fmt.Errorf("invalid file fetch: %w", err)
+	}
+	if fetch.TaskID == "" || fetch.FileID == "" {
+		return Fetch{}, fmt.Errorf("invalid file fetch: missing task or file ID")
+	}
+	if fetch.Index < 0 {
+		return Fetch{}, fmt.Errorf("invalid file fetch: negative index %d", fetch.Index)
+	}
+	return fetch, nil
+}
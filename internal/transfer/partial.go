@@ -0,0 +1,213 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// manifestFile describes a transfer next to its chunks
+	manifestFile = "manifest.json"
+
+	// chunkSuffix ends the name of every chunk file
+	chunkSuffix = ".chunk"
+)
+
+// ErrMismatch is returned when reassembled data does not match its hash. The chunks received
+// are discarded, the sender has to start over.
+var ErrMismatch = errors.New("reassembled data does not match its hash")
+
+// Partial is data being received, its chunks are kept in a directory until every one arrived and
+// they can be reassembled. A Partial is not safe for concurrent use.
+type Partial struct {
+	dir      string
+	manifest Manifest
+	received map[int]bool
+}
+
+// OpenPartial returns the transfer kept in dir, picking up the chunks an earlier run received
+func OpenPartial(dir string) *Partial {
+	p := &Partial{dir: dir, received: make(map[int]bool)}
+	if encoded, err := os.ReadFile(filepath.Join(dir, manifestFile)); err == nil {
+		if err := json.Unmarshal(encoded, &p.manifest); err == nil {
+			p.scan()
+		}
+	}
+	return p
+}
+
+// Manifest returns what is being received, the zero Manifest before Begin
+func (p *Partial) Manifest() Manifest {
+	return p.manifest
+}
+
+// Begin starts receiving the data a manifest describes, keeping the chunks held so far when it is
+// the same data. It returns whether the transfer started over.
+func (p *Partial) Begin(manifest Manifest) (bool, error) {
+	if p.manifest == manifest {
+		return false, nil
+	}
+	return true, p.reset(manifest)
+}
+
+// Put keeps a chunk. Chunks already held are accepted again without being written twice.
+func (p *Partial) Put(index int, data []byte) error {
+	if p.manifest.ChunkSize == 0 {
+		return fmt.Errorf("transfer not begun")
+	}
+	if index < 0 || index >= p.manifest.Chunks() {
+		return fmt.Errorf("chunk %d is outside the %d chunk(s) expected", index, p.manifest.Chunks())
+	}
+	if int64(len(data)) != p.manifest.ChunkLen(index) {
+		return fmt.Errorf("chunk %d should be %d bytes, got %d", index, p.manifest.ChunkLen(index), len(data))
+	}
+	if p.received[index] {
+		return nil
+	}
+
+	if err := writeAtomic(p.chunkPath(index), data); err != nil {
+		return fmt.Errorf("failed to store chunk %d: %w", index, err)
+	}
+	p.received[index] = true
+	return nil
+}
+
+// Received returns the indexes of the chunks held, in order
+func (p *Partial) Received() []int {
+	received := make([]int, 0, len(p.received))
+	for index := range p.received {
+		received = append(received, index)
+	}
+	sort.Ints(received)
+	return received
+}
+
+// Done reports whether every chunk arrived
+func (p *Partial) Done() bool {
+	return p.manifest.ChunkSize != 0 && len(p.received) == p.manifest.Chunks()
+}
+
+// Progress reports how much was received
+func (p *Partial) Progress() Progress {
+	progress := Progress{Chunks: p.manifest.Chunks(), ChunksDone: len(p.received), Size: p.manifest.Size}
+	for index := range p.received {
+		progress.BytesReceived += p.manifest.ChunkLen(index)
+	}
+	return progress
+}
+
+// Assemble concatenates the chunks into path and checks the result against the manifest's hash,
+// then discards the chunks. A mismatch discards them too and returns ErrMismatch.
+func (p *Partial) Assemble(path string) error {
+	if !p.Done() {
+		return fmt.Errorf("%d/%d chunk(s) received", len(p.received), p.manifest.Chunks())
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	output := io.MultiWriter(file, hash)
+	for index := 0; index < p.manifest.Chunks(); index++ {
+		if err = appendFile(output, p.chunkPath(index)); err != nil {
+			break
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != p.manifest.SHA256 {
+		err = ErrMismatch
+		_ = p.Discard()
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return err
+	}
+	return p.Discard()
+}
+
+// Discard removes every chunk and forgets the manifest
+func (p *Partial) Discard() error {
+	return p.reset(Manifest{})
+}
+
+// reset starts over for different data, discarding the chunks held so far
+func (p *Partial) reset(manifest Manifest) error {
+	p.manifest = manifest
+	p.received = make(map[int]bool)
+	if err := os.RemoveAll(p.dir); err != nil {
+		return fmt.Errorf("failed to discard previous chunks: %w", err)
+	}
+	if manifest.ChunkSize == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create transfer directory: %w", err)
+	}
+	return writeAtomic(filepath.Join(p.dir, manifestFile), encoded)
+}
+
+// scan picks up the chunks already on disk, those of the expected length at least, their
+// hashes are checked as a whole once the data is reassembled
+func (p *Partial) scan() {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil || p.manifest.ChunkSize == 0 {
+		return
+	}
+	for _, entry := range entries {
+		name, isChunk := strings.CutSuffix(entry.Name(), chunkSuffix)
+		index, err := strconv.Atoi(name)
+		if !isChunk || err != nil || index < 0 || index >= p.manifest.Chunks() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.Size() == p.manifest.ChunkLen(index) {
+			p.received[index] = true
+		}
+	}
+}
+
+// chunkPath returns where a chunk is kept
+func (p *Partial) chunkPath(index int) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%06d%s", index, chunkSuffix))
+}
+
+// appendFile copies a file to w
+func appendFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// writeAtomic writes then renames, so a crash never leaves a truncated file behind
+func writeAtomic(path string, data []byte) error {
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
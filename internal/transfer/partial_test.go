@@ -0,0 +1,218 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testData returns size bytes that differ from chunk to chunk, so misplaced chunks show
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/MinChunkSize)
+	}
+	return data
+}
+
+// manifestFor describes data split into MinChunkSize chunks
+func manifestFor(data []byte) Manifest {
+	return Manifest{Size: int64(len(data)), ChunkSize: MinChunkSize, SHA256: Sum(data)}
+}
+
+// chunkOf returns a chunk of data as the sender cuts it
+func chunkOf(m Manifest, data []byte, index int) []byte {
+	offset := m.ChunkOffset(index)
+	return data[offset : offset+m.ChunkLen(index)]
+}
+
+// beginPartial starts receiving data in a fresh directory
+func beginPartial(t *testing.T, m Manifest) (*Partial, string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "partial")
+	p := OpenPartial(dir)
+	if restarted, err := p.Begin(m); err != nil || !restarted {
+		t.Fatalf("Begin = %v, %v, want a fresh start", restarted, err)
+	}
+	return p, dir
+}
+
+// put keeps the chunks given, in that order
+func put(t *testing.T, p *Partial, data []byte, indexes ...int) {
+	t.Helper()
+
+	for _, index := range indexes {
+		if err := p.Put(index, chunkOf(p.Manifest(), data, index)); err != nil {
+			t.Fatalf("Put(%d): %v", index, err)
+		}
+	}
+}
+
+// assemble reassembles the data and checks it came out whole, with the chunks discarded
+func assemble(t *testing.T, p *Partial, dir string, want []byte) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "assembled")
+	if err := p.Assemble(path); err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("assembled %d bytes that differ from the %d sent", len(got), len(want))
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("chunks kept after assembly: %v", err)
+	}
+}
+
+func TestPartialOutOfOrder(t *testing.T) {
+	data := testData(4*MinChunkSize + 100)
+	p, dir := beginPartial(t, manifestFor(data))
+
+	put(t, p, data, 4, 1, 3)
+	if p.Done() {
+		t.Fatal("Done with chunks missing")
+	}
+	if err := p.Assemble(filepath.Join(t.TempDir(), "assembled")); err == nil {
+		t.Fatal("Assemble succeeded with chunks missing")
+	}
+	if got, want := p.Received(), []int{1, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Received = %v, want %v", got, want)
+	}
+
+	put(t, p, data, 0, 2)
+	if !p.Done() {
+		t.Fatal("not Done once every chunk arrived")
+	}
+	assemble(t, p, dir, data)
+}
+
+func TestPartialDuplicateChunks(t *testing.T) {
+	data := testData(3 * MinChunkSize)
+	p, dir := beginPartial(t, manifestFor(data))
+
+	put(t, p, data, 0, 1)
+	// A chunk sent again, e.g. when its status was lost, is kept once and not rewritten
+	if err := p.Put(1, bytes.Repeat([]byte{0xff}, MinChunkSize)); err != nil {
+		t.Fatalf("Put of a chunk already held: %v", err)
+	}
+	put(t, p, data, 1)
+
+	progress := p.Progress()
+	if progress.ChunksDone != 2 || progress.BytesReceived != 2*MinChunkSize {
+		t.Errorf("Progress = %+v, want 2 chunks and %d bytes", progress, 2*MinChunkSize)
+	}
+
+	put(t, p, data, 2, 0)
+	assemble(t, p, dir, data)
+}
+
+func TestPartialResume(t *testing.T) {
+	data := testData(3*MinChunkSize + 1)
+	m := manifestFor(data)
+	p, dir := beginPartial(t, m)
+	put(t, p, data, 0, 2)
+
+	// A chunk cut short and a write interrupted by a crash are not picked up
+	os.WriteFile(filepath.Join(dir, "000001"+chunkSuffix), data[:10], 0600)
+	os.WriteFile(filepath.Join(dir, "000003"+chunkSuffix+".tmp"), chunkOf(m, data, 3), 0600)
+
+	reopened := OpenPartial(dir)
+	if reopened.Manifest() != m {
+		t.Fatalf("reopened manifest = %+v, want %+v", reopened.Manifest(), m)
+	}
+	if got, want := reopened.Received(), []int{0, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reopened Received = %v, want %v", got, want)
+	}
+	if restarted, err := reopened.Begin(m); err != nil || restarted {
+		t.Fatalf("Begin of the same data = %v, %v, want it resumed", restarted, err)
+	}
+
+	put(t, reopened, data, 3, 1)
+	assemble(t, reopened, dir, data)
+}
+
+func TestPartialBeginOtherDataStartsOver(t *testing.T) {
+	data := testData(2 * MinChunkSize)
+	p, dir := beginPartial(t, manifestFor(data))
+	put(t, p, data, 0)
+
+	other := testData(3 * MinChunkSize)[MinChunkSize:]
+	if restarted, err := p.Begin(manifestFor(other)); err != nil || !restarted {
+		t.Fatalf("Begin of other data = %v, %v, want a fresh start", restarted, err)
+	}
+	if received := p.Received(); len(received) != 0 {
+		t.Errorf("Received = %v after starting over, want nothing", received)
+	}
+	if reopened := OpenPartial(dir); len(reopened.Received()) != 0 {
+		t.Errorf("chunks of the earlier data still on disk: %v", reopened.Received())
+	}
+}
+
+func TestPartialMismatchDiscardsChunks(t *testing.T) {
+	data := testData(2*MinChunkSize + 5)
+	m := manifestFor(data)
+	m.SHA256 = Sum([]byte("something else"))
+	p, dir := beginPartial(t, m)
+	put(t, p, data, 0, 1, 2)
+
+	path := filepath.Join(t.TempDir(), "assembled")
+	if err := p.Assemble(path); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("Assemble error = %v, want %v", err, ErrMismatch)
+	}
+	for _, leftover := range []string{path, path + ".tmp", dir} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after the mismatch: %v", leftover, err)
+		}
+	}
+	if p.Manifest() != (Manifest{}) || len(p.Received()) != 0 {
+		t.Errorf("partial still holds %+v with chunks %v, want it wiped", p.Manifest(), p.Received())
+	}
+
+	// The sender starts over from nothing, even after a restart
+	reopened := OpenPartial(dir)
+	if restarted, err := reopened.Begin(m); err != nil || !restarted {
+		t.Errorf("Begin after the mismatch = %v, %v, want a fresh start", restarted, err)
+	}
+}
+
+func TestPartialPutRejects(t *testing.T) {
+	data := testData(2*MinChunkSize + 5)
+	m := manifestFor(data)
+
+	tests := []struct {
+		name  string
+		index int
+		data  []byte
+	}{
+		{"negative index", -1, data[:MinChunkSize]},
+		{"index past the last chunk", 3, data[:5]},
+		{"short chunk", 0, data[:MinChunkSize-1]},
+		{"long last chunk", 2, data[:MinChunkSize]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := beginPartial(t, m)
+			if err := p.Put(tt.index, tt.data); err == nil {
+				t.Errorf("Put(%d) of %d bytes succeeded, want an error", tt.index, len(tt.data))
+			}
+			if received := p.Received(); len(received) != 0 {
+				t.Errorf("Received = %v, want nothing kept", received)
+			}
+		})
+	}
+
+	t.Run("not begun", func(t *testing.T) {
+		p := OpenPartial(filepath.Join(t.TempDir(), "partial"))
+		if err := p.Put(0, data[:MinChunkSize]); err == nil {
+			t.Error("Put before Begin succeeded, want an error")
+		}
+	})
+}
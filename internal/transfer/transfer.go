@@ -0,0 +1,165 @@
+// Package transfer moves data between agents and the server in numbered chunks, each with its own
+// hash, so no request carries more than a chunk and a transfer interrupted by a reconnect, or a
+// restart on either side, resumes with the chunks still missing. The whole is checked against its
+// hash once reassembled.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// DefaultChunkSize is the chunk size used when none is given
+	DefaultChunkSize = 1 << 20
+
+	// MinChunkSize and MaxChunkSize bound the chunk size of a transfer
+	MinChunkSize = 4 << 10
+	MaxChunkSize = 8 << 20
+
+	// MaxSize caps the size of a single transfer
+	MaxSize = 4 << 30
+)
+
+// Manifest describes the data being transferred
+type Manifest struct {
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunkSize"`
+	SHA256    string `json:"sha256"` // Hex-encoded hash of the whole
+}
+
+// Chunks returns how many chunks the data is split into
+func (m Manifest) Chunks() int {
+	return int((m.Size + int64(m.ChunkSize) - 1) / int64(m.ChunkSize))
+}
+
+// ChunkOffset returns where a chunk starts in the data
+func (m Manifest) ChunkOffset(index int) int64 {
+	return int64(index) * int64(m.ChunkSize)
+}
+
+// ChunkLen returns the length of a chunk, only the last one may be short
+func (m Manifest) ChunkLen(index int) int64 {
+	if index == m.Chunks()-1 {
+		return m.Size - m.ChunkOffset(index)
+	}
+	return int64(m.ChunkSize)
+}
+
+// Validate checks the manifest describes something that can be transferred, and normalises its hash
+func (m *Manifest) Validate() error {
+	if m.Size < 0 || m.Size > MaxSize {
+		return fmt.Errorf("size must be 0 to %d bytes, got %d", int64(MaxSize), m.Size)
+	}
+	if err := ValidateChunkSize(m.ChunkSize); err != nil {
+		return err
+	}
+	if !ValidHash(m.SHA256) {
+		return fmt.Errorf("hash must be a hex-encoded SHA-256")
+	}
+	m.SHA256 = strings.ToLower(m.SHA256)
+	return nil
+}
+
+// ValidateChunkSize checks a chunk size is within bounds
+func ValidateChunkSize(size int) error {
+	if size < MinChunkSize || size > MaxChunkSize {
+		return fmt.Errorf("chunk size must be %d to %d bytes, got %d", MinChunkSize, MaxChunkSize, size)
+	}
+	return nil
+}
+
+// Chunk is a single numbered piece of the data being transferred
+type Chunk struct {
+	ID     string `json:"id"` // What the data belongs to, e.g. a task or a file
+	Index  int    `json:"index"`
+	SHA256 string `json:"sha256"` // Hex-encoded hash of Data
+	Data   []byte `json:"data"`
+}
+
+// NewChunk creates a chunk, hashing its data
+func NewChunk(id string, index int, data []byte) Chunk {
+	return Chunk{ID: id, Index: index, SHA256: Sum(data), Data: data}
+}
+
+// Marshal encodes a chunk as an envelope payload
+func (c Chunk) Marshal() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// UnmarshalChunk decodes a chunk, checking it against its hash
+func UnmarshalChunk(payload []byte) (Chunk, error) {
+	var chunk Chunk
+	if err := json.Unmarshal(payload, &chunk); err != nil {
+		return Chunk{}, fmt.Errorf("invalid chunk: %w", err)
+	}
+	if chunk.ID == "" {
+		return Chunk{}, fmt.Errorf("invalid chunk: missing ID")
+	}
+	if chunk.Index < 0 {
+		return Chunk{}, fmt.Errorf("invalid chunk: negative index %d", chunk.Index)
+	}
+	if Sum(chunk.Data) != strings.ToLower(chunk.SHA256) {
+		return Chunk{}, fmt.Errorf("chunk %d of %s does not match its hash", chunk.Index, chunk.ID)
+	}
+	return chunk, nil
+}
+
+// Status answers the start of a transfer or a chunk with the chunks the receiver holds
+type Status struct {
+	ID       string `json:"id"`
+	Received []int  `json:"received"` // Chunk indexes, in order
+	Complete bool   `json:"complete"` // Reassembled and verified
+
+	// Set when the receiver does not expect the data, or no longer does: the sender should drop
+	// it rather than retry
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// Marshal encodes a status as an envelope payload
+func (s Status) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// UnmarshalStatus decodes a status
+func UnmarshalStatus(payload []byte) (Status, error) {
+	var status Status
+	if err := json.Unmarshal(payload, &status); err != nil {
+		return Status{}, fmt.Errorf("invalid transfer status: %w", err)
+	}
+	return status, nil
+}
+
+// Progress is how far a transfer got, for the UI
+type Progress struct {
+	Chunks        int   `json:"chunks"`
+	ChunksDone    int   `json:"chunksDone"`
+	Size          int64 `json:"size"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// Sum returns the hex-encoded SHA-256 of data
+func Sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SumReader returns the hex-encoded SHA-256 of everything r yields, and its length
+func SumReader(r io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+// ValidHash reports whether s is a hex-encoded SHA-256
+func ValidHash(s string) bool {
+	decoded, err := hex.DecodeString(s)
+	return err == nil && len(decoded) == sha256.Size
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"encoding/json"
+	"firestarter/internal/filestore"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleFiles serves the file store to the UI over plain HTTP, files being too large for
+// WebSocket messages. GET /files/<id> downloads a retrieved or staged file, and POST /files
+// stages the request body and queues its upload to an agent, described by the query string:
+// agentUUID, path and name, with optional chunkSize in bytes and timeoutSeconds.
+func (s *SocketServer) handleFiles(w http.ResponseWriter, r *http.Request) {
+	// The UI is served from another origin during development, like the WebSocket upgrade
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		s.serveFile(w, r)
+	case http.MethodPost:
+		s.pushFile(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveFile sends the content of a file, with its hash as ETag
+func (s *SocketServer) serveFile(w http.ResponseWriter, r *http.Request) {
+	store := filestore.GetStore()
+	if store == nil {
+		http.Error(w, "file store not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+	file, record, err := store.Open(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": record.Name}))
+	w.Header().Set("ETag", strconv.Quote(record.SHA256))
+	http.ServeContent(w, r, record.Name, record.UpdatedAt, file)
+	fmt.Printf("[📁FIL] -> Served %s (%d bytes) to the UI.\n", record.Name, record.Size)
+}
+
+// pushFile stages the request body and queues its upload to an agent, answering with the file's
+// record
+func (s *SocketServer) pushFile(w http.ResponseWriter, r *http.Request) {
+	files := GetFileBridge()
+	if files == nil {
+		http.Error(w, "file bridge not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	var chunkSize int
+	if value := query.Get("chunkSize"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "chunkSize must be a number of bytes", http.StatusBadRequest)
+			return
+		}
+		chunkSize = size
+	}
+	var timeout time.Duration
+	if value := query.Get("timeoutSeconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			http.Error(w, "timeoutSeconds must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	record, err := files.PushFile(query.Get("agentUUID"), query.Get("name"), query.Get("path"), chunkSize, timeout, r.Body)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error pushing file to agent %s: %v", query.Get("agentUUID"), err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("[📁FIL] -> Task %s uploads %s to agent %s.\n", record.TaskID, record.Name, record.AgentUUID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		log.Printf("[❌ERR] -> Error answering file push: %v", err)
+	}
+}
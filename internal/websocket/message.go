@@ -2,22 +2,43 @@ package websocket
 
 import (
 	"encoding/json"
+	"firestarter/internal/runtimeconfig"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
 	"strconv"
+	"time"
 )
 
 // MessageType defines the type of WebSocket messages
 type MessageType string
 
 const (
-	ListenerCreated     MessageType = "listener_created"
-	ListenerStopped     MessageType = "listener_stopped"
-	ListenersSnapshot   MessageType = "listeners_snapshot"
-	ConnectionCreated   MessageType = "connection_created"
-	ConnectionStopped   MessageType = "connection_stopped"
-	ConnectionsSnapshot MessageType = "connections_snapshot"
+	ListenerCreated        MessageType = "listener_created"
+	ListenerStopped        MessageType = "listener_stopped"
+	ListenerUpdated        MessageType = "listener_updated"
+	ListenersSnapshot      MessageType = "listeners_snapshot"
+	ConnectionCreated      MessageType = "connection_created"
+	ConnectionStopped      MessageType = "connection_stopped"
+	ConnectionsSnapshot    MessageType = "connections_snapshot"
+	SecurityEventRecorded  MessageType = "security_event"
+	SecurityEventsSnapshot MessageType = "security_events_snapshot"
+	AgentTrafficUpdated    MessageType = "agent_traffic"
+	AgentTrafficSnapshot   MessageType = "agent_traffic_snapshot"
+	AgentRegistered        MessageType = "agent_registered"
+	RegistrationsSnapshot  MessageType = "registrations_snapshot"
+	AgentHealthUpdated     MessageType = "agent_health"
+	AgentHealthSnapshot    MessageType = "agent_health_snapshot"
+	AgentConfigUpdated     MessageType = "agent_config"
+	AgentConfigsSnapshot   MessageType = "agent_configs_snapshot"
+	ScopeViolationRecorded MessageType = "scope_violation"
+	ScopeSnapshot          MessageType = "scope_snapshot"
+	TaskUpdated            MessageType = "task_updated"
+	TasksSnapshot          MessageType = "tasks_snapshot"
+	TaskTables             MessageType = "task_tables"
+	FileUpdated            MessageType = "file_updated"
+	FileDeleted            MessageType = "file_deleted"
+	FilesSnapshot          MessageType = "files_snapshot"
 )
 
 // Message is the standard format for all WebSocket messages
@@ -119,6 +140,41 @@ func (s *SocketServer) processClientMessage(conn *websocket.Conn, rawMessage []b
 		} else {
 			fmt.Printf("[🛑STP] -> Listener %s stopped successfully.\n", id)
 		}
+	case "set_listener_maintenance":
+		// Extract the listener ID, the new state and the optional retry delay from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for set_listener_maintenance command")
+			return
+		}
+
+		id, ok := payloadMap["id"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'id' in set_listener_maintenance payload")
+			return
+		}
+
+		enabled, ok := payloadMap["enabled"].(bool)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'enabled' in set_listener_maintenance payload")
+			return
+		}
+
+		// Seconds agents are told to wait, the default applies when absent
+		var retryAfter time.Duration
+		if seconds, ok := payloadMap["retryAfter"].(float64); ok {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+
+		err := bridge.SetListenerMaintenance(id, enabled, retryAfter)
+		if err != nil {
+			log.Printf("[❌ERR] -> Error setting maintenance of listener %s: %v", id, err)
+		} else if enabled {
+			fmt.Printf("[🚧MNT] -> Listener %s is in maintenance.\n", id)
+		} else {
+			fmt.Printf("[🚧MNT] -> Listener %s is out of maintenance.\n", id)
+		}
+
 	case "get_connections":
 		// Send a snapshot of all connections
 		s.SendConnectionsSnapshot(conn)
@@ -150,6 +206,267 @@ func (s *SocketServer) processClientMessage(conn *websocket.Conn, rawMessage []b
 		} else {
 			fmt.Printf("[🛑STP] -> Connection %s stopped successfully.\n", id)
 		}
+	case "get_security_events":
+		// Send the recent security events
+		s.SendSecurityEventsSnapshot(conn)
+
+	case "get_scope":
+		// Send the authorized scope and the agents seen outside it
+		s.SendScopeSnapshot(conn)
+
+	case "get_agent_traffic":
+		// Send the compressed and uncompressed byte counts of every agent
+		s.SendAgentTrafficSnapshot(conn)
+
+	case "get_registrations":
+		// Send the host metadata every agent registered with
+		s.SendRegistrationsSnapshot(conn)
+
+	case "get_agent_health":
+		// Send the latest status record and state of every agent
+		s.SendAgentHealthSnapshot(conn)
+
+	case "get_agent_configs":
+		// Send the configuration in effect on every agent, and the updates still pending
+		s.SendAgentConfigsSnapshot(conn)
+
+	case "set_agent_config":
+		// Extract the agent UUID and its new settings from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for set_agent_config command")
+			return
+		}
+
+		agentUUID, ok := payloadMap["agentUUID"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'agentUUID' in set_agent_config payload")
+			return
+		}
+
+		settingsValue, exists := payloadMap["settings"]
+		if !exists {
+			log.Println("[❌ERR] -> Missing 'settings' in set_agent_config payload")
+			return
+		}
+
+		// Round-trip the settings through JSON to decode them into their type
+		var settings runtimeconfig.Settings
+		encoded, err := json.Marshal(settingsValue)
+		if err == nil {
+			err = json.Unmarshal(encoded, &settings)
+		}
+		if err != nil {
+			log.Printf("[❌ERR] -> Invalid settings in set_agent_config payload: %v", err)
+			return
+		}
+
+		// Sign and queue the update using the service bridge
+		err = bridge.PushAgentConfig(agentUUID, settings)
+		if err != nil {
+			log.Printf("[❌ERR] -> Error pushing configuration to agent %s: %v", agentUUID, err)
+		}
+
+	case "get_tasks":
+		// Send every task queued for any agent, along with its state
+		s.SendTasksSnapshot(conn)
+
+	case "queue_task":
+		// Extract the agent UUID, task type, arguments and expiry from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for queue_task command")
+			return
+		}
+
+		agentUUID, ok := payloadMap["agentUUID"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'agentUUID' in queue_task payload")
+			return
+		}
+
+		taskType, ok := payloadMap["type"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'type' in queue_task payload")
+			return
+		}
+
+		// Arguments are optional, and passed on to the agent as JSON
+		var args json.RawMessage
+		if argsValue, exists := payloadMap["args"]; exists && argsValue != nil {
+			encoded, err := json.Marshal(argsValue)
+			if err != nil {
+				log.Printf("[❌ERR] -> Invalid arguments in queue_task payload: %v", err)
+				return
+			}
+			args = encoded
+		}
+
+		// Expiry and timeout are optional, in seconds
+		var ttl time.Duration
+		if seconds, ok := payloadMap["ttlSeconds"].(float64); ok && seconds > 0 {
+			ttl = time.Duration(seconds * float64(time.Second))
+		}
+		var timeout time.Duration
+		if seconds, ok := payloadMap["timeoutSeconds"].(float64); ok && seconds > 0 {
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+
+		tasks := GetTaskingBridge()
+		if tasks == nil {
+			log.Println("[❌ERR] -> Tasking bridge not available")
+			return
+		}
+
+		// Queue the task using the tasking bridge
+		task, err := tasks.QueueTask(agentUUID, taskType, args, ttl, timeout)
+		if err != nil {
+			log.Printf("[❌ERR] -> Error queueing %s task for agent %s: %v", taskType, agentUUID, err)
+		} else {
+			fmt.Printf("[📋TSK] -> Task %s queued for agent %s from the UI.\n", task.ID, agentUUID)
+		}
+
+	case "cancel_task":
+		// Extract the task ID from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for cancel_task command")
+			return
+		}
+
+		taskID, ok := payloadMap["id"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'id' in cancel_task payload")
+			return
+		}
+
+		tasks := GetTaskingBridge()
+		if tasks == nil {
+			log.Println("[❌ERR] -> Tasking bridge not available")
+			return
+		}
+
+		// Cancel the task using the tasking bridge
+		if err := tasks.CancelTask(taskID); err != nil {
+			log.Printf("[❌ERR] -> Error cancelling task %s: %v", taskID, err)
+		} else {
+			fmt.Printf("[📋TSK] -> Task %s cancelled from the UI.\n", taskID)
+		}
+
+	case "get_task_tables":
+		// Extract the task ID, and the earlier run to compare with if any, from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for get_task_tables command")
+			return
+		}
+
+		taskID, ok := payloadMap["id"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'id' in get_task_tables payload")
+			return
+		}
+		against, _ := payloadMap["against"].(string)
+
+		// Send the task's output as tables to this client only
+		s.SendTaskTables(conn, taskID, against)
+
+	case "get_files":
+		// Send every file retrieved from or staged for an agent, along with its transfer
+		s.SendFilesSnapshot(conn)
+
+	case "retrieve_file":
+		// Extract the agent UUID and the path of the file on the agent from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for retrieve_file command")
+			return
+		}
+
+		agentUUID, ok := payloadMap["agentUUID"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'agentUUID' in retrieve_file payload")
+			return
+		}
+
+		path, ok := payloadMap["path"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'path' in retrieve_file payload")
+			return
+		}
+
+		// Chunk size is optional, in bytes, and timeout in seconds
+		var chunkSize int
+		if size, ok := payloadMap["chunkSize"].(float64); ok && size > 0 {
+			chunkSize = int(size)
+		}
+		var timeout time.Duration
+		if seconds, ok := payloadMap["timeoutSeconds"].(float64); ok && seconds > 0 {
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+
+		files := GetFileBridge()
+		if files == nil {
+			log.Println("[❌ERR] -> File bridge not available")
+			return
+		}
+
+		// Queue the download task using the file bridge
+		task, err := files.RetrieveFile(agentUUID, path, chunkSize, timeout)
+		if err != nil {
+			log.Printf("[❌ERR] -> Error retrieving %s from agent %s: %v", path, agentUUID, err)
+		} else {
+			fmt.Printf("[📁FIL] -> Task %s retrieves %s from agent %s.\n", task.ID, path, agentUUID)
+		}
+
+	case "delete_file":
+		// Extract the file ID from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for delete_file command")
+			return
+		}
+
+		fileID, ok := payloadMap["id"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'id' in delete_file payload")
+			return
+		}
+
+		files := GetFileBridge()
+		if files == nil {
+			log.Println("[❌ERR] -> File bridge not available")
+			return
+		}
+
+		// Delete the file using the file bridge
+		if err := files.DeleteFile(fileID); err != nil {
+			log.Printf("[❌ERR] -> Error deleting file %s: %v", fileID, err)
+		} else {
+			s.BroadcastFileDeleted(fileID)
+		}
+
+	case "ping_agent":
+		// Extract the agent UUID from the payload
+		payloadMap, ok := cmd.Payload.(map[string]interface{})
+		if !ok {
+			log.Println("[❌ERR] -> Invalid payload format for ping_agent command")
+			return
+		}
+
+		agentUUID, ok := payloadMap["agentUUID"].(string)
+		if !ok {
+			log.Println("[❌ERR] -> Missing 'agentUUID' in ping_agent payload")
+			return
+		}
+
+		// Queue the ping using the service bridge
+		err := bridge.PingAgent(agentUUID)
+		if err != nil {
+			log.Printf("[❌ERR] -> Error pinging agent %s: %v", agentUUID, err)
+		} else {
+			fmt.Printf("[📥CHK] -> Ping queued for agent %s.\n", agentUUID)
+		}
 	case "check_port":
 		// Extract the port from the payload
 		payloadMap, ok := cmd.Payload.(map[string]interface{})
@@ -285,10 +602,42 @@ func convertText(action string) string {
 		return "Get Listeners Snapshot"
 	case "stop_listener":
 		return "Stop Listener"
+	case "set_listener_maintenance":
+		return "Set Listener Maintenance"
 	case "get_connections":
 		return "Get Connections Snapshot"
 	case "stop_connection":
 		return "Stop Connection"
+	case "get_security_events":
+		return "Get Security Events Snapshot"
+	case "get_scope":
+		return "Get Authorized Scope Snapshot"
+	case "get_agent_traffic":
+		return "Get Agent Traffic Snapshot"
+	case "get_registrations":
+		return "Get Registrations Snapshot"
+	case "get_agent_health":
+		return "Get Agent Health Snapshot"
+	case "get_agent_configs":
+		return "Get Agent Configurations Snapshot"
+	case "set_agent_config":
+		return "Set Agent Configuration"
+	case "get_tasks":
+		return "Get Tasks Snapshot"
+	case "queue_task":
+		return "Queue Task"
+	case "cancel_task":
+		return "Cancel Task"
+	case "get_task_tables":
+		return "Get Task Tables"
+	case "get_files":
+		return "Get Files Snapshot"
+	case "retrieve_file":
+		return "Retrieve File"
+	case "delete_file":
+		return "Delete File"
+	case "ping_agent":
+		return "Ping Agent"
 	case "check_port":
 		return "Check Port Availability"
 	case "create_listener":
@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/runtimeconfig"
+)
+
+// BroadcastAgentConfig sends an agent's changed configuration to all clients
+func (s *SocketServer) BroadcastAgentConfig(config runtimeconfig.AgentConfig) {
+	s.Broadcast(Message{
+		Type:    AgentConfigUpdated,
+		Payload: config,
+	})
+}
@@ -0,0 +1,21 @@
+package websocket
+
+import (
+	"firestarter/internal/filestore"
+)
+
+// BroadcastFile sends a file whose transfer started or progressed to all clients
+func (s *SocketServer) BroadcastFile(record filestore.Record) {
+	s.Broadcast(Message{
+		Type:    FileUpdated,
+		Payload: record,
+	})
+}
+
+// BroadcastFileDeleted tells all clients a file was removed from the file store
+func (s *SocketServer) BroadcastFileDeleted(id string) {
+	s.Broadcast(Message{
+		Type:    FileDeleted,
+		Payload: map[string]string{"id": id},
+	})
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"firestarter/internal/maintenance"
 	"firestarter/internal/types"
 	"time"
 )
@@ -11,14 +12,25 @@ type ListenerInfo struct {
 	Port      string    `json:"port"`
 	Protocol  string    `json:"protocol"`
 	CreatedAt time.Time `json:"createdAt"`
+
+	// Maintenance turns agents away with a hint to retry after RetryAfterSeconds
+	Maintenance       bool       `json:"maintenance"`
+	MaintenanceSince  *time.Time `json:"maintenanceSince,omitempty"`
+	RetryAfterSeconds int64      `json:"retryAfterSeconds,omitempty"`
 }
 
 // ConvertListener converts a listener to ListenerInfo format
 func ConvertListener(listener types.Listener) ListenerInfo {
-	return ListenerInfo{
+	info := ListenerInfo{
 		ID:        listener.GetID(),
 		Port:      listener.GetPort(),
 		Protocol:  listener.GetProtocol(),
 		CreatedAt: listener.GetCreatedAt(),
 	}
+	if window, active := maintenance.Active(listener.GetID()); active {
+		info.Maintenance = true
+		info.MaintenanceSince = &window.Since
+		info.RetryAfterSeconds = int64(window.RetryAfter.Seconds())
+	}
+	return info
 }
@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/registration"
+)
+
+// BroadcastAgentRegistered sends an agent's new registration to all clients
+func (s *SocketServer) BroadcastAgentRegistered(reg registration.Registration) {
+	s.Broadcast(Message{
+		Type:    AgentRegistered,
+		Payload: reg,
+	})
+}
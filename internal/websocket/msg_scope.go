@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/scope"
+)
+
+// BroadcastScopeViolation sends a new or updated scope violation to all clients
+func (s *SocketServer) BroadcastScopeViolation(violation scope.Violation) {
+	s.Broadcast(Message{
+		Type:    ScopeViolationRecorded,
+		Payload: violation,
+	})
+}
@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/security"
+)
+
+// BroadcastSecurityEvent sends a newly recorded security event to all clients
+func (s *SocketServer) BroadcastSecurityEvent(event security.Event) {
+	s.Broadcast(Message{
+		Type:    SecurityEventRecorded,
+		Payload: event,
+	})
+}
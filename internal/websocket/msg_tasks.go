@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/tasking"
+)
+
+// BroadcastTask sends a queued or changed task to all clients
+func (s *SocketServer) BroadcastTask(task tasking.Task) {
+	s.Broadcast(Message{
+		Type:    TaskUpdated,
+		Payload: task,
+	})
+}
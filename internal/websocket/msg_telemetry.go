@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/telemetry"
+)
+
+// BroadcastAgentHealth sends an agent's changed health to all clients
+func (s *SocketServer) BroadcastAgentHealth(health telemetry.AgentHealth) {
+	s.Broadcast(Message{
+		Type:    AgentHealthUpdated,
+		Payload: health,
+	})
+}
@@ -0,0 +1,13 @@
+package websocket
+
+import (
+	"firestarter/internal/compression"
+)
+
+// BroadcastAgentTraffic sends an agent's updated traffic counters to all clients
+func (s *SocketServer) BroadcastAgentTraffic(traffic compression.Traffic) {
+	s.Broadcast(Message{
+		Type:    AgentTrafficUpdated,
+		Payload: traffic,
+	})
+}
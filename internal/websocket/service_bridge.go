@@ -1,8 +1,15 @@
 package websocket
 
 import (
+	"encoding/json"
+	"firestarter/internal/filestore"
 	"firestarter/internal/interfaces"
+	"firestarter/internal/recon"
+	"firestarter/internal/runtimeconfig"
+	"firestarter/internal/tasking"
 	"firestarter/internal/types"
+	"io"
+	"time"
 )
 
 // ServiceBridge acts as contract between the WebSocket server and the service layer
@@ -13,11 +20,24 @@ type ServiceBridge interface {
 	StopConnection(id string) error
 	IsPortAvailable(port string) bool
 	CreateListener(id string, protocol int, port string) (types.Listener, error)
+	PingAgent(agentUUID string) error
+	SetListenerMaintenance(id string, enabled bool, retryAfter time.Duration) error
+	PushAgentConfig(agentUUID string, settings runtimeconfig.Settings) error
+}
+
+// TaskingBridge acts as contract between the WebSocket server and the tasking service
+type TaskingBridge interface {
+	QueueTask(agentUUID string, taskType string, args json.RawMessage, ttl time.Duration, timeout time.Duration) (tasking.Task, error)
+	CancelTask(id string) error
+	TaskTables(id string, against string) (recon.Report, error)
 }
 
 // Global service bridge instance
 var serviceBridge ServiceBridge
 
+// Global tasking bridge instance
+var taskingBridge TaskingBridge
+
 // RegisterServiceBridge sets the service bridge implementation
 func RegisterServiceBridge(bridge ServiceBridge) {
 	serviceBridge = bridge
@@ -27,3 +47,33 @@ func RegisterServiceBridge(bridge ServiceBridge) {
 func GetServiceBridge() ServiceBridge {
 	return serviceBridge
 }
+
+// RegisterTaskingBridge sets the tasking bridge implementation
+func RegisterTaskingBridge(bridge TaskingBridge) {
+	taskingBridge = bridge
+}
+
+// GetTaskingBridge returns the current tasking bridge
+func GetTaskingBridge() TaskingBridge {
+	return taskingBridge
+}
+
+// FileBridge acts as contract between the WebSocket server and the file service
+type FileBridge interface {
+	PushFile(agentUUID string, name string, path string, chunkSize int, timeout time.Duration, content io.Reader) (filestore.Record, error)
+	RetrieveFile(agentUUID string, path string, chunkSize int, timeout time.Duration) (tasking.Task, error)
+	DeleteFile(id string) error
+}
+
+// Global file bridge instance
+var fileBridge FileBridge
+
+// RegisterFileBridge sets the file bridge implementation
+func RegisterFileBridge(bridge FileBridge) {
+	fileBridge = bridge
+}
+
+// GetFileBridge returns the current file bridge
+func GetFileBridge() FileBridge {
+	return fileBridge
+}
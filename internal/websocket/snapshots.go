@@ -1,6 +1,15 @@
 package websocket
 
 import (
+	"firestarter/internal/compression"
+	"firestarter/internal/filestore"
+	"firestarter/internal/recon"
+	"firestarter/internal/registration"
+	"firestarter/internal/runtimeconfig"
+	"firestarter/internal/scope"
+	"firestarter/internal/security"
+	"firestarter/internal/tasking"
+	"firestarter/internal/telemetry"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
@@ -69,3 +78,214 @@ func (s *SocketServer) SendConnectionsSnapshot(conn *websocket.Conn) {
 		fmt.Printf("[📷SNP] -> Sent snapshot with %d connections.\n", len(connections))
 	}
 }
+
+// SendSecurityEventsSnapshot sends the recent security events to a client
+func (s *SocketServer) SendSecurityEventsSnapshot(conn *websocket.Conn) {
+	eventLog := security.GetEventLog()
+	if eventLog == nil {
+		log.Println("[❌ERR] -> Cannot send security events snapshot: event log not available.")
+		return
+	}
+
+	events := eventLog.Events()
+
+	snapshotMsg := Message{
+		Type:    SecurityEventsSnapshot,
+		Payload: events,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending security events snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with %d security events.\n", len(events))
+	}
+}
+
+// SendScopeSnapshot sends the authorized scope and the agents seen outside it to a client
+func (s *SocketServer) SendScopeSnapshot(conn *websocket.Conn) {
+	authorized := scope.GetScope()
+	if authorized == nil {
+		log.Println("[❌ERR] -> Cannot send scope snapshot: scope not available.")
+		return
+	}
+
+	status := authorized.Status()
+
+	snapshotMsg := Message{
+		Type:    ScopeSnapshot,
+		Payload: status,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending scope snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent scope snapshot with %d violations.\n", len(status.Violations))
+	}
+}
+
+// SendAgentTrafficSnapshot sends the traffic counters of every agent to a client
+func (s *SocketServer) SendAgentTrafficSnapshot(conn *websocket.Conn) {
+	stats := compression.GetTrafficStats()
+	if stats == nil {
+		log.Println("[❌ERR] -> Cannot send agent traffic snapshot: traffic stats not available.")
+		return
+	}
+
+	traffic := stats.Snapshot()
+
+	snapshotMsg := Message{
+		Type:    AgentTrafficSnapshot,
+		Payload: traffic,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending agent traffic snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with traffic of %d agents.\n", len(traffic))
+	}
+}
+
+// SendRegistrationsSnapshot sends the latest registration of every agent to a client
+func (s *SocketServer) SendRegistrationsSnapshot(conn *websocket.Conn) {
+	registry := registration.GetRegistry()
+	if registry == nil {
+		log.Println("[❌ERR] -> Cannot send registrations snapshot: registry not available.")
+		return
+	}
+
+	registrations := registry.Snapshot()
+
+	snapshotMsg := Message{
+		Type:    RegistrationsSnapshot,
+		Payload: registrations,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending registrations snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with %d agent registrations.\n", len(registrations))
+	}
+}
+
+// SendAgentHealthSnapshot sends the latest status record and state of every agent to a client
+func (s *SocketServer) SendAgentHealthSnapshot(conn *websocket.Conn) {
+	monitor := telemetry.GetMonitor()
+	if monitor == nil {
+		log.Println("[❌ERR] -> Cannot send agent health snapshot: telemetry monitor not available.")
+		return
+	}
+
+	health := monitor.Snapshot()
+
+	snapshotMsg := Message{
+		Type:    AgentHealthSnapshot,
+		Payload: health,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending agent health snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with health of %d agents.\n", len(health))
+	}
+}
+
+// SendTasksSnapshot sends every task and its state to a client
+func (s *SocketServer) SendTasksSnapshot(conn *websocket.Conn) {
+	queue := tasking.GetQueue()
+	if queue == nil {
+		log.Println("[❌ERR] -> Cannot send tasks snapshot: task queue not available.")
+		return
+	}
+
+	tasks := queue.Snapshot()
+
+	snapshotMsg := Message{
+		Type:    TasksSnapshot,
+		Payload: tasks,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending tasks snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with %d tasks.\n", len(tasks))
+	}
+}
+
+// SendTaskTables sends the output of a host information task laid out as tables to a client,
+// compared with an earlier run when against is set. Failures are sent along for the UI to show.
+func (s *SocketServer) SendTaskTables(conn *websocket.Conn, id string, against string) {
+	tasks := GetTaskingBridge()
+	if tasks == nil {
+		log.Println("[❌ERR] -> Cannot send task tables: tasking bridge not available.")
+		return
+	}
+
+	report, err := tasks.TaskTables(id, against)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error laying out task %s as tables: %v", id, err)
+		report = recon.Report{TaskID: id, Against: against, Error: err.Error()}
+	}
+
+	tablesMsg := Message{
+		Type:    TaskTables,
+		Payload: report,
+	}
+
+	if err := s.sendMessage(conn, tablesMsg); err != nil {
+		log.Printf("[❌ERR] -> Error sending task tables: %v.", err)
+	} else {
+		fmt.Printf("[📋TSK] -> Sent %d table(s) for task %s.\n", len(report.Tables), id)
+	}
+}
+
+// SendFilesSnapshot sends every file in the file store and how far its transfer got to a client
+func (s *SocketServer) SendFilesSnapshot(conn *websocket.Conn) {
+	store := filestore.GetStore()
+	if store == nil {
+		log.Println("[❌ERR] -> Cannot send files snapshot: file store not available.")
+		return
+	}
+
+	files := store.Snapshot()
+
+	snapshotMsg := Message{
+		Type:    FilesSnapshot,
+		Payload: files,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending files snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with %d files.\n", len(files))
+	}
+}
+
+// SendAgentConfigsSnapshot sends the configuration of every agent to a client
+func (s *SocketServer) SendAgentConfigsSnapshot(conn *websocket.Conn) {
+	store := runtimeconfig.GetStore()
+	if store == nil {
+		log.Println("[❌ERR] -> Cannot send agent configurations snapshot: configuration store not available.")
+		return
+	}
+
+	configs := store.Snapshot()
+
+	snapshotMsg := Message{
+		Type:    AgentConfigsSnapshot,
+		Payload: configs,
+	}
+
+	err := s.sendMessage(conn, snapshotMsg)
+	if err != nil {
+		log.Printf("[❌ERR] -> Error sending agent configurations snapshot: %v.", err)
+	} else {
+		fmt.Printf("[📷SNP] -> Sent snapshot with configuration of %d agents.\n", len(configs))
+	}
+}
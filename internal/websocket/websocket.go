@@ -64,6 +64,10 @@ func (s *SocketServer) Start() error {
 	// Set up HTTP handler for the WebSocket endpoint
 	http.HandleFunc("/ws", s.handleWebSocket)
 
+	// Files are moved over plain HTTP, see handleFiles
+	http.HandleFunc("/files", s.handleFiles)
+	http.HandleFunc("/files/", s.handleFiles)
+
 	// Start the server
 	addr := fmt.Sprintf(":%d", s.port)
 